@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
+)
+
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+
+	// accessTokenPrefix makes leaked tokens easy to recognize in logs and secret scanners
+	accessTokenPrefix         = "todo_pat_"
+	maxAccessTokenNameLength  = 64
+	maxAccessTokenLifetime    = 365 * 24 * time.Hour
+	accessTokenTouchInterval  = time.Minute
+	accessTokenAuthHeaderHint = `Bearer realm="todo"`
+)
+
+// CreateAccessToken issues a personal access token for scripts. The returned secret is
+// only stored hashed, so it cannot be shown again.
+func (as *Service) CreateAccessToken(ctx context.Context, userId int64, name string, scopes []string, lifetime time.Duration) (t string, err error) {
+	name = strings.TrimSpace(name)
+	defer func() {
+		as.recordEvent(ctx, model.AuthEvent{Type: AuthEventAccessTokenCreate, UserId: userId, Reason: name}, err)
+	}()
+
+	if name == "" || utf8.RuneCountInString(name) > maxAccessTokenNameLength {
+		return "", ErrAccessTokenName
+	}
+	if len(scopes) == 0 {
+		return "", ErrAccessTokenScopes
+	}
+	for _, scope := range scopes {
+		if scope != ScopeRead && scope != ScopeWrite {
+			return "", ErrAccessTokenScopes
+		}
+	}
+	if lifetime <= 0 || lifetime > maxAccessTokenLifetime {
+		return "", ErrAccessTokenLifetime
+	}
+
+	secret, err := generateTokenSession()
+	if err != nil {
+		return "", err
+	}
+	token := accessTokenPrefix + secret
+
+	now := time.Now()
+	_, err = as.queries.CreateAccessToken(ctx, db.CreateAccessTokenParams{
+		UserID:    userId,
+		Name:      name,
+		TokenHash: hashToken(token),
+		Scopes:    strings.Join(normalizeScopes(scopes), " "),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(lifetime).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create access token: %w", err)
+	}
+
+	return token, nil
+}
+
+// ListAccessTokens returns the personal access tokens of the user, newest first
+func (as *Service) ListAccessTokens(ctx context.Context, userId int64) ([]model.AccessToken, error) {
+	rows, err := as.queries.ListUserAccessTokens(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list access tokens: %w", err)
+	}
+
+	tokens := make([]model.AccessToken, 0, len(rows))
+	for _, row := range rows {
+		tokens = append(tokens, model.AccessToken{
+			Id:         row.ID,
+			Name:       row.Name,
+			Scopes:     strings.Fields(row.Scopes),
+			CreatedAt:  row.CreatedAt,
+			ExpiresAt:  row.ExpiresAt,
+			LastUsedAt: row.LastUsedAt.Int64,
+		})
+	}
+	return tokens, nil
+}
+
+// RevokeAccessToken deletes a personal access token of the user
+func (as *Service) RevokeAccessToken(ctx context.Context, userId, tokenId int64) (err error) {
+	defer func() {
+		as.recordEvent(ctx, model.AuthEvent{Type: AuthEventAccessTokenRevoke, UserId: userId, Reason: fmt.Sprintf("token %d", tokenId)}, err)
+	}()
+
+	deleted, err := as.queries.DeleteUserAccessToken(ctx, db.DeleteUserAccessTokenParams{
+		ID:     tokenId,
+		UserID: userId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+	if deleted == 0 {
+		return ErrAccessTokenNotFound
+	}
+	return nil
+}
+
+// authenticateAccessToken returns the owner of the token if it is valid and grants the scope
+func (as *Service) authenticateAccessToken(ctx context.Context, token, scope string) (model.User, error) {
+	if !strings.HasPrefix(token, accessTokenPrefix) {
+		return model.User{}, ErrAccessTokenInvalid
+	}
+
+	row, err := as.queries.ValidateAccessToken(ctx, hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.User{}, ErrAccessTokenInvalid
+	}
+	if err != nil {
+		return model.User{}, fmt.Errorf("failed to validate access token: %w", err)
+	}
+
+	now := time.Now()
+	if now.Unix() >= row.ExpiresAt {
+		return model.User{}, ErrAccessTokenExpired
+	}
+	// Tokens are only issued to verified accounts, but the email may have changed since
+	if row.EmailVerified == 0 {
+		return model.User{}, ErrAccessTokenInvalid
+	}
+	if row.Disabled != 0 {
+		return model.User{}, ErrAccountDisabled
+	}
+	if !slices.Contains(strings.Fields(row.Scopes), scope) {
+		return model.User{}, ErrAccessTokenScope
+	}
+
+	if now.Unix()-row.LastUsedAt.Int64 >= int64(accessTokenTouchInterval.Seconds()) {
+		err := as.queries.TouchAccessToken(ctx, db.TouchAccessTokenParams{
+			LastUsedAt: sql.NullInt64{Int64: now.Unix(), Valid: true},
+			ID:         row.ID,
+		})
+		if err != nil {
+			return model.User{}, fmt.Errorf("failed to update access token last used time: %w", err)
+		}
+	}
+
+	return model.User{
+		Id:            row.UserID,
+		Email:         row.Email,
+		EmailVerified: true,
+		Role:          row.Role,
+	}, nil
+}
+
+// ProtectedAPIRouteMiddleware protects routes that scripts may call with a personal access token.
+// Safe methods need the read scope, everything else the write scope.
+// Requests without an Authorization header fall back to the session cookie.
+func (as *Service) ProtectedAPIRouteMiddleware(h http.Handler) http.HandlerFunc {
+	withSession := as.ProtectedRouteMiddleware(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := httpserver.BearerToken(r)
+		if !ok {
+			withSession.ServeHTTP(w, r)
+			return
+		}
+
+		scope := ScopeWrite
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			scope = ScopeRead
+		}
+
+		user, err := as.authenticateAccessToken(r.Context(), token, scope)
+		switch {
+		case errors.Is(err, ErrAccessTokenScope):
+			w.Header().Set("WWW-Authenticate", accessTokenAuthHeaderHint+`, error="insufficient_scope"`)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case errors.Is(err, ErrAccessTokenInvalid), errors.Is(err, ErrAccessTokenExpired), errors.Is(err, ErrAccountDisabled):
+			w.Header().Set("WWW-Authenticate", accessTokenAuthHeaderHint+`, error="invalid_token"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case err != nil:
+			slog.Error("failed to authenticate access token", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), UserContextKey, user)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// normalizeScopes sorts and deduplicates scopes so they are stored the same way
+func normalizeScopes(scopes []string) []string {
+	normalized := slices.Clone(scopes)
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/store"
+)
+
+func givenAccessTokenService() (*Service, *store.FakeQuerier) {
+	fakeQuerier := store.NewFakeQuerier()
+	fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@example.com", EmailVerified: 1}
+	fakeQuerier.Users[2] = db.User{ID: 2, Email: "other@example.com", EmailVerified: 1}
+	return Init(givenTestConfig(), fakeQuerier), fakeQuerier
+}
+
+func TestCreateAccessToken(t *testing.T) {
+	as, fakeQuerier := givenAccessTokenService()
+	ctx := context.Background()
+
+	f := func(name string, scopes []string, lifetime time.Duration, expect error) {
+		t.Helper()
+
+		token, err := as.CreateAccessToken(ctx, 1, name, scopes, lifetime)
+		if !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+		if expect == nil && !strings.HasPrefix(token, accessTokenPrefix) {
+			t.Fatalf("unexpected token format %q", token)
+		}
+	}
+
+	// missing name
+	f(" ", []string{ScopeRead}, time.Hour, ErrAccessTokenName)
+
+	// name too long
+	f(strings.Repeat("a", maxAccessTokenNameLength+1), []string{ScopeRead}, time.Hour, ErrAccessTokenName)
+
+	// missing scopes
+	f("script", nil, time.Hour, ErrAccessTokenScopes)
+
+	// unknown scope
+	f("script", []string{"admin"}, time.Hour, ErrAccessTokenScopes)
+
+	// no expiry
+	f("script", []string{ScopeRead}, 0, ErrAccessTokenLifetime)
+
+	// expiry too far away
+	f("script", []string{ScopeRead}, maxAccessTokenLifetime+time.Hour, ErrAccessTokenLifetime)
+
+	// valid token
+	f("script", []string{ScopeWrite, ScopeRead, ScopeRead}, 30*24*time.Hour, nil)
+
+	tokens, err := as.ListAccessTokens(ctx, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].Name != "script" {
+		t.Fatalf("unexpected tokens %+v", tokens)
+	}
+	if strings.Join(tokens[0].Scopes, " ") != "read write" {
+		t.Fatalf("expected scopes to be normalized, got %v", tokens[0].Scopes)
+	}
+	if len(fakeQuerier.AccessTokens[tokens[0].Id].TokenHash) != 64 {
+		t.Fatalf("expected token to be stored hashed")
+	}
+}
+
+func TestAuthenticateAccessToken(t *testing.T) {
+	as, fakeQuerier := givenAccessTokenService()
+	ctx := context.Background()
+
+	readOnly, err := as.CreateAccessToken(ctx, 1, "read-only", []string{ScopeRead}, time.Hour)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	expired, err := as.CreateAccessToken(ctx, 1, "expired", []string{ScopeRead}, time.Hour)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	for id, token := range fakeQuerier.AccessTokens {
+		if token.Name == "expired" {
+			token.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+			fakeQuerier.AccessTokens[id] = token
+		}
+	}
+
+	f := func(token, scope string, expect error) {
+		t.Helper()
+
+		user, err := as.authenticateAccessToken(ctx, token, scope)
+		if !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+		if expect == nil && user.Id != 1 {
+			t.Fatalf("expected token owner, got user %d", user.Id)
+		}
+	}
+
+	// unknown token
+	f(accessTokenPrefix+"unknown", ScopeRead, ErrAccessTokenInvalid)
+
+	// a session token is not an access token
+	f("not-a-token", ScopeRead, ErrAccessTokenInvalid)
+
+	// expired token
+	f(expired, ScopeRead, ErrAccessTokenExpired)
+
+	// missing scope
+	f(readOnly, ScopeWrite, ErrAccessTokenScope)
+
+	// valid token
+	f(readOnly, ScopeRead, nil)
+
+	tokens, _ := as.ListAccessTokens(ctx, 1)
+	for _, token := range tokens {
+		if token.Name == "read-only" && token.LastUsedAt == 0 {
+			t.Fatalf("expected last used time to be recorded")
+		}
+	}
+
+	// revoked token
+	if err := as.RevokeAccessToken(ctx, 2, tokens[0].Id); !errors.Is(err, ErrAccessTokenNotFound) {
+		t.Fatalf("expected ErrAccessTokenNotFound, got: %v", err)
+	}
+	for _, token := range tokens {
+		if err := as.RevokeAccessToken(ctx, 1, token.Id); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	f(readOnly, ScopeRead, ErrAccessTokenInvalid)
+}
+
+func TestProtectedAPIRouteMiddleware(t *testing.T) {
+	as, fakeQuerier := givenAccessTokenService()
+	ctx := context.Background()
+
+	token, err := as.CreateAccessToken(ctx, 1, "read-only", []string{ScopeRead}, time.Hour)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := GetSessionUserFrom(r.Context()); !ok {
+			t.Fatalf("expected user in context")
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	f := func(h http.Handler, method, authorization string, expectStatus int) {
+		t.Helper()
+
+		req := httptest.NewRequest(method, "/todos", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != expectStatus {
+			t.Fatalf("unexpected status; got %d; want %d", rr.Code, expectStatus)
+		}
+	}
+
+	api := as.ProtectedAPIRouteMiddleware(handler)
+
+	// read with a read token
+	f(api, http.MethodGet, "Bearer "+token, http.StatusOK)
+
+	// write with a read token
+	f(api, http.MethodPost, "Bearer "+token, http.StatusForbidden)
+
+	// invalid token does not fall back to the session
+	f(api, http.MethodGet, "Bearer "+accessTokenPrefix+"invalid", http.StatusUnauthorized)
+
+	// no credentials
+	f(api, http.MethodGet, "", http.StatusFound)
+
+	// cookie only routes refuse bearer requests
+	f(as.ProtectedRouteMiddleware(handler), http.MethodGet, "Bearer "+token, http.StatusUnauthorized)
+
+	// tokens of a disabled account are refused like invalid ones
+	user := fakeQuerier.Users[1]
+	user.Disabled = 1
+	fakeQuerier.Users[1] = user
+	f(api, http.MethodGet, "Bearer "+token, http.StatusUnauthorized)
+
+	req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, req)
+	if header := rr.Header().Get("WWW-Authenticate"); !strings.Contains(header, `error="invalid_token"`) {
+		t.Fatalf("unexpected WWW-Authenticate header %q", header)
+	}
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+)
+
+// AccountExport is the personal data of a user as downloaded from the account settings
+type AccountExport struct {
+	ExportedAt time.Time         `json:"exported_at"`
+	User       ExportedUser      `json:"user"`
+	Sessions   []ExportedSession `json:"sessions"`
+	Todos      []ExportedTodo    `json:"todos"`
+	Events     []model.AuthEvent `json:"security_events"`
+}
+
+type ExportedUser struct {
+	Id            int64  `json:"id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	CreatedAt     string `json:"created_at"`
+	UpdatedAt     string `json:"updated_at"`
+}
+
+type ExportedSession struct {
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  string    `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type ExportedTodo struct {
+	Id          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	IsComplete  bool   `json:"is_complete"`
+}
+
+// ExportAccount collects the account, its active sessions and its todos.
+// Secrets such as the password hash or session ids are left out.
+func (as *Service) ExportAccount(ctx context.Context, userId int64) (e AccountExport, err error) {
+	defer func() { as.recordEvent(ctx, model.AuthEvent{Type: AuthEventAccountExport, UserId: userId}, err) }()
+
+	user, err := as.queries.GetUserByID(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AccountExport{}, ErrUserNotFound
+	}
+	if err != nil {
+		return AccountExport{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	now := time.Now()
+	sessions, err := as.queries.ListUserSessions(ctx, db.ListUserSessionsParams{
+		UserID:    userId,
+		ExpiresAt: now.Unix(),
+	})
+	if err != nil {
+		return AccountExport{}, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	todos, err := as.queries.GetTodos(ctx, userId)
+	if err != nil {
+		return AccountExport{}, fmt.Errorf("failed to list todos: %w", err)
+	}
+
+	events, err := as.ListAuthEvents(ctx, AuthEventFilter{UserId: userId, Limit: maxAuthEventsLimit})
+	if err != nil {
+		return AccountExport{}, err
+	}
+
+	export := AccountExport{
+		ExportedAt: now.UTC(),
+		User: ExportedUser{
+			Id:            user.ID,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified == 1,
+			CreatedAt:     user.CreatedAt.String,
+			UpdatedAt:     user.UpdatedAt.String,
+		},
+		Sessions: make([]ExportedSession, 0, len(sessions)),
+		Todos:    make([]ExportedTodo, 0, len(todos)),
+		Events:   events,
+	}
+	for _, session := range sessions {
+		export.Sessions = append(export.Sessions, ExportedSession{
+			IPAddress:  session.IpAddress,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  session.CreatedAt.String,
+			LastSeenAt: time.Unix(session.LastSeenAt, 0).UTC(),
+			ExpiresAt:  time.Unix(session.ExpiresAt, 0).UTC(),
+		})
+	}
+	for _, todo := range todos {
+		export.Todos = append(export.Todos, ExportedTodo{
+			Id:          todo.ID,
+			Name:        todo.Name,
+			Description: todo.Description.String,
+			IsComplete:  todo.IsComplete != 0,
+		})
+	}
+
+	return export, nil
+}
+
+// DeleteAccount removes the user and everything attached to it once they proved their password again,
+// and their authenticator code when two-factor authentication is enabled.
+// Every row pointing to the user is removed by the cascading foreign keys, and its audit log by a trigger,
+// only the deletion itself is recorded.
+func (as *Service) DeleteAccount(ctx context.Context, userId int64, password, code string) (err error) {
+	defer func() { as.recordEvent(ctx, model.AuthEvent{Type: AuthEventAccountDelete, UserId: userId}, err) }()
+
+	user, err := as.queries.GetUserByID(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	// Accounts created through an OAuth provider have no password to prove until they reset it
+	if user.PasswordHash == "" {
+		return ErrReauthenticationFailed
+	}
+	validPassword, err := as.verifyPassword(ctx, user.PasswordHash, password)
+	if err != nil {
+		return err
+	}
+	if !validPassword {
+		return ErrReauthenticationFailed
+	}
+
+	credential, err := as.queries.GetTOTPCredential(ctx, userId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to get totp credential: %w", err)
+	}
+	if err == nil && credential.Enabled == 1 {
+		validCode, err := as.verifyTOTP(ctx, credential, code)
+		if err != nil {
+			return err
+		}
+		if !validCode {
+			return ErrReauthenticationFailed
+		}
+	}
+
+	err = as.queries.InTx(ctx, func(q db.Querier) error {
+		if err := q.DeleteLoginAttempt(ctx, normalizeEmail(user.Email)); err != nil {
+			return err
+		}
+		deleted, err := q.DeleteUser(ctx, userId)
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return ErrUserNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete account: %w", err)
+	}
+
+	slog.Info("account deleted", "userId", userId)
+	return nil
+}
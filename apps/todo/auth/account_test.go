@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/store"
+	"github.com/AltSoyuz/soy-experiments/lib/argon2id"
+)
+
+func givenAccountWithData(t *testing.T, fakeQuerier *store.FakeQuerier, as *Service, userId int64, email string) {
+	t.Helper()
+
+	hash, err := argon2id.Hash("Str0ngP@ssw0rd!")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	fakeQuerier.Users[userId] = db.User{ID: userId, Email: email, PasswordHash: hash, EmailVerified: 1}
+	fakeQuerier.Todos[userId*10] = db.Todo{ID: userId * 10, UserID: userId, Name: "todo", Description: sql.NullString{String: "details", Valid: true}}
+	fakeQuerier.OAuthAccounts[userId] = db.OauthAccount{ID: userId, UserID: userId, Provider: "github"}
+	fakeQuerier.EmailVerificationRequests[userId] = db.EmailVerificationRequest{UserID: userId}
+	fakeQuerier.PasswordResetRequests[userId] = db.PasswordResetRequest{ID: userId, UserID: userId}
+
+	ctx := context.Background()
+	if _, err := as.createSession(ctx, userId, ClientInfo{IPAddress: "192.0.2.1", UserAgent: "test-agent"}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := as.CreateAccessToken(ctx, userId, "script", []string{ScopeRead}, time.Hour); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+}
+
+func TestExportAccount(t *testing.T) {
+	fakeQuerier := store.NewFakeQuerier()
+	as := Init(givenTestConfig(), fakeQuerier)
+	givenAccountWithData(t, fakeQuerier, as, 1, "user@example.com")
+	ctx := context.Background()
+
+	export, err := as.ExportAccount(ctx, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if export.User.Email != "user@example.com" || !export.User.EmailVerified {
+		t.Fatalf("unexpected user %+v", export.User)
+	}
+	if len(export.Sessions) != 1 || export.Sessions[0].UserAgent != "test-agent" {
+		t.Fatalf("unexpected sessions %+v", export.Sessions)
+	}
+	if len(export.Todos) != 1 || export.Todos[0].Description != "details" {
+		t.Fatalf("unexpected todos %+v", export.Todos)
+	}
+
+	data, err := json.Marshal(export)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if strings.Contains(string(data), fakeQuerier.Users[1].PasswordHash) {
+		t.Fatalf("expected the password hash to be left out")
+	}
+	for id := range fakeQuerier.Sessions {
+		if strings.Contains(string(data), id) {
+			t.Fatalf("expected session ids to be left out")
+		}
+	}
+
+	if _, err := as.ExportAccount(ctx, 2); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got: %v", err)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	ctx := context.Background()
+
+	f := func(password string, expect error) {
+		t.Helper()
+
+		fakeQuerier := store.NewFakeQuerier()
+		as := Init(givenTestConfig(), fakeQuerier)
+		givenAccountWithData(t, fakeQuerier, as, 1, "user@example.com")
+		givenAccountWithData(t, fakeQuerier, as, 2, "other@example.com")
+
+		err := as.DeleteAccount(ctx, 1, password, "")
+		if !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+		if expect != nil {
+			if _, ok := fakeQuerier.Users[1]; !ok {
+				t.Fatalf("expected the user to remain")
+			}
+			return
+		}
+
+		if _, ok := fakeQuerier.Users[1]; ok {
+			t.Fatalf("expected the user to be deleted")
+		}
+		for _, table := range []int{
+			len(fakeQuerier.Todos),
+			len(fakeQuerier.Sessions),
+			len(fakeQuerier.OAuthAccounts),
+			len(fakeQuerier.EmailVerificationRequests),
+			len(fakeQuerier.PasswordResetRequests),
+			len(fakeQuerier.AccessTokens),
+		} {
+			if table != 1 {
+				t.Fatalf("expected only the rows of the other user to remain, got %d", table)
+			}
+		}
+		if _, ok := fakeQuerier.Users[2]; !ok {
+			t.Fatalf("expected the other user to remain")
+		}
+	}
+
+	// wrong password
+	f("wrong-password", ErrReauthenticationFailed)
+
+	// valid password
+	f("Str0ngP@ssw0rd!", nil)
+}
+
+func TestDeleteAccountWithTwoFactor(t *testing.T) {
+	as, fakeQuerier, secret := givenTOTPUser(t)
+	ctx := context.Background()
+
+	if err := as.DeleteAccount(ctx, 1, "Str0ngP@ssw0rd!", ""); !errors.Is(err, ErrReauthenticationFailed) {
+		t.Fatalf("expected ErrReauthenticationFailed without a code, got: %v", err)
+	}
+	if err := as.DeleteAccount(ctx, 1, "Str0ngP@ssw0rd!", givenTOTPCode(t, secret)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(fakeQuerier.Users) != 0 || len(fakeQuerier.TOTPCredentials) != 0 {
+		t.Fatalf("expected the user and its authenticator to be deleted")
+	}
+}
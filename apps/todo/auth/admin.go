@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+)
+
+// UserFilter selects the accounts listed in the admin console.
+// Query matches any part of the email, and After is the id the page starts after.
+type UserFilter struct {
+	Query string
+	After int64
+	Limit int
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// IsAdmin reports whether the user can manage every account and read their events
+func (as *Service) IsAdmin(user model.User) bool {
+	return user.Role == model.RoleAdmin
+}
+
+// PromoteConfiguredAdmins gives the admin role to the verified accounts of the configured admin emails.
+// It runs at startup, accounts verifying their email later are promoted by VerifyEmail.
+func (as *Service) PromoteConfiguredAdmins(ctx context.Context) error {
+	for _, email := range as.Config.AdminEmails {
+		if err := as.promoteConfiguredAdmin(ctx, email); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promoteConfiguredAdmin gives the admin role to the account of the email if it is a configured admin email
+func (as *Service) promoteConfiguredAdmin(ctx context.Context, email string) error {
+	email = normalizeEmail(email)
+	if !slices.Contains(as.Config.AdminEmails, email) {
+		return nil
+	}
+
+	promoted, err := as.queries.SetUserRoleByEmail(ctx, db.SetUserRoleByEmailParams{Role: model.RoleAdmin, Email: email})
+	if err != nil {
+		return fmt.Errorf("failed to promote admin: %w", err)
+	}
+	if promoted > 0 {
+		slog.Info("configured admin promoted", "email", email)
+	}
+	return nil
+}
+
+// ListUsers returns the accounts matching the filter in the order they were created
+func (as *Service) ListUsers(ctx context.Context, filter UserFilter) ([]model.UserSummary, error) {
+	limit := filter.Limit
+	if limit <= 0 || limit > adminUsersPageSize {
+		limit = adminUsersPageSize
+	}
+
+	rows, err := as.queries.ListUsers(ctx, db.ListUsersParams{
+		Pattern: "%" + likeEscaper.Replace(strings.TrimSpace(filter.Query)) + "%",
+		AfterID: filter.After,
+		Limit:   int64(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	users := make([]model.UserSummary, 0, len(rows))
+	for _, row := range rows {
+		users = append(users, model.UserSummary{
+			Id:            row.ID,
+			Email:         row.Email,
+			Role:          row.Role,
+			EmailVerified: row.EmailVerified == 1,
+			Disabled:      row.Disabled == 1,
+			TodoCount:     row.TodoCount,
+			CreatedAt:     row.CreatedAt.String,
+		})
+	}
+	return users, nil
+}
+
+// GetUserSummary returns a single account as listed in the admin console
+func (as *Service) GetUserSummary(ctx context.Context, userId int64) (model.UserSummary, error) {
+	user, err := as.queries.GetUserByID(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.UserSummary{}, ErrUserNotFound
+	}
+	if err != nil {
+		return model.UserSummary{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	todoCount, err := as.queries.CountUserTodos(ctx, userId)
+	if err != nil {
+		return model.UserSummary{}, fmt.Errorf("failed to count todos: %w", err)
+	}
+
+	return model.UserSummary{
+		Id:            user.ID,
+		Email:         user.Email,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified == 1,
+		Disabled:      user.Disabled == 1,
+		TodoCount:     todoCount,
+		CreatedAt:     user.CreatedAt.String,
+	}, nil
+}
+
+// SetUserDisabled disables or re-enables an account on behalf of an admin.
+// Disabling signs the user out everywhere, and their access tokens stop working until it is enabled again.
+func (as *Service) SetUserDisabled(ctx context.Context, admin model.User, userId int64, disabled bool) (err error) {
+	event := model.AuthEvent{Type: AuthEventAccountEnable, UserId: userId, Reason: "by " + admin.Email}
+	if disabled {
+		event.Type = AuthEventAccountDisable
+	}
+	defer func() { as.recordEvent(ctx, event, err) }()
+
+	// An admin locking themselves out would need the sqlite shell to get back in
+	if userId == admin.Id {
+		return ErrCannotManageSelf
+	}
+
+	var flag int64
+	if disabled {
+		flag = 1
+	}
+	err = as.queries.InTx(ctx, func(q db.Querier) error {
+		updated, err := q.SetUserDisabled(ctx, db.SetUserDisabledParams{Disabled: flag, ID: userId})
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return ErrUserNotFound
+		}
+		if disabled {
+			return q.DeleteUserSessions(ctx, userId)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("account disabled state changed by admin", "userId", userId, "disabled", disabled, "adminId", admin.Id)
+	return nil
+}
+
+// ForceVerifyEmail marks the email of an account as verified on behalf of an admin,
+// for users who cannot receive the verification code
+func (as *Service) ForceVerifyEmail(ctx context.Context, admin model.User, userId int64) (err error) {
+	event := model.AuthEvent{Type: AuthEventEmailVerification, UserId: userId, Reason: "forced by " + admin.Email}
+	defer func() { as.recordEvent(ctx, event, err) }()
+
+	user, err := as.queries.GetUserByID(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	event.Email = user.Email
+
+	err = as.queries.InTx(ctx, func(q db.Querier) error {
+		if err := q.SetUserEmailVerified(ctx, userId); err != nil {
+			return err
+		}
+		return q.DeleteUserEmailVerificationRequest(ctx, userId)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	slog.Info("email verification forced by admin", "userId", userId, "adminId", admin.Id)
+	return nil
+}
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+)
+
+func TestIsAdmin(t *testing.T) {
+	as := Init(givenTestConfig(), nil)
+
+	f := func(role string, expect bool) {
+		t.Helper()
+
+		if got := as.IsAdmin(model.User{Email: "user@example.com", Role: role}); got != expect {
+			t.Fatalf("unexpected result for role %q; got %v; want %v", role, got, expect)
+		}
+	}
+
+	f(model.RoleAdmin, true)
+	f(model.RoleUser, false)
+	f("", false)
+}
+
+func TestRequireRole(t *testing.T) {
+	as := Init(givenTestConfig(), nil)
+	handler := as.RequireRole(model.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	f := func(user *model.User, expectStatus int) {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		if user != nil {
+			req = req.WithContext(context.WithValue(req.Context(), UserContextKey, *user))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != expectStatus {
+			t.Fatalf("unexpected status; got %d; want %d", rec.Code, expectStatus)
+		}
+	}
+
+	// not signed in
+	f(nil, http.StatusUnauthorized)
+
+	// regular user
+	f(&model.User{Id: 1, Role: model.RoleUser}, http.StatusForbidden)
+
+	// admin
+	f(&model.User{Id: 1, Role: model.RoleAdmin}, http.StatusNoContent)
+}
+
+func TestPromoteConfiguredAdmins(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	as.Config.AdminEmails = []string{"user@example.com", "pending@example.com"}
+	fakeQuerier.Users[2] = db.User{ID: 2, Email: "Pending@Example.com", Role: model.RoleUser}
+	ctx := context.Background()
+
+	if err := as.PromoteConfiguredAdmins(ctx); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if fakeQuerier.Users[1].Role != model.RoleAdmin {
+		t.Fatalf("expected the verified account to be promoted")
+	}
+	// Registering a configured email is not enough, it must be verified first
+	if fakeQuerier.Users[2].Role != model.RoleUser {
+		t.Fatalf("expected the unverified account not to be promoted")
+	}
+
+	if err := as.CreateAndSendVerificationEmail(ctx, 2, "Pending@Example.com"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	token, err := as.createSession(ctx, 2, ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := as.VerifyEmail(ctx, token, TestEmailVerificationCode); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if fakeQuerier.Users[2].Role != model.RoleAdmin {
+		t.Fatalf("expected the account to be promoted once verified")
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	fakeQuerier.Users[2] = db.User{ID: 2, Email: "alice@example.org"}
+	fakeQuerier.Users[3] = db.User{ID: 3, Email: "bob@example.org", Disabled: 1}
+	fakeQuerier.Todos[1] = db.Todo{ID: 1, UserID: 2, Name: "first"}
+	fakeQuerier.Todos[2] = db.Todo{ID: 2, UserID: 2, Name: "second"}
+	ctx := context.Background()
+
+	f := func(filter UserFilter, expectIds ...int64) []model.UserSummary {
+		t.Helper()
+
+		users, err := as.ListUsers(ctx, filter)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if len(users) != len(expectIds) {
+			t.Fatalf("unexpected users %+v; want ids %v", users, expectIds)
+		}
+		for i, user := range users {
+			if user.Id != expectIds[i] {
+				t.Fatalf("unexpected users %+v; want ids %v", users, expectIds)
+			}
+		}
+		return users
+	}
+
+	// everyone
+	users := f(UserFilter{}, 1, 2, 3)
+	if !users[0].EmailVerified || users[1].TodoCount != 2 || !users[2].Disabled {
+		t.Fatalf("unexpected summaries %+v", users)
+	}
+
+	// search by part of the email
+	f(UserFilter{Query: "EXAMPLE.ORG"}, 2, 3)
+	f(UserFilter{Query: "nobody"})
+
+	// pages
+	f(UserFilter{Limit: 2}, 1, 2)
+	f(UserFilter{After: 2, Limit: 2}, 3)
+}
+
+func TestSetUserDisabled(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	admin := model.User{Id: 2, Email: "admin@example.com", Role: model.RoleAdmin}
+	ctx := context.Background()
+
+	laptop := ClientInfo{IPAddress: "203.0.113.42", UserAgent: firefoxLinux, DeviceToken: testDeviceOne}
+	_, token, err := as.AuthenticateWithPassword(ctx, "user@example.com", "Str0ngP@ssw0rd!", laptop)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	// Disabling signs the user out and keeps them out
+	if err := as.SetUserDisabled(ctx, admin, 1, true); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(fakeQuerier.Sessions) != 0 {
+		t.Fatalf("expected the sessions to be revoked, got %d", len(fakeQuerier.Sessions))
+	}
+	if _, _, err := as.validateSession(ctx, token); err == nil {
+		t.Fatalf("expected the session to be invalid")
+	}
+
+	// The right password on a new device has no side effects for a disabled account
+	if _, _, err := as.AuthenticateWithPassword(ctx, "user@example.com", "wrong password", laptop); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got: %v", err)
+	}
+	phone := ClientInfo{IPAddress: "198.51.100.7", UserAgent: safariIPhone, DeviceToken: testDeviceTwo}
+	if _, _, err := as.AuthenticateWithPassword(ctx, "user@example.com", "Str0ngP@ssw0rd!", phone); !errors.Is(err, ErrAccountDisabled) {
+		t.Fatalf("expected ErrAccountDisabled, got: %v", err)
+	}
+	if len(fakeQuerier.Sessions) != 0 {
+		t.Fatalf("expected no session for a disabled account, got %d", len(fakeQuerier.Sessions))
+	}
+	if len(fakeQuerier.KnownDevices) != 1 {
+		t.Fatalf("expected the new device not to be recorded, got %d devices", len(fakeQuerier.KnownDevices))
+	}
+	if _, ok := fakeQuerier.LoginAttempts["user@example.com"]; !ok {
+		t.Fatalf("expected the failed attempts to be kept")
+	}
+	if _, err := as.createSession(ctx, 1, phone); !errors.Is(err, ErrAccountDisabled) {
+		t.Fatalf("expected ErrAccountDisabled, got: %v", err)
+	}
+	if len(fakeQuerier.KnownDevices) != 1 {
+		t.Fatalf("expected the new device not to be recorded, got %d devices", len(fakeQuerier.KnownDevices))
+	}
+
+	// Enabling lets them sign in again
+	if err := as.SetUserDisabled(ctx, admin, 1, false); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, _, err := as.AuthenticateWithPassword(ctx, "user@example.com", "Str0ngP@ssw0rd!", ClientInfo{}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	// Admins cannot lock themselves out
+	if err := as.SetUserDisabled(ctx, admin, admin.Id, true); !errors.Is(err, ErrCannotManageSelf) {
+		t.Fatalf("expected ErrCannotManageSelf, got: %v", err)
+	}
+	if err := as.SetUserDisabled(ctx, admin, 42, true); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got: %v", err)
+	}
+
+	events, err := as.ListAuthEvents(ctx, AuthEventFilter{UserId: 1, Type: AuthEventAccountDisable})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(events) != 1 || events[0].Reason != "by admin@example.com" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
+
+func TestForceVerifyEmail(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	admin := model.User{Id: 2, Email: "admin@example.com", Role: model.RoleAdmin}
+	fakeQuerier.Users[3] = db.User{ID: 3, Email: "stuck@example.com"}
+	ctx := context.Background()
+
+	if err := as.CreateAndSendVerificationEmail(ctx, 3, "stuck@example.com"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := as.ForceVerifyEmail(ctx, admin, 3); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if fakeQuerier.Users[3].EmailVerified != 1 {
+		t.Fatalf("expected the email to be verified")
+	}
+	if _, ok := fakeQuerier.EmailVerificationRequests[3]; ok {
+		t.Fatalf("expected the pending code to be removed")
+	}
+
+	if err := as.ForceVerifyEmail(ctx, admin, 42); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got: %v", err)
+	}
+}
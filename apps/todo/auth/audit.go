@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+)
+
+// Types of the authentication events recorded in the audit log
+const (
+	AuthEventRegister             = "register"
+	AuthEventVerificationEmail    = "verification_email"
+	AuthEventEmailVerification    = "email_verification"
+	AuthEventLogin                = "login"
+	AuthEventLoginUnlock          = "login_unlock"
+	AuthEventMagicLinkRequest     = "magic_link_request"
+	AuthEventTwoFactor            = "two_factor"
+	AuthEventLogout               = "logout"
+	AuthEventPasswordResetRequest = "password_reset_request"
+	AuthEventPasswordReset        = "password_reset"
+	AuthEventEmailChangeRequest   = "email_change_request"
+	AuthEventEmailChange          = "email_change"
+	AuthEventEmailChangeCancel    = "email_change_cancel"
+	AuthEventTOTPEnrollment       = "totp_enrollment"
+	AuthEventTOTPDisable          = "totp_disable"
+	AuthEventRecoveryCodes        = "recovery_codes"
+	AuthEventSessionRevoke        = "session_revoke"
+	AuthEventAccessTokenCreate    = "access_token_create"
+	AuthEventAccessTokenRevoke    = "access_token_revoke"
+	AuthEventAccountExport        = "account_export"
+	AuthEventAccountDelete        = "account_delete"
+	AuthEventAccountDisable       = "account_disable"
+	AuthEventAccountEnable        = "account_enable"
+	AuthEventImpersonationStart   = "impersonation_start"
+	AuthEventImpersonationStop    = "impersonation_stop"
+	AuthEventInvitation           = "invitation"
+	AuthEventInvitationRevoke     = "invitation_revoke"
+	AuthEventPasskeyAdd           = "passkey_add"
+	AuthEventPasskeyRemove        = "passkey_remove"
+	AuthEventNewDevice            = "new_device"
+	AuthEventDeviceReport         = "device_report"
+	AuthEventDeviceRemove         = "device_remove"
+)
+
+// Outcomes of an authentication event
+const (
+	AuthOutcomeSuccess = "success"
+	AuthOutcomeFailure = "failure"
+)
+
+const clientInfoContextKey contextKey = "client"
+
+// AuthEventFilter selects the events returned by ListAuthEvents, zero values match every event.
+// Before only keeps events with a lower id, to page through results from the most recent one.
+type AuthEventFilter struct {
+	UserId    int64
+	Email     string
+	Type      string
+	Outcome   string
+	IPAddress string
+	Since     time.Time
+	Until     time.Time
+	Before    int64
+	Limit     int
+}
+
+// ClientInfoMiddleware attaches the client of the request to its context,
+// so that the events recorded while serving it know where it came from
+func ClientInfoMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithClientInfo(r.Context(), ClientInfoFrom(r))
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// WithClientInfo returns a copy of ctx carrying the client
+func WithClientInfo(ctx context.Context, client ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoContextKey, client)
+}
+
+func clientInfoFromContext(ctx context.Context) ClientInfo {
+	client, _ := ctx.Value(clientInfoContextKey).(ClientInfo)
+	return client
+}
+
+// recordEvent adds an event to the audit log. The event is a failure when err is set,
+// and err is appended to its reason, which otherwise tells how the operation was done, such as the login method.
+// The operation already happened, so an event that cannot be written is logged instead of failing it.
+func (as *Service) recordEvent(ctx context.Context, event model.AuthEvent, err error) {
+	event.Outcome = AuthOutcomeSuccess
+	if err != nil {
+		event.Outcome = AuthOutcomeFailure
+		if event.Reason == "" {
+			event.Reason = err.Error()
+		} else {
+			event.Reason += ": " + err.Error()
+		}
+	}
+
+	client := clientInfoFromContext(ctx)
+	writeErr := as.queries.CreateAuthEvent(context.WithoutCancel(ctx), db.CreateAuthEventParams{
+		EventType: event.Type,
+		UserID:    sql.NullInt64{Int64: event.UserId, Valid: event.UserId != 0},
+		Email:     normalizeEmail(event.Email),
+		Outcome:   event.Outcome,
+		Reason:    event.Reason,
+		IpAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+		CreatedAt: time.Now().Unix(),
+	})
+	if writeErr != nil {
+		slog.Error("failed to record auth event", "type", event.Type, "userId", event.UserId, "error", writeErr)
+	}
+}
+
+// ListUserAuthEvents returns the most recent events of the user, for their security activity page
+func (as *Service) ListUserAuthEvents(ctx context.Context, userId int64) ([]model.AuthEvent, error) {
+	rows, err := as.queries.ListUserAuthEvents(ctx, db.ListUserAuthEventsParams{
+		UserID: sql.NullInt64{Int64: userId, Valid: true},
+		Limit:  userAuthEventsLimit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list auth events: %w", err)
+	}
+	return authEventsFrom(rows), nil
+}
+
+// ListAuthEvents returns the events of every user matching the filter, most recent first
+func (as *Service) ListAuthEvents(ctx context.Context, filter AuthEventFilter) ([]model.AuthEvent, error) {
+	params := db.ListAuthEventsParams{
+		UserID:    sql.NullInt64{Int64: filter.UserId, Valid: filter.UserId != 0},
+		Email:     sql.NullString{String: normalizeEmail(filter.Email), Valid: filter.Email != ""},
+		EventType: sql.NullString{String: filter.Type, Valid: filter.Type != ""},
+		Outcome:   sql.NullString{String: filter.Outcome, Valid: filter.Outcome != ""},
+		IpAddress: sql.NullString{String: filter.IPAddress, Valid: filter.IPAddress != ""},
+		Until:     math.MaxInt64,
+		BeforeID:  math.MaxInt64,
+		Limit:     defaultAuthEventsLimit,
+	}
+	if !filter.Since.IsZero() {
+		params.Since = filter.Since.Unix()
+	}
+	if !filter.Until.IsZero() {
+		params.Until = filter.Until.Unix()
+	}
+	if filter.Before > 0 {
+		params.BeforeID = filter.Before
+	}
+	if filter.Limit > 0 {
+		params.Limit = int64(min(filter.Limit, maxAuthEventsLimit))
+	}
+
+	rows, err := as.queries.ListAuthEvents(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list auth events: %w", err)
+	}
+	return authEventsFrom(rows), nil
+}
+
+// PruneAuthEvents deletes the events older than the configured retention
+func (as *Service) PruneAuthEvents(ctx context.Context) (int64, error) {
+	retention := time.Duration(as.Config.AuthEventRetentionDays) * 24 * time.Hour
+	deleted, err := as.queries.DeleteAuthEventsBefore(ctx, time.Now().Add(-retention).Unix())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune auth events: %w", err)
+	}
+	return deleted, nil
+}
+
+// RunAuthEventRetention prunes old events right away and then periodically until ctx is done
+func (as *Service) RunAuthEventRetention(ctx context.Context) {
+	ticker := time.NewTicker(authEventPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := as.PruneAuthEvents(ctx)
+		if err != nil {
+			slog.Error("failed to prune auth events", "error", err)
+		} else if deleted > 0 {
+			slog.Info("pruned auth events", "deleted", deleted, "retentionDays", as.Config.AuthEventRetentionDays)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func authEventsFrom(rows []db.AuthEvent) []model.AuthEvent {
+	events := make([]model.AuthEvent, 0, len(rows))
+	for _, row := range rows {
+		events = append(events, model.AuthEvent{
+			Id:        row.ID,
+			Type:      row.EventType,
+			UserId:    row.UserID.Int64,
+			Email:     row.Email,
+			Outcome:   row.Outcome,
+			Reason:    row.Reason,
+			IPAddress: row.IpAddress,
+			UserAgent: row.UserAgent,
+			CreatedAt: time.Unix(row.CreatedAt, 0).UTC(),
+		})
+	}
+	return events
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+)
+
+func TestRecordLoginEvents(t *testing.T) {
+	as, _ := givenPasswordUser(t)
+	ctx := WithClientInfo(context.Background(), ClientInfo{IPAddress: "203.0.113.7", UserAgent: "test-agent"})
+
+	as.AuthenticateWithPassword(ctx, "user@example.com", "wrong-password", ClientInfo{})
+	as.AuthenticateWithPassword(ctx, "unknown@example.com", "wrong-password", ClientInfo{})
+	if _, _, err := as.AuthenticateWithPassword(ctx, "user@example.com", "Str0ngP@ssw0rd!", ClientInfo{}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	// The user only sees the events of their account, most recent first
+	events, err := as.ListUserAuthEvents(ctx, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %+v", events)
+	}
+	success, failure := events[0], events[1]
+	if success.Type != AuthEventLogin || success.Outcome != AuthOutcomeSuccess || success.Reason != "password" {
+		t.Fatalf("unexpected success event %+v", success)
+	}
+	if failure.Outcome != AuthOutcomeFailure || failure.Reason != "password: "+ErrInvalidCredentials.Error() {
+		t.Fatalf("unexpected failure event %+v", failure)
+	}
+	if failure.IPAddress != "203.0.113.7" || failure.UserAgent != "test-agent" || failure.Email != "user@example.com" {
+		t.Fatalf("expected the client to be recorded, got %+v", failure)
+	}
+
+	// Unknown emails are kept for admins without being tied to an account
+	unknown, err := as.ListAuthEvents(ctx, AuthEventFilter{Email: "Unknown@Example.com"})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(unknown) != 1 || unknown[0].UserId != 0 || unknown[0].Reason != "unknown email: "+ErrInvalidCredentials.Error() {
+		t.Fatalf("unexpected events for the unknown email %+v", unknown)
+	}
+}
+
+func TestListAuthEvents(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	for i, event := range []model.AuthEvent{
+		{Type: AuthEventLogin, UserId: 1, Email: "user@example.com"},
+		{Type: AuthEventLogout, UserId: 1},
+		{Type: AuthEventRegister, UserId: 2, Email: "other@example.com"},
+		{Type: AuthEventLogin, UserId: 2, Email: "other@example.com"},
+	} {
+		var err error
+		if i == 0 {
+			err = ErrInvalidCredentials
+		}
+		as.recordEvent(ctx, event, err)
+	}
+	// the first event happened a while ago
+	first := fakeQuerier.AuthEvents[1]
+	first.CreatedAt = now.Add(-time.Hour).Unix()
+	fakeQuerier.AuthEvents[1] = first
+
+	f := func(filter AuthEventFilter, expectIDs ...int64) {
+		t.Helper()
+
+		events, err := as.ListAuthEvents(ctx, filter)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		var got []int64
+		for _, event := range events {
+			got = append(got, event.Id)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(expectIDs) {
+			t.Fatalf("unexpected events; got %v; want %v", got, expectIDs)
+		}
+	}
+
+	f(AuthEventFilter{}, 4, 3, 2, 1)
+	f(AuthEventFilter{UserId: 1}, 2, 1)
+	f(AuthEventFilter{Type: AuthEventLogin}, 4, 1)
+	f(AuthEventFilter{Outcome: AuthOutcomeFailure}, 1)
+	f(AuthEventFilter{Email: "other@example.com", Type: AuthEventRegister}, 3)
+	f(AuthEventFilter{Since: now.Add(-time.Minute)}, 4, 3, 2)
+	f(AuthEventFilter{Until: now.Add(-time.Minute)}, 1)
+	f(AuthEventFilter{Before: 4, Limit: 2}, 3, 2)
+}
+
+func TestPruneAuthEvents(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	as.Config.AuthEventRetentionDays = 30
+	ctx := context.Background()
+
+	now := time.Now()
+	fakeQuerier.AuthEvents[1] = db.AuthEvent{ID: 1, EventType: AuthEventLogin, CreatedAt: now.Add(-31 * 24 * time.Hour).Unix()}
+	fakeQuerier.AuthEvents[2] = db.AuthEvent{ID: 2, EventType: AuthEventLogin, CreatedAt: now.Add(-29 * 24 * time.Hour).Unix()}
+
+	deleted, err := as.PruneAuthEvents(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("expected 1 event to be pruned, got %d", deleted)
+	}
+	if _, kept := fakeQuerier.AuthEvents[2]; !kept {
+		t.Fatalf("expected events within the retention to be kept")
+	}
+}
+
+func TestDeleteAccountRemovesAuthEvents(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	ctx := context.Background()
+
+	as.AuthenticateWithPassword(ctx, "user@example.com", "Str0ngP@ssw0rd!", ClientInfo{})
+	if err := as.DeleteAccount(ctx, 1, "Str0ngP@ssw0rd!", ""); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	// Only the deletion itself remains
+	if len(fakeQuerier.AuthEvents) != 1 {
+		t.Fatalf("expected the events of the user to be deleted, got %+v", fakeQuerier.AuthEvents)
+	}
+	for _, event := range fakeQuerier.AuthEvents {
+		if event.EventType != AuthEventAccountDelete || event.UserID != (sql.NullInt64{Int64: 1, Valid: true}) {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	}
+}
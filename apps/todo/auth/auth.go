@@ -2,27 +2,120 @@ package auth
 
 import (
 	"context"
+	"crypto/cipher"
 	"errors"
 	"net/http"
 	"time"
 
 	"github.com/AltSoyuz/soy-experiments/apps/todo/config"
-	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/store"
+	"github.com/AltSoyuz/soy-experiments/lib/argon2id"
+	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
 	"github.com/AltSoyuz/soy-experiments/lib/ratelimit"
 )
 
 var (
-	ErrWeakPassword           = errors.New("password too weak or compromised")
-	ErrSessionExpired         = errors.New("session expired")
-	ErrSessionInvalid         = errors.New("invalid session")
-	TestEmailVerificationCode = "12345678"
+	ErrWeakPassword             = errors.New("password too weak or compromised")
+	ErrSessionExpired           = errors.New("session expired")
+	ErrSessionInvalid           = errors.New("invalid session")
+	ErrSessionNotFound          = errors.New("session not found")
+	ErrInvalidResetCode         = errors.New("invalid or expired password reset code")
+	ErrOAuthProviderUnknown     = errors.New("unknown oauth provider")
+	ErrOAuthStateMismatch       = errors.New("invalid oauth state")
+	ErrOAuthEmailNotVerified    = errors.New("the provider did not confirm a verified email address")
+	ErrOAuthAccountUnverified   = errors.New("an unverified account already uses this email, sign in with your password and verify it first")
+	ErrInvalidTOTPCode          = errors.New("invalid authentication code")
+	ErrTOTPAlreadyEnabled       = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled           = errors.New("two-factor authentication is not enabled")
+	ErrReauthenticationFailed   = errors.New("invalid password or authentication code")
+	ErrInvalidRecoveryCode      = errors.New("invalid recovery code")
+	ErrTwoFactorLocked          = errors.New("too many wrong codes, sign in again")
+	ErrAccessTokenInvalid       = errors.New("invalid access token")
+	ErrAccessTokenExpired       = errors.New("access token expired")
+	ErrAccessTokenNotFound      = errors.New("access token not found")
+	ErrAccessTokenScope         = errors.New("access token does not have the required scope")
+	ErrAccessTokenName          = errors.New("access token name must be between 1 and 64 characters")
+	ErrAccessTokenScopes        = errors.New("access token scopes must be read and/or write")
+	ErrAccessTokenLifetime      = errors.New("access token must expire within a year")
+	ErrInvalidMagicLink         = errors.New("this sign-in link is invalid, expired or was opened in another browser")
+	ErrInvalidEmail             = errors.New("invalid email address")
+	ErrEmailUnchanged           = errors.New("this is already your email address")
+	ErrEmailTaken               = errors.New("this email address is already used by another account")
+	ErrInvalidEmailChangeCode   = errors.New("invalid or expired email change code")
+	ErrInvalidEmailChangeLink   = errors.New("this link is invalid, expired or was already used")
+	ErrEmailChangeCodeLocked    = errors.New("too many wrong codes, request the email change again")
+	ErrUserNotFound             = errors.New("user not found")
+	ErrInvalidCredentials       = errors.New("invalid email or password")
+	ErrInvalidUnlockLink        = errors.New("this unlock link is invalid or was already used")
+	ErrPasswordCheckUnavailable = errors.New("the password could not be checked against breached passwords, try again later")
+	ErrInvalidVerificationCode  = errors.New("invalid email verification code")
+	ErrVerificationCodeExpired  = errors.New("email verification code expired, request a new one")
+	ErrVerificationCodeLocked   = errors.New("too many wrong codes, request a new one")
+	ErrEmailAlreadyVerified     = errors.New("email address is already verified")
+	ErrAccountDisabled          = errors.New("this account is disabled")
+	ErrCannotManageSelf         = errors.New("admins cannot disable their own account")
+	ErrCannotImpersonate        = errors.New("only other verified and enabled accounts without the admin role can be impersonated")
+	ErrImpersonationForbidden   = errors.New("this action is not allowed while impersonating a user")
+	ErrNotImpersonating         = errors.New("this session is not impersonating a user")
+	ErrRegistrationClosed       = errors.New("registration is closed")
+	ErrInvitationRequired       = errors.New("registration is by invitation only, ask someone with an account to invite you")
+	ErrInvalidInvitation        = errors.New("this invitation is invalid, expired or for another email address")
+	ErrInvitationNotFound       = errors.New("invitation not found")
+	ErrEmailDomainNotAllowed    = errors.New("accounts cannot be created with this email domain")
+	ErrTooManyInvitations       = errors.New("too many pending invitations, revoke some or wait for them to be accepted")
+	ErrInvalidPasskey           = errors.New("this passkey could not be verified, try again")
+	ErrPasskeyNotFound          = errors.New("passkey not found")
+	ErrPasskeyName              = errors.New("passkey name must be between 1 and 64 characters")
+	ErrDeviceNotFound           = errors.New("device not found")
+	ErrInvalidDeviceReportLink  = errors.New("this link is invalid, expired or was already used")
+	ErrServerBusy               = errors.New("the server is busy, try again in a moment")
+	ErrPasswordResetRequired    = errors.New("this password must be changed before signing in, use the forgot password link to choose a new one")
+	TestEmailVerificationCode   = "12345678"
+	TestPasswordResetCode       = "test-password-reset-code"
+	TestMagicLinkToken          = "test-magic-link-token"
+	TestEmailChangeCancelCode   = "test-email-change-cancel-code"
+	TestBreachedPassword        = "Br3ached-P@ssw0rd"
+	TestLoginUnlockToken        = "test-login-unlock-token"
+	TestInvitationToken         = "test-invitation-token"
+	TestDeviceReportToken       = "test-device-report-token"
 )
 
 const (
-	minPasswordLength       = 12
-	sessionDuration         = 24 * time.Hour
-	sessionRenewalThreshold = 15 * 24 * time.Hour
-	SessionCookieName       = "session"
+	minPasswordLength          = 12
+	passwordResetDuration      = 15 * time.Minute
+	magicLinkDuration          = 10 * time.Minute
+	emailChangeDuration        = 30 * time.Minute
+	emailChangeRevertDuration  = 7 * 24 * time.Hour
+	twoFactorPendingDuration   = 10 * time.Minute
+	maxTwoFactorAttempts       = 5
+	loginBackoffThreshold      = 3
+	loginLockoutThreshold      = 10
+	loginBackoffBase           = time.Second
+	loginBackoffMax            = time.Minute
+	loginLockoutDuration       = 15 * time.Minute
+	loginFailureWindow         = 24 * time.Hour
+	sessionTouchInterval       = time.Minute
+	maxUserAgentLength         = 512
+	userAuthEventsLimit        = 50
+	defaultAuthEventsLimit     = 100
+	maxAuthEventsLimit         = 1000
+	authEventPruneInterval     = time.Hour
+	adminUsersPageSize         = 50
+	emailVerificationDuration  = 10 * time.Minute
+	maxVerificationAttempts    = 5
+	verificationResendCooldown = time.Minute
+	verificationSendLimit      = 5
+	verificationSendWindow     = 24 * time.Hour
+	impersonationDuration      = 15 * time.Minute
+	invitationDuration         = 7 * 24 * time.Hour
+	maxPendingInvitations      = 10
+	passkeyChallengeDuration   = 5 * time.Minute
+	maxPasskeyNameLength       = 64
+	deviceCookieDuration       = 365 * 24 * time.Hour
+	deviceReportDuration       = 7 * 24 * time.Hour
+	SessionCookieName          = "session"
+	ImpersonatorCookieName     = "impersonator_session"
+	DeviceCookieName           = "device"
 )
 
 type contextKey string
@@ -31,33 +124,61 @@ const UserContextKey contextKey = "user"
 
 type Service struct {
 	Config                     *config.Config
-	queries                    db.Querier
+	queries                    store.Querier
 	LimitLoginMiddleware       func(http.Handler) http.HandlerFunc
 	LimitRegisterMiddleware    func(http.Handler) http.HandlerFunc
 	LimitVerifyEmailMiddleware func(http.Handler) http.HandlerFunc
+	LimitResetMiddleware       func(http.Handler) http.HandlerFunc
+	LimitTwoFactorMiddleware   func(http.Handler) http.HandlerFunc
+	oauthProviders             map[string]*oauthProvider
+	totpSecrets                cipher.AEAD
+	passwordChecker            PasswordChecker
+	passwordHasher             *argon2id.Limiter
+	dummyPasswordHash          func() string
 }
 
-func Init(config *config.Config, queries db.Querier) *Service {
+func Init(config *config.Config, queries store.Querier) *Service {
 	loginLimiter := ratelimit.With(5, time.Minute)
 	registerLimiter := ratelimit.With(5, time.Minute)
 	verifyEmailLimiter := ratelimit.With(5, time.Minute)
+	resetLimiter := ratelimit.With(5, time.Minute)
+	twoFactorLimiter := ratelimit.With(5, time.Minute)
+	passwordHasher := newPasswordHasher(config.PasswordHashing)
 	return &Service{
 		Config:                     config,
 		queries:                    queries,
 		LimitLoginMiddleware:       ratelimit.LimitMiddleware(loginLimiter),
 		LimitRegisterMiddleware:    ratelimit.LimitMiddleware(registerLimiter),
 		LimitVerifyEmailMiddleware: ratelimit.LimitMiddleware(verifyEmailLimiter),
+		LimitResetMiddleware:       ratelimit.LimitMiddleware(resetLimiter),
+		LimitTwoFactorMiddleware:   ratelimit.LimitMiddleware(twoFactorLimiter),
+		oauthProviders:             newOAuthProviders(config.OAuthProviders),
+		totpSecrets:                newTOTPSecretsAEAD(config.TOTPEncryptionKey),
+		passwordChecker:            newPasswordChecker(config),
+		passwordHasher:             passwordHasher,
+		dummyPasswordHash:          newDummyPasswordHash(passwordHasher.Params()),
 	}
 }
 
 // ProtectedRouteMiddleware is a middleware that protects routes from unauthorized access
 func (as *Service) ProtectedRouteMiddleware(h http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Bearer requests skip CSRF checks, so they must never fall back to the session cookie
+		if _, ok := httpserver.BearerToken(r); ok {
+			w.Header().Set("WWW-Authenticate", accessTokenAuthHeaderHint)
+			http.Error(w, "access tokens are not accepted here", http.StatusUnauthorized)
+			return
+		}
+
 		token := GetTokenFromCookie(r)
 		if token != "" {
 			session, user, err := as.validateSession(r.Context(), token)
 
-			if err == nil {
+			if err == nil && session.TwoFactorPending {
+				// The password was right but the second factor is still missing
+				http.Redirect(w, r, "/login/two-factor", http.StatusFound)
+				return
+			} else if err == nil {
 				// Store session info in context
 				ctx := context.WithValue(r.Context(), UserContextKey, user)
 				r = r.WithContext(ctx)
@@ -69,7 +190,7 @@ func (as *Service) ProtectedRouteMiddleware(h http.Handler) http.HandlerFunc {
 					http.Redirect(w, r, "/verify-email", http.StatusFound)
 					return
 				}
-			} else if err == ErrSessionExpired || err == ErrSessionInvalid {
+			} else if err == ErrSessionExpired || err == ErrSessionInvalid || err == ErrAccountDisabled {
 				DeleteSessionCookie(w)
 			}
 		} else {
@@ -80,3 +201,22 @@ func (as *Service) ProtectedRouteMiddleware(h http.Handler) http.HandlerFunc {
 		h.ServeHTTP(w, r)
 	})
 }
+
+// RequireRole only lets users with the given role through.
+// It reads the user stored by ProtectedRouteMiddleware, so it must be wrapped by it.
+func (as *Service) RequireRole(role string) func(http.Handler) http.HandlerFunc {
+	return func(h http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			user, ok := GetSessionUserFrom(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if user.Role != role {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+		}
+	}
+}
@@ -11,6 +11,12 @@ func givenTestConfig() *config.Config {
 		SenderEmail: "sender@example.com",
 		SenderPass:  "password",
 		Env:         "test",
+		Session: config.SessionPolicy{
+			IdleTimeout:        config.DefaultSessionIdleTimeout,
+			AbsoluteLifetime:   config.DefaultSessionAbsoluteLifetime,
+			RenewalWindow:      config.DefaultSessionRenewalWindow,
+			RememberMeDuration: config.DefaultSessionRememberMeDuration,
+		},
 	}
 	return c
 }
@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+)
+
+const deviceTokenContextKey contextKey = "device_token"
+
+// deviceTokenLength is the length of the tokens generated by generateTokenSession
+const deviceTokenLength = 43
+
+// userAgentBrowsers and userAgentSystems are matched in order, the first token found in a user agent names it.
+// Browsers built on Chrome mention it too, and phones mention the desktop system they derive from.
+var (
+	userAgentBrowsers = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	}
+	userAgentSystems = []struct{ token, name string }{
+		{"Windows", "Windows"},
+		{"iPhone", "iOS"},
+		{"iPad", "iOS"},
+		{"Android", "Android"},
+		{"CrOS", "ChromeOS"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// DeviceCookieMiddleware makes sure sign-in requests carry a device cookie.
+// Browsers without one get a new random token, which recognizes them the next time they sign in.
+func DeviceCookieMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := GetDeviceTokenFromCookie(r)
+		if token == "" {
+			var err error
+			token, err = generateTokenSession()
+			if err != nil {
+				slog.Error("failed to generate device token", "error", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), deviceTokenContextKey, token))
+		}
+		SetDeviceCookie(w, token)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// GetDeviceTokenFromCookie returns the device token of the request, or an empty string when it has none that looks valid
+func GetDeviceTokenFromCookie(r *http.Request) string {
+	cookie, err := r.Cookie(DeviceCookieName)
+	if err != nil || len(cookie.Value) != deviceTokenLength {
+		return ""
+	}
+	return cookie.Value
+}
+
+// SetDeviceCookie keeps the device token in the browser for a year, it outlives sessions on purpose
+func SetDeviceCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     DeviceCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		// Lax so that the cookie comes along when an OAuth provider redirects back
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Now().Add(deviceCookieDuration),
+	})
+}
+
+// deviceTokenFrom returns the token handed out by DeviceCookieMiddleware for this request, or the one of its cookie
+func deviceTokenFrom(r *http.Request) string {
+	if token, ok := r.Context().Value(deviceTokenContextKey).(string); ok {
+		return token
+	}
+	return GetDeviceTokenFromCookie(r)
+}
+
+// recognizeDevice returns the known device a session is created from, remembering it when it is new.
+// A device is known by its cookie. A new cookie from the same browser and system on the same network gets a device
+// of its own, as another browser may share the fingerprint, but the owner is not emailed about it.
+// The owner is emailed about every other new device except the first one of the account.
+// Clients without a device token, which never went through DeviceCookieMiddleware, are not tracked.
+func (as *Service) recognizeDevice(ctx context.Context, userId int64, client ClientInfo, now time.Time) (sql.NullInt64, error) {
+	if client.DeviceToken == "" {
+		return sql.NullInt64{}, nil
+	}
+	tokenHash := hashToken(client.DeviceToken)
+	fingerprint := deviceFingerprint(client)
+
+	device, err := as.queries.GetKnownDeviceByTokenHash(ctx, db.GetKnownDeviceByTokenHashParams{UserID: userId, TokenHash: tokenHash})
+	if err == nil {
+		err = as.queries.TouchKnownDevice(ctx, db.TouchKnownDeviceParams{
+			TokenHash:  tokenHash,
+			UserAgent:  client.UserAgent,
+			IpAddress:  client.IPAddress,
+			LastSeenAt: now.Unix(),
+			ID:         device.ID,
+		})
+		if err != nil {
+			return sql.NullInt64{}, fmt.Errorf("failed to update known device: %w", err)
+		}
+		return sql.NullInt64{Int64: device.ID, Valid: true}, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return sql.NullInt64{}, fmt.Errorf("failed to get known device: %w", err)
+	}
+
+	var notify bool
+	_, err = as.queries.GetKnownDeviceByFingerprint(ctx, db.GetKnownDeviceByFingerprintParams{UserID: userId, Fingerprint: fingerprint})
+	switch {
+	case err == nil:
+		// Most likely the same browser after its cookie was cleared
+	case errors.Is(err, sql.ErrNoRows):
+		count, err := as.queries.CountUserKnownDevices(ctx, userId)
+		if err != nil {
+			return sql.NullInt64{}, fmt.Errorf("failed to count known devices: %w", err)
+		}
+		notify = count > 0
+	default:
+		return sql.NullInt64{}, fmt.Errorf("failed to get known device: %w", err)
+	}
+
+	params := db.CreateKnownDeviceParams{
+		UserID:      userId,
+		TokenHash:   tokenHash,
+		Fingerprint: fingerprint,
+		UserAgent:   client.UserAgent,
+		IpAddress:   client.IPAddress,
+		CreatedAt:   now.Unix(),
+		LastSeenAt:  now.Unix(),
+	}
+	var user db.User
+	var reportToken string
+	if notify {
+		user, err = as.queries.GetUserByID(ctx, userId)
+		if err != nil {
+			return sql.NullInt64{}, fmt.Errorf("failed to get user: %w", err)
+		}
+		reportToken, err = as.generateDeviceReportToken(user.Email)
+		if err != nil {
+			return sql.NullInt64{}, err
+		}
+		params.ReportTokenHash = sql.NullString{String: hashToken(reportToken), Valid: true}
+		params.ReportExpiresAt = now.Add(deviceReportDuration).Unix()
+	}
+
+	device, err = as.queries.CreateKnownDevice(ctx, params)
+	if err != nil {
+		return sql.NullInt64{}, fmt.Errorf("failed to create known device: %w", err)
+	}
+
+	if notify {
+		as.notifyNewDevice(ctx, user, client, now, reportToken)
+	}
+	return sql.NullInt64{Int64: device.ID, Valid: true}, nil
+}
+
+// notifyNewDevice emails the user about a sign-in from a new device, with a link to report it
+func (as *Service) notifyNewDevice(ctx context.Context, user db.User, client ClientInfo, now time.Time, reportToken string) {
+	as.recordEvent(ctx, model.AuthEvent{
+		Type:   AuthEventNewDevice,
+		UserId: user.ID,
+		Email:  user.Email,
+		Reason: describeUserAgent(client.UserAgent),
+	}, nil)
+
+	link := fmt.Sprintf("%s/login/device-report?code=%s", as.Config.BaseURL, url.QueryEscape(reportToken))
+	go as.sendEmailAsync(EmailParams{
+		To:      []string{user.Email},
+		Subject: "New sign-in to your account",
+		Body: fmt.Sprintf(
+			"Your account was just signed in to from a device we have not seen before.\r\n\r\nTime: %s\r\nDevice: %s\r\nIP address: %s\r\n\r\nIf this was you, you can ignore this email. If it was not, follow this link to sign that device out and choose a new password: %s\r\nThe link expires in %d days.",
+			now.UTC().Format("2006-01-02 15:04 UTC"),
+			describeUserAgent(client.UserAgent),
+			client.IPAddress,
+			link,
+			int(deviceReportDuration.Hours()/24),
+		),
+	})
+
+	slog.Info("sign-in from a new device", "userId", user.ID)
+}
+
+// ReportUnknownDevice handles the link of a new device email the user did not recognize.
+// The sessions of that device are revoked, the device is forgotten and the password stops working for sign-in
+// until it is reset, with the code returned or a later reset request.
+// Other sessions are kept: the link travels by email and must not let whoever holds it sign the owner out everywhere.
+func (as *Service) ReportUnknownDevice(ctx context.Context, token string) (resetCode string, err error) {
+	event := model.AuthEvent{Type: AuthEventDeviceReport}
+	defer func() { as.recordEvent(ctx, event, err) }()
+
+	if token == "" {
+		return "", ErrInvalidDeviceReportLink
+	}
+
+	device, err := as.queries.GetKnownDeviceByReportTokenHash(ctx, sql.NullString{String: hashToken(token), Valid: true})
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrInvalidDeviceReportLink
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get known device: %w", err)
+	}
+	event.UserId = device.UserID
+	event.Reason = describeUserAgent(device.UserAgent)
+
+	now := time.Now()
+	if now.Unix() >= device.ReportExpiresAt {
+		return "", ErrInvalidDeviceReportLink
+	}
+
+	err = as.queries.InTx(ctx, func(q db.Querier) error {
+		err := q.DeleteDeviceSessions(ctx, db.DeleteDeviceSessionsParams{
+			DeviceID: sql.NullInt64{Int64: device.ID, Valid: true},
+			UserID:   device.UserID,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := q.DeleteUserKnownDevice(ctx, db.DeleteUserKnownDeviceParams{ID: device.ID, UserID: device.UserID}); err != nil {
+			return err
+		}
+		if err := q.SetUserPasswordResetRequired(ctx, device.UserID); err != nil {
+			return err
+		}
+		// Links of earlier reset requests may have reached whoever signed in
+		if err := q.DeletePasswordResetRequest(ctx, device.UserID); err != nil {
+			return err
+		}
+		resetCode, err = as.createPasswordResetRequest(ctx, q, device.UserID, now)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to secure account: %w", err)
+	}
+
+	slog.Warn("unknown device reported, device signed out and password reset required", "userId", device.UserID)
+	return resetCode, nil
+}
+
+// ListKnownDevices returns the devices the user signed in from, most recently used first.
+// deviceToken is the device cookie of the request, it marks the current device.
+func (as *Service) ListKnownDevices(ctx context.Context, userId int64, deviceToken string) ([]model.KnownDevice, error) {
+	rows, err := as.queries.ListUserKnownDevices(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list known devices: %w", err)
+	}
+
+	devices := make([]model.KnownDevice, 0, len(rows))
+	for _, row := range rows {
+		devices = append(devices, model.KnownDevice{
+			Id:         row.ID,
+			Name:       describeUserAgent(row.UserAgent),
+			IPAddress:  row.IpAddress,
+			CreatedAt:  row.CreatedAt,
+			LastSeenAt: row.LastSeenAt,
+			Current:    deviceToken != "" && row.TokenHash == hashToken(deviceToken),
+		})
+	}
+	return devices, nil
+}
+
+// RemoveKnownDevice signs the device out and forgets it, its next sign-in is reported as a new device
+func (as *Service) RemoveKnownDevice(ctx context.Context, userId, deviceId int64) (err error) {
+	defer func() { as.recordEvent(ctx, model.AuthEvent{Type: AuthEventDeviceRemove, UserId: userId}, err) }()
+
+	return as.queries.InTx(ctx, func(q db.Querier) error {
+		err := q.DeleteDeviceSessions(ctx, db.DeleteDeviceSessionsParams{
+			DeviceID: sql.NullInt64{Int64: deviceId, Valid: true},
+			UserID:   userId,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to revoke device sessions: %w", err)
+		}
+		deleted, err := q.DeleteUserKnownDevice(ctx, db.DeleteUserKnownDeviceParams{ID: deviceId, UserID: userId})
+		if err != nil {
+			return fmt.Errorf("failed to remove device: %w", err)
+		}
+		if deleted == 0 {
+			return ErrDeviceNotFound
+		}
+		return nil
+	})
+}
+
+// deviceFingerprint identifies a browser and system on a network, it recognizes devices whose cookie was cleared
+func deviceFingerprint(client ClientInfo) string {
+	return hashToken(describeUserAgent(client.UserAgent) + "|" + ipNetwork(client.IPAddress))
+}
+
+// ipNetwork keeps the network part of an address, the /24 of IPv4 and the /48 of IPv6 addresses,
+// since the address of a device on the same network often changes
+func ipNetwork(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
+
+// describeUserAgent names the browser and operating system of a user agent, such as "Firefox on Linux".
+// User agents it does not recognize are returned as they are.
+func describeUserAgent(userAgent string) string {
+	browser, system := "", ""
+	for _, b := range userAgentBrowsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, s := range userAgentSystems {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case userAgent == "":
+		return "Unknown device"
+	default:
+		return userAgent
+	}
+}
+
+// generateDeviceReportToken generates the random token carried by the link of a new device email.
+// Tests get one derived from the email so several reports can be pending at once.
+func (as *Service) generateDeviceReportToken(email string) (string, error) {
+	if as.Config.Env == "test" {
+		return TestDeviceReportToken + "-" + email, nil
+	}
+	return generateTokenSession()
+}
@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const (
+	firefoxLinux    = "Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0"
+	safariIPhone    = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.6 Mobile/15E148 Safari/604.1"
+	testDeviceOne   = "device-token-000000000000000000000000000001"
+	testDeviceTwo   = "device-token-000000000000000000000000000002"
+	testDeviceThree = "device-token-000000000000000000000000000003"
+)
+
+func TestDescribeUserAgent(t *testing.T) {
+	f := func(userAgent, expect string) {
+		t.Helper()
+
+		if got := describeUserAgent(userAgent); got != expect {
+			t.Fatalf("unexpected description of %q; got %q; want %q", userAgent, got, expect)
+		}
+	}
+
+	f(firefoxLinux, "Firefox on Linux")
+	f(safariIPhone, "Safari on iOS")
+	f("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36 Edg/129.0.0.0", "Edge on Windows")
+	f("Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36", "Chrome on macOS")
+	f("Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Mobile Safari/537.36", "Chrome on Android")
+
+	// unknown clients are shown as they are
+	f("curl/8.5.0", "curl/8.5.0")
+	f("", "Unknown device")
+}
+
+func TestIPNetwork(t *testing.T) {
+	f := func(ip, expect string) {
+		t.Helper()
+
+		if got := ipNetwork(ip); got != expect {
+			t.Fatalf("unexpected network of %q; got %q; want %q", ip, got, expect)
+		}
+	}
+
+	f("203.0.113.42", "203.0.113.0/24")
+	f("::ffff:203.0.113.42", "203.0.113.0/24")
+	f("2001:db8:1234:5678::1", "2001:db8:1234::/48")
+
+	// addresses that do not parse are kept whole
+	f("unknown", "unknown")
+}
+
+func TestGetDeviceTokenFromCookie(t *testing.T) {
+	f := func(value, expect string) {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: DeviceCookieName, Value: value})
+		if got := GetDeviceTokenFromCookie(r); got != expect {
+			t.Fatalf("unexpected device token; got %q; want %q", got, expect)
+		}
+	}
+
+	f(testDeviceOne, testDeviceOne)
+	f("short", "")
+	f("", "")
+}
+
+func TestDeviceCookieMiddleware(t *testing.T) {
+	var seen string
+	h := DeviceCookieMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = ClientInfoFrom(r).DeviceToken
+	}))
+
+	// a new token is handed out and used for the request
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/authenticate/password", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != DeviceCookieName || cookies[0].Value != seen || len(seen) != deviceTokenLength {
+		t.Fatalf("unexpected device cookie %v for token %q", cookies, seen)
+	}
+
+	// a known token is kept
+	r := httptest.NewRequest(http.MethodPost, "/authenticate/password", nil)
+	r.AddCookie(&http.Cookie{Name: DeviceCookieName, Value: testDeviceOne})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	cookies = w.Result().Cookies()
+	if seen != testDeviceOne || len(cookies) != 1 || cookies[0].Value != testDeviceOne {
+		t.Fatalf("unexpected device cookie %v for token %q", cookies, seen)
+	}
+}
+
+func TestRecognizeDevice(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	ctx := context.Background()
+
+	f := func(client ClientInfo, expectDevices int, expectReport bool) int64 {
+		t.Helper()
+
+		token, err := as.createSession(ctx, 1, client)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		session := fakeQuerier.Sessions[hashToken(token)]
+		if len(fakeQuerier.KnownDevices) != expectDevices {
+			t.Fatalf("unexpected known devices %d; want %d", len(fakeQuerier.KnownDevices), expectDevices)
+		}
+		device, ok := fakeQuerier.KnownDevices[session.DeviceID.Int64]
+		if !ok {
+			t.Fatalf("expected the session to belong to a known device")
+		}
+		if device.ReportTokenHash.Valid != expectReport {
+			t.Fatalf("unexpected report link %v; want %v", device.ReportTokenHash.Valid, expectReport)
+		}
+		return device.ID
+	}
+
+	laptop := ClientInfo{IPAddress: "203.0.113.42", UserAgent: firefoxLinux, DeviceToken: testDeviceOne}
+
+	// the first device of an account is not reported
+	first := f(laptop, 1, false)
+
+	// the same cookie is the same device
+	if id := f(laptop, 1, false); id != first {
+		t.Fatalf("unexpected device %d; want %d", id, first)
+	}
+
+	// a new cookie on the same browser and network is a device of its own, but it is not reported
+	renewed := ClientInfo{IPAddress: "203.0.113.7", UserAgent: firefoxLinux, DeviceToken: testDeviceTwo}
+	if id := f(renewed, 2, false); id == first {
+		t.Fatalf("expected a new device")
+	}
+	// and the first cookie, which may belong to another browser with the same fingerprint, keeps working
+	if device := fakeQuerier.KnownDevices[first]; device.TokenHash != hashToken(testDeviceOne) {
+		t.Fatalf("expected the device to keep its cookie, got %+v", device)
+	}
+	if id := f(laptop, 2, false); id != first {
+		t.Fatalf("unexpected device %d; want %d", id, first)
+	}
+
+	// another browser is reported
+	phone := ClientInfo{IPAddress: "198.51.100.1", UserAgent: safariIPhone, DeviceToken: testDeviceThree}
+	if id := f(phone, 3, true); id == first {
+		t.Fatalf("expected a new device")
+	}
+
+	// clients without a device cookie are not tracked
+	token, err := as.createSession(ctx, 1, ClientInfo{UserAgent: firefoxLinux})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if session := fakeQuerier.Sessions[hashToken(token)]; session.DeviceID.Valid {
+		t.Fatalf("expected no device, got %d", session.DeviceID.Int64)
+	}
+}
+
+func TestReportUnknownDevice(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	ctx := context.Background()
+
+	laptop := ClientInfo{IPAddress: "203.0.113.42", UserAgent: firefoxLinux, DeviceToken: testDeviceOne}
+	laptopToken, err := as.createSession(ctx, 1, laptop)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	stranger := ClientInfo{IPAddress: "198.51.100.1", UserAgent: safariIPhone, DeviceToken: testDeviceTwo}
+	strangerToken, err := as.createSession(ctx, 1, stranger)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	reportToken := TestDeviceReportToken + "-user@example.com"
+
+	// an unknown link
+	if _, err := as.ReportUnknownDevice(ctx, "unknown"); !errors.Is(err, ErrInvalidDeviceReportLink) {
+		t.Fatalf("expected ErrInvalidDeviceReportLink, got: %v", err)
+	}
+
+	resetCode, err := as.ReportUnknownDevice(ctx, reportToken)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	// only the reported device is signed out, the link must not sign the owner out everywhere
+	if _, ok := fakeQuerier.Sessions[hashToken(strangerToken)]; ok {
+		t.Fatalf("expected the session of the reported device to be revoked")
+	}
+	if _, ok := fakeQuerier.Sessions[hashToken(laptopToken)]; !ok {
+		t.Fatalf("expected the other sessions to be kept")
+	}
+	if len(fakeQuerier.KnownDevices) != 1 {
+		t.Fatalf("expected the reported device to be forgotten, got %d devices", len(fakeQuerier.KnownDevices))
+	}
+	// the password is kept but no longer signs in
+	if fakeQuerier.Users[1].PasswordHash == "" {
+		t.Fatalf("expected the password hash to be kept")
+	}
+	if _, _, err := as.AuthenticateWithPassword(ctx, "user@example.com", "Str0ngP@ssw0rd!", laptop); !errors.Is(err, ErrPasswordResetRequired) {
+		t.Fatalf("expected ErrPasswordResetRequired, got: %v", err)
+	}
+	// a wrong password is still reported as such
+	if _, _, err := as.AuthenticateWithPassword(ctx, "user@example.com", "wrong password", laptop); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got: %v", err)
+	}
+
+	// the link works once
+	if _, err := as.ReportUnknownDevice(ctx, reportToken); !errors.Is(err, ErrInvalidDeviceReportLink) {
+		t.Fatalf("expected ErrInvalidDeviceReportLink, got: %v", err)
+	}
+
+	// the returned code chooses the new password, which signs in again
+	if err := as.ResetPassword(ctx, resetCode, "An0ther-Str0ng-P@ss"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, _, err := as.AuthenticateWithPassword(ctx, "user@example.com", "An0ther-Str0ng-P@ss", laptop); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+}
+
+func TestReportUnknownDeviceExpired(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	ctx := context.Background()
+
+	for _, token := range []string{testDeviceOne, testDeviceTwo} {
+		client := ClientInfo{IPAddress: "198.51.100.1", UserAgent: token, DeviceToken: token}
+		if _, err := as.createSession(ctx, 1, client); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	for id, device := range fakeQuerier.KnownDevices {
+		device.ReportExpiresAt = time.Now().Add(-time.Minute).Unix()
+		fakeQuerier.KnownDevices[id] = device
+	}
+
+	if _, err := as.ReportUnknownDevice(ctx, TestDeviceReportToken+"-user@example.com"); !errors.Is(err, ErrInvalidDeviceReportLink) {
+		t.Fatalf("expected ErrInvalidDeviceReportLink, got: %v", err)
+	}
+	if len(fakeQuerier.Sessions) != 2 {
+		t.Fatalf("expected the sessions to be kept, got %d", len(fakeQuerier.Sessions))
+	}
+}
+
+func TestKnownDevices(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	ctx := context.Background()
+
+	laptop := ClientInfo{IPAddress: "203.0.113.42", UserAgent: firefoxLinux, DeviceToken: testDeviceOne}
+	laptopToken, err := as.createSession(ctx, 1, laptop)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	phone := ClientInfo{IPAddress: "198.51.100.1", UserAgent: safariIPhone, DeviceToken: testDeviceTwo}
+	if _, err := as.createSession(ctx, 1, phone); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	devices, err := as.ListKnownDevices(ctx, 1, testDeviceOne)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("unexpected devices %+v", devices)
+	}
+	var phoneId int64
+	for _, device := range devices {
+		if device.Current != (device.Name == "Firefox on Linux") {
+			t.Fatalf("unexpected current device %+v", device)
+		}
+		if device.Name == "Safari on iOS" {
+			phoneId = device.Id
+		}
+	}
+
+	// devices of other users cannot be removed
+	if err := as.RemoveKnownDevice(ctx, 2, phoneId); !errors.Is(err, ErrDeviceNotFound) {
+		t.Fatalf("expected ErrDeviceNotFound, got: %v", err)
+	}
+
+	// removing a device signs it out and keeps the other sessions
+	if err := as.RemoveKnownDevice(ctx, 1, phoneId); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(fakeQuerier.Sessions) != 1 {
+		t.Fatalf("expected one session left, got %d", len(fakeQuerier.Sessions))
+	}
+	if _, ok := fakeQuerier.Sessions[hashToken(laptopToken)]; !ok {
+		t.Fatalf("expected the laptop to stay signed in")
+	}
+	if err := as.RemoveKnownDevice(ctx, 1, phoneId); !errors.Is(err, ErrDeviceNotFound) {
+		t.Fatalf("expected ErrDeviceNotFound, got: %v", err)
+	}
+}
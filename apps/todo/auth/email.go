@@ -3,7 +3,9 @@ package auth
 import (
 	"context"
 	"crypto/rand"
+	"database/sql"
 	"encoding/base32"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/smtp"
@@ -12,6 +14,7 @@ import (
 	"time"
 
 	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
 )
 
 type EmailParams struct {
@@ -21,24 +24,43 @@ type EmailParams struct {
 	Attachments []string
 }
 
-func (as *Service) VerifyEmail(ctx context.Context, token, code string) error {
+// VerificationResendThrottledError is returned when a new verification code is asked for too soon,
+// either during the cooldown after the last one or once the daily number of codes was sent.
+type VerificationResendThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *VerificationResendThrottledError) Error() string {
+	return fmt.Sprintf("a new code can be sent in %s", FormatRetryAfter(e.RetryAfter))
+}
+
+// VerifyEmail marks the email of the session user as verified when the code matches.
+// Every attempt is counted before the code is compared, and after maxVerificationAttempts
+// wrong codes the code stops working until a new one is sent.
+func (as *Service) VerifyEmail(ctx context.Context, token, code string) (err error) {
 	_, user, err := as.validateSession(ctx, token)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		as.recordEvent(ctx, model.AuthEvent{Type: AuthEventEmailVerification, UserId: user.Id, Email: user.Email}, err)
+	}()
 
-	verificationRequest, err := as.queries.GetUserEmailVerificationRequest(ctx, user.Id)
+	// The request is kept when the code is expired or spent so the daily send count survives
+	verificationRequest, err := as.queries.IncrementEmailVerificationAttempts(ctx, user.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidVerificationCode
+	}
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to count verification attempt: %w", err)
 	}
 
-	// Check request expiration
 	now := time.Now().Unix()
 	if now >= verificationRequest.ExpiresAt {
-		if err := as.queries.DeleteUserEmailVerificationRequest(ctx, user.Id); err != nil {
-			return err
-		}
-		return fmt.Errorf("email verification request expired")
+		return ErrVerificationCodeExpired
+	}
+	if verificationRequest.Attempts > maxVerificationAttempts {
+		return ErrVerificationCodeLocked
 	}
 
 	// Validate verification code
@@ -48,7 +70,10 @@ func (as *Service) VerifyEmail(ctx context.Context, token, code string) error {
 		ExpiresAt: now,
 	})
 	if err != nil || validCode.Code == "" {
-		return fmt.Errorf("invalid email verification code")
+		if verificationRequest.Attempts == maxVerificationAttempts {
+			return ErrVerificationCodeLocked
+		}
+		return ErrInvalidVerificationCode
 	}
 
 	// Mark email as verified
@@ -56,19 +81,85 @@ func (as *Service) VerifyEmail(ctx context.Context, token, code string) error {
 		return err
 	}
 
-	return nil
+	// Configured admins get their role once they proved they own the email
+	return as.promoteConfiguredAdmin(ctx, user.Email)
 }
 
 // CreateAndSendVerificationEmail creates a new email verification request and sends the verification email
-func (as *Service) CreateAndSendVerificationEmail(ctx context.Context, userId int64, email string) error {
+func (as *Service) CreateAndSendVerificationEmail(ctx context.Context, userId int64, email string) (err error) {
+	defer func() {
+		as.recordEvent(ctx, model.AuthEvent{Type: AuthEventVerificationEmail, UserId: userId, Email: email}, err)
+	}()
+
+	return as.sendVerificationCode(ctx, userId, email)
+}
+
+// ResendVerificationEmail replaces the code of the session user with a new one and emails it.
+// A code can be sent again verificationResendCooldown after the previous one,
+// and at most verificationSendLimit codes are sent per verificationSendWindow.
+func (as *Service) ResendVerificationEmail(ctx context.Context, token string) (err error) {
+	_, user, err := as.validateSession(ctx, token)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		as.recordEvent(ctx, model.AuthEvent{Type: AuthEventVerificationEmail, UserId: user.Id, Email: user.Email, Reason: "resend"}, err)
+	}()
+
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+
+	wait, err := as.verificationResendWait(ctx, user.Id)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return &VerificationResendThrottledError{RetryAfter: wait}
+	}
+
+	return as.sendVerificationCode(ctx, user.Id, user.Email)
+}
+
+// VerificationResendWait is how long the session user must wait before a new code can be sent
+func (as *Service) VerificationResendWait(ctx context.Context, token string) (time.Duration, error) {
+	_, user, err := as.validateSession(ctx, token)
+	if err != nil {
+		return 0, err
+	}
+	return as.verificationResendWait(ctx, user.Id)
+}
+
+func (as *Service) verificationResendWait(ctx context.Context, userId int64) (time.Duration, error) {
+	verificationRequest, err := as.queries.GetUserEmailVerificationRequest(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get email verification request: %w", err)
+	}
+
+	now := time.Now()
+	wait := time.Unix(verificationRequest.CreatedAt, 0).Add(verificationResendCooldown).Sub(now)
+	if verificationRequest.SendCount >= verificationSendLimit {
+		windowEnd := time.Unix(verificationRequest.SendWindowStartedAt, 0).Add(verificationSendWindow)
+		wait = max(wait, windowEnd.Sub(now))
+	}
+	return max(wait, 0), nil
+}
+
+// sendVerificationCode stores a new code for the user, resetting its attempts, and emails it
+func (as *Service) sendVerificationCode(ctx context.Context, userId int64, email string) error {
 	code := as.generateEmailVerificationCode()
 	slog.Info("Generated code", "code", code, "email", email)
 
+	now := time.Now()
 	_, err := as.queries.InsertUserEmailVerificationRequest(ctx, db.InsertUserEmailVerificationRequestParams{
-		UserID:    userId,
-		CreatedAt: time.Now().Unix(),
-		ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
-		Code:      code,
+		UserID:      userId,
+		CreatedAt:   now.Unix(),
+		ExpiresAt:   now.Add(emailVerificationDuration).Unix(),
+		Code:        code,
+		WindowStart: now.Add(-verificationSendWindow).Unix(),
 	})
 	if err != nil {
 		return err
@@ -80,12 +171,15 @@ func (as *Service) CreateAndSendVerificationEmail(ctx context.Context, userId in
 
 // sendVerificationEmail sends a verification email to the given email address
 func (s *Service) sendVerificationEmail(email, code string) error {
-	emailHeaders := EmailParams{
+	return s.sendEmail(EmailParams{
 		To:      []string{email},
 		Subject: "Verify your email",
 		Body:    fmt.Sprintf("Your verification code is: %s", code),
-	}
+	})
+}
 
+// sendEmail sends a plain text email through the configured SMTP server
+func (s *Service) sendEmail(emailHeaders EmailParams) error {
 	// Set up authentication information
 	auth := smtp.PlainAuth(
 		"",
@@ -134,6 +228,11 @@ func isValidEmail(email string) bool {
 	return emailRegex.MatchString(email)
 }
 
+// normalizeEmail is the form emails are compared in, such as when counting login failures
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 // generateEmailVerificationCode generates a random 5-character email verification code
 func (as *Service) generateEmailVerificationCode() string {
 	if as.Config.Env == "test" {
@@ -163,3 +262,15 @@ func (as *Service) sendVerificationEmailAsync(email, code string, userID int64)
 		)
 	}
 }
+
+// sendEmailAsync sends an email in the background and logs any failure
+func (as *Service) sendEmailAsync(params EmailParams) {
+	if err := as.sendEmail(params); err != nil {
+		slog.Error(
+			"failed to send email",
+			"error", err,
+			"subject", params.Subject,
+			"to", params.To,
+		)
+	}
+}
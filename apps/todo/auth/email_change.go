@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+)
+
+// RequestEmailChange starts moving the account to a new address once the user proved their password again.
+// A code is sent to the new address and the old address is told about the change with a link to cancel it,
+// which keeps working for emailChangeRevertDuration after the change to move the account back.
+// The address is only swapped by ConfirmEmailChange.
+func (as *Service) RequestEmailChange(ctx context.Context, userId int64, password, newEmail string) (err error) {
+	newEmail = strings.TrimSpace(newEmail)
+	defer func() {
+		as.recordEvent(ctx, model.AuthEvent{Type: AuthEventEmailChangeRequest, UserId: userId, Email: newEmail}, err)
+	}()
+
+	if newEmail == "" || !isValidEmail(newEmail) {
+		return ErrInvalidEmail
+	}
+
+	user, err := as.queries.GetUserByID(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if user.Email == newEmail {
+		return ErrEmailUnchanged
+	}
+
+	// Accounts created through an OAuth provider have no password to prove until they reset it
+	if user.PasswordHash == "" {
+		return ErrReauthenticationFailed
+	}
+	validPassword, err := as.verifyPassword(ctx, user.PasswordHash, password)
+	if err != nil {
+		return err
+	}
+	if !validPassword {
+		return ErrReauthenticationFailed
+	}
+
+	if err := ensureEmailAvailable(ctx, as.queries, newEmail); err != nil {
+		return err
+	}
+
+	code := as.generateEmailVerificationCode()
+	cancelCode, err := as.generateEmailChangeCancelCode()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = as.queries.InsertEmailChangeRequest(ctx, db.InsertEmailChangeRequestParams{
+		UserID:          userId,
+		NewEmail:        newEmail,
+		CodeHash:        hashToken(code),
+		CancelTokenHash: hashToken(cancelCode),
+		CreatedAt:       now.Unix(),
+		ExpiresAt:       now.Add(emailChangeDuration).Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create email change request: %w", err)
+	}
+
+	go as.sendEmailAsync(EmailParams{
+		To:      []string{newEmail},
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf(
+			"Your confirmation code is: %s\r\n\r\nThe code expires in %d minutes.",
+			code,
+			int(emailChangeDuration.Minutes()),
+		),
+	})
+
+	cancelLink := fmt.Sprintf("%s/settings/email/cancel?code=%s", as.Config.BaseURL, url.QueryEscape(cancelCode))
+	go as.sendEmailAsync(EmailParams{
+		To:      []string{user.Email},
+		Subject: "Your email address is being changed",
+		Body: fmt.Sprintf(
+			"Someone asked to move your account to %s.\r\n\r\nIf this was not you, cancel the change and sign out every device with this link: %s\r\nIt also moves the account back to this address for %d days after the change is made. Then reset your password.",
+			newEmail,
+			cancelLink,
+			int(emailChangeRevertDuration.Hours()/24),
+		),
+	})
+
+	return nil
+}
+
+// PendingEmailChange returns the address waiting for confirmation, or an empty string
+func (as *Service) PendingEmailChange(ctx context.Context, userId int64) (string, error) {
+	request, err := as.queries.GetEmailChangeRequest(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get email change request: %w", err)
+	}
+	if time.Now().Unix() >= request.ExpiresAt {
+		return "", nil
+	}
+	return request.NewEmail, nil
+}
+
+// ConfirmEmailChange swaps the address once the code sent to it is entered.
+// Every other session is signed out since they were opened under the old address,
+// and links already mailed to the old address stop working, except the cancel link which now reverts the change.
+// Like VerifyEmail, every attempt is counted and after maxVerificationAttempts wrong codes the change must be requested again.
+func (as *Service) ConfirmEmailChange(ctx context.Context, userId int64, currentSessionId, code string) (err error) {
+	event := model.AuthEvent{Type: AuthEventEmailChange, UserId: userId}
+	defer func() { as.recordEvent(ctx, event, err) }()
+
+	request, err := as.queries.IncrementEmailChangeAttempts(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidEmailChangeCode
+	}
+	if err != nil {
+		return fmt.Errorf("failed to count email change attempt: %w", err)
+	}
+	event.Email = request.NewEmail
+
+	now := time.Now()
+	if now.Unix() >= request.ExpiresAt {
+		if _, err := as.queries.DeleteEmailChangeRequest(ctx, request.ID); err != nil {
+			return err
+		}
+		return ErrInvalidEmailChangeCode
+	}
+	if request.Attempts > maxVerificationAttempts {
+		return ErrEmailChangeCodeLocked
+	}
+	if subtle.ConstantTimeCompare([]byte(request.CodeHash), []byte(hashToken(code))) != 1 {
+		if request.Attempts == maxVerificationAttempts {
+			return ErrEmailChangeCodeLocked
+		}
+		return ErrInvalidEmailChangeCode
+	}
+
+	// The address only changes together with everything tied to the old one
+	err = as.queries.InTx(ctx, func(q db.Querier) error {
+		// Consume the request first so the code can never be used twice
+		deleted, err := q.DeleteEmailChangeRequest(ctx, request.ID)
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return ErrInvalidEmailChangeCode
+		}
+
+		// Someone may have registered the address while the code was in flight
+		if err := ensureEmailAvailable(ctx, q, request.NewEmail); err != nil {
+			return err
+		}
+		user, err := q.GetUserByID(ctx, userId)
+		if err != nil {
+			return err
+		}
+		// The cancel link mailed to the old address becomes the way back if the change was not theirs
+		err = q.InsertEmailChangeRevert(ctx, db.InsertEmailChangeRevertParams{
+			UserID:    userId,
+			OldEmail:  user.Email,
+			TokenHash: request.CancelTokenHash,
+			ExpiresAt: now.Add(emailChangeRevertDuration).Unix(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to keep the old email: %w", err)
+		}
+		err = q.UpdateUserEmail(ctx, db.UpdateUserEmailParams{
+			Email: request.NewEmail,
+			ID:    userId,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update email: %w", err)
+		}
+
+		if err := q.DeletePasswordResetRequest(ctx, userId); err != nil {
+			return err
+		}
+		if err := q.DeleteUserMagicLinkRequest(ctx, userId); err != nil {
+			return err
+		}
+		err = q.DeleteOtherUserSessions(ctx, db.DeleteOtherUserSessionsParams{
+			UserID: userId,
+			ID:     currentSessionId,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to revoke sessions: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	as.recordEvent(ctx, model.AuthEvent{Type: AuthEventSessionRevoke, UserId: userId, Reason: "other sessions"}, nil)
+
+	slog.Info("email address changed", "userId", userId)
+	return nil
+}
+
+// CancelEmailChange is reached from the link sent to the old address. Whoever started the change
+// knew the password, so every session of the user is signed out as well.
+// Once the change was confirmed, the link moves the account back to the old address instead.
+func (as *Service) CancelEmailChange(ctx context.Context, cancelCode string) (err error) {
+	event := model.AuthEvent{Type: AuthEventEmailChangeCancel}
+	defer func() { as.recordEvent(ctx, event, err) }()
+
+	if cancelCode == "" {
+		return ErrInvalidEmailChangeLink
+	}
+
+	request, err := as.queries.GetEmailChangeRequestByCancelTokenHash(ctx, hashToken(cancelCode))
+	if errors.Is(err, sql.ErrNoRows) {
+		return as.revertEmailChange(ctx, &event, cancelCode)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get email change request: %w", err)
+	}
+	event.UserId, event.Email = request.UserID, request.NewEmail
+
+	deleted, err := as.queries.DeleteEmailChangeRequest(ctx, request.ID)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrInvalidEmailChangeLink
+	}
+
+	if err := as.queries.DeleteUserSessions(ctx, request.UserID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	slog.Info("email change cancelled from the old address", "userId", request.UserID)
+	return nil
+}
+
+// revertEmailChange moves the account back to the address the cancel link was mailed to.
+// Later changes are undone with it, while the links of earlier addresses keep working.
+func (as *Service) revertEmailChange(ctx context.Context, event *model.AuthEvent, cancelCode string) error {
+	revert, err := as.queries.GetEmailChangeRevertByTokenHash(ctx, hashToken(cancelCode))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidEmailChangeLink
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get email change revert: %w", err)
+	}
+	event.UserId, event.Email, event.Reason = revert.UserID, revert.OldEmail, "reverted"
+
+	if time.Now().Unix() >= revert.ExpiresAt {
+		return ErrInvalidEmailChangeLink
+	}
+
+	err = as.queries.InTx(ctx, func(q db.Querier) error {
+		deleted, err := q.DeleteEmailChangeRevertsSince(ctx, db.DeleteEmailChangeRevertsSinceParams{
+			UserID: revert.UserID,
+			ID:     revert.ID,
+		})
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return ErrInvalidEmailChangeLink
+		}
+
+		user, err := q.GetUserByID(ctx, revert.UserID)
+		if err != nil {
+			return err
+		}
+		if user.Email != revert.OldEmail {
+			if err := ensureEmailAvailable(ctx, q, revert.OldEmail); err != nil {
+				return err
+			}
+			err = q.UpdateUserEmail(ctx, db.UpdateUserEmailParams{
+				Email: revert.OldEmail,
+				ID:    revert.UserID,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to restore email: %w", err)
+			}
+		}
+
+		// Nothing started from the other address may outlive the revert
+		if err := q.DeleteUserEmailChangeRequest(ctx, revert.UserID); err != nil {
+			return err
+		}
+		if err := q.DeletePasswordResetRequest(ctx, revert.UserID); err != nil {
+			return err
+		}
+		if err := q.DeleteUserMagicLinkRequest(ctx, revert.UserID); err != nil {
+			return err
+		}
+		if err := q.DeleteUserSessions(ctx, revert.UserID); err != nil {
+			return fmt.Errorf("failed to revoke sessions: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("email change reverted from the old address", "userId", revert.UserID)
+	return nil
+}
+
+// ensureEmailAvailable checks that no other account uses the address
+func ensureEmailAvailable(ctx context.Context, q db.Querier, email string) error {
+	_, err := q.GetUserByEmail(ctx, email)
+	if err == nil {
+		return ErrEmailTaken
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return nil
+}
+
+// generateEmailChangeCancelCode generates the random code carried by the cancel link
+func (as *Service) generateEmailChangeCancelCode() (string, error) {
+	if as.Config.Env == "test" {
+		return TestEmailChangeCancelCode, nil
+	}
+	return generateTokenSession()
+}
@@ -0,0 +1,265 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+)
+
+func TestRequestEmailChange(t *testing.T) {
+	ctx := context.Background()
+
+	f := func(password, newEmail string, expect error) {
+		t.Helper()
+
+		as, fakeQuerier := givenPasswordUser(t)
+		fakeQuerier.Users[2] = db.User{ID: 2, Email: "taken@example.com", EmailVerified: 1}
+		err := as.RequestEmailChange(ctx, 1, password, newEmail)
+		if !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+
+		pending, err := as.PendingEmailChange(ctx, 1)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if expect != nil {
+			if pending != "" {
+				t.Fatalf("expected no pending change, got %q", pending)
+			}
+			return
+		}
+		if pending != newEmail {
+			t.Fatalf("unexpected pending email; got %q; want %q", pending, newEmail)
+		}
+		if fakeQuerier.Users[1].Email != "user@example.com" {
+			t.Fatalf("expected the address to stay the same until confirmed")
+		}
+	}
+
+	// wrong password
+	f("wrong-password", "new@example.com", ErrReauthenticationFailed)
+
+	// invalid address
+	f("Str0ngP@ssw0rd!", "not-an-email", ErrInvalidEmail)
+
+	// same address
+	f("Str0ngP@ssw0rd!", "user@example.com", ErrEmailUnchanged)
+
+	// address of another account
+	f("Str0ngP@ssw0rd!", "taken@example.com", ErrEmailTaken)
+
+	// valid request
+	f("Str0ngP@ssw0rd!", "new@example.com", nil)
+}
+
+func TestConfirmEmailChange(t *testing.T) {
+	ctx := context.Background()
+
+	f := func(code string, expiresIn time.Duration, expect error) {
+		t.Helper()
+
+		as, fakeQuerier := givenPasswordUser(t)
+		if err := as.RequestEmailChange(ctx, 1, "Str0ngP@ssw0rd!", "new@example.com"); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		request := fakeQuerier.EmailChangeRequests[1]
+		request.ExpiresAt = time.Now().Add(expiresIn).Unix()
+		fakeQuerier.EmailChangeRequests[1] = request
+
+		current, err := as.createSession(ctx, 1, ClientInfo{})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if _, err := as.createSession(ctx, 1, ClientInfo{}); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+
+		err = as.ConfirmEmailChange(ctx, 1, hashToken(current), code)
+		if !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+		if expect != nil {
+			if fakeQuerier.Users[1].Email != "user@example.com" {
+				t.Fatalf("expected the address to stay the same")
+			}
+			return
+		}
+
+		if fakeQuerier.Users[1].Email != "new@example.com" || fakeQuerier.Users[1].EmailVerified != 1 {
+			t.Fatalf("expected the verified new address, got %+v", fakeQuerier.Users[1])
+		}
+		if len(fakeQuerier.Sessions) != 1 {
+			t.Fatalf("expected only the current session to remain, got %d", len(fakeQuerier.Sessions))
+		}
+		if _, ok := fakeQuerier.Sessions[hashToken(current)]; !ok {
+			t.Fatalf("expected the current session to remain")
+		}
+
+		// single use
+		if err := as.ConfirmEmailChange(ctx, 1, hashToken(current), code); !errors.Is(err, ErrInvalidEmailChangeCode) {
+			t.Fatalf("expected ErrInvalidEmailChangeCode on reuse, got: %v", err)
+		}
+	}
+
+	// wrong code
+	f("00000000", time.Minute, ErrInvalidEmailChangeCode)
+
+	// expired
+	f(TestEmailVerificationCode, -time.Minute, ErrInvalidEmailChangeCode)
+
+	// valid code
+	f(TestEmailVerificationCode, time.Minute, nil)
+}
+
+func TestConfirmEmailChangeTakenMeanwhile(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	ctx := context.Background()
+
+	if err := as.RequestEmailChange(ctx, 1, "Str0ngP@ssw0rd!", "new@example.com"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	fakeQuerier.Users[3] = db.User{ID: 3, Email: "new@example.com"}
+	if _, err := as.createSession(ctx, 1, ClientInfo{}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	if err := as.ConfirmEmailChange(ctx, 1, "", TestEmailVerificationCode); !errors.Is(err, ErrEmailTaken) {
+		t.Fatalf("expected ErrEmailTaken, got: %v", err)
+	}
+	if fakeQuerier.Users[1].Email != "user@example.com" {
+		t.Fatalf("expected the address to stay the same")
+	}
+	// nothing else changes without the address
+	if len(fakeQuerier.Sessions) != 1 {
+		t.Fatalf("expected the other session to remain, got %d", len(fakeQuerier.Sessions))
+	}
+}
+
+func TestCancelEmailChange(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	ctx := context.Background()
+
+	if err := as.RequestEmailChange(ctx, 1, "Str0ngP@ssw0rd!", "new@example.com"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := as.createSession(ctx, 1, ClientInfo{}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	if err := as.CancelEmailChange(ctx, "unknown"); !errors.Is(err, ErrInvalidEmailChangeLink) {
+		t.Fatalf("expected ErrInvalidEmailChangeLink, got: %v", err)
+	}
+	if err := as.CancelEmailChange(ctx, TestEmailChangeCancelCode); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(fakeQuerier.Sessions) != 0 {
+		t.Fatalf("expected every session to be revoked")
+	}
+	if err := as.ConfirmEmailChange(ctx, 1, "", TestEmailVerificationCode); !errors.Is(err, ErrInvalidEmailChangeCode) {
+		t.Fatalf("expected the change to be cancelled, got: %v", err)
+	}
+	if err := as.CancelEmailChange(ctx, TestEmailChangeCancelCode); !errors.Is(err, ErrInvalidEmailChangeLink) {
+		t.Fatalf("expected ErrInvalidEmailChangeLink on reuse, got: %v", err)
+	}
+}
+
+func TestConfirmEmailChangeAttemptsLimit(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	ctx := context.Background()
+
+	if err := as.RequestEmailChange(ctx, 1, "Str0ngP@ssw0rd!", "new@example.com"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	for i := 1; i < maxVerificationAttempts; i++ {
+		if err := as.ConfirmEmailChange(ctx, 1, "", "00000000"); !errors.Is(err, ErrInvalidEmailChangeCode) {
+			t.Fatalf("attempt %d: expected ErrInvalidEmailChangeCode, got: %v", i, err)
+		}
+	}
+	// the last wrong code locks the request
+	if err := as.ConfirmEmailChange(ctx, 1, "", "00000000"); !errors.Is(err, ErrEmailChangeCodeLocked) {
+		t.Fatalf("expected ErrEmailChangeCodeLocked, got: %v", err)
+	}
+	// even the right code is refused afterwards
+	if err := as.ConfirmEmailChange(ctx, 1, "", TestEmailVerificationCode); !errors.Is(err, ErrEmailChangeCodeLocked) {
+		t.Fatalf("expected ErrEmailChangeCodeLocked, got: %v", err)
+	}
+	if fakeQuerier.Users[1].Email != "user@example.com" {
+		t.Fatalf("expected the address to stay the same")
+	}
+
+	// requesting the change again starts over
+	if err := as.RequestEmailChange(ctx, 1, "Str0ngP@ssw0rd!", "new@example.com"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := as.ConfirmEmailChange(ctx, 1, "", TestEmailVerificationCode); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+}
+
+func TestRevertEmailChange(t *testing.T) {
+	ctx := context.Background()
+
+	f := func(expiresIn time.Duration, expect error) {
+		t.Helper()
+
+		as, fakeQuerier := givenPasswordUser(t)
+		if err := as.RequestEmailChange(ctx, 1, "Str0ngP@ssw0rd!", "new@example.com"); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		current, err := as.createSession(ctx, 1, ClientInfo{})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if err := as.ConfirmEmailChange(ctx, 1, hashToken(current), TestEmailVerificationCode); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		for id, revert := range fakeQuerier.EmailChangeReverts {
+			revert.ExpiresAt = time.Now().Add(expiresIn).Unix()
+			fakeQuerier.EmailChangeReverts[id] = revert
+		}
+		// whoever changed the address starts another change from it, with its own cancel link
+		if err := as.RequestEmailChange(ctx, 1, "Str0ngP@ssw0rd!", "other@example.com"); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		request := fakeQuerier.EmailChangeRequests[1]
+		request.CancelTokenHash = hashToken("other-cancel-code")
+		fakeQuerier.EmailChangeRequests[1] = request
+
+		err = as.CancelEmailChange(ctx, TestEmailChangeCancelCode)
+		if !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+		if expect != nil {
+			if fakeQuerier.Users[1].Email != "new@example.com" {
+				t.Fatalf("expected the new address to stay, got %q", fakeQuerier.Users[1].Email)
+			}
+			return
+		}
+
+		if fakeQuerier.Users[1].Email != "user@example.com" || fakeQuerier.Users[1].EmailVerified != 1 {
+			t.Fatalf("expected the verified old address, got %+v", fakeQuerier.Users[1])
+		}
+		if len(fakeQuerier.Sessions) != 0 {
+			t.Fatalf("expected every session to be revoked")
+		}
+		if len(fakeQuerier.EmailChangeRequests) != 0 {
+			t.Fatalf("expected the pending change to be dropped")
+		}
+
+		// single use
+		if err := as.CancelEmailChange(ctx, TestEmailChangeCancelCode); !errors.Is(err, ErrInvalidEmailChangeLink) {
+			t.Fatalf("expected ErrInvalidEmailChangeLink on reuse, got: %v", err)
+		}
+	}
+
+	// expired
+	f(-time.Minute, ErrInvalidEmailChangeLink)
+
+	// within the revert window
+	f(time.Hour, nil)
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/AltSoyuz/soy-experiments/apps/todo/config"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
 	"github.com/AltSoyuz/soy-experiments/apps/todo/store"
 )
 
@@ -109,3 +110,125 @@ func TestIsValidEmail(t *testing.T) {
 	f("test@test.", false)
 	f("test@test.com", true)
 }
+
+// givenUnverifiedUser returns a session token of a user waiting for the verification code
+func givenUnverifiedUser(t *testing.T) (*Service, *store.FakeQuerier, string) {
+	t.Helper()
+
+	fakeQuerier := store.NewFakeQuerier()
+	fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@example.com"}
+	as := Init(givenTestConfig(), fakeQuerier)
+
+	ctx := context.Background()
+	if err := as.CreateAndSendVerificationEmail(ctx, 1, "user@example.com"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	token, err := as.createSession(ctx, 1, ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	return as, fakeQuerier, token
+}
+
+func TestVerifyEmailAttempts(t *testing.T) {
+	ctx := context.Background()
+
+	f := func(wrongCodes int, expectLast, expectValid error) {
+		t.Helper()
+
+		as, fakeQuerier, token := givenUnverifiedUser(t)
+
+		var err error
+		for range wrongCodes {
+			err = as.VerifyEmail(ctx, token, "WRONGCODE")
+		}
+		if !errors.Is(err, expectLast) {
+			t.Fatalf("unexpected error after %d wrong codes; got %v; want %v", wrongCodes, err, expectLast)
+		}
+
+		err = as.VerifyEmail(ctx, token, TestEmailVerificationCode)
+		if !errors.Is(err, expectValid) {
+			t.Fatalf("unexpected error for the right code; got %v; want %v", err, expectValid)
+		}
+		if verified := fakeQuerier.Users[1].EmailVerified == 1; verified != (expectValid == nil) {
+			t.Fatalf("unexpected verified state %v", verified)
+		}
+	}
+
+	// the right code after a few mistakes still works
+	f(maxVerificationAttempts-1, ErrInvalidVerificationCode, nil)
+
+	// the last allowed mistake spends the code
+	f(maxVerificationAttempts, ErrVerificationCodeLocked, ErrVerificationCodeLocked)
+}
+
+func TestVerifyEmailExpiredCode(t *testing.T) {
+	as, fakeQuerier, token := givenUnverifiedUser(t)
+	ctx := context.Background()
+
+	request := fakeQuerier.EmailVerificationRequests[1]
+	request.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	fakeQuerier.EmailVerificationRequests[1] = request
+
+	if err := as.VerifyEmail(ctx, token, TestEmailVerificationCode); !errors.Is(err, ErrVerificationCodeExpired) {
+		t.Fatalf("expected ErrVerificationCodeExpired, got: %v", err)
+	}
+	// The request is kept so the codes sent today are still counted
+	if _, ok := fakeQuerier.EmailVerificationRequests[1]; !ok {
+		t.Fatalf("expected the expired request to be kept")
+	}
+}
+
+func TestResendVerificationEmail(t *testing.T) {
+	as, fakeQuerier, token := givenUnverifiedUser(t)
+	ctx := context.Background()
+
+	// givenCooldownElapsed moves the last code back so a new one can be sent
+	givenCooldownElapsed := func() {
+		request := fakeQuerier.EmailVerificationRequests[1]
+		request.CreatedAt -= int64(verificationResendCooldown.Seconds())
+		request.SendWindowStartedAt -= int64(verificationResendCooldown.Seconds())
+		fakeQuerier.EmailVerificationRequests[1] = request
+	}
+
+	// right after the first code
+	var throttled *VerificationResendThrottledError
+	err := as.ResendVerificationEmail(ctx, token)
+	if !errors.As(err, &throttled) || throttled.RetryAfter <= 0 || throttled.RetryAfter > verificationResendCooldown {
+		t.Fatalf("expected the cooldown to apply, got: %v", err)
+	}
+
+	// a new code resets the wrong attempts
+	if err := as.VerifyEmail(ctx, token, "WRONGCODE"); !errors.Is(err, ErrInvalidVerificationCode) {
+		t.Fatalf("expected ErrInvalidVerificationCode, got: %v", err)
+	}
+	givenCooldownElapsed()
+	if wait, err := as.VerificationResendWait(ctx, token); err != nil || wait != 0 {
+		t.Fatalf("expected no wait, got %s, %v", wait, err)
+	}
+	if err := as.ResendVerificationEmail(ctx, token); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if request := fakeQuerier.EmailVerificationRequests[1]; request.Attempts != 0 || request.SendCount != 2 {
+		t.Fatalf("unexpected request after resend: %+v", request)
+	}
+
+	// the daily cap waits for the end of the window
+	for request := fakeQuerier.EmailVerificationRequests[1]; request.SendCount < verificationSendLimit; request = fakeQuerier.EmailVerificationRequests[1] {
+		givenCooldownElapsed()
+		if err := as.ResendVerificationEmail(ctx, token); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	givenCooldownElapsed()
+	err = as.ResendVerificationEmail(ctx, token)
+	if !errors.As(err, &throttled) || throttled.RetryAfter <= verificationResendCooldown {
+		t.Fatalf("expected the daily cap to apply, got: %v", err)
+	}
+
+	// verified users have nothing to resend
+	fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@example.com", EmailVerified: 1}
+	if err := as.ResendVerificationEmail(ctx, token); !errors.Is(err, ErrEmailAlreadyVerified) {
+		t.Fatalf("expected ErrEmailAlreadyVerified, got: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+)
+
+// StartImpersonation signs the admin in as another user to see the app exactly as they do.
+// The session it creates remembers the admin, cannot be renewed and ends after impersonationDuration.
+// Admins, disabled and unverified accounts cannot be impersonated.
+func (as *Service) StartImpersonation(ctx context.Context, admin model.User, userId int64, client ClientInfo) (s model.Session, t string, err error) {
+	event := model.AuthEvent{Type: AuthEventImpersonationStart, UserId: userId, Reason: "by " + admin.Email}
+	defer func() { as.recordEvent(ctx, event, err) }()
+
+	if admin.Impersonation != nil {
+		return model.Session{}, "", ErrImpersonationForbidden
+	}
+	if userId == admin.Id {
+		return model.Session{}, "", ErrCannotImpersonate
+	}
+
+	user, err := as.queries.GetUserByID(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.Session{}, "", ErrUserNotFound
+	}
+	if err != nil {
+		return model.Session{}, "", fmt.Errorf("failed to get user: %w", err)
+	}
+	event.Email = user.Email
+	if user.Role == model.RoleAdmin || user.Disabled != 0 || user.EmailVerified == 0 {
+		return model.Session{}, "", ErrCannotImpersonate
+	}
+
+	token, err := generateTokenSession()
+	if err != nil {
+		return model.Session{}, "", err
+	}
+	now := time.Now()
+	expiresAt := now.Add(impersonationDuration).Unix()
+	_, err = as.queries.CreateSession(ctx, db.CreateSessionParams{
+		ID:                hashToken(token),
+		UserID:            userId,
+		ExpiresAt:         expiresAt,
+		IpAddress:         client.IPAddress,
+		UserAgent:         client.UserAgent,
+		LastSeenAt:        now.Unix(),
+		ImpersonatorID:    sql.NullInt64{Int64: admin.Id, Valid: true},
+		AbsoluteExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return model.Session{}, "", fmt.Errorf("failed to create session: %w", err)
+	}
+
+	session, _, err := as.validateSession(ctx, token)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+
+	slog.Info("impersonation started", "userId", userId, "adminId", admin.Id)
+	return session, token, nil
+}
+
+// StopImpersonation ends the impersonation session.
+// When adminToken is still a valid session of the admin who started it, that session is returned
+// so the admin gets back to it, otherwise the returned session is empty and the admin must sign in again.
+func (as *Service) StopImpersonation(ctx context.Context, session model.Session, adminToken string) (s model.Session, err error) {
+	if session.Impersonation == nil {
+		return model.Session{}, ErrNotImpersonating
+	}
+	event := model.AuthEvent{Type: AuthEventImpersonationStop, UserId: session.UserId, Reason: "by " + session.Impersonation.AdminEmail}
+	defer func() { as.recordEvent(ctx, event, err) }()
+
+	if err := as.queries.DeleteSession(ctx, session.Id); err != nil {
+		return model.Session{}, fmt.Errorf("failed to delete session: %w", err)
+	}
+	slog.Info("impersonation stopped", "userId", session.UserId, "adminId", session.Impersonation.AdminId)
+
+	adminSession, _, err := as.validateSession(ctx, adminToken)
+	if err != nil || adminSession.UserId != session.Impersonation.AdminId || adminSession.TwoFactorPending {
+		return model.Session{}, nil
+	}
+	return adminSession, nil
+}
+
+// ForbidImpersonationMiddleware refuses security sensitive actions, such as changing the email,
+// the second factor or the access tokens of the user, to admins impersonating them.
+// It reads the user stored by ProtectedRouteMiddleware, so it must be wrapped by it.
+func (as *Service) ForbidImpersonationMiddleware(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if user, ok := GetSessionUserFrom(r.Context()); ok && user.Impersonation != nil {
+			http.Error(w, ErrImpersonationForbidden.Error(), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+}
+
+// GetImpersonatorTokenFromCookie returns the session token the admin had before impersonating a user
+func GetImpersonatorTokenFromCookie(r *http.Request) string {
+	if cookie, err := r.Cookie(ImpersonatorCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// SetImpersonatorCookie keeps the session token of the admin while they impersonate a user
+func SetImpersonatorCookie(w http.ResponseWriter, token string, expiresAt int64) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     ImpersonatorCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Expires:  time.Unix(expiresAt, 0),
+	})
+}
+
+// DeleteImpersonatorCookie deletes the cookie set by SetImpersonatorCookie
+func DeleteImpersonatorCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     ImpersonatorCookieName,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   -1,
+	})
+}
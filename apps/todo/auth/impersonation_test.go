@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/store"
+)
+
+// givenAdmin adds a verified admin as user 2 next to the password user and signs them in
+func givenAdmin(t *testing.T) (*Service, *store.FakeQuerier, model.User, string) {
+	t.Helper()
+
+	as, fakeQuerier := givenPasswordUser(t)
+	fakeQuerier.Users[2] = db.User{ID: 2, Email: "admin@example.com", EmailVerified: 1, Role: model.RoleAdmin}
+	token, err := as.createSession(context.Background(), 2, ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	_, admin, err := as.validateSession(context.Background(), token)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	return as, fakeQuerier, admin, token
+}
+
+func TestStartImpersonation(t *testing.T) {
+	as, fakeQuerier, admin, _ := givenAdmin(t)
+	fakeQuerier.Users[3] = db.User{ID: 3, Email: "disabled@example.com", EmailVerified: 1, Disabled: 1}
+	fakeQuerier.Users[4] = db.User{ID: 4, Email: "unverified@example.com"}
+	fakeQuerier.Users[5] = db.User{ID: 5, Email: "other-admin@example.com", EmailVerified: 1, Role: model.RoleAdmin}
+	ctx := context.Background()
+
+	f := func(userId int64, expect error) {
+		t.Helper()
+
+		_, _, err := as.StartImpersonation(ctx, admin, userId, ClientInfo{})
+		if !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+	}
+
+	f(admin.Id, ErrCannotImpersonate)
+	f(3, ErrCannotImpersonate)
+	f(4, ErrCannotImpersonate)
+	f(5, ErrCannotImpersonate)
+	f(42, ErrUserNotFound)
+
+	session, token, err := as.StartImpersonation(ctx, admin, 1, ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if session.UserId != 1 || session.Impersonation == nil || session.Impersonation.AdminEmail != admin.Email {
+		t.Fatalf("unexpected session %+v", session)
+	}
+	if lifetime := time.Until(time.Unix(session.ExpiresAt, 0)); lifetime > impersonationDuration {
+		t.Fatalf("expected a short session, it lasts %s", lifetime)
+	}
+
+	_, user, err := as.validateSession(ctx, token)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if user.Id != 1 || user.Impersonation == nil || user.Impersonation.AdminId != admin.Id {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	// An impersonation cannot start another one
+	if _, _, err := as.StartImpersonation(ctx, user, 4, ClientInfo{}); !errors.Is(err, ErrImpersonationForbidden) {
+		t.Fatalf("expected ErrImpersonationForbidden, got: %v", err)
+	}
+
+	events, err := as.ListUserAuthEvents(ctx, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(events) != 1 || events[0].Type != AuthEventImpersonationStart || events[0].Reason != "by admin@example.com" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
+
+func TestImpersonationSessionIsNotRenewed(t *testing.T) {
+	as, fakeQuerier, admin, _ := givenAdmin(t)
+	ctx := context.Background()
+
+	session, token, err := as.StartImpersonation(ctx, admin, 1, ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	renewed, _, err := as.validateSession(ctx, token)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if renewed.ExpiresAt != session.ExpiresAt {
+		t.Fatalf("expected the expiry to stay %d, got %d", session.ExpiresAt, renewed.ExpiresAt)
+	}
+
+	// Expiring ends the impersonation
+	stored := fakeQuerier.Sessions[session.Id]
+	stored.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	fakeQuerier.Sessions[session.Id] = stored
+	if _, _, err := as.validateSession(ctx, token); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("expected ErrSessionExpired, got: %v", err)
+	}
+	events, err := as.ListUserAuthEvents(ctx, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if events[0].Type != AuthEventImpersonationStop || events[0].Reason != "expired" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
+
+func TestImpersonationEndsWithAdmin(t *testing.T) {
+	ctx := context.Background()
+
+	f := func(demote func(admin *db.User)) {
+		t.Helper()
+
+		as, fakeQuerier, admin, _ := givenAdmin(t)
+		_, token, err := as.StartImpersonation(ctx, admin, 1, ClientInfo{})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+
+		stored := fakeQuerier.Users[admin.Id]
+		demote(&stored)
+		fakeQuerier.Users[admin.Id] = stored
+		if _, _, err := as.validateSession(ctx, token); !errors.Is(err, ErrSessionInvalid) {
+			t.Fatalf("expected ErrSessionInvalid, got: %v", err)
+		}
+		if _, ok := fakeQuerier.Sessions[hashToken(token)]; ok {
+			t.Fatalf("expected the impersonation session to be deleted")
+		}
+	}
+
+	// admin disabled
+	f(func(admin *db.User) { admin.Disabled = 1 })
+
+	// admin role removed
+	f(func(admin *db.User) { admin.Role = model.RoleUser })
+}
+
+func TestStopImpersonation(t *testing.T) {
+	ctx := context.Background()
+
+	f := func(adminToken func(as *Service, token string) string, expectRestored bool) {
+		t.Helper()
+
+		as, fakeQuerier, admin, token := givenAdmin(t)
+		session, _, err := as.StartImpersonation(ctx, admin, 1, ClientInfo{})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+
+		adminSession, err := as.StopImpersonation(ctx, session, adminToken(as, token))
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if _, exists := fakeQuerier.Sessions[session.Id]; exists {
+			t.Fatalf("expected the impersonation session to be deleted")
+		}
+		if restored := adminSession.UserId == admin.Id; restored != expectRestored {
+			t.Fatalf("unexpected admin session %+v", adminSession)
+		}
+
+		events, err := as.ListUserAuthEvents(ctx, 1)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if events[0].Type != AuthEventImpersonationStop || events[0].Outcome != AuthOutcomeSuccess {
+			t.Fatalf("unexpected events %+v", events)
+		}
+	}
+
+	// the admin gets their session back
+	f(func(as *Service, token string) string { return token }, true)
+
+	// the admin session is gone
+	f(func(as *Service, token string) string { return "" }, false)
+
+	// the kept token belongs to someone else
+	f(func(as *Service, token string) string {
+		other, err := as.createSession(ctx, 1, ClientInfo{})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		return other
+	}, false)
+
+	as, _ := givenPasswordUser(t)
+	if _, err := as.StopImpersonation(ctx, model.Session{Id: "session", UserId: 1}, ""); !errors.Is(err, ErrNotImpersonating) {
+		t.Fatalf("expected ErrNotImpersonating, got: %v", err)
+	}
+}
+
+func TestForbidImpersonationMiddleware(t *testing.T) {
+	as := Init(givenTestConfig(), nil)
+	handler := as.ForbidImpersonationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	f := func(user model.User, expectStatus int) {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodPost, "/settings/email", nil)
+		req = req.WithContext(context.WithValue(req.Context(), UserContextKey, user))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != expectStatus {
+			t.Fatalf("unexpected status; got %d; want %d", rec.Code, expectStatus)
+		}
+	}
+
+	f(model.User{Id: 1}, http.StatusNoContent)
+	f(model.User{Id: 1, Impersonation: &model.Impersonation{AdminId: 2}}, http.StatusForbidden)
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/config"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+)
+
+// InviteUser emails a single-use registration link to the given address on behalf of the inviter.
+// Only the invited email can register with it, and it expires after invitationDuration.
+func (as *Service) InviteUser(ctx context.Context, inviter model.User, email string) (err error) {
+	email = normalizeEmail(email)
+	defer func() {
+		as.recordEvent(ctx, model.AuthEvent{Type: AuthEventInvitation, UserId: inviter.Id, Email: email}, err)
+	}()
+
+	if as.Config.Registration.Mode == config.RegistrationClosed {
+		return ErrRegistrationClosed
+	}
+	if !isValidEmail(email) {
+		return ErrInvalidEmail
+	}
+	if !as.emailDomainAllowed(email) {
+		return ErrEmailDomainNotAllowed
+	}
+
+	// Accounts keep the case their email was typed in, while invitations are stored normalized
+	_, err = as.queries.GetUserByNormalizedEmail(ctx, email)
+	if err == nil {
+		return ErrEmailTaken
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	now := time.Now()
+	pending, err := as.queries.ListPendingInvitations(ctx, db.ListPendingInvitationsParams{
+		InviterID: inviter.Id,
+		ExpiresAt: now.Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list invitations: %w", err)
+	}
+	if len(pending) >= maxPendingInvitations {
+		return ErrTooManyInvitations
+	}
+
+	token, err := as.generateInvitationToken(email)
+	if err != nil {
+		return err
+	}
+	_, err = as.queries.CreateInvitation(ctx, db.CreateInvitationParams{
+		Email:     email,
+		TokenHash: hashToken(token),
+		InviterID: inviter.Id,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(invitationDuration).Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create invitation: %w", err)
+	}
+
+	link := fmt.Sprintf("%s/register?invite=%s", as.Config.BaseURL, url.QueryEscape(token))
+	go as.sendEmailAsync(EmailParams{
+		To:      []string{email},
+		Subject: "You are invited to create an account",
+		Body: fmt.Sprintf(
+			"%s invited you to create an account.\r\n\r\nRegister with this link within %d days: %s\r\nIf you do not know them, you can ignore this email.",
+			inviter.Email,
+			int(invitationDuration.Hours()/24),
+			link,
+		),
+	})
+
+	slog.Info("invitation sent", "inviterId", inviter.Id)
+	return nil
+}
+
+// ListInvitations returns the invitations of the user that were neither used nor expired, most recent first
+func (as *Service) ListInvitations(ctx context.Context, inviterId int64) ([]model.Invitation, error) {
+	rows, err := as.queries.ListPendingInvitations(ctx, db.ListPendingInvitationsParams{
+		InviterID: inviterId,
+		ExpiresAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invitations: %w", err)
+	}
+
+	invitations := make([]model.Invitation, 0, len(rows))
+	for _, row := range rows {
+		invitations = append(invitations, model.Invitation{
+			Id:        row.ID,
+			Email:     row.Email,
+			CreatedAt: row.CreatedAt,
+			ExpiresAt: row.ExpiresAt,
+		})
+	}
+	return invitations, nil
+}
+
+// RevokeInvitation deletes a pending invitation of the user so its link stops working
+func (as *Service) RevokeInvitation(ctx context.Context, inviterId, invitationId int64) (err error) {
+	defer func() {
+		as.recordEvent(ctx, model.AuthEvent{Type: AuthEventInvitationRevoke, UserId: inviterId}, err)
+	}()
+
+	deleted, err := as.queries.DeleteInvitation(ctx, db.DeleteInvitationParams{
+		ID:        invitationId,
+		InviterID: inviterId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke invitation: %w", err)
+	}
+	if deleted == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// PrepareRegistration tells whether the register page can be used with the given invitation token, if any.
+// When a valid token is given, its invitation is returned so the page can fill in the invited email.
+func (as *Service) PrepareRegistration(ctx context.Context, inviteToken string) (model.Invitation, error) {
+	if as.Config.Registration.Mode == config.RegistrationClosed {
+		return model.Invitation{}, ErrRegistrationClosed
+	}
+	if inviteToken == "" {
+		if as.Config.Registration.Mode == config.RegistrationInviteOnly {
+			return model.Invitation{}, ErrInvitationRequired
+		}
+		return model.Invitation{}, nil
+	}
+
+	invitation, err := as.findInvitation(ctx, inviteToken)
+	if err != nil {
+		return model.Invitation{}, err
+	}
+	return model.Invitation{
+		Id:        invitation.ID,
+		Email:     invitation.Email,
+		CreatedAt: invitation.CreatedAt,
+		ExpiresAt: invitation.ExpiresAt,
+	}, nil
+}
+
+// checkRegistration applies the registration settings to a new account with the given email.
+// It returns the invitation to use up with the account, or nil when registering without one.
+func (as *Service) checkRegistration(ctx context.Context, email, inviteToken string) (*db.Invitation, error) {
+	if as.Config.Registration.Mode == config.RegistrationClosed {
+		return nil, ErrRegistrationClosed
+	}
+	if !as.emailDomainAllowed(email) {
+		return nil, ErrEmailDomainNotAllowed
+	}
+	if inviteToken == "" {
+		if as.Config.Registration.Mode == config.RegistrationInviteOnly {
+			return nil, ErrInvitationRequired
+		}
+		return nil, nil
+	}
+
+	invitation, err := as.findInvitation(ctx, inviteToken)
+	if err != nil {
+		return nil, err
+	}
+	// The link could have been forwarded, only the invited address can use it
+	if normalizeEmail(email) != invitation.Email {
+		return nil, ErrInvalidInvitation
+	}
+	return &invitation, nil
+}
+
+// findInvitation returns the invitation of the token if it can still be used
+func (as *Service) findInvitation(ctx context.Context, token string) (db.Invitation, error) {
+	invitation, err := as.queries.GetInvitationByTokenHash(ctx, hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.Invitation{}, ErrInvalidInvitation
+	}
+	if err != nil {
+		return db.Invitation{}, fmt.Errorf("failed to get invitation: %w", err)
+	}
+	if invitation.UsedAt.Valid || time.Now().Unix() >= invitation.ExpiresAt {
+		return db.Invitation{}, ErrInvalidInvitation
+	}
+	return invitation, nil
+}
+
+// emailDomainAllowed reports whether new accounts can use the domain of the email
+func (as *Service) emailDomainAllowed(email string) bool {
+	if len(as.Config.Registration.AllowedDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	return slices.Contains(as.Config.Registration.AllowedDomains, normalizeEmail(email[at+1:]))
+}
+
+// generateInvitationToken generates the random token carried by the invitation link.
+// Tests get one derived from the invited email so several invitations can be pending at once.
+func (as *Service) generateInvitationToken(email string) (string, error) {
+	if as.Config.Env == "test" {
+		return TestInvitationToken + "-" + email, nil
+	}
+	return generateTokenSession()
+}
@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/config"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/store"
+)
+
+// givenInviter sets up the registration settings with a verified user able to send invitations.
+// The inviter does not use the first IDs so the accounts created by the tests do not replace it.
+func givenInviter(mode string, allowedDomains ...string) (*Service, *store.FakeQuerier, model.User) {
+	c := givenTestConfig()
+	c.Registration = config.Registration{Mode: mode, AllowedDomains: allowedDomains}
+	fakeQuerier := store.NewFakeQuerier()
+	fakeQuerier.Users[100] = db.User{ID: 100, Email: "inviter@example.com", EmailVerified: 1}
+	return Init(c, fakeQuerier), fakeQuerier, model.User{Id: 100, Email: "inviter@example.com"}
+}
+
+func TestInviteUser(t *testing.T) {
+	ctx := context.Background()
+
+	f := func(mode string, allowedDomains []string, email string, expect error) {
+		t.Helper()
+
+		as, fakeQuerier, inviter := givenInviter(mode, allowedDomains...)
+		err := as.InviteUser(ctx, inviter, email)
+		if !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+		if created := len(fakeQuerier.Invitations) == 1; created != (expect == nil) {
+			t.Fatalf("unexpected invitations %+v", fakeQuerier.Invitations)
+		}
+	}
+
+	f(config.RegistrationInviteOnly, nil, "friend@example.com", nil)
+	f(config.RegistrationOpen, nil, "Friend@Example.com", nil)
+	f(config.RegistrationInviteOnly, []string{"example.com"}, "friend@example.com", nil)
+	f(config.RegistrationClosed, nil, "friend@example.com", ErrRegistrationClosed)
+	f(config.RegistrationInviteOnly, nil, "not-an-email", ErrInvalidEmail)
+	f(config.RegistrationInviteOnly, []string{"corp.example"}, "friend@example.com", ErrEmailDomainNotAllowed)
+	f(config.RegistrationInviteOnly, nil, "inviter@example.com", ErrEmailTaken)
+	f(config.RegistrationInviteOnly, nil, "Inviter@Example.com", ErrEmailTaken)
+
+	// accounts keep the case their email was registered with
+	as, fakeQuerier, inviter := givenInviter(config.RegistrationInviteOnly)
+	fakeQuerier.Users[101] = db.User{ID: 101, Email: "Friend@Example.com", EmailVerified: 1}
+	if err := as.InviteUser(ctx, inviter, "friend@example.com"); !errors.Is(err, ErrEmailTaken) {
+		t.Fatalf("expected ErrEmailTaken, got: %v", err)
+	}
+}
+
+func TestInviteUserLimitsPendingInvitations(t *testing.T) {
+	as, _, inviter := givenInviter(config.RegistrationInviteOnly)
+	ctx := context.Background()
+
+	for i := range maxPendingInvitations {
+		email := string(rune('a'+i)) + "@example.com"
+		if err := as.InviteUser(ctx, inviter, email); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	if err := as.InviteUser(ctx, inviter, "one-more@example.com"); !errors.Is(err, ErrTooManyInvitations) {
+		t.Fatalf("expected ErrTooManyInvitations, got: %v", err)
+	}
+
+	// Revoking one makes room for another
+	invitations, err := as.ListInvitations(ctx, inviter.Id)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(invitations) != maxPendingInvitations {
+		t.Fatalf("expected %d invitations, got %d", maxPendingInvitations, len(invitations))
+	}
+	if err := as.RevokeInvitation(ctx, 1, invitations[0].Id); !errors.Is(err, ErrInvitationNotFound) {
+		t.Fatalf("expected ErrInvitationNotFound for another user, got: %v", err)
+	}
+	if err := as.RevokeInvitation(ctx, inviter.Id, invitations[0].Id); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := as.InviteUser(ctx, inviter, "one-more@example.com"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+}
+
+func TestRegisterUserWithRegistrationModes(t *testing.T) {
+	ctx := context.Background()
+
+	f := func(mode string, allowedDomains []string, email, inviteToken string, expect error) {
+		t.Helper()
+
+		as, _, inviter := givenInviter(mode, allowedDomains...)
+		if mode != config.RegistrationClosed && allowedDomains == nil {
+			if err := as.InviteUser(ctx, inviter, "friend@example.com"); err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+		}
+
+		err := as.RegisterUser(ctx, email, "validpassword123", inviteToken)
+		if !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+	}
+
+	invite := TestInvitationToken + "-friend@example.com"
+
+	// open registration, an invitation is optional
+	f(config.RegistrationOpen, nil, "stranger@example.com", "", nil)
+	f(config.RegistrationOpen, nil, "friend@example.com", invite, nil)
+	f("", nil, "stranger@example.com", "", nil)
+
+	// invite only
+	f(config.RegistrationInviteOnly, nil, "friend@example.com", invite, nil)
+	f(config.RegistrationInviteOnly, nil, "Friend@Example.com", invite, nil)
+	f(config.RegistrationInviteOnly, nil, "stranger@example.com", "", ErrInvitationRequired)
+	f(config.RegistrationInviteOnly, nil, "stranger@example.com", invite, ErrInvalidInvitation)
+	f(config.RegistrationInviteOnly, nil, "friend@example.com", "wrong-token", ErrInvalidInvitation)
+
+	// closed
+	f(config.RegistrationClosed, nil, "stranger@example.com", "", ErrRegistrationClosed)
+
+	// allowed domains
+	f(config.RegistrationOpen, []string{"example.com"}, "stranger@example.com", "", nil)
+	f(config.RegistrationOpen, []string{"corp.example"}, "stranger@example.com", "", ErrEmailDomainNotAllowed)
+}
+
+func TestRegisterUserUsesInvitationOnce(t *testing.T) {
+	as, fakeQuerier, inviter := givenInviter(config.RegistrationInviteOnly)
+	ctx := context.Background()
+
+	if err := as.InviteUser(ctx, inviter, "friend@example.com"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	invite := TestInvitationToken + "-friend@example.com"
+
+	invitation, err := as.PrepareRegistration(ctx, invite)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if invitation.Email != "friend@example.com" {
+		t.Fatalf("unexpected invitation %+v", invitation)
+	}
+
+	if err := as.RegisterUser(ctx, "friend@example.com", "validpassword123", invite); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !fakeQuerier.Invitations[invitation.Id].UsedAt.Valid {
+		t.Fatalf("expected the invitation to be used")
+	}
+
+	if _, err := as.PrepareRegistration(ctx, invite); !errors.Is(err, ErrInvalidInvitation) {
+		t.Fatalf("expected ErrInvalidInvitation, got: %v", err)
+	}
+	delete(fakeQuerier.Users, 1)
+	if err := as.RegisterUser(ctx, "friend@example.com", "validpassword123", invite); !errors.Is(err, ErrInvalidInvitation) {
+		t.Fatalf("expected ErrInvalidInvitation, got: %v", err)
+	}
+
+	events, err := as.ListUserAuthEvents(ctx, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(events) == 0 || events[0].Type != AuthEventRegister || events[0].Reason != "invitation" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
+
+func TestPrepareRegistrationRefusesExpiredInvitation(t *testing.T) {
+	as, fakeQuerier, inviter := givenInviter(config.RegistrationInviteOnly)
+	ctx := context.Background()
+
+	if err := as.InviteUser(ctx, inviter, "friend@example.com"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	for id, invitation := range fakeQuerier.Invitations {
+		invitation.ExpiresAt = time.Now().Add(-time.Second).Unix()
+		fakeQuerier.Invitations[id] = invitation
+	}
+
+	if _, err := as.PrepareRegistration(ctx, TestInvitationToken+"-friend@example.com"); !errors.Is(err, ErrInvalidInvitation) {
+		t.Fatalf("expected ErrInvalidInvitation, got: %v", err)
+	}
+	if _, err := as.PrepareRegistration(ctx, ""); !errors.Is(err, ErrInvitationRequired) {
+		t.Fatalf("expected ErrInvitationRequired, got: %v", err)
+	}
+}
@@ -2,28 +2,99 @@ package auth
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
 
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
 	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
 	"github.com/AltSoyuz/soy-experiments/lib/argon2id"
 )
 
-func (as *Service) AuthenticateWithPassword(ctx context.Context, email, password string) (s model.Session, t string, err error) {
-	user, err := as.queries.GetUserByEmail(ctx, email)
-	if err != nil {
+// LoginThrottledError is returned while password attempts for an email are paused after repeated failures.
+// Unknown emails are throttled the same way so the error does not reveal which accounts exist.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("too many failed attempts, sign-in is locked for %s. If this account exists, we sent it an email to unlock it", FormatRetryAfter(e.RetryAfter))
+	}
+	return fmt.Sprintf("too many failed attempts, try again in %s", FormatRetryAfter(e.RetryAfter))
+}
+
+// newDummyPasswordHash returns the hash verified for unknown emails so they take as long to reject as a wrong password.
+// It is created on first use, with the parameters of new password hashes.
+func newDummyPasswordHash(params argon2id.Params) func() string {
+	return sync.OnceValue(func() string {
+		hash, err := argon2id.HashWithParams("dummy password for unknown accounts", params)
+		if err != nil {
+			panic(fmt.Sprintf("failed to hash dummy password: %v", err))
+		}
+		return hash
+	})
+}
+
+// AuthenticateWithPassword creates a session when the password matches.
+// Failures are counted per email: after a few of them each attempt must wait longer,
+// and after too many the email is locked for a while and the owner gets a link to unlock it.
+// Wrong passwords and unknown emails both return ErrInvalidCredentials or a *LoginThrottledError.
+func (as *Service) AuthenticateWithPassword(ctx context.Context, email, password string, client ClientInfo) (s model.Session, t string, err error) {
+	event := model.AuthEvent{Type: AuthEventLogin, Email: email, Reason: "password"}
+	defer func() { as.recordEvent(ctx, event, err) }()
+
+	key := normalizeEmail(email)
+	if err := as.checkLoginThrottle(ctx, key); err != nil {
 		return model.Session{}, "", err
 	}
 
-	validPassword, err := argon2id.Verify(user.PasswordHash, password)
+	user, err := as.queries.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := as.verifyPassword(ctx, as.dummyPasswordHash(), password); err != nil {
+			return model.Session{}, "", err
+		}
+		event.Reason = "unknown email"
+		return model.Session{}, "", as.recordLoginFailure(ctx, key, nil)
+	}
 	if err != nil {
 		return model.Session{}, "", err
 	}
+	event.UserId = user.ID
 
+	// Accounts created through an OAuth provider have no password until they reset it
+	validPassword := false
+	if user.PasswordHash != "" {
+		validPassword, err = as.verifyPassword(ctx, user.PasswordHash, password)
+		if err != nil {
+			return model.Session{}, "", err
+		}
+	}
 	if !validPassword {
-		return model.Session{}, "", fmt.Errorf("invalid password")
+		return model.Session{}, "", as.recordLoginFailure(ctx, key, &user)
+	}
+	// Only reported once the password is known to be right, so it does not help guessing it
+	if user.Disabled != 0 {
+		event.Reason = "account disabled"
+		return model.Session{}, "", ErrAccountDisabled
+	}
+	if user.PasswordResetRequired != 0 {
+		event.Reason = "password reset required"
+		return model.Session{}, "", ErrPasswordResetRequired
 	}
 
-	token, err := as.createSession(ctx, user.ID)
+	if err := as.queries.DeleteLoginAttempt(ctx, key); err != nil {
+		return model.Session{}, "", err
+	}
+
+	as.rehashPassword(ctx, user, password)
+
+	token, err := as.createSession(ctx, user.ID, client)
 	if err != nil {
 		return model.Session{}, "", err
 	}
@@ -35,3 +106,166 @@ func (as *Service) AuthenticateWithPassword(ctx context.Context, email, password
 
 	return session, token, err
 }
+
+// rehashPassword replaces a password hash created with outdated parameters while the password is known.
+// Failures are only logged, the old hash still verifies and the next login tries again.
+func (as *Service) rehashPassword(ctx context.Context, user db.User, password string) {
+	needsRehash, err := as.passwordHasher.NeedsRehash(user.PasswordHash)
+	if err != nil || !needsRehash {
+		return
+	}
+
+	passwordHash, err := as.hashPassword(ctx, password)
+	if err != nil {
+		slog.Error("failed to rehash password", "userId", user.ID, "error", err)
+		return
+	}
+
+	// The hash is only replaced if the password did not change since it was verified
+	updated, err := as.queries.RehashUserPassword(ctx, db.RehashUserPasswordParams{
+		PasswordHash:         passwordHash,
+		ID:                   user.ID,
+		PreviousPasswordHash: user.PasswordHash,
+	})
+	if err != nil {
+		slog.Error("failed to store rehashed password", "userId", user.ID, "error", err)
+		return
+	}
+	if updated == 1 {
+		slog.Info("password rehashed with the current parameters", "userId", user.ID)
+	}
+}
+
+// UnlockLogin clears the failed attempts of the email the unlock link was sent for
+func (as *Service) UnlockLogin(ctx context.Context, token string) (err error) {
+	event := model.AuthEvent{Type: AuthEventLoginUnlock}
+	defer func() { as.recordEvent(ctx, event, err) }()
+
+	if token == "" {
+		return ErrInvalidUnlockLink
+	}
+
+	attempt, err := as.queries.GetLoginAttemptByUnlockTokenHash(ctx, sql.NullString{String: hashToken(token), Valid: true})
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidUnlockLink
+	}
+	if err != nil {
+		return err
+	}
+	event.Email = attempt.Email
+
+	slog.Info("sign-in unlocked from the emailed link")
+	return as.queries.DeleteLoginAttempt(ctx, attempt.Email)
+}
+
+// checkLoginThrottle refuses attempts made while the email is locked or still waiting after its last failure
+func (as *Service) checkLoginThrottle(ctx context.Context, key string) error {
+	attempt, err := as.queries.GetLoginAttempt(ctx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if lockedUntil := time.Unix(attempt.LockedUntil, 0); lockedUntil.After(now) {
+		return &LoginThrottledError{RetryAfter: lockedUntil.Sub(now), Locked: true}
+	}
+	retryAt := time.Unix(attempt.LastFailedAt, 0).Add(loginBackoff(attempt.FailedCount))
+	if retryAt.After(now) {
+		return &LoginThrottledError{RetryAfter: retryAt.Sub(now)}
+	}
+
+	return nil
+}
+
+// recordLoginFailure counts a failed attempt and returns the error to show for it.
+// user is nil when no account uses the email.
+func (as *Service) recordLoginFailure(ctx context.Context, key string, user *db.User) error {
+	now := time.Now()
+	attempt, err := as.queries.RecordLoginFailure(ctx, db.RecordLoginFailureParams{
+		Email:       key,
+		FailedAt:    now.Unix(),
+		WindowStart: now.Add(-loginFailureWindow).Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record login failure: %w", err)
+	}
+
+	if attempt.FailedCount >= loginLockoutThreshold {
+		return as.lockLogin(ctx, key, user, now)
+	}
+	if wait := loginBackoff(attempt.FailedCount); wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+	return ErrInvalidCredentials
+}
+
+// lockLogin locks the email and sends the owner of the account, if any, a link to unlock it
+func (as *Service) lockLogin(ctx context.Context, key string, user *db.User, now time.Time) error {
+	token, err := as.generateLoginUnlockToken()
+	if err != nil {
+		return err
+	}
+
+	err = as.queries.LockLoginAttempt(ctx, db.LockLoginAttemptParams{
+		LockedUntil:     now.Add(loginLockoutDuration).Unix(),
+		UnlockTokenHash: sql.NullString{String: hashToken(token), Valid: true},
+		Email:           key,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to lock login: %w", err)
+	}
+
+	if user != nil {
+		slog.Warn("sign-in locked after repeated failures", "userId", user.ID)
+		link := fmt.Sprintf("%s/login/unlock?code=%s", as.Config.BaseURL, url.QueryEscape(token))
+		go as.sendEmailAsync(EmailParams{
+			To:      []string{user.Email},
+			Subject: "Sign-in to your account was locked",
+			Body: fmt.Sprintf(
+				"There were too many failed attempts to sign in to your account, so password sign-in is locked for %s.\r\n\r\nIf this was you, unlock it now with this link: %s\r\nIf it was not, nobody got in, but consider changing your password.",
+				FormatRetryAfter(loginLockoutDuration),
+				link,
+			),
+		})
+	}
+
+	return &LoginThrottledError{RetryAfter: loginLockoutDuration, Locked: true}
+}
+
+// loginBackoff is how long to wait after the given number of consecutive failures
+func loginBackoff(failures int64) time.Duration {
+	if failures < loginBackoffThreshold {
+		return 0
+	}
+	wait := loginBackoffBase
+	for i := int64(loginBackoffThreshold); i < failures && wait < loginBackoffMax; i++ {
+		wait *= 2
+	}
+	return min(wait, loginBackoffMax)
+}
+
+// generateLoginUnlockToken generates the random token carried by the unlock link
+func (as *Service) generateLoginUnlockToken() (string, error) {
+	if as.Config.Env == "test" {
+		return TestLoginUnlockToken, nil
+	}
+	return generateTokenSession()
+}
+
+// FormatRetryAfter rounds a wait up to whole seconds or minutes for messages shown to users
+func FormatRetryAfter(d time.Duration) string {
+	if seconds := int((d + time.Second - 1) / time.Second); seconds < 60 {
+		if seconds == 1 {
+			return "1 second"
+		}
+		return fmt.Sprintf("%d seconds", seconds)
+	}
+	minutes := int((d + time.Minute - 1) / time.Minute)
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
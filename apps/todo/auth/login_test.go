@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/store"
+	"github.com/AltSoyuz/soy-experiments/lib/argon2id"
+)
+
+func givenPasswordUser(t *testing.T) (*Service, *store.FakeQuerier) {
+	t.Helper()
+
+	fakeQuerier := store.NewFakeQuerier()
+	hash, err := argon2id.Hash("Str0ngP@ssw0rd!")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@example.com", PasswordHash: hash, EmailVerified: 1}
+	return Init(givenTestConfig(), fakeQuerier), fakeQuerier
+}
+
+// givenWaitElapsed moves the last failure back so the next attempt is not throttled by the backoff
+func givenWaitElapsed(fakeQuerier *store.FakeQuerier, email string) {
+	attempt := fakeQuerier.LoginAttempts[email]
+	attempt.LastFailedAt -= int64(loginBackoffMax.Seconds())
+	fakeQuerier.LoginAttempts[email] = attempt
+}
+
+func TestLoginBackoff(t *testing.T) {
+	f := func(failures int64, expect time.Duration) {
+		t.Helper()
+
+		if got := loginBackoff(failures); got != expect {
+			t.Fatalf("unexpected backoff after %d failures; got %s; want %s", failures, got, expect)
+		}
+	}
+
+	f(0, 0)
+	f(loginBackoffThreshold-1, 0)
+	f(loginBackoffThreshold, loginBackoffBase)
+	f(loginBackoffThreshold+1, 2*loginBackoffBase)
+	f(loginBackoffThreshold+3, 8*loginBackoffBase)
+	f(loginLockoutThreshold*10, loginBackoffMax)
+}
+
+func TestAuthenticateWithPasswordThrottling(t *testing.T) {
+	ctx := context.Background()
+
+	f := func(email string) {
+		t.Helper()
+
+		as, fakeQuerier := givenPasswordUser(t)
+
+		for i := 1; i < loginBackoffThreshold; i++ {
+			if _, _, err := as.AuthenticateWithPassword(ctx, email, "wrong-password", ClientInfo{}); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("expected ErrInvalidCredentials, got: %v", err)
+			}
+		}
+
+		// The next failure must wait before another attempt, even with the right password
+		var throttled *LoginThrottledError
+		if _, _, err := as.AuthenticateWithPassword(ctx, email, "wrong-password", ClientInfo{}); !errors.As(err, &throttled) || throttled.Locked {
+			t.Fatalf("expected a backoff, got: %v", err)
+		}
+		if _, _, err := as.AuthenticateWithPassword(ctx, email, "Str0ngP@ssw0rd!", ClientInfo{}); !errors.As(err, &throttled) {
+			t.Fatalf("expected the attempt to be refused during the backoff, got: %v", err)
+		}
+
+		for i := loginBackoffThreshold; i < loginLockoutThreshold; i++ {
+			givenWaitElapsed(fakeQuerier, "user@example.com")
+			_, _, err := as.AuthenticateWithPassword(ctx, email, "wrong-password", ClientInfo{})
+			if !errors.As(err, &throttled) {
+				t.Fatalf("expected a throttled error, got: %v", err)
+			}
+		}
+		if !throttled.Locked || throttled.RetryAfter != loginLockoutDuration {
+			t.Fatalf("expected the email to be locked, got: %+v", throttled)
+		}
+
+		// Waiting out the backoff is not enough while locked
+		givenWaitElapsed(fakeQuerier, "user@example.com")
+		if _, _, err := as.AuthenticateWithPassword(ctx, email, "Str0ngP@ssw0rd!", ClientInfo{}); !errors.As(err, &throttled) || !throttled.Locked {
+			t.Fatalf("expected the email to stay locked, got: %v", err)
+		}
+
+		// The emailed link unlocks it
+		if err := as.UnlockLogin(ctx, "unknown"); !errors.Is(err, ErrInvalidUnlockLink) {
+			t.Fatalf("expected ErrInvalidUnlockLink, got: %v", err)
+		}
+		if err := as.UnlockLogin(ctx, TestLoginUnlockToken); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if _, _, err := as.AuthenticateWithPassword(ctx, "user@example.com", "Str0ngP@ssw0rd!", ClientInfo{}); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if len(fakeQuerier.LoginAttempts) != 0 {
+			t.Fatalf("expected failures to be cleared after a successful login")
+		}
+	}
+
+	// failures are counted whatever the case of the email
+	f("user@example.com")
+	f("User@Example.com ")
+}
+
+func TestAuthenticateWithPasswordUnknownEmail(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	ctx := context.Background()
+
+	// Unknown emails look exactly like wrong passwords
+	var throttled *LoginThrottledError
+	for i := 1; i <= loginLockoutThreshold; i++ {
+		givenWaitElapsed(fakeQuerier, "unknown@example.com")
+		_, _, err := as.AuthenticateWithPassword(ctx, "unknown@example.com", "wrong-password", ClientInfo{})
+		if i < loginBackoffThreshold && !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected ErrInvalidCredentials, got: %v", err)
+		}
+		if i >= loginBackoffThreshold && !errors.As(err, &throttled) {
+			t.Fatalf("expected a throttled error, got: %v", err)
+		}
+	}
+	if !throttled.Locked {
+		t.Fatalf("expected the unknown email to be locked too")
+	}
+}
+
+func TestAuthenticateWithPasswordRehash(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	ctx := context.Background()
+
+	outdated, err := argon2id.HashWithParams("Str0ngP@ssw0rd!", argon2id.Params{Memory: 8192, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	user := fakeQuerier.Users[1]
+	user.PasswordHash = outdated
+	fakeQuerier.Users[1] = user
+
+	// A wrong password keeps the outdated hash
+	if _, _, err := as.AuthenticateWithPassword(ctx, "user@example.com", "wrong-password", ClientInfo{}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got: %v", err)
+	}
+	if fakeQuerier.Users[1].PasswordHash != outdated {
+		t.Fatalf("expected the hash to be kept")
+	}
+
+	// A successful login stores a hash with the current parameters
+	if _, _, err := as.AuthenticateWithPassword(ctx, "user@example.com", "Str0ngP@ssw0rd!", ClientInfo{}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	rehashed := fakeQuerier.Users[1].PasswordHash
+	needsRehash, err := argon2id.NeedsRehash(rehashed, argon2id.DefaultParams)
+	if err != nil || needsRehash {
+		t.Fatalf("expected a hash with the default parameters, got %s, %v", rehashed, err)
+	}
+
+	// The new hash verifies and is left alone
+	if _, _, err := as.AuthenticateWithPassword(ctx, "user@example.com", "Str0ngP@ssw0rd!", ClientInfo{}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if fakeQuerier.Users[1].PasswordHash != rehashed {
+		t.Fatalf("expected the current hash to be kept")
+	}
+}
+
+func TestLoginFailuresExpire(t *testing.T) {
+	as, fakeQuerier := givenPasswordUser(t)
+	ctx := context.Background()
+
+	fakeQuerier.LoginAttempts["user@example.com"] = db.LoginAttempt{
+		Email:        "user@example.com",
+		FailedCount:  loginLockoutThreshold - 1,
+		LastFailedAt: time.Now().Add(-loginFailureWindow - time.Minute).Unix(),
+	}
+
+	if _, _, err := as.AuthenticateWithPassword(ctx, "user@example.com", "wrong-password", ClientInfo{}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected old failures to be forgotten, got: %v", err)
+	}
+	if got := fakeQuerier.LoginAttempts["user@example.com"].FailedCount; got != 1 {
+		t.Fatalf("expected the count to restart, got %d", got)
+	}
+}
+
+func TestFormatRetryAfter(t *testing.T) {
+	f := func(d time.Duration, expect string) {
+		t.Helper()
+
+		if got := FormatRetryAfter(d); got != expect {
+			t.Fatalf("unexpected format; got %q; want %q", got, expect)
+		}
+	}
+
+	f(time.Second, "1 second")
+	f(1500*time.Millisecond, "2 seconds")
+	f(59500*time.Millisecond, "1 minute")
+	f(time.Minute, "1 minute")
+	f(14*time.Minute+time.Second, "15 minutes")
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+)
+
+const magicLinkCookieName = "magic_link"
+
+// RequestMagicLink emails a single-use sign-in link to the given address.
+// The returned browser token must be stored with SetMagicLinkCookie: the link only works
+// in the browser holding it, so an intercepted link cannot be replayed from another device.
+// A browser token is returned even when no account matches so that callers cannot probe for registered emails.
+func (as *Service) RequestMagicLink(ctx context.Context, email string) (b string, err error) {
+	event := model.AuthEvent{Type: AuthEventMagicLinkRequest, Email: email}
+	defer func() { as.recordEvent(ctx, event, err) }()
+
+	browserToken, err := generateTokenSession()
+	if err != nil {
+		return "", err
+	}
+
+	// The address is matched whatever its case, the link still goes to the one stored on the account
+	user, err := as.queries.GetUserByNormalizedEmail(ctx, normalizeEmail(email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			slog.Info("magic link requested for unknown email")
+			event.Reason = "unknown email"
+			return browserToken, nil
+		}
+		return "", err
+	}
+	event.UserId = user.ID
+
+	token, err := as.generateMagicLinkToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = as.queries.InsertMagicLinkRequest(ctx, db.InsertMagicLinkRequestParams{
+		UserID:      user.ID,
+		TokenHash:   hashToken(token),
+		BrowserHash: hashToken(browserToken),
+		CreatedAt:   now.Unix(),
+		ExpiresAt:   now.Add(magicLinkDuration).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create magic link request: %w", err)
+	}
+
+	link := fmt.Sprintf("%s/login/magic-link/verify?token=%s", as.Config.BaseURL, url.QueryEscape(token))
+	go as.sendEmailAsync(EmailParams{
+		To:      []string{user.Email},
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf(
+			"Follow this link to sign in: %s\r\n\r\nThe link expires in %d minutes and only works in the browser where you asked for it. If you did not ask to sign in, you can ignore this email.",
+			link,
+			int(magicLinkDuration.Minutes()),
+		),
+	})
+
+	return browserToken, nil
+}
+
+// AuthenticateWithMagicLink consumes a sign-in link opened in the browser that requested it
+// and creates a session. Two-factor authentication still applies to the new session.
+// Accounts whose password must be reset are refused: the link proves the address, which a reset also
+// requires, but skipping the reset would leave the password in place.
+func (as *Service) AuthenticateWithMagicLink(ctx context.Context, token, browserToken string, client ClientInfo) (s model.Session, t string, err error) {
+	event := model.AuthEvent{Type: AuthEventLogin, Reason: "magic link"}
+	defer func() { as.recordEvent(ctx, event, err) }()
+
+	if token == "" || browserToken == "" {
+		return model.Session{}, "", ErrInvalidMagicLink
+	}
+
+	request, err := as.queries.GetMagicLinkRequestByTokenHash(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.Session{}, "", ErrInvalidMagicLink
+		}
+		return model.Session{}, "", err
+	}
+	event.UserId = request.UserID
+
+	// A link opened elsewhere is left untouched so the requesting browser can still use it
+	if subtle.ConstantTimeCompare([]byte(request.BrowserHash), []byte(hashToken(browserToken))) != 1 {
+		slog.Info("magic link opened in another browser", "userId", request.UserID)
+		return model.Session{}, "", ErrInvalidMagicLink
+	}
+
+	// Consume the link before anything else so concurrent requests cannot both use it
+	deleted, err := as.queries.DeleteMagicLinkRequest(ctx, request.ID)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+	if deleted == 0 || time.Now().Unix() >= request.ExpiresAt {
+		return model.Session{}, "", ErrInvalidMagicLink
+	}
+
+	user, err := as.queries.GetUserByID(ctx, request.UserID)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+	if user.PasswordResetRequired != 0 {
+		event.Reason = "password reset required"
+		return model.Session{}, "", ErrPasswordResetRequired
+	}
+
+	// Following the emailed link proves ownership of the address
+	if err := as.queries.SetUserEmailVerified(ctx, request.UserID); err != nil {
+		return model.Session{}, "", err
+	}
+
+	sessionToken, err := as.createSession(ctx, request.UserID, client)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+
+	session, _, err := as.validateSession(ctx, sessionToken)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+
+	return session, sessionToken, nil
+}
+
+// generateMagicLinkToken generates the random token carried by the sign-in link
+func (as *Service) generateMagicLinkToken() (string, error) {
+	if as.Config.Env == "test" {
+		return TestMagicLinkToken, nil
+	}
+	return generateTokenSession()
+}
+
+// SetMagicLinkCookie binds a requested sign-in link to this browser
+func SetMagicLinkCookie(w http.ResponseWriter, browserToken string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkCookieName,
+		Value:    browserToken,
+		Path:     "/login/magic-link",
+		HttpOnly: true,
+		Secure:   true,
+		// Lax is required for the cookie to be sent when the link is opened from a mail client
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(magicLinkDuration.Seconds()),
+	})
+}
+
+// GetMagicLinkFromCookie reads the browser token stored by SetMagicLinkCookie
+func GetMagicLinkFromCookie(r *http.Request) string {
+	cookie, err := r.Cookie(magicLinkCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// DeleteMagicLinkCookie deletes the browser token once the link was used
+func DeleteMagicLinkCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkCookieName,
+		Value:    "",
+		Path:     "/login/magic-link",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+}
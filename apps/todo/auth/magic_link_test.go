@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/store"
+)
+
+func TestRequestMagicLink(t *testing.T) {
+	fakeQuerier := store.NewFakeQuerier()
+	as := Init(givenTestConfig(), fakeQuerier)
+	fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@test.com", PasswordHash: "hash"}
+	ctx := context.Background()
+
+	// unknown emails still get a browser token so they look the same to the caller
+	browserToken, err := as.RequestMagicLink(ctx, "unknown@test.com")
+	if err != nil || browserToken == "" {
+		t.Fatalf("expected a browser token, got %q, %v", browserToken, err)
+	}
+	if len(fakeQuerier.MagicLinkRequests) != 0 {
+		t.Fatalf("expected no magic link for an unknown email")
+	}
+
+	browserToken, err = as.RequestMagicLink(ctx, "user@test.com")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	request, ok := fakeQuerier.MagicLinkRequests[1]
+	if !ok {
+		t.Fatalf("expected a magic link request")
+	}
+	if request.TokenHash != hashToken(TestMagicLinkToken) || request.BrowserHash != hashToken(browserToken) {
+		t.Fatalf("expected tokens to be stored hashed")
+	}
+	if request.ExpiresAt > time.Now().Add(magicLinkDuration).Unix() {
+		t.Fatalf("expected magic link to expire within %s", magicLinkDuration)
+	}
+
+	// the address is matched whatever its case
+	delete(fakeQuerier.MagicLinkRequests, 1)
+	if _, err := as.RequestMagicLink(ctx, " User@Test.com "); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, ok := fakeQuerier.MagicLinkRequests[1]; !ok {
+		t.Fatalf("expected a magic link request")
+	}
+}
+
+func TestAuthenticateWithMagicLink(t *testing.T) {
+	ctx := context.Background()
+
+	f := func(token string, sameBrowser bool, expiresIn time.Duration, expect error) {
+		t.Helper()
+
+		fakeQuerier := store.NewFakeQuerier()
+		as := Init(givenTestConfig(), fakeQuerier)
+		fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@test.com", PasswordHash: "hash"}
+
+		browserToken, err := as.RequestMagicLink(ctx, "user@test.com")
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		request := fakeQuerier.MagicLinkRequests[1]
+		request.ExpiresAt = time.Now().Add(expiresIn).Unix()
+		fakeQuerier.MagicLinkRequests[1] = request
+
+		if !sameBrowser {
+			browserToken = "another-browser"
+		}
+
+		session, sessionToken, err := as.AuthenticateWithMagicLink(ctx, token, browserToken, ClientInfo{})
+		if !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+		if expect != nil {
+			return
+		}
+
+		if sessionToken == "" || session.UserId != 1 {
+			t.Fatalf("expected a session for the user")
+		}
+		if fakeQuerier.Users[1].EmailVerified != 1 {
+			t.Fatalf("expected email to be verified")
+		}
+
+		// single use
+		if _, _, err := as.AuthenticateWithMagicLink(ctx, token, browserToken, ClientInfo{}); !errors.Is(err, ErrInvalidMagicLink) {
+			t.Fatalf("expected ErrInvalidMagicLink on reuse, got: %v", err)
+		}
+	}
+
+	// unknown token
+	f("unknown", true, time.Minute, ErrInvalidMagicLink)
+
+	// opened in another browser
+	f(TestMagicLinkToken, false, time.Minute, ErrInvalidMagicLink)
+
+	// expired
+	f(TestMagicLinkToken, true, -time.Minute, ErrInvalidMagicLink)
+
+	// valid link in the requesting browser
+	f(TestMagicLinkToken, true, time.Minute, nil)
+}
+
+func TestMagicLinkFromAnotherBrowserKeepsLink(t *testing.T) {
+	fakeQuerier := store.NewFakeQuerier()
+	as := Init(givenTestConfig(), fakeQuerier)
+	fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@test.com", PasswordHash: "hash"}
+	ctx := context.Background()
+
+	browserToken, err := as.RequestMagicLink(ctx, "user@test.com")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	// A mail scanner or attacker opening the link must not burn it for the user
+	if _, _, err := as.AuthenticateWithMagicLink(ctx, TestMagicLinkToken, "", ClientInfo{}); !errors.Is(err, ErrInvalidMagicLink) {
+		t.Fatalf("expected ErrInvalidMagicLink, got: %v", err)
+	}
+	if _, _, err := as.AuthenticateWithMagicLink(ctx, TestMagicLinkToken, browserToken, ClientInfo{}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+}
+
+func TestMagicLinkCookie(t *testing.T) {
+	rr := httptest.NewRecorder()
+	SetMagicLinkCookie(rr, "browser-token")
+
+	req := httptest.NewRequest(http.MethodGet, "/login/magic-link/verify", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.SameSite != http.SameSiteLaxMode || !cookie.HttpOnly || !cookie.Secure {
+			t.Fatalf("unexpected cookie attributes %+v", cookie)
+		}
+		req.AddCookie(cookie)
+	}
+
+	if got := GetMagicLinkFromCookie(req); got != "browser-token" {
+		t.Fatalf("unexpected browser token %q", got)
+	}
+}
+
+func TestMagicLinkPasswordResetRequired(t *testing.T) {
+	fakeQuerier := store.NewFakeQuerier()
+	as := Init(givenTestConfig(), fakeQuerier)
+	fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@test.com", PasswordHash: "hash", PasswordResetRequired: 1}
+	ctx := context.Background()
+
+	browserToken, err := as.RequestMagicLink(ctx, "user@test.com")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, _, err := as.AuthenticateWithMagicLink(ctx, TestMagicLinkToken, browserToken, ClientInfo{}); !errors.Is(err, ErrPasswordResetRequired) {
+		t.Fatalf("expected ErrPasswordResetRequired, got: %v", err)
+	}
+	if len(fakeQuerier.Sessions) != 0 {
+		t.Fatalf("expected no session to be created")
+	}
+}
@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/config"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+	"github.com/AltSoyuz/soy-experiments/lib/oidc"
+)
+
+const (
+	oauthStateCookieName = "oauth_state"
+	oauthStateMaxAge     = 10 * 60
+)
+
+// OAuthState is what the browser must bring back to the callback to complete a login
+type OAuthState struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+// oauthProvider lazily discovers the OpenID Connect metadata of a configured provider
+type oauthProvider struct {
+	config config.OAuthProvider
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func newOAuthProviders(providers []config.OAuthProvider) map[string]*oauthProvider {
+	m := make(map[string]*oauthProvider, len(providers))
+	for _, p := range providers {
+		m[p.Name] = &oauthProvider{config: p}
+	}
+	return m
+}
+
+// OAuthProviderNames returns the names of the configured login providers
+func (as *Service) OAuthProviderNames() []string {
+	names := make([]string, 0, len(as.Config.OAuthProviders))
+	for _, p := range as.Config.OAuthProviders {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// StartOAuth returns the authorization URL of the provider and the state to store in the browser
+func (as *Service) StartOAuth(ctx context.Context, providerName string) (string, OAuthState, error) {
+	provider, cfg, err := as.oidcProvider(ctx, providerName)
+	if err != nil {
+		return "", OAuthState{}, err
+	}
+
+	var st OAuthState
+	for _, v := range []*string{&st.State, &st.Nonce, &st.CodeVerifier} {
+		if *v, err = oidc.RandomString(); err != nil {
+			return "", OAuthState{}, err
+		}
+	}
+
+	return provider.AuthCodeURL(cfg, st.State, st.Nonce, st.CodeVerifier), st, nil
+}
+
+// AuthenticateWithOAuth completes the authorization code flow and creates a session for the linked user
+func (as *Service) AuthenticateWithOAuth(ctx context.Context, providerName string, expected OAuthState, state, code string, client ClientInfo) (s model.Session, t string, err error) {
+	event := model.AuthEvent{Type: AuthEventLogin, Reason: "oauth " + providerName}
+	defer func() { as.recordEvent(ctx, event, err) }()
+
+	if expected.State == "" || subtle.ConstantTimeCompare([]byte(expected.State), []byte(state)) != 1 {
+		return model.Session{}, "", ErrOAuthStateMismatch
+	}
+
+	provider, cfg, err := as.oidcProvider(ctx, providerName)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+
+	token, err := provider.Exchange(ctx, cfg, code, expected.CodeVerifier)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+
+	claims, err := provider.VerifyIDToken(ctx, cfg, token.IDToken, expected.Nonce)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+
+	email, emailVerified := claims.Email, bool(claims.EmailVerified)
+	if email == "" {
+		info, err := provider.UserInfo(ctx, token.AccessToken)
+		if err != nil {
+			return model.Session{}, "", err
+		}
+		if info.Subject != claims.Subject {
+			return model.Session{}, "", fmt.Errorf("userinfo subject does not match id token")
+		}
+		email, emailVerified = info.Email, bool(info.EmailVerified)
+	}
+	event.Email = email
+
+	userId, err := as.resolveOAuthUser(ctx, providerName, claims.Subject, strings.ToLower(email), emailVerified)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+	event.UserId = userId
+
+	sessionToken, err := as.createSession(ctx, userId, client)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+
+	session, _, err := as.validateSession(ctx, sessionToken)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+
+	return session, sessionToken, nil
+}
+
+// resolveOAuthUser finds the user linked to the provider account, linking or creating one by verified email
+func (as *Service) resolveOAuthUser(ctx context.Context, providerName, subject, email string, emailVerified bool) (int64, error) {
+	account, err := as.queries.GetOAuthAccount(ctx, db.GetOAuthAccountParams{
+		Provider:       providerName,
+		ProviderUserID: subject,
+	})
+	if err == nil {
+		return account.UserID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	// Without a verified email we cannot tell who the account belongs to
+	if email == "" || !emailVerified {
+		return 0, ErrOAuthEmailNotVerified
+	}
+
+	// The account is only created together with its link, or the email would be taken without a way to sign in
+	var user db.User
+	err = as.queries.InTx(ctx, func(q db.Querier) error {
+		user, err = q.GetUserByEmail(ctx, email)
+		switch {
+		case err == nil:
+			// Linking to an unverified account would let whoever registered it keep access
+			if user.EmailVerified == 0 {
+				return ErrOAuthAccountUnverified
+			}
+		case errors.Is(err, sql.ErrNoRows):
+			// Provider accounts cannot carry an invitation, so they only create accounts when registration is open
+			if _, err := as.checkRegistration(ctx, email, ""); err != nil {
+				return err
+			}
+			user, err = q.CreateUser(ctx, db.CreateUserParams{
+				Email:        email,
+				PasswordHash: "",
+			})
+			if err != nil {
+				return err
+			}
+			if err := q.SetUserEmailVerified(ctx, user.ID); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		_, err = q.CreateOAuthAccount(ctx, db.CreateOAuthAccountParams{
+			UserID:         user.ID,
+			Provider:       providerName,
+			ProviderUserID: subject,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to link oauth account: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	slog.Info("linked oauth account", "provider", providerName, "userId", user.ID)
+	return user.ID, nil
+}
+
+// oidcProvider returns the discovered provider and the relying party settings for it
+func (as *Service) oidcProvider(ctx context.Context, name string) (*oidc.Provider, oidc.Config, error) {
+	p, ok := as.oauthProviders[name]
+	if !ok {
+		return nil, oidc.Config{}, ErrOAuthProviderUnknown
+	}
+
+	cfg := oidc.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  fmt.Sprintf("%s/oauth/%s/callback", as.Config.BaseURL, name),
+		Scopes:       p.config.Scopes,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.provider == nil {
+		provider, err := oidc.Discover(ctx, p.config.Issuer, nil)
+		if err != nil {
+			return nil, oidc.Config{}, err
+		}
+		p.provider = provider
+	}
+
+	return p.provider, cfg, nil
+}
+
+// SetOAuthStateCookie stores the login state until the provider redirects back
+func SetOAuthStateCookie(w http.ResponseWriter, st OAuthState) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookieName,
+		Value:    strings.Join([]string{st.State, st.Nonce, st.CodeVerifier}, "."),
+		Path:     "/oauth/",
+		HttpOnly: true,
+		Secure:   true,
+		// Lax is required for the cookie to be sent on the redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   oauthStateMaxAge,
+	})
+}
+
+// GetOAuthStateFromCookie reads the login state stored by SetOAuthStateCookie
+func GetOAuthStateFromCookie(r *http.Request) (OAuthState, bool) {
+	cookie, err := r.Cookie(oauthStateCookieName)
+	if err != nil {
+		return OAuthState{}, false
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return OAuthState{}, false
+	}
+	return OAuthState{State: parts[0], Nonce: parts[1], CodeVerifier: parts[2]}, true
+}
+
+// DeleteOAuthStateCookie deletes the login state cookie
+func DeleteOAuthStateCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookieName,
+		Value:    "",
+		Path:     "/oauth/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/config"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/store"
+	"github.com/AltSoyuz/soy-experiments/lib/oidc/oidctest"
+)
+
+func givenOAuthService(t *testing.T) (*Service, *store.FakeQuerier, *oidctest.Provider) {
+	t.Helper()
+
+	provider := oidctest.NewProvider("todo", "secret")
+	t.Cleanup(provider.Close)
+
+	c := givenTestConfig()
+	c.BaseURL = "http://localhost:8080"
+	c.OAuthProviders = []config.OAuthProvider{{
+		Name:         "idp",
+		Issuer:       provider.Issuer(),
+		ClientID:     "todo",
+		ClientSecret: "secret",
+	}}
+
+	fakeQuerier := store.NewFakeQuerier()
+	return Init(c, fakeQuerier), fakeQuerier, provider
+}
+
+// authorizeWithProvider follows the authorization URL and returns the state and code sent to the callback
+func authorizeWithProvider(t *testing.T, authURL string) (string, string) {
+	t.Helper()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorization request failed: %v", err)
+	}
+	res.Body.Close()
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect: %v", err)
+	}
+	if location.Path != "/oauth/idp/callback" {
+		t.Fatalf("unexpected redirect %s", location)
+	}
+	return location.Query().Get("state"), location.Query().Get("code")
+}
+
+func TestAuthenticateWithOAuth(t *testing.T) {
+	as, fakeQuerier, provider := givenOAuthService(t)
+	ctx := context.Background()
+
+	fakeQuerier.Users[10] = db.User{ID: 10, Email: "verified@example.com", PasswordHash: "hash", EmailVerified: 1}
+	fakeQuerier.Users[11] = db.User{ID: 11, Email: "unverified@example.com", PasswordHash: "hash"}
+
+	f := func(user oidctest.User, expectUserId int64, expect error) {
+		t.Helper()
+
+		provider.SetUser(user)
+		authURL, st, err := as.StartOAuth(ctx, "idp")
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		state, code := authorizeWithProvider(t, authURL)
+
+		session, token, err := as.AuthenticateWithOAuth(ctx, "idp", st, state, code, ClientInfo{})
+		if !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+		if expect != nil {
+			return
+		}
+
+		if token == "" {
+			t.Fatalf("expected a session token")
+		}
+		if expectUserId != 0 && session.UserId != expectUserId {
+			t.Fatalf("expected session for user %d, got %d", expectUserId, session.UserId)
+		}
+		if fakeQuerier.Users[session.UserId].EmailVerified != 1 {
+			t.Fatalf("expected user email to be verified")
+		}
+	}
+
+	// links to an existing verified account by email
+	f(oidctest.User{Subject: "a", Email: "verified@example.com", EmailVerified: true}, 10, nil)
+
+	// returning users are found through the link even if the email changed at the provider
+	f(oidctest.User{Subject: "a", Email: "renamed@example.com", EmailVerified: true}, 10, nil)
+
+	// refuses to take over an account whose owner never verified the email
+	f(oidctest.User{Subject: "b", Email: "unverified@example.com", EmailVerified: true}, 0, ErrOAuthAccountUnverified)
+
+	// refuses emails the provider did not verify
+	f(oidctest.User{Subject: "c", Email: "new@example.com", EmailVerified: false}, 0, ErrOAuthEmailNotVerified)
+
+	// creates a new account otherwise
+	f(oidctest.User{Subject: "d", Email: "New@Example.com", EmailVerified: true}, 0, nil)
+	created, err := fakeQuerier.GetUserByEmail(ctx, "new@example.com")
+	if err != nil {
+		t.Fatalf("expected user to be created, got: %v", err)
+	}
+	if created.PasswordHash != "" {
+		t.Fatalf("expected oauth user to have no password")
+	}
+}
+
+// failingOAuthLinkQuerier fails to link provider accounts
+type failingOAuthLinkQuerier struct {
+	*store.FakeQuerier
+}
+
+func (q failingOAuthLinkQuerier) CreateOAuthAccount(ctx context.Context, arg db.CreateOAuthAccountParams) (db.OauthAccount, error) {
+	return db.OauthAccount{}, errors.New("disk full")
+}
+
+func (q failingOAuthLinkQuerier) InTx(ctx context.Context, fn func(db.Querier) error) error {
+	return q.FakeQuerier.InTx(ctx, func(db.Querier) error { return fn(q) })
+}
+
+func TestResolveOAuthUserLinkFails(t *testing.T) {
+	fakeQuerier := store.NewFakeQuerier()
+	as := Init(givenTestConfig(), failingOAuthLinkQuerier{fakeQuerier})
+
+	if _, err := as.resolveOAuthUser(context.Background(), "idp", "a", "new@example.com", true); err == nil {
+		t.Fatalf("expected the link to fail")
+	}
+	// the account is not created without its link, so the email stays free
+	if len(fakeQuerier.Users) != 0 {
+		t.Fatalf("expected no user to be created, got %+v", fakeQuerier.Users)
+	}
+}
+
+func TestAuthenticateWithOAuthState(t *testing.T) {
+	as, _, _ := givenOAuthService(t)
+	ctx := context.Background()
+
+	authURL, st, err := as.StartOAuth(ctx, "idp")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	_, code := authorizeWithProvider(t, authURL)
+
+	if _, _, err := as.AuthenticateWithOAuth(ctx, "idp", st, "forged", code, ClientInfo{}); !errors.Is(err, ErrOAuthStateMismatch) {
+		t.Fatalf("expected ErrOAuthStateMismatch, got: %v", err)
+	}
+	if _, _, err := as.AuthenticateWithOAuth(ctx, "idp", OAuthState{}, "", code, ClientInfo{}); !errors.Is(err, ErrOAuthStateMismatch) {
+		t.Fatalf("expected ErrOAuthStateMismatch, got: %v", err)
+	}
+
+	// the PKCE verifier must belong to the authorization request
+	st.CodeVerifier = "other"
+	if _, _, err := as.AuthenticateWithOAuth(ctx, "idp", st, st.State, code, ClientInfo{}); err == nil {
+		t.Fatalf("expected exchange with wrong code verifier to fail")
+	}
+
+	if _, _, err := as.StartOAuth(ctx, "unknown"); !errors.Is(err, ErrOAuthProviderUnknown) {
+		t.Fatalf("expected ErrOAuthProviderUnknown, got: %v", err)
+	}
+}
+
+func TestOAuthStateCookie(t *testing.T) {
+	st := OAuthState{State: "state", Nonce: "nonce", CodeVerifier: "verifier"}
+
+	rr := httptest.NewRecorder()
+	SetOAuthStateCookie(rr, st)
+
+	req := httptest.NewRequest(http.MethodGet, "/oauth/idp/callback", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.SameSite != http.SameSiteLaxMode || !cookie.HttpOnly || !cookie.Secure {
+			t.Fatalf("unexpected cookie attributes %+v", cookie)
+		}
+		req.AddCookie(cookie)
+	}
+
+	got, ok := GetOAuthStateFromCookie(req)
+	if !ok || got != st {
+		t.Fatalf("unexpected state; got %+v; want %+v", got, st)
+	}
+}
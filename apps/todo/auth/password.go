@@ -2,70 +2,206 @@ package auth
 
 import (
 	"bufio"
-	"crypto/sha256"
-	"crypto/subtle"
+	"context"
+	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"runtime"
 	"strings"
 	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/config"
+	"github.com/AltSoyuz/soy-experiments/lib/argon2id"
 )
 
-// verifyPasswordStrength checks password strength and HIBP database
-func verifyPasswordStrength(password string) error {
+const pwnedPasswordsURL = "https://api.pwnedpasswords.com"
+
+// PasswordChecker reports whether a password appears in a list of breached passwords
+type PasswordChecker interface {
+	IsBreached(ctx context.Context, password string) (bool, error)
+}
+
+// verifyPasswordStrength checks password length and the breached password list.
+// When the list cannot be checked the password is accepted, unless the check is configured to fail closed.
+func (as *Service) verifyPasswordStrength(ctx context.Context, password string) error {
 	// Basic length check
 	if len(password) < minPasswordLength {
 		return ErrWeakPassword
 	}
 
-	// Check if password exists in HIBP database
-	if err := checkHIBPDatabase(password); err != nil {
-		return err
+	breached, err := as.passwordChecker.IsBreached(ctx, password)
+	if err != nil {
+		if as.Config.PasswordCheckFailClosed {
+			slog.Error("breached password check failed", "error", err)
+			return ErrPasswordCheckUnavailable
+		}
+		slog.Warn("breached password check failed, accepting the password", "error", err)
+		return nil
+	}
+	if breached {
+		return ErrWeakPassword
 	}
 
 	return nil
 }
 
-// checkHIBPDatabase checks if password exists in the HIBP database
-func checkHIBPDatabase(password string) error {
-	// Generate SHA256 hash of password
-	hash := generatePasswordHash(password)
-	hashPrefix := hash[0:5]
+// newPasswordChecker returns the checker selected by the configuration.
+// A local index that cannot be opened is reported on every check so that the failure policy applies.
+func newPasswordChecker(cfg *config.Config) PasswordChecker {
+	if cfg.Env == "test" {
+		return &FakePasswordChecker{Breached: []string{TestBreachedPassword}}
+	}
+
+	switch cfg.PasswordCheck {
+	case config.PasswordCheckNone:
+		slog.Warn("breached password check disabled")
+		return disabledPasswordChecker{}
+	case config.PasswordCheckLocal:
+		checker, err := OpenLocalPasswordChecker(cfg.PasswordIndexPath, cfg.PasswordHashListPath)
+		if err != nil {
+			slog.Error("failed to open breached password index", "path", cfg.PasswordIndexPath, "error", err)
+			return unavailablePasswordChecker{err: err}
+		}
+		return checker
+	default:
+		return NewPwnedPasswordsChecker()
+	}
+}
+
+type disabledPasswordChecker struct{}
 
-	// Query HIBP API
-	matches, err := queryHIBPAPI(hashPrefix)
+func (disabledPasswordChecker) IsBreached(ctx context.Context, password string) (bool, error) {
+	return false, nil
+}
+
+type unavailablePasswordChecker struct {
+	err error
+}
+
+func (c unavailablePasswordChecker) IsBreached(ctx context.Context, password string) (bool, error) {
+	return false, c.err
+}
+
+// PwnedPasswordsChecker queries the Pwned Passwords range API.
+// Only the first 5 characters of the SHA-1 hash of the password leave the server (k-anonymity),
+// and responses are padded so their size does not reveal the prefix either.
+type PwnedPasswordsChecker struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewPwnedPasswordsChecker() *PwnedPasswordsChecker {
+	return &PwnedPasswordsChecker{
+		BaseURL: pwnedPasswordsURL,
+		Client:  &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (c *PwnedPasswordsChecker) IsBreached(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/range/"+prefix, nil)
 	if err != nil {
-		return fmt.Errorf("HIBP API check failed: %w", err)
+		return false, err
 	}
+	req.Header.Set("Add-Padding", "true")
 
-	// Check if password hash exists in response
-	if isHashCompromised(hash, hashPrefix, matches) {
-		return ErrWeakPassword
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("pwned passwords request failed: %w", err)
 	}
+	defer res.Body.Close()
 
-	return nil
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("pwned passwords returned status %d", res.StatusCode)
+	}
+
+	// Each line is the rest of a hash and how often it was seen, padding lines are seen 0 times
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		lineSuffix, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if ok && strings.EqualFold(lineSuffix, suffix) {
+			return count != "0", nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read pwned passwords response: %w", err)
+	}
+
+	return false, nil
+}
+
+// FakePasswordChecker is a PasswordChecker for tests, it only knows the given passwords.
+// When Err is set every check fails with it.
+type FakePasswordChecker struct {
+	Breached []string
+	Err      error
 }
 
-func generatePasswordHash(password string) string {
-	passwordHashBytes := sha256.Sum256([]byte(password))
-	return hex.EncodeToString(passwordHashBytes[:])
+func (c *FakePasswordChecker) IsBreached(ctx context.Context, password string) (bool, error) {
+	if c.Err != nil {
+		return false, c.Err
+	}
+	for _, breached := range c.Breached {
+		if breached == password {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
-func queryHIBPAPI(hashPrefix string) (*bufio.Scanner, error) {
-	client := &http.Client{Timeout: 5 * time.Second}
-	res, err := client.Get(fmt.Sprintf("https://api.pwnedpasswords.com/range/%s", hashPrefix))
+// defaultPasswordHashQueue is how many hashes may wait for a free slot when the queue is not configured
+const defaultPasswordHashQueue = 64
+
+// newPasswordHasher builds the limiter computing every password hash from the configuration, zero values keep the defaults
+func newPasswordHasher(cfg config.PasswordHashing) *argon2id.Limiter {
+	params := argon2id.DefaultParams
+	if cfg.Memory > 0 {
+		params.Memory = uint32(cfg.Memory)
+	}
+	if cfg.Iterations > 0 {
+		params.Iterations = uint32(cfg.Iterations)
+	}
+	if cfg.Parallelism > 0 {
+		params.Parallelism = uint8(cfg.Parallelism)
+	}
+	concurrency := cfg.Concurrency
+	if concurrency == 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	queue := cfg.Queue
+	if queue == 0 {
+		queue = defaultPasswordHashQueue
+	}
+
+	limiter, err := argon2id.NewLimiter(params, concurrency, queue)
 	if err != nil {
-		return nil, err
+		panic(fmt.Sprintf("invalid password hashing configuration: %v", err))
 	}
-	return bufio.NewScanner(res.Body), nil
+	return limiter
 }
 
-func isHashCompromised(fullHash, prefix string, scanner *bufio.Scanner) bool {
-	for scanner.Scan() {
-		hashSuffix := strings.ToLower(scanner.Text()[:35])
-		if subtle.ConstantTimeCompare([]byte(fullHash), []byte(prefix+hashSuffix)) == 1 {
-			return true
-		}
+// hashPassword hashes a password or recovery code, ErrServerBusy is returned when too many hashes are in progress
+func (as *Service) hashPassword(ctx context.Context, password string) (string, error) {
+	hash, err := as.passwordHasher.Hash(ctx, password)
+	if errors.Is(err, argon2id.ErrOverloaded) {
+		slog.Warn("password hashing overloaded")
+		return "", ErrServerBusy
+	}
+	return hash, err
+}
+
+// verifyPassword checks a password or recovery code against its hash, ErrServerBusy is returned when too many hashes are in progress
+func (as *Service) verifyPassword(ctx context.Context, hash, password string) (bool, error) {
+	valid, err := as.passwordHasher.Verify(ctx, hash, password)
+	if errors.Is(err, argon2id.ErrOverloaded) {
+		slog.Warn("password hashing overloaded")
+		return false, ErrServerBusy
 	}
-	return false
+	return valid, err
 }
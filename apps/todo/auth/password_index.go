@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The index starts with passwordIndexMagic followed by the first passwordIndexEntrySize bytes
+// of every SHA-1 hash of the list, sorted and deduplicated. Keeping 8 of the 20 bytes makes the index
+// 6 times smaller than the list while the chance of a false match stays below one in a billion.
+const (
+	passwordIndexMagic     = "PWIDX001"
+	passwordIndexEntrySize = 8
+)
+
+var errInvalidPasswordIndex = errors.New("invalid breached password index")
+
+// BuildPasswordIndex writes the index of a downloaded SHA-1 hash list, as produced by the Pwned Passwords downloader.
+// Each line holds an hexadecimal hash, optionally followed by a colon and a count, and lines must be sorted by hash.
+func BuildPasswordIndex(dst io.Writer, src io.Reader) error {
+	w := bufio.NewWriter(dst)
+	if _, err := w.WriteString(passwordIndexMagic); err != nil {
+		return err
+	}
+
+	var previous uint64
+	entries := 0
+	scanner := bufio.NewScanner(src)
+	for line := 1; scanner.Scan(); line++ {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if hash == "" {
+			continue
+		}
+		decoded, err := hex.DecodeString(hash)
+		if err != nil || len(decoded) != sha1.Size {
+			return fmt.Errorf("line %d: invalid SHA-1 hash %q", line, hash)
+		}
+
+		entry := binary.BigEndian.Uint64(decoded)
+		if entries > 0 && entry < previous {
+			return fmt.Errorf("line %d: hash list must be sorted by hash", line)
+		}
+		if entries > 0 && entry == previous {
+			continue
+		}
+		if err := binary.Write(w, binary.BigEndian, entry); err != nil {
+			return err
+		}
+		previous = entry
+		entries++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read hash list: %w", err)
+	}
+
+	return w.Flush()
+}
+
+// LocalPasswordChecker looks passwords up in an index built by BuildPasswordIndex without any network access.
+// The index stays on disk and is binary searched, so its size does not matter for memory.
+type LocalPasswordChecker struct {
+	file    *os.File
+	entries int64
+}
+
+// OpenLocalPasswordChecker opens the index at indexPath.
+// If it does not exist and hashListPath is set, it is built from the hash list first.
+func OpenLocalPasswordChecker(indexPath, hashListPath string) (*LocalPasswordChecker, error) {
+	if _, err := os.Stat(indexPath); errors.Is(err, os.ErrNotExist) && hashListPath != "" {
+		if err := buildPasswordIndexFile(indexPath, hashListPath); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	magic := make([]byte, len(passwordIndexMagic))
+	if _, err := file.ReadAt(magic, 0); err != nil || string(magic) != passwordIndexMagic {
+		file.Close()
+		return nil, errInvalidPasswordIndex
+	}
+	size := info.Size() - int64(len(passwordIndexMagic))
+	if size%passwordIndexEntrySize != 0 {
+		file.Close()
+		return nil, errInvalidPasswordIndex
+	}
+
+	return &LocalPasswordChecker{file: file, entries: size / passwordIndexEntrySize}, nil
+}
+
+func (c *LocalPasswordChecker) IsBreached(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	target := sum[:passwordIndexEntrySize]
+
+	entry := make([]byte, passwordIndexEntrySize)
+	low, high := int64(0), c.entries
+	for low < high {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		middle := low + (high-low)/2
+		offset := int64(len(passwordIndexMagic)) + middle*passwordIndexEntrySize
+		if _, err := c.file.ReadAt(entry, offset); err != nil {
+			return false, fmt.Errorf("failed to read breached password index: %w", err)
+		}
+
+		switch bytes.Compare(entry, target) {
+		case 0:
+			return true, nil
+		case -1:
+			low = middle + 1
+		default:
+			high = middle
+		}
+	}
+
+	return false, nil
+}
+
+func (c *LocalPasswordChecker) Close() error {
+	return c.file.Close()
+}
+
+// buildPasswordIndexFile builds the index next to its final path and only moves it there once complete
+func buildPasswordIndexFile(indexPath, hashListPath string) error {
+	list, err := os.Open(hashListPath)
+	if err != nil {
+		return err
+	}
+	defer list.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(indexPath), filepath.Base(indexPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	slog.Info("building breached password index", "from", hashListPath, "to", indexPath)
+	if err := BuildPasswordIndex(tmp, list); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to build breached password index: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), indexPath)
+}
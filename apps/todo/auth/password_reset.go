@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+)
+
+// RequestPasswordReset creates a password reset request for the given email and sends the reset link.
+// It returns nil when no account matches so that callers cannot probe for registered emails.
+func (as *Service) RequestPasswordReset(ctx context.Context, email string) (err error) {
+	event := model.AuthEvent{Type: AuthEventPasswordResetRequest, Email: email}
+	defer func() { as.recordEvent(ctx, event, err) }()
+
+	user, err := as.queries.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			slog.Info("password reset requested for unknown email")
+			event.Reason = "unknown email"
+			return nil
+		}
+		return err
+	}
+	event.UserId = user.ID
+
+	code, err := as.createPasswordResetRequest(ctx, as.queries, user.ID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?code=%s", as.Config.BaseURL, url.QueryEscape(code))
+	go as.sendEmailAsync(EmailParams{
+		To:      []string{user.Email},
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Follow this link to choose a new password: %s\r\n\r\nThe link expires in %d minutes. If you did not ask for a reset, you can ignore this email.",
+			link,
+			int(passwordResetDuration.Minutes()),
+		),
+	})
+
+	return nil
+}
+
+// createPasswordResetRequest stores a new password reset request of the user and returns its code
+func (as *Service) createPasswordResetRequest(ctx context.Context, q db.Querier, userId int64, now time.Time) (string, error) {
+	code, err := as.generatePasswordResetCode()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = q.InsertPasswordResetRequest(ctx, db.InsertPasswordResetRequestParams{
+		UserID:    userId,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(passwordResetDuration).Unix(),
+		CodeHash:  hashToken(code),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create password reset request: %w", err)
+	}
+	return code, nil
+}
+
+// ResetPassword consumes a password reset code, sets the new password and revokes every session of the user
+func (as *Service) ResetPassword(ctx context.Context, code, password string) (err error) {
+	event := model.AuthEvent{Type: AuthEventPasswordReset}
+	defer func() { as.recordEvent(ctx, event, err) }()
+
+	if code == "" {
+		return ErrInvalidResetCode
+	}
+
+	request, err := as.queries.GetPasswordResetRequestByCodeHash(ctx, hashToken(code))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidResetCode
+		}
+		return err
+	}
+	event.UserId = request.UserID
+
+	if time.Now().Unix() >= request.ExpiresAt {
+		if _, err := as.queries.DeletePasswordResetRequestByCodeHash(ctx, request.CodeHash); err != nil {
+			return err
+		}
+		return ErrInvalidResetCode
+	}
+
+	if err := as.validatePassword(ctx, password); err != nil {
+		return err
+	}
+
+	passwordHash, err := as.hashPassword(ctx, password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	// The code is consumed in the same transaction as the reset, only one of concurrent requests can delete it
+	return as.queries.InTx(ctx, func(q db.Querier) error {
+		deleted, err := q.DeletePasswordResetRequestByCodeHash(ctx, request.CodeHash)
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return ErrInvalidResetCode
+		}
+
+		if err := q.UpdateUserPassword(ctx, db.UpdateUserPasswordParams{
+			PasswordHash: passwordHash,
+			ID:           request.UserID,
+		}); err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+
+		if err := q.DeleteUserSessions(ctx, request.UserID); err != nil {
+			return fmt.Errorf("failed to revoke sessions: %w", err)
+		}
+
+		// Following the emailed link proves ownership of the address
+		if err := q.SetUserEmailVerified(ctx, request.UserID); err != nil {
+			return err
+		}
+
+		// Failures against the old password no longer matter
+		user, err := q.GetUserByID(ctx, request.UserID)
+		if err != nil {
+			return err
+		}
+		return q.DeleteLoginAttempt(ctx, normalizeEmail(user.Email))
+	})
+}
+
+// generatePasswordResetCode generates a random code for the password reset link
+func (as *Service) generatePasswordResetCode() (string, error) {
+	if as.Config.Env == "test" {
+		return TestPasswordResetCode, nil
+	}
+	return generateTokenSession()
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/store"
+)
+
+func TestRequestPasswordReset(t *testing.T) {
+	c := givenTestConfig()
+	fakeQuerier := store.NewFakeQuerier()
+	as := Init(c, fakeQuerier)
+	fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@test.com", PasswordHash: "hash"}
+
+	f := func(email string, expectRequest bool) {
+		t.Helper()
+
+		ctx := context.Background()
+		if err := as.RequestPasswordReset(ctx, email); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		request, ok := fakeQuerier.PasswordResetRequests[1]
+		if ok != expectRequest {
+			t.Fatalf("unexpected password reset request presence; got %v; want %v", ok, expectRequest)
+		}
+		if !expectRequest {
+			return
+		}
+
+		if request.CodeHash != hashToken(TestPasswordResetCode) {
+			t.Fatalf("expected the code to be stored hashed")
+		}
+		if request.ExpiresAt > time.Now().Add(passwordResetDuration).Unix() {
+			t.Fatalf("expected password reset request to expire within %s", passwordResetDuration)
+		}
+	}
+
+	// unknown emails are silently ignored
+	f("unknown@test.com", false)
+
+	f("user@test.com", true)
+}
+
+func TestResetPassword(t *testing.T) {
+	c := givenTestConfig()
+	ctx := context.Background()
+
+	f := func(code, password string, expiresAt int64, expect error) {
+		t.Helper()
+
+		fakeQuerier := store.NewFakeQuerier()
+		as := Init(c, fakeQuerier)
+		fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@test.com", PasswordHash: "hash"}
+		fakeQuerier.PasswordResetRequests[1] = db.PasswordResetRequest{
+			ID:        1,
+			UserID:    1,
+			ExpiresAt: expiresAt,
+			CodeHash:  hashToken(TestPasswordResetCode),
+		}
+		token, err := as.createSession(ctx, 1, ClientInfo{})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+
+		err = as.ResetPassword(ctx, code, password)
+		if !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+
+		_, sessionExists := fakeQuerier.Sessions[hashToken(token)]
+		if expect != nil {
+			if !sessionExists {
+				t.Fatalf("expected session to be kept on failed reset")
+			}
+			return
+		}
+
+		if sessionExists {
+			t.Fatalf("expected sessions to be revoked after reset")
+		}
+		if fakeQuerier.Users[1].PasswordHash == "hash" {
+			t.Fatalf("expected password hash to be updated")
+		}
+		if _, ok := fakeQuerier.PasswordResetRequests[1]; ok {
+			t.Fatalf("expected password reset request to be consumed")
+		}
+
+		// codes are single use
+		if err := as.ResetPassword(ctx, code, password); !errors.Is(err, ErrInvalidResetCode) {
+			t.Fatalf("expected reused code to be rejected, got: %v", err)
+		}
+	}
+
+	valid := time.Now().Add(passwordResetDuration).Unix()
+	expired := time.Now().Add(-time.Minute).Unix()
+
+	f("", "validpassword123", valid, ErrInvalidResetCode)
+	f("wrong-code", "validpassword123", valid, ErrInvalidResetCode)
+	f(TestPasswordResetCode, "validpassword123", expired, ErrInvalidResetCode)
+	f(TestPasswordResetCode, "short", valid, ErrWeakPassword)
+	f(TestPasswordResetCode, "validpassword123", valid, nil)
+}
+
+// concurrentResetQuerier lets another request consume the code right after it was read
+type concurrentResetQuerier struct {
+	*store.FakeQuerier
+}
+
+func (q concurrentResetQuerier) GetPasswordResetRequestByCodeHash(ctx context.Context, codeHash string) (db.PasswordResetRequest, error) {
+	request, err := q.FakeQuerier.GetPasswordResetRequestByCodeHash(ctx, codeHash)
+	if err == nil {
+		delete(q.PasswordResetRequests, request.UserID)
+	}
+	return request, err
+}
+
+func TestResetPasswordConsumedConcurrently(t *testing.T) {
+	ctx := context.Background()
+	fakeQuerier := store.NewFakeQuerier()
+	as := Init(givenTestConfig(), concurrentResetQuerier{fakeQuerier})
+	fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@test.com", PasswordHash: "hash"}
+	fakeQuerier.PasswordResetRequests[1] = db.PasswordResetRequest{
+		ID:        1,
+		UserID:    1,
+		ExpiresAt: time.Now().Add(passwordResetDuration).Unix(),
+		CodeHash:  hashToken(TestPasswordResetCode),
+	}
+
+	if err := as.ResetPassword(ctx, TestPasswordResetCode, "validpassword123"); !errors.Is(err, ErrInvalidResetCode) {
+		t.Fatalf("expected ErrInvalidResetCode, got: %v", err)
+	}
+	if fakeQuerier.Users[1].PasswordHash != "hash" {
+		t.Fatalf("expected the password to be kept")
+	}
+}
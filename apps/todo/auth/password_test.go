@@ -1,28 +1,220 @@
 package auth
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/store"
 )
 
 func TestVerfifyPasswordStrength(t *testing.T) {
+	as := Init(givenTestConfig(), store.NewFakeQuerier())
+	ctx := context.Background()
+
 	f := func(password string, expect error) {
 		t.Helper()
 
-		err := verifyPasswordStrength(password)
+		err := as.verifyPasswordStrength(ctx, password)
 
 		if !errors.Is(err, expect) {
 			t.Fatalf("unexpected error; got %v; want %v", err, expect)
 		}
-
-		if expect == nil {
-			if err := verifyPasswordStrength(password); err != nil {
-				t.Fatalf("expected no error, got: %v", err)
-			}
-		}
 	}
 
 	f("", ErrWeakPassword)
 	f("short", ErrWeakPassword)
+	f(TestBreachedPassword, ErrWeakPassword)
 	f("validpassword123", nil)
 }
+
+func TestVerifyPasswordStrengthFailurePolicy(t *testing.T) {
+	ctx := context.Background()
+
+	f := func(failClosed bool, expect error) {
+		t.Helper()
+
+		cfg := givenTestConfig()
+		cfg.PasswordCheckFailClosed = failClosed
+		as := Init(cfg, store.NewFakeQuerier())
+		as.passwordChecker = &FakePasswordChecker{Err: errors.New("network is down")}
+
+		err := as.verifyPasswordStrength(ctx, "validpassword123")
+		if !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+	}
+
+	// fail open accepts the password
+	f(false, nil)
+
+	// fail closed rejects it
+	f(true, ErrPasswordCheckUnavailable)
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestPwnedPasswordsChecker(t *testing.T) {
+	breached := sha1Hex("password123456")
+	padded := sha1Hex("padding-only")
+
+	var requestedPaths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPaths = append(requestedPaths, r.URL.Path)
+		if r.Header.Get("Add-Padding") != "true" {
+			t.Errorf("expected padding to be requested")
+		}
+		if r.URL.Path == "/range/"+sha1Hex("unavailable")[:5] {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n")
+		fmt.Fprintf(w, "%s:3861493\r\n", breached[5:])
+		fmt.Fprintf(w, "%s:0\r\n", padded[5:])
+	}))
+	defer server.Close()
+
+	checker := NewPwnedPasswordsChecker()
+	checker.BaseURL = server.URL
+	ctx := context.Background()
+
+	f := func(password string, expect bool, expectErr bool) {
+		t.Helper()
+
+		got, err := checker.IsBreached(ctx, password)
+		if (err != nil) != expectErr {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got != expect {
+			t.Fatalf("unexpected result for %q; got %v; want %v", password, got, expect)
+		}
+	}
+
+	// listed password
+	f("password123456", true, false)
+
+	// padding lines are not breaches
+	f("padding-only", false, false)
+
+	// unknown password
+	f("validpassword123", false, false)
+
+	// api errors are reported
+	f("unavailable", false, true)
+
+	// only the prefix of the SHA-1 hash is sent
+	if requestedPaths[0] != "/range/"+breached[:5] {
+		t.Fatalf("unexpected request path %q", requestedPaths[0])
+	}
+}
+
+func givenPasswordIndex(t *testing.T, passwords ...string) string {
+	t.Helper()
+
+	var hashes []string
+	for _, password := range passwords {
+		hashes = append(hashes, sha1Hex(password)+":1")
+	}
+	sort.Strings(hashes)
+
+	dir := t.TempDir()
+	listPath := filepath.Join(dir, "pwned-passwords-sha1.txt")
+	if err := os.WriteFile(listPath, []byte(strings.Join(hashes, "\r\n")), 0o600); err != nil {
+		t.Fatalf("failed to write hash list: %v", err)
+	}
+	return listPath
+}
+
+func TestLocalPasswordChecker(t *testing.T) {
+	listPath := givenPasswordIndex(t, "password123456", "qwertyuiop", "letmein", "dragon", "monkey")
+	indexPath := filepath.Join(filepath.Dir(listPath), "pwned.idx")
+
+	// The index is built from the list the first time
+	checker, err := OpenLocalPasswordChecker(indexPath, listPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer checker.Close()
+	if checker.entries != 5 {
+		t.Fatalf("unexpected number of entries %d", checker.entries)
+	}
+
+	ctx := context.Background()
+	for _, password := range []string{"password123456", "qwertyuiop", "letmein", "dragon", "monkey"} {
+		if breached, err := checker.IsBreached(ctx, password); err != nil || !breached {
+			t.Fatalf("expected %q to be breached, got %v, %v", password, breached, err)
+		}
+	}
+	if breached, err := checker.IsBreached(ctx, "validpassword123"); err != nil || breached {
+		t.Fatalf("expected password not to be breached, got %v, %v", breached, err)
+	}
+
+	// Later starts use the existing index without the list
+	if err := os.Remove(listPath); err != nil {
+		t.Fatalf("failed to remove hash list: %v", err)
+	}
+	reopened, err := OpenLocalPasswordChecker(indexPath, "")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer reopened.Close()
+	if breached, err := reopened.IsBreached(ctx, "dragon"); err != nil || !breached {
+		t.Fatalf("expected password to be breached, got %v, %v", breached, err)
+	}
+}
+
+func TestBuildPasswordIndex(t *testing.T) {
+	f := func(list string, expectErr bool) {
+		t.Helper()
+
+		var index bytes.Buffer
+		err := BuildPasswordIndex(&index, strings.NewReader(list))
+		if (err != nil) != expectErr {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	first, second := sha1Hex("dragon"), sha1Hex("monkey")
+	if first > second {
+		first, second = second, first
+	}
+
+	// sorted list with counts
+	f(first+":10\n"+second+":3\n", false)
+
+	// duplicates and lowercase hashes
+	f(strings.ToLower(first)+"\n"+first+"\n"+second+"\n", false)
+
+	// unsorted list
+	f(second+"\n"+first+"\n", true)
+
+	// not a SHA-1 hash
+	f("5f4dcc3b5aa765d61d8327deb882cf99:1\n", true)
+}
+
+func TestOpenLocalPasswordCheckerInvalidIndex(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "pwned.idx")
+	if err := os.WriteFile(indexPath, []byte("not an index"), 0o600); err != nil {
+		t.Fatalf("failed to write index: %v", err)
+	}
+
+	if _, err := OpenLocalPasswordChecker(indexPath, ""); !errors.Is(err, errInvalidPasswordIndex) {
+		t.Fatalf("expected errInvalidPasswordIndex, got: %v", err)
+	}
+	if _, err := OpenLocalPasswordChecker(filepath.Join(t.TempDir(), "missing.idx"), ""); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a missing index to be reported, got: %v", err)
+	}
+}
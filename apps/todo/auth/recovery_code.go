@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+)
+
+const (
+	recoveryCodeCount    = 10
+	recoveryCodeLength   = 10
+	recoveryCodeAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+)
+
+// GenerateRecoveryCodes replaces the recovery codes of the user with a new set.
+// The codes are only returned here, the database keeps their hashes.
+func (as *Service) GenerateRecoveryCodes(ctx context.Context, userId int64) (c []string, err error) {
+	defer func() { as.recordEvent(ctx, model.AuthEvent{Type: AuthEventRecoveryCodes, UserId: userId}, err) }()
+
+	enabled, err := as.TOTPEnabled(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrTOTPNotEnabled
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i], err = generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		hashes[i], err = as.hashPassword(ctx, normalizeRecoveryCode(codes[i]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The old codes are kept unless every new one is stored
+	now := time.Now().Unix()
+	err = as.queries.InTx(ctx, func(q db.Querier) error {
+		if err := q.DeleteRecoveryCodes(ctx, userId); err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+		for _, hash := range hashes {
+			err := q.CreateRecoveryCode(ctx, db.CreateRecoveryCodeParams{
+				UserID:    userId,
+				CodeHash:  hash,
+				CreatedAt: now,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to store recovery code: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// RemainingRecoveryCodes returns how many recovery codes the user has not used yet
+func (as *Service) RemainingRecoveryCodes(ctx context.Context, userId int64) (int64, error) {
+	return as.queries.CountUnusedRecoveryCodes(ctx, userId)
+}
+
+// VerifyRecoveryCode accepts a recovery code in place of the authenticator code for a pending session.
+// Each code works once, and wrong codes count against the same attempts as authenticator codes.
+func (as *Service) VerifyRecoveryCode(ctx context.Context, token, code string) (s model.Session, err error) {
+	session, user, err := as.validateSession(ctx, token)
+	if err != nil {
+		return model.Session{}, err
+	}
+	if !session.TwoFactorPending {
+		return session, nil
+	}
+	defer func() {
+		as.recordEvent(ctx, model.AuthEvent{Type: AuthEventTwoFactor, UserId: user.Id, Email: user.Email, Reason: "recovery code"}, err)
+	}()
+
+	// Counted before the codes are checked, each wrong guess costs a verification per unused code
+	last, err := as.countTwoFactorAttempt(ctx, session.Id)
+	if err != nil {
+		return model.Session{}, err
+	}
+
+	recoveryCodes, err := as.queries.GetUnusedRecoveryCodes(ctx, session.UserId)
+	if err != nil {
+		return model.Session{}, fmt.Errorf("failed to get recovery codes: %w", err)
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	for _, recoveryCode := range recoveryCodes {
+		valid, err := as.verifyPassword(ctx, recoveryCode.CodeHash, normalized)
+		if err != nil {
+			return model.Session{}, err
+		}
+		if !valid {
+			continue
+		}
+
+		// Only the request that flips used_at gets in, a concurrent one sees no affected row
+		marked, err := as.queries.MarkRecoveryCodeUsed(ctx, db.MarkRecoveryCodeUsedParams{
+			UsedAt: sql.NullInt64{Int64: time.Now().Unix(), Valid: true},
+			ID:     recoveryCode.ID,
+		})
+		if err != nil {
+			return model.Session{}, fmt.Errorf("failed to mark recovery code used: %w", err)
+		}
+		if marked == 0 {
+			return model.Session{}, ErrInvalidRecoveryCode
+		}
+
+		slog.Info("recovery code used", "userId", session.UserId, "remaining", len(recoveryCodes)-1)
+		return as.completeTwoFactor(ctx, session)
+	}
+
+	return model.Session{}, as.rejectTwoFactorCode(ctx, session.Id, last, ErrInvalidRecoveryCode)
+}
+
+// generateRecoveryCode returns a random code formatted as two groups of five characters
+func generateRecoveryCode() (string, error) {
+	bytes := make([]byte, recoveryCodeLength)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	code := make([]byte, recoveryCodeLength)
+	for i, b := range bytes {
+		// The alphabet has 32 characters so the modulo is not biased
+		code[i] = recoveryCodeAlphabet[int(b)%len(recoveryCodeAlphabet)]
+	}
+	return string(code[:recoveryCodeLength/2]) + "-" + string(code[recoveryCodeLength/2:]), nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes users may type differently
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/store"
+)
+
+// failingRecoveryCodeQuerier fails to store recovery codes after the first few
+type failingRecoveryCodeQuerier struct {
+	*store.FakeQuerier
+	stored *int
+}
+
+func (q failingRecoveryCodeQuerier) CreateRecoveryCode(ctx context.Context, arg db.CreateRecoveryCodeParams) error {
+	if *q.stored == 3 {
+		return errors.New("disk full")
+	}
+	*q.stored++
+	return q.FakeQuerier.CreateRecoveryCode(ctx, arg)
+}
+
+func (q failingRecoveryCodeQuerier) InTx(ctx context.Context, fn func(db.Querier) error) error {
+	return q.FakeQuerier.InTx(ctx, func(db.Querier) error { return fn(q) })
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	ctx := context.Background()
+
+	// Without a second factor there is nothing to recover
+	as := Init(givenTestConfig(), store.NewFakeQuerier())
+	if _, err := as.GenerateRecoveryCodes(ctx, 1); !errors.Is(err, ErrTOTPNotEnabled) {
+		t.Fatalf("expected ErrTOTPNotEnabled, got: %v", err)
+	}
+
+	as, fakeQuerier, _ := givenTOTPUser(t)
+	codes, err := as.GenerateRecoveryCodes(ctx, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("expected %d codes, got %d", recoveryCodeCount, len(codes))
+	}
+
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != recoveryCodeLength+1 || code[recoveryCodeLength/2] != '-' {
+			t.Fatalf("unexpected code format %q", code)
+		}
+		if seen[code] {
+			t.Fatalf("duplicate code %q", code)
+		}
+		seen[code] = true
+	}
+
+	for _, stored := range fakeQuerier.RecoveryCodes {
+		if !strings.HasPrefix(stored.CodeHash, "$argon2id$") {
+			t.Fatalf("expected codes to be stored hashed, got %q", stored.CodeHash)
+		}
+	}
+
+	// Regenerating replaces the whole set
+	if _, err := as.GenerateRecoveryCodes(ctx, 1); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if remaining, _ := as.RemainingRecoveryCodes(ctx, 1); remaining != recoveryCodeCount {
+		t.Fatalf("expected %d remaining codes, got %d", recoveryCodeCount, remaining)
+	}
+
+	// A failure part-way keeps the previous set
+	previous := maps.Clone(fakeQuerier.RecoveryCodes)
+	as.queries = failingRecoveryCodeQuerier{FakeQuerier: fakeQuerier, stored: new(int)}
+	if _, err := as.GenerateRecoveryCodes(ctx, 1); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !maps.Equal(previous, fakeQuerier.RecoveryCodes) {
+		t.Fatalf("expected the previous recovery codes to be kept")
+	}
+}
+
+func TestVerifyRecoveryCode(t *testing.T) {
+	as, fakeQuerier, secret := givenTOTPUser(t)
+	ctx := context.Background()
+
+	codes, err := as.GenerateRecoveryCodes(ctx, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	login := func() string {
+		t.Helper()
+
+		session, token, err := as.AuthenticateWithPassword(ctx, "user@example.com", "Str0ngP@ssw0rd!", ClientInfo{})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if !session.TwoFactorPending {
+			t.Fatalf("expected a pending session")
+		}
+		return token
+	}
+
+	f := func(token, code string, expect error) {
+		t.Helper()
+
+		session, err := as.VerifyRecoveryCode(ctx, token, code)
+		if !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+		if expect == nil && session.TwoFactorPending {
+			t.Fatalf("expected session to be upgraded")
+		}
+	}
+
+	// unknown code
+	f(login(), "aaaaa-aaaaa", ErrInvalidRecoveryCode)
+
+	// case and dashes do not matter
+	f(login(), strings.ToUpper(strings.ReplaceAll(codes[0], "-", "")), nil)
+	if remaining, _ := as.RemainingRecoveryCodes(ctx, 1); remaining != recoveryCodeCount-1 {
+		t.Fatalf("expected %d remaining codes, got %d", recoveryCodeCount-1, remaining)
+	}
+
+	// single use
+	f(login(), codes[0], ErrInvalidRecoveryCode)
+
+	// the old set stops working once regenerated
+	if _, err := as.GenerateRecoveryCodes(ctx, 1); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	f(login(), codes[1], ErrInvalidRecoveryCode)
+
+	// disabling the second factor removes the codes
+	if err := as.DisableTOTP(ctx, 1, "Str0ngP@ssw0rd!", givenTOTPCode(t, secret)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(fakeQuerier.RecoveryCodes) != 0 {
+		t.Fatalf("expected recovery codes to be deleted")
+	}
+}
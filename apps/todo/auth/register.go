@@ -2,30 +2,63 @@ package auth
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
-	"github.com/AltSoyuz/soy-experiments/lib/argon2id"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
 )
 
-// RegisterUser creates a new user with the given email and password
-func (as *Service) RegisterUser(ctx context.Context, email, password string) error {
-	if err := validateUserInput(email, password); err != nil {
+// RegisterUser creates a new user with the given email and password.
+// The registration settings decide whether an invitation token is needed, a given one is used up by the new account.
+func (as *Service) RegisterUser(ctx context.Context, email, password, inviteToken string) (err error) {
+	event := model.AuthEvent{Type: AuthEventRegister, Email: email}
+	defer func() { as.recordEvent(ctx, event, err) }()
+
+	invitation, err := as.checkRegistration(ctx, email, inviteToken)
+	if err != nil {
 		return err
 	}
+	if invitation != nil {
+		event.Reason = "invitation"
+	}
 
-	passwordHash, err := argon2id.Hash(password)
+	if err := as.validateUserInput(ctx, email, password); err != nil {
+		return err
+	}
+
+	passwordHash, err := as.hashPassword(ctx, password)
 	if err != nil {
 		return fmt.Errorf("failed to hash password: %w", err)
 	}
 
-	user, err := as.queries.CreateUser(ctx, db.CreateUserParams{
-		Email:        email,
-		PasswordHash: passwordHash,
+	var user db.User
+	err = as.queries.InTx(ctx, func(q db.Querier) error {
+		// Using the invitation first makes sure two registrations cannot share it
+		if invitation != nil {
+			used, err := q.UseInvitation(ctx, db.UseInvitationParams{
+				UsedAt: sql.NullInt64{Int64: time.Now().Unix(), Valid: true},
+				ID:     invitation.ID,
+			})
+			if err != nil {
+				return err
+			}
+			if used == 0 {
+				return ErrInvalidInvitation
+			}
+		}
+
+		user, err = q.CreateUser(ctx, db.CreateUserParams{
+			Email:        email,
+			PasswordHash: passwordHash,
+		})
+		return err
 	})
 	if err != nil {
 		return err
 	}
+	event.UserId = user.ID
 
 	if err := as.CreateAndSendVerificationEmail(ctx, user.ID, email); err != nil {
 		return err
@@ -35,12 +68,20 @@ func (as *Service) RegisterUser(ctx context.Context, email, password string) err
 }
 
 // validateUserInput validates the user input for email and password
-func validateUserInput(email, password string) error {
+func (as *Service) validateUserInput(ctx context.Context, email, password string) error {
 	if password == "" || len(password) > 127 {
 		return fmt.Errorf("invalid password: %w", ErrWeakPassword)
 	}
 	if email == "" || !isValidEmail(email) {
 		return fmt.Errorf("invalid email: %w", ErrWeakPassword)
 	}
-	return verifyPasswordStrength(password)
+	return as.verifyPasswordStrength(ctx, password)
+}
+
+// validatePassword validates a new password for an existing user
+func (as *Service) validatePassword(ctx context.Context, password string) error {
+	if password == "" || len(password) > 127 {
+		return fmt.Errorf("invalid password: %w", ErrWeakPassword)
+	}
+	return as.verifyPasswordStrength(ctx, password)
 }
@@ -17,7 +17,7 @@ func TestCreateUser(t *testing.T) {
 		t.Helper()
 
 		ctx := context.Background()
-		err := as.RegisterUser(ctx, email, password)
+		err := as.RegisterUser(ctx, email, password, "")
 		if !errors.Is(err, expect) {
 			t.Fatalf("unexpected error; got %v; want %v", err, expect)
 		}
@@ -9,13 +9,39 @@ import (
 	"encoding/hex"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
 	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
 )
 
+// ClientInfo describes the device a session is created from
+type ClientInfo struct {
+	IPAddress string
+	UserAgent string
+	// RememberMe asks for a session lasting the remember me duration of the session policy
+	RememberMe bool
+	// DeviceToken is the device cookie of the request, it recognizes devices the user signed in from before
+	DeviceToken string
+}
+
+// ClientInfoFrom extracts the client address and user agent from the request
+func ClientInfoFrom(r *http.Request) ClientInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return ClientInfo{IPAddress: ip, UserAgent: userAgent, DeviceToken: deviceTokenFrom(r)}
+}
+
 // generateTokenSession creates a cryptographically secure session token
 func generateTokenSession() (string, error) {
 	bytes := make([]byte, 32) // Increased from 20 to 32 bytes for better security
@@ -43,30 +69,81 @@ func (as *Service) validateSession(ctx context.Context, token string) (model.Ses
 	}
 
 	now := time.Now()
-	if now.Unix() >= row.ExpiresAt {
+	if now.Unix() >= min(row.ExpiresAt, row.AbsoluteExpiresAt) {
 		if err := as.queries.DeleteSession(ctx, sessionId); err != nil {
 			slog.Error("failed to delete expired session", "error", err)
 			return model.Session{}, model.User{}, fmt.Errorf("failed to delete expired session: %w", err)
 		}
+		if row.ImpersonatorID.Valid {
+			as.recordEvent(ctx, model.AuthEvent{
+				Type:   AuthEventImpersonationStop,
+				UserId: row.UserID,
+				Email:  row.Email,
+				Reason: "expired",
+			}, nil)
+		}
 		return model.Session{}, model.User{}, ErrSessionExpired
 	}
+	// Sessions are revoked when an account is disabled, this also covers any created in the meantime
+	if row.Disabled != 0 {
+		if err := as.queries.DeleteSession(ctx, sessionId); err != nil {
+			return model.Session{}, model.User{}, fmt.Errorf("failed to delete session of disabled account: %w", err)
+		}
+		return model.Session{}, model.User{}, ErrAccountDisabled
+	}
+	// Impersonation ends as soon as the admin behind it is disabled or loses the role
+	if row.ImpersonatorID.Valid && (row.ImpersonatorDisabled.Int64 != 0 || row.ImpersonatorRole.String != model.RoleAdmin) {
+		if err := as.queries.DeleteSession(ctx, sessionId); err != nil {
+			return model.Session{}, model.User{}, fmt.Errorf("failed to delete impersonation session: %w", err)
+		}
+		as.recordEvent(ctx, model.AuthEvent{
+			Type:   AuthEventImpersonationStop,
+			UserId: row.UserID,
+			Email:  row.Email,
+			Reason: "impersonator no longer an admin",
+		}, nil)
+		return model.Session{}, model.User{}, ErrSessionInvalid
+	}
 
 	session := model.Session{
-		Id:        row.ID,
-		UserId:    row.UserID,
-		ExpiresAt: row.ExpiresAt,
+		Id:                row.ID,
+		UserId:            row.UserID,
+		ExpiresAt:         row.ExpiresAt,
+		AbsoluteExpiresAt: row.AbsoluteExpiresAt,
+		RememberMe:        row.RememberMe != 0,
+		TwoFactorPending:  row.TwoFactorPending != 0,
+	}
+	if row.ImpersonatorID.Valid {
+		session.Impersonation = &model.Impersonation{
+			AdminId:    row.ImpersonatorID.Int64,
+			AdminEmail: row.ImpersonatorEmail.String,
+			ExpiresAt:  row.ExpiresAt,
+		}
 	}
 
-	// Renew session if it's close to expiration
-	if now.Unix() >= row.ExpiresAt-int64(sessionRenewalThreshold.Seconds()) {
-		updatedSession, err := as.queries.UpdateSession(ctx, db.UpdateSessionParams{
-			ExpiresAt: now.Add(sessionDuration).Unix(),
-			ID:        session.Id,
-		})
+	// Push the idle timeout back once it moves by a whole renewal window, or reaches the absolute expiry,
+	// so sessions in use are not written on every request. Pending and impersonation sessions keep their short lifetime.
+	if !session.TwoFactorPending && session.Impersonation == nil {
+		expiresAt := as.sessionExpiry(now, session)
+		renewalDue := expiresAt-row.ExpiresAt >= int64(as.Config.Session.RenewalWindow.Seconds()) || expiresAt == session.AbsoluteExpiresAt
+		if expiresAt > row.ExpiresAt && renewalDue {
+			updatedSession, err := as.queries.UpdateSession(ctx, db.UpdateSessionParams{
+				ExpiresAt: expiresAt,
+				ID:        session.Id,
+			})
+			if err != nil {
+				return model.Session{}, model.User{}, fmt.Errorf("failed to renew session: %w", err)
+			}
+			session.ExpiresAt = updatedSession.ExpiresAt
+		}
+	}
+
+	// Keep track of when the device was last used without writing on every request
+	if now.Unix()-row.LastSeenAt >= int64(sessionTouchInterval.Seconds()) {
+		err := as.queries.TouchSession(ctx, db.TouchSessionParams{LastSeenAt: now.Unix(), ID: session.Id})
 		if err != nil {
-			return model.Session{}, model.User{}, fmt.Errorf("failed to renew session: %w", err)
+			slog.Error("failed to update session last seen time", "error", err)
 		}
-		session.ExpiresAt = updatedSession.ExpiresAt
 	}
 
 	emailVerified := false
@@ -79,6 +156,8 @@ func (as *Service) validateSession(ctx context.Context, token string) (model.Ses
 		Id:            row.UserID,
 		Email:         row.Email,
 		EmailVerified: emailVerified,
+		Role:          row.Role,
+		Impersonation: session.Impersonation,
 	}
 
 	return session, user, nil
@@ -105,8 +184,14 @@ func SetSessionCookie(w http.ResponseWriter, token string, expiresAt int64) {
 	})
 }
 
-// DeleteSessionCookie deletes the session cookie
+// DeleteSessionCookie deletes the session cookie.
+// A session cookie already queued on the response, such as the one renewed by the middleware, is dropped
+// so only the deletion reaches the browser, other cookies of the response are kept.
 func DeleteSessionCookie(w http.ResponseWriter) {
+	header := w.Header()
+	header["Set-Cookie"] = slices.DeleteFunc(header["Set-Cookie"], func(cookie string) bool {
+		return strings.HasPrefix(cookie, SessionCookieName+"=")
+	})
 	http.SetCookie(w, &http.Cookie{
 		Name:     SessionCookieName,
 		Value:    "",
@@ -118,6 +203,22 @@ func DeleteSessionCookie(w http.ResponseWriter) {
 	})
 }
 
+// sessionLifetimes returns how long a session may stay unused and how long it may last at all.
+// Remembered sessions last the remember me duration from sign-in whether they are used or not.
+func (as *Service) sessionLifetimes(rememberMe bool) (idleTimeout, absoluteLifetime time.Duration) {
+	policy := as.Config.Session
+	if rememberMe {
+		return policy.RememberMeDuration, policy.RememberMeDuration
+	}
+	return policy.IdleTimeout, policy.AbsoluteLifetime
+}
+
+// sessionExpiry returns when the session ends if it is not used again after now
+func (as *Service) sessionExpiry(now time.Time, session model.Session) int64 {
+	idleTimeout, _ := as.sessionLifetimes(session.RememberMe)
+	return min(now.Add(idleTimeout).Unix(), session.AbsoluteExpiresAt)
+}
+
 // hashToken creates a SHA-256 hash of the session token
 func hashToken(token string) string {
 	hash := sha256.New()
@@ -125,20 +226,58 @@ func hashToken(token string) string {
 	return hex.EncodeToString(hash.Sum(nil))
 }
 
-// createSession creates a new session for the given user
-func (as *Service) createSession(ctx context.Context, userId int64) (string, error) {
+// createSession creates a new session for the given user.
+// Users with two-factor authentication get a short lived pending session until they verify their code.
+// Disabled accounts are refused before the device is recorded or a sign-in alert is sent.
+func (as *Service) createSession(ctx context.Context, userId int64, client ClientInfo) (string, error) {
+	user, err := as.queries.GetUserByID(ctx, userId)
+	if err != nil {
+		return "", fmt.Errorf("failed to load user: %w", err)
+	}
+	if user.Disabled != 0 {
+		return "", ErrAccountDisabled
+	}
+
 	token, err := generateTokenSession()
 	if err != nil {
 		return "", err
 	}
 
-	sessionId := hashToken(token)
-	expiresAt := time.Now().Add(sessionDuration)
+	twoFactorEnabled, err := as.TOTPEnabled(ctx, userId)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, absoluteLifetime := as.sessionLifetimes(client.RememberMe)
+	session := model.Session{AbsoluteExpiresAt: now.Add(absoluteLifetime).Unix(), RememberMe: client.RememberMe}
+	expiresAt := as.sessionExpiry(now, session)
+	var twoFactorPending int64
+	if twoFactorEnabled {
+		expiresAt = now.Add(twoFactorPendingDuration).Unix()
+		twoFactorPending = 1
+	}
+	var rememberMe int64
+	if client.RememberMe {
+		rememberMe = 1
+	}
+
+	deviceId, err := as.recognizeDevice(ctx, userId, client, now)
+	if err != nil {
+		return "", err
+	}
 
 	_, err = as.queries.CreateSession(ctx, db.CreateSessionParams{
-		ID:        sessionId,
-		UserID:    userId,
-		ExpiresAt: expiresAt.Unix(),
+		ID:                hashToken(token),
+		UserID:            userId,
+		ExpiresAt:         expiresAt,
+		TwoFactorPending:  twoFactorPending,
+		IpAddress:         client.IPAddress,
+		UserAgent:         client.UserAgent,
+		LastSeenAt:        now.Unix(),
+		RememberMe:        rememberMe,
+		AbsoluteExpiresAt: session.AbsoluteExpiresAt,
+		DeviceID:          deviceId,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to create session: %w", err)
@@ -168,3 +307,72 @@ func (as *Service) InvalidateSession(ctx context.Context, sessionId string) erro
 	err := as.queries.DeleteSession(ctx, sessionId)
 	return err
 }
+
+// Logout ends the session the user is signed in with.
+// Signing out of an impersonation session ends the impersonation.
+func (as *Service) Logout(ctx context.Context, session model.Session) error {
+	err := as.InvalidateSession(ctx, session.Id)
+	event := model.AuthEvent{Type: AuthEventLogout, UserId: session.UserId}
+	if session.Impersonation != nil {
+		event = model.AuthEvent{Type: AuthEventImpersonationStop, UserId: session.UserId, Reason: "signed out by " + session.Impersonation.AdminEmail}
+	}
+	as.recordEvent(ctx, event, err)
+	return err
+}
+
+// ListSessions returns the active sessions of the user, most recently used first
+func (as *Service) ListSessions(ctx context.Context, userId int64) ([]model.ActiveSession, error) {
+	rows, err := as.queries.ListUserSessions(ctx, db.ListUserSessionsParams{
+		UserID:    userId,
+		ExpiresAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	sessions := make([]model.ActiveSession, 0, len(rows))
+	for _, row := range rows {
+		sessions = append(sessions, model.ActiveSession{
+			Id:           row.ID,
+			IPAddress:    row.IpAddress,
+			UserAgent:    row.UserAgent,
+			CreatedAt:    row.CreatedAt.String,
+			LastSeenAt:   row.LastSeenAt,
+			Impersonated: row.ImpersonatorID.Valid,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeSession signs out one session of the user, for example on a lost device
+func (as *Service) RevokeSession(ctx context.Context, userId int64, sessionId string) (err error) {
+	defer func() { as.recordEvent(ctx, model.AuthEvent{Type: AuthEventSessionRevoke, UserId: userId}, err) }()
+
+	deleted, err := as.queries.DeleteUserSession(ctx, db.DeleteUserSessionParams{
+		ID:     sessionId,
+		UserID: userId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if deleted == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeOtherSessions signs out every session of the user except the current one
+func (as *Service) RevokeOtherSessions(ctx context.Context, userId int64, currentSessionId string) (err error) {
+	defer func() {
+		as.recordEvent(ctx, model.AuthEvent{Type: AuthEventSessionRevoke, UserId: userId, Reason: "other sessions"}, err)
+	}()
+
+	err = as.queries.DeleteOtherUserSessions(ctx, db.DeleteOtherUserSessionsParams{
+		UserID: userId,
+		ID:     currentSessionId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
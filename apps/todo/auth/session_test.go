@@ -2,11 +2,14 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
 	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
 	"github.com/AltSoyuz/soy-experiments/apps/todo/store"
 )
@@ -34,13 +37,14 @@ func TestHashToken(t *testing.T) {
 func TestCreateSession(t *testing.T) {
 	c := givenTestConfig()
 	fakeQuerier := store.NewFakeQuerier()
+	fakeQuerier.Users[123] = db.User{ID: 123, Email: "user@example.com"}
 	as := Init(c, fakeQuerier)
 
 	ctx := context.Background()
 	userId := int64(123)
 
 	// Call CreateSession
-	token, err := as.createSession(ctx, userId)
+	token, err := as.createSession(ctx, userId, ClientInfo{})
 	if err != nil {
 		t.Fatalf("expected no error, got: %v", err)
 	}
@@ -66,13 +70,14 @@ func TestCreateSession(t *testing.T) {
 func TestValidateSession(t *testing.T) {
 	c := givenTestConfig()
 	fakeQuerier := store.NewFakeQuerier()
+	fakeQuerier.Users[123] = db.User{ID: 123, Email: "user@example.com"}
 	as := Init(c, fakeQuerier)
 
 	ctx := context.Background()
 	userId := int64(123)
 
 	// Create a session to validate
-	token, err := as.createSession(ctx, userId)
+	token, err := as.createSession(ctx, userId, ClientInfo{})
 	if err != nil {
 		t.Fatalf("expected no error, got: %v", err)
 	}
@@ -108,6 +113,114 @@ func TestValidateSession(t *testing.T) {
 	}
 }
 
+func TestValidateSessionRenewal(t *testing.T) {
+	f := func(sinceRenewal time.Duration, expectRenewed bool) {
+		t.Helper()
+
+		fakeQuerier := store.NewFakeQuerier()
+		fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@example.com"}
+		as := Init(givenTestConfig(), fakeQuerier)
+		ctx := context.Background()
+
+		token, err := as.createSession(ctx, 1, ClientInfo{})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		stored := fakeQuerier.Sessions[hashToken(token)]
+		stored.ExpiresAt -= int64(sinceRenewal.Seconds())
+		fakeQuerier.Sessions[hashToken(token)] = stored
+
+		session, _, err := as.validateSession(ctx, token)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		renewed := fakeQuerier.Sessions[hashToken(token)].ExpiresAt != stored.ExpiresAt
+		if renewed != expectRenewed {
+			t.Fatalf("unexpected renewal; got %v; want %v", renewed, expectRenewed)
+		}
+		if session.ExpiresAt != fakeQuerier.Sessions[hashToken(token)].ExpiresAt {
+			t.Fatalf("expected the returned session to match the stored expiry")
+		}
+	}
+
+	// used again within the renewal window
+	f(0, false)
+	f(30*time.Minute, false)
+
+	// the idle timeout moves once a whole window went by
+	f(time.Hour, true)
+	f(20*time.Hour, true)
+}
+
+func TestValidateSessionAbsoluteLifetime(t *testing.T) {
+	fakeQuerier := store.NewFakeQuerier()
+	fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@example.com"}
+	as := Init(givenTestConfig(), fakeQuerier)
+	ctx := context.Background()
+
+	token, err := as.createSession(ctx, 1, ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	stored := fakeQuerier.Sessions[hashToken(token)]
+	if lifetime := time.Until(time.Unix(stored.AbsoluteExpiresAt, 0)); lifetime > as.Config.Session.AbsoluteLifetime || lifetime < as.Config.Session.AbsoluteLifetime-time.Minute {
+		t.Fatalf("unexpected absolute lifetime %v", lifetime)
+	}
+
+	// Renewals never go past the absolute expiry
+	absoluteExpiresAt := time.Now().Add(30 * time.Minute).Unix()
+	stored.AbsoluteExpiresAt = absoluteExpiresAt
+	stored.ExpiresAt = time.Now().Add(10 * time.Minute).Unix()
+	fakeQuerier.Sessions[hashToken(token)] = stored
+	session, _, err := as.validateSession(ctx, token)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if session.ExpiresAt != absoluteExpiresAt {
+		t.Fatalf("expected the expiry to be capped at %d, got %d", absoluteExpiresAt, session.ExpiresAt)
+	}
+
+	// A session in use still ends once its absolute lifetime is over
+	stored = fakeQuerier.Sessions[hashToken(token)]
+	stored.AbsoluteExpiresAt = time.Now().Add(-time.Second).Unix()
+	stored.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	fakeQuerier.Sessions[hashToken(token)] = stored
+	if _, _, err := as.validateSession(ctx, token); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("expected ErrSessionExpired, got: %v", err)
+	}
+	if _, ok := fakeQuerier.Sessions[hashToken(token)]; ok {
+		t.Fatalf("expected the expired session to be deleted")
+	}
+}
+
+func TestRememberMeSession(t *testing.T) {
+	fakeQuerier := store.NewFakeQuerier()
+	fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@example.com"}
+	as := Init(givenTestConfig(), fakeQuerier)
+	ctx := context.Background()
+
+	token, err := as.createSession(ctx, 1, ClientInfo{RememberMe: true})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	stored := fakeQuerier.Sessions[hashToken(token)]
+	if stored.RememberMe != 1 || stored.ExpiresAt != stored.AbsoluteExpiresAt {
+		t.Fatalf("unexpected remembered session %+v", stored)
+	}
+	if lifetime := time.Until(time.Unix(stored.ExpiresAt, 0)); lifetime <= as.Config.Session.AbsoluteLifetime {
+		t.Fatalf("expected a session outliving the absolute lifetime, got %v", lifetime)
+	}
+
+	// The expiry is already as far as it goes, using the session does not write it
+	session, _, err := as.validateSession(ctx, token)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !session.RememberMe || session.ExpiresAt != stored.ExpiresAt {
+		t.Fatalf("unexpected session %+v", session)
+	}
+}
+
 func TestGetTokenFromCookie(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 
@@ -133,7 +246,7 @@ func TestGetTokenFromCookie(t *testing.T) {
 func TestSetSessionCookie(t *testing.T) {
 	rr := httptest.NewRecorder()
 	token := "testtoken"
-	expiresAt := time.Now().Add(sessionDuration).Unix()
+	expiresAt := time.Now().Add(24 * time.Hour).Unix()
 
 	SetSessionCookie(rr, token, expiresAt)
 
@@ -192,6 +305,17 @@ func TestDeleteSessionCookie(t *testing.T) {
 	if cookie.SameSite != http.SameSiteStrictMode {
 		t.Fatalf("expected SameSite to be %v, got: %v", http.SameSiteStrictMode, cookie.SameSite)
 	}
+
+	// a renewed session cookie is replaced, other cookies of the response are kept
+	rr = httptest.NewRecorder()
+	SetSessionCookie(rr, "renewed", time.Now().Add(time.Hour).Unix())
+	SetDeviceCookie(rr, testDeviceOne)
+	DeleteSessionCookie(rr)
+
+	cookies = rr.Result().Cookies()
+	if len(cookies) != 2 || cookies[0].Name != DeviceCookieName || cookies[1].Name != SessionCookieName || cookies[1].Value != "" {
+		t.Fatalf("unexpected cookies %v", cookies)
+	}
 }
 
 func TestGetSessionFrom(t *testing.T) {
@@ -220,13 +344,14 @@ func TestGetSessionFrom(t *testing.T) {
 func TestInvalidateSession(t *testing.T) {
 	c := givenTestConfig()
 	fakeQuerier := store.NewFakeQuerier()
+	fakeQuerier.Users[123] = db.User{ID: 123, Email: "user@example.com"}
 	as := Init(c, fakeQuerier)
 
 	ctx := context.Background()
 	userId := int64(123)
 
 	// Create a session to invalidate
-	token, err := as.createSession(ctx, userId)
+	token, err := as.createSession(ctx, userId, ClientInfo{})
 	if err != nil {
 		t.Fatalf("expected no error, got: %v", err)
 	}
@@ -270,3 +395,105 @@ func TestGetSessionUserFrom(t *testing.T) {
 		t.Fatalf("expected not to retrieve user from context")
 	}
 }
+
+func TestSessionRevocation(t *testing.T) {
+	fakeQuerier := store.NewFakeQuerier()
+	fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@example.com"}
+	fakeQuerier.Users[2] = db.User{ID: 2, Email: "other@example.com"}
+	as := Init(givenTestConfig(), fakeQuerier)
+	ctx := context.Background()
+
+	laptop, err := as.createSession(ctx, 1, ClientInfo{IPAddress: "192.0.2.1", UserAgent: "laptop"})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	phone, err := as.createSession(ctx, 1, ClientInfo{IPAddress: "192.0.2.2", UserAgent: "phone"})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	tablet, err := as.createSession(ctx, 1, ClientInfo{IPAddress: "192.0.2.3", UserAgent: "tablet"})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	other, err := as.createSession(ctx, 2, ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	sessions, err := as.ListSessions(ctx, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(sessions) != 3 {
+		t.Fatalf("expected 3 sessions, got %d", len(sessions))
+	}
+	for _, s := range sessions {
+		if s.IPAddress == "" || s.UserAgent == "" || s.LastSeenAt == 0 {
+			t.Fatalf("expected client info to be recorded, got %+v", s)
+		}
+	}
+
+	// Users cannot revoke sessions of someone else
+	if err := as.RevokeSession(ctx, 1, hashToken(other)); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("expected ErrSessionNotFound, got: %v", err)
+	}
+
+	if err := as.RevokeSession(ctx, 1, hashToken(laptop)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, _, err := as.validateSession(ctx, laptop); err == nil {
+		t.Fatalf("expected revoked session to be invalid")
+	}
+
+	if err := as.RevokeOtherSessions(ctx, 1, hashToken(phone)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, _, err := as.validateSession(ctx, tablet); err == nil {
+		t.Fatalf("expected other sessions to be revoked")
+	}
+	for _, token := range []string{phone, other} {
+		if _, _, err := as.validateSession(ctx, token); err != nil {
+			t.Fatalf("expected session to survive, got: %v", err)
+		}
+	}
+}
+
+func TestValidateSessionTouchesLastSeen(t *testing.T) {
+	fakeQuerier := store.NewFakeQuerier()
+	fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@example.com"}
+	as := Init(givenTestConfig(), fakeQuerier)
+	ctx := context.Background()
+
+	token, err := as.createSession(ctx, 1, ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	stale := time.Now().Add(-time.Hour).Unix()
+	session := fakeQuerier.Sessions[hashToken(token)]
+	session.LastSeenAt = stale
+	fakeQuerier.Sessions[hashToken(token)] = session
+
+	if _, _, err := as.validateSession(ctx, token); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if fakeQuerier.Sessions[hashToken(token)].LastSeenAt <= stale {
+		t.Fatalf("expected last seen time to be updated")
+	}
+}
+
+func TestClientInfoFrom(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.7:54321"
+	req.Header.Set("User-Agent", strings.Repeat("a", maxUserAgentLength+10))
+	// Proxy headers can be forged by the client
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+	client := ClientInfoFrom(req)
+	if client.IPAddress != "203.0.113.7" {
+		t.Fatalf("unexpected ip address %q", client.IPAddress)
+	}
+	if len(client.UserAgent) != maxUserAgentLength {
+		t.Fatalf("expected user agent to be truncated, got %d bytes", len(client.UserAgent))
+	}
+}
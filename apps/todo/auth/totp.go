@@ -0,0 +1,318 @@
+package auth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+	"github.com/AltSoyuz/soy-experiments/lib/otp"
+)
+
+// totpIssuer names the app in authenticator apps, next to the email of the account
+const totpIssuer = relyingPartyName
+
+// totpOptions are the settings authenticator apps assume, a step of skew accepts codes typed just before they changed
+var totpOptions = otp.Options{Digits: 6, Period: 30 * time.Second, Skew: 1}
+
+// newTOTPSecretsAEAD builds the cipher protecting stored TOTP secrets.
+// Without a configured key a random one is used, so secrets do not survive a restart.
+func newTOTPSecretsAEAD(encodedKey string) cipher.AEAD {
+	var key []byte
+	if encodedKey != "" {
+		decoded, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			panic(fmt.Sprintf("invalid totp encryption key: %v", err))
+		}
+		key = decoded
+	} else {
+		slog.Warn("no totp encryption key configured, using a temporary key")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(fmt.Sprintf("failed to generate totp encryption key: %v", err))
+		}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(fmt.Sprintf("invalid totp encryption key: %v", err))
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create totp cipher: %v", err))
+	}
+	return aead
+}
+
+// TOTPEnabled reports whether the user has a confirmed authenticator
+func (as *Service) TOTPEnabled(ctx context.Context, userId int64) (bool, error) {
+	credential, err := as.queries.GetTOTPCredential(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get totp credential: %w", err)
+	}
+	return credential.Enabled != 0, nil
+}
+
+// BeginTOTPEnrollment generates a new key for authenticator apps, with its secret and otpauth URL.
+// The secret is not required at login until a first code is confirmed with ConfirmTOTPEnrollment.
+func (as *Service) BeginTOTPEnrollment(ctx context.Context, user model.User) (otp.Key, error) {
+	enabled, err := as.TOTPEnabled(ctx, user.Id)
+	if err != nil {
+		return otp.Key{}, err
+	}
+	if enabled {
+		return otp.Key{}, ErrTOTPAlreadyEnabled
+	}
+
+	key, err := otp.GenerateKey(otp.KeyOptions{
+		Issuer:      totpIssuer,
+		AccountName: user.Email,
+		Options:     totpOptions,
+	})
+	if err != nil {
+		return otp.Key{}, fmt.Errorf("failed to generate totp key: %w", err)
+	}
+
+	encryptedSecret, err := as.encryptTOTPSecret(user.Id, key.Secret)
+	if err != nil {
+		return otp.Key{}, err
+	}
+
+	err = as.queries.UpsertTOTPCredential(ctx, db.UpsertTOTPCredentialParams{
+		UserID:          user.Id,
+		EncryptedSecret: encryptedSecret,
+		CreatedAt:       time.Now().Unix(),
+	})
+	if err != nil {
+		return otp.Key{}, fmt.Errorf("failed to store totp credential: %w", err)
+	}
+
+	return key, nil
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication once the authenticator produces a valid code
+func (as *Service) ConfirmTOTPEnrollment(ctx context.Context, userId int64, code string) (err error) {
+	defer func() { as.recordEvent(ctx, model.AuthEvent{Type: AuthEventTOTPEnrollment, UserId: userId}, err) }()
+
+	credential, err := as.queries.GetTOTPCredential(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTOTPNotEnabled
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get totp credential: %w", err)
+	}
+	if credential.Enabled != 0 {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	valid, err := as.verifyTOTP(ctx, credential, code)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrInvalidTOTPCode
+	}
+
+	return as.queries.EnableTOTPCredential(ctx, userId)
+}
+
+// VerifyTwoFactor checks the code for a pending session and upgrades it to a full session.
+// After maxTwoFactorAttempts wrong codes the pending session is deleted and the user signs in again.
+func (as *Service) VerifyTwoFactor(ctx context.Context, token, code string) (s model.Session, err error) {
+	session, user, err := as.validateSession(ctx, token)
+	if err != nil {
+		return model.Session{}, err
+	}
+	if !session.TwoFactorPending {
+		return session, nil
+	}
+	defer func() {
+		as.recordEvent(ctx, model.AuthEvent{Type: AuthEventTwoFactor, UserId: user.Id, Email: user.Email, Reason: "authenticator code"}, err)
+	}()
+
+	last, err := as.countTwoFactorAttempt(ctx, session.Id)
+	if err != nil {
+		return model.Session{}, err
+	}
+
+	credential, err := as.queries.GetTOTPCredential(ctx, session.UserId)
+	if err != nil {
+		return model.Session{}, fmt.Errorf("failed to get totp credential: %w", err)
+	}
+
+	valid, err := as.verifyTOTP(ctx, credential, code)
+	if err != nil {
+		return model.Session{}, err
+	}
+	if !valid {
+		return model.Session{}, as.rejectTwoFactorCode(ctx, session.Id, last, ErrInvalidTOTPCode)
+	}
+
+	return as.completeTwoFactor(ctx, session)
+}
+
+// countTwoFactorAttempt counts an attempt on the pending session before the code is checked and reports
+// whether it is the last one. Past maxTwoFactorAttempts the session is deleted so guessing needs the password again.
+func (as *Service) countTwoFactorAttempt(ctx context.Context, sessionId string) (bool, error) {
+	attempts, err := as.queries.IncrementSessionTwoFactorAttempts(ctx, sessionId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrSessionInvalid
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to count two-factor attempt: %w", err)
+	}
+	if attempts > maxTwoFactorAttempts {
+		return false, as.rejectTwoFactorCode(ctx, sessionId, true, nil)
+	}
+	return attempts == maxTwoFactorAttempts, nil
+}
+
+// rejectTwoFactorCode returns err for a wrong code, or deletes the pending session when it was the last attempt
+func (as *Service) rejectTwoFactorCode(ctx context.Context, sessionId string, last bool, err error) error {
+	if !last {
+		return err
+	}
+	if err := as.queries.DeleteSession(ctx, sessionId); err != nil {
+		return fmt.Errorf("failed to delete pending session: %w", err)
+	}
+	return ErrTwoFactorLocked
+}
+
+// completeTwoFactor upgrades a pending session to a full session once a second factor was verified
+func (as *Service) completeTwoFactor(ctx context.Context, session model.Session) (model.Session, error) {
+	updated, err := as.queries.CompleteSessionTwoFactor(ctx, db.CompleteSessionTwoFactorParams{
+		ExpiresAt: as.sessionExpiry(time.Now(), session),
+		ID:        session.Id,
+	})
+	if err != nil {
+		return model.Session{}, fmt.Errorf("failed to complete session: %w", err)
+	}
+
+	session.TwoFactorPending = false
+	session.ExpiresAt = updated.ExpiresAt
+	return session, nil
+}
+
+// DisableTOTP removes the authenticator once the user has proven again both their password and a current code
+func (as *Service) DisableTOTP(ctx context.Context, userId int64, password, code string) (err error) {
+	defer func() { as.recordEvent(ctx, model.AuthEvent{Type: AuthEventTOTPDisable, UserId: userId}, err) }()
+
+	credential, err := as.queries.GetTOTPCredential(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTOTPNotEnabled
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get totp credential: %w", err)
+	}
+	if credential.Enabled == 0 {
+		return ErrTOTPNotEnabled
+	}
+
+	user, err := as.queries.GetUserByID(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if user.PasswordHash == "" {
+		return ErrReauthenticationFailed
+	}
+
+	validPassword, err := as.verifyPassword(ctx, user.PasswordHash, password)
+	if err != nil {
+		return err
+	}
+	validCode, err := as.verifyTOTP(ctx, credential, code)
+	if err != nil {
+		return err
+	}
+	if !validPassword || !validCode {
+		return ErrReauthenticationFailed
+	}
+
+	// Recovery codes only stand in for the authenticator, they go with it
+	if err := as.queries.DeleteRecoveryCodes(ctx, userId); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	return as.queries.DeleteTOTPCredential(ctx, userId)
+}
+
+// verifyTOTP checks the code and marks its time step as used, so a code is accepted only once
+func (as *Service) verifyTOTP(ctx context.Context, credential db.TotpCredential, code string) (bool, error) {
+	secret, err := as.decryptTOTPSecret(credential.UserID, credential.EncryptedSecret)
+	if err != nil {
+		return false, err
+	}
+	verifier := otp.Verifier{Store: totpStepStore{queries: as.queries}, Options: totpOptions}
+	_, err = verifier.VerifyTOTP(ctx, strconv.FormatInt(credential.UserID, 10), secret, time.Now(), code)
+	if errors.Is(err, otp.ErrInvalidCode) || errors.Is(err, otp.ErrReplayedCode) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// totpStepStore keeps the next unused time step in the totp credential of the user, the key id is the user id
+type totpStepStore struct {
+	queries db.Querier
+}
+
+func (s totpStepStore) Next(ctx context.Context, keyID string) (uint64, error) {
+	userId, err := strconv.ParseInt(keyID, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	credential, err := s.queries.GetTOTPCredential(ctx, userId)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(credential.NextStep), nil
+}
+
+func (s totpStepStore) Advance(ctx context.Context, keyID string, next uint64) (bool, error) {
+	userId, err := strconv.ParseInt(keyID, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	rows, err := s.queries.AdvanceTOTPCredentialStep(ctx, db.AdvanceTOTPCredentialStepParams{
+		NextStep: int64(next),
+		UserID:   userId,
+	})
+	return rows > 0, err
+}
+
+// encryptTOTPSecret seals the secret with a random nonce prepended to the ciphertext
+func (as *Service) encryptTOTPSecret(userId int64, secret []byte) ([]byte, error) {
+	nonce := make([]byte, as.totpSecrets.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return as.totpSecrets.Seal(nonce, nonce, secret, totpSecretAdditionalData(userId)), nil
+}
+
+func (as *Service) decryptTOTPSecret(userId int64, encryptedSecret []byte) ([]byte, error) {
+	nonceSize := as.totpSecrets.NonceSize()
+	if len(encryptedSecret) < nonceSize {
+		return nil, errors.New("invalid encrypted totp secret")
+	}
+	secret, err := as.totpSecrets.Open(nil, encryptedSecret[:nonceSize], encryptedSecret[nonceSize:], totpSecretAdditionalData(userId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt totp secret: %w", err)
+	}
+	return secret, nil
+}
+
+// totpSecretAdditionalData binds a ciphertext to its user so it cannot be copied to another account
+func totpSecretAdditionalData(userId int64) []byte {
+	return []byte(strconv.FormatInt(userId, 10))
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/store"
+	"github.com/AltSoyuz/soy-experiments/lib/otp"
+)
+
+func givenTOTPCode(t *testing.T, secret string) string {
+	t.Helper()
+
+	return givenTOTPCodeAt(t, secret, time.Now())
+}
+
+// givenTOTPCodeAt returns the code of another time step, each step is accepted only once
+func givenTOTPCodeAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+
+	key, err := otp.DecodeSecret(secret)
+	if err != nil {
+		t.Fatalf("invalid secret %q: %v", secret, err)
+	}
+	code, err := otp.GenerateTOTP(key, at, totpOptions)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	return code
+}
+
+func givenTOTPUser(t *testing.T) (*Service, *store.FakeQuerier, string) {
+	t.Helper()
+
+	as, fakeQuerier := givenPasswordUser(t)
+	ctx := context.Background()
+	key, err := as.BeginTOTPEnrollment(ctx, model.User{Id: 1, Email: "user@example.com"})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	secret := key.EncodedSecret()
+	// The previous step is still within the skew, which leaves the current one to the test
+	if err := as.ConfirmTOTPEnrollment(ctx, 1, givenTOTPCodeAt(t, secret, time.Now().Add(-totpOptions.Period))); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	return as, fakeQuerier, secret
+}
+
+func TestTOTPEnrollment(t *testing.T) {
+	fakeQuerier := store.NewFakeQuerier()
+	as := Init(givenTestConfig(), fakeQuerier)
+	ctx := context.Background()
+
+	key, err := as.BeginTOTPEnrollment(ctx, model.User{Id: 1, Email: "user@example.com"})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	secret := key.EncodedSecret()
+	if key.Issuer != totpIssuer || key.AccountName != "user@example.com" {
+		t.Fatalf("unexpected key label %q:%q", key.Issuer, key.AccountName)
+	}
+
+	// The secret is only stored encrypted
+	stored := fakeQuerier.TOTPCredentials[1].EncryptedSecret
+	if bytes.Contains(stored, key.Secret) {
+		t.Fatalf("expected secret to be encrypted at rest")
+	}
+
+	// Not required before the first code is confirmed
+	if enabled, _ := as.TOTPEnabled(ctx, 1); enabled {
+		t.Fatalf("expected totp to be disabled before confirmation")
+	}
+
+	if err := as.ConfirmTOTPEnrollment(ctx, 1, "abcdef"); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Fatalf("expected ErrInvalidTOTPCode, got: %v", err)
+	}
+	if err := as.ConfirmTOTPEnrollment(ctx, 1, givenTOTPCode(t, secret)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if enabled, _ := as.TOTPEnabled(ctx, 1); !enabled {
+		t.Fatalf("expected totp to be enabled")
+	}
+
+	// An enabled authenticator cannot be silently replaced
+	if _, err := as.BeginTOTPEnrollment(ctx, model.User{Id: 1, Email: "user@example.com"}); !errors.Is(err, ErrTOTPAlreadyEnabled) {
+		t.Fatalf("expected ErrTOTPAlreadyEnabled, got: %v", err)
+	}
+
+	// The ciphertext is bound to its user
+	if _, err := as.decryptTOTPSecret(2, stored); err == nil {
+		t.Fatalf("expected decryption for another user to fail")
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	as, fakeQuerier, secret := givenTOTPUser(t)
+	ctx := context.Background()
+
+	session, token, err := as.AuthenticateWithPassword(ctx, "user@example.com", "Str0ngP@ssw0rd!", ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !session.TwoFactorPending {
+		t.Fatalf("expected a pending session")
+	}
+	if time.Until(time.Unix(session.ExpiresAt, 0)) > twoFactorPendingDuration {
+		t.Fatalf("expected pending session to be short lived")
+	}
+
+	if _, err := as.VerifyTwoFactor(ctx, token, "abcdef"); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Fatalf("expected ErrInvalidTOTPCode, got: %v", err)
+	}
+	if fakeQuerier.Sessions[hashToken(token)].TwoFactorPending != 1 {
+		t.Fatalf("expected session to stay pending after a wrong code")
+	}
+
+	session, err = as.VerifyTwoFactor(ctx, token, givenTOTPCode(t, secret))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if session.TwoFactorPending || fakeQuerier.Sessions[hashToken(token)].TwoFactorPending != 0 {
+		t.Fatalf("expected session to be upgraded")
+	}
+	if time.Until(time.Unix(session.ExpiresAt, 0)) <= twoFactorPendingDuration {
+		t.Fatalf("expected upgraded session to get the full lifetime")
+	}
+
+	// The same code cannot complete another login
+	_, token, err = as.AuthenticateWithPassword(ctx, "user@example.com", "Str0ngP@ssw0rd!", ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := as.VerifyTwoFactor(ctx, token, givenTOTPCode(t, secret)); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Fatalf("expected a replayed code to be rejected, got: %v", err)
+	}
+	if _, err := as.VerifyTwoFactor(ctx, token, givenTOTPCodeAt(t, secret, time.Now().Add(totpOptions.Period))); err != nil {
+		t.Fatalf("expected the next code to be accepted, got: %v", err)
+	}
+}
+
+func TestTwoFactorAttemptsLimit(t *testing.T) {
+	as, fakeQuerier, secret := givenTOTPUser(t)
+	ctx := context.Background()
+
+	_, token, err := as.AuthenticateWithPassword(ctx, "user@example.com", "Str0ngP@ssw0rd!", ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	for i := 1; i < maxTwoFactorAttempts; i++ {
+		if _, err := as.VerifyTwoFactor(ctx, token, "000000"); !errors.Is(err, ErrInvalidTOTPCode) {
+			t.Fatalf("expected ErrInvalidTOTPCode on attempt %d, got: %v", i, err)
+		}
+	}
+
+	// recovery codes count against the same attempts
+	if _, err := as.VerifyRecoveryCode(ctx, token, "aaaaa-aaaaa"); !errors.Is(err, ErrTwoFactorLocked) {
+		t.Fatalf("expected ErrTwoFactorLocked, got: %v", err)
+	}
+	if _, ok := fakeQuerier.Sessions[hashToken(token)]; ok {
+		t.Fatalf("expected the pending session to be deleted")
+	}
+	if _, err := as.VerifyTwoFactor(ctx, token, givenTOTPCode(t, secret)); err == nil {
+		t.Fatalf("expected the right code to no longer work")
+	}
+
+	// signing in again starts over
+	_, token, err = as.AuthenticateWithPassword(ctx, "user@example.com", "Str0ngP@ssw0rd!", ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := as.VerifyTwoFactor(ctx, token, givenTOTPCode(t, secret)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+}
+
+func TestDisableTOTP(t *testing.T) {
+	as, _, secret := givenTOTPUser(t)
+	ctx := context.Background()
+
+	f := func(password, code string, expect error) {
+		t.Helper()
+
+		err := as.DisableTOTP(ctx, 1, password, code)
+		if !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+	}
+
+	// wrong password, the code is used up all the same
+	f("wrong-password", givenTOTPCode(t, secret), ErrReauthenticationFailed)
+	f("Str0ngP@ssw0rd!", givenTOTPCode(t, secret), ErrReauthenticationFailed)
+
+	// wrong code
+	f("Str0ngP@ssw0rd!", "abcdef", ErrReauthenticationFailed)
+
+	// password and code
+	f("Str0ngP@ssw0rd!", givenTOTPCodeAt(t, secret, time.Now().Add(totpOptions.Period)), nil)
+
+	// already disabled
+	f("Str0ngP@ssw0rd!", givenTOTPCode(t, secret), ErrTOTPNotEnabled)
+
+	// login no longer asks for a code
+	session, _, err := as.AuthenticateWithPassword(ctx, "user@example.com", "Str0ngP@ssw0rd!", ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if session.TwoFactorPending {
+		t.Fatalf("expected a full session once totp is disabled")
+	}
+}
@@ -0,0 +1,371 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+	"github.com/AltSoyuz/soy-experiments/lib/webauthn"
+)
+
+// Ceremonies a stored challenge can be answered for
+const (
+	passkeyCeremonyRegister  = "register"
+	passkeyCeremonyLogin     = "login"
+	passkeyCeremonyTwoFactor = "two_factor"
+)
+
+// relyingPartyName is shown by authenticators next to the passkeys of the app
+const relyingPartyName = "Todo"
+
+// BeginPasskeyRegistration returns the options for the browser to create a passkey for the user.
+// Passkeys the user already has are excluded so an authenticator does not register twice.
+func (as *Service) BeginPasskeyRegistration(ctx context.Context, user model.User) (webauthn.CreationOptions, error) {
+	rp, err := as.relyingParty()
+	if err != nil {
+		return webauthn.CreationOptions{}, err
+	}
+
+	rows, err := as.queries.ListUserWebAuthnCredentials(ctx, user.Id)
+	if err != nil {
+		return webauthn.CreationOptions{}, fmt.Errorf("failed to list passkeys: %w", err)
+	}
+	exclude, err := passkeyCredentialIDs(rows)
+	if err != nil {
+		return webauthn.CreationOptions{}, err
+	}
+
+	challenge, err := as.createPasskeyChallenge(ctx, passkeyCeremonyRegister, user.Id)
+	if err != nil {
+		return webauthn.CreationOptions{}, err
+	}
+
+	webauthnUser := webauthn.User{ID: passkeyUserHandle(user.Id), Name: user.Email, DisplayName: user.Email}
+	return rp.CreationOptions(webauthnUser, challenge, exclude), nil
+}
+
+// FinishPasskeyRegistration verifies the credential created by the browser and stores its public key
+func (as *Service) FinishPasskeyRegistration(ctx context.Context, user model.User, name string, response webauthn.RegistrationResponse) (err error) {
+	name = strings.TrimSpace(name)
+	defer func() {
+		as.recordEvent(ctx, model.AuthEvent{Type: AuthEventPasskeyAdd, UserId: user.Id, Email: user.Email, Reason: name}, err)
+	}()
+
+	if name == "" || utf8.RuneCountInString(name) > maxPasskeyNameLength {
+		return ErrPasskeyName
+	}
+
+	rp, err := as.relyingParty()
+	if err != nil {
+		return err
+	}
+	challenge, err := as.consumePasskeyChallenge(ctx, response.ClientData, passkeyCeremonyRegister, user.Id)
+	if err != nil {
+		return err
+	}
+
+	credential, err := rp.VerifyRegistration(challenge, response)
+	if err != nil {
+		slog.Info("passkey registration rejected", "userId", user.Id, "error", err)
+		return ErrInvalidPasskey
+	}
+
+	_, err = as.queries.CreateWebAuthnCredential(ctx, db.CreateWebAuthnCredentialParams{
+		UserID:       user.Id,
+		CredentialID: credential.ID.String(),
+		PublicKey:    credential.PublicKey,
+		SignCount:    int64(credential.SignCount),
+		Name:         name,
+		CreatedAt:    time.Now().Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store passkey: %w", err)
+	}
+	return nil
+}
+
+// BeginPasskeyLogin returns the options for the browser to sign in with any passkey of the app.
+// The authenticator lets the user pick the account, so no email is asked first.
+func (as *Service) BeginPasskeyLogin(ctx context.Context) (webauthn.RequestOptions, error) {
+	rp, err := as.relyingParty()
+	if err != nil {
+		return webauthn.RequestOptions{}, err
+	}
+
+	challenge, err := as.createPasskeyChallenge(ctx, passkeyCeremonyLogin, 0)
+	if err != nil {
+		return webauthn.RequestOptions{}, err
+	}
+	return rp.RequestOptions(challenge, nil, webauthn.UserVerificationRequired), nil
+}
+
+// AuthenticateWithPasskey verifies the assertion of a passkey and creates a session for its owner.
+// The authenticator verified the user with a PIN or biometrics, so the passkey counts as two factors
+// and the session is not left pending even when the account has an authenticator app.
+func (as *Service) AuthenticateWithPasskey(ctx context.Context, response webauthn.AssertionResponse, client ClientInfo) (s model.Session, t string, err error) {
+	event := model.AuthEvent{Type: AuthEventLogin, Reason: "passkey"}
+	defer func() { as.recordEvent(ctx, event, err) }()
+
+	challenge, err := as.consumePasskeyChallenge(ctx, response.ClientData, passkeyCeremonyLogin, 0)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+
+	row, err := as.queries.GetWebAuthnCredential(ctx, response.RawID.String())
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.Session{}, "", ErrInvalidPasskey
+	}
+	if err != nil {
+		return model.Session{}, "", fmt.Errorf("failed to get passkey: %w", err)
+	}
+	event.UserId = row.UserID
+
+	// The user handle is optional for a non-discoverable credential, but when sent it must be the owner
+	if len(response.Response.UserHandle) > 0 && !bytes.Equal(response.Response.UserHandle, passkeyUserHandle(row.UserID)) {
+		return model.Session{}, "", ErrInvalidPasskey
+	}
+
+	if err := as.verifyPasskeyAssertion(ctx, row, challenge, response, true); err != nil {
+		return model.Session{}, "", err
+	}
+
+	token, err := as.createSession(ctx, row.UserID, client)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+
+	session, _, err := as.validateSession(ctx, token)
+	if err != nil {
+		return model.Session{}, "", err
+	}
+	if session.TwoFactorPending {
+		session, err = as.completeTwoFactor(ctx, session)
+		if err != nil {
+			return model.Session{}, "", err
+		}
+	}
+
+	return session, token, nil
+}
+
+// BeginPasskeyTwoFactor returns the options for the browser to confirm a pending session with one of
+// the passkeys of the user. It returns ErrPasskeyNotFound when the user has none.
+func (as *Service) BeginPasskeyTwoFactor(ctx context.Context, token string) (webauthn.RequestOptions, error) {
+	session, _, err := as.validateSession(ctx, token)
+	if err != nil {
+		return webauthn.RequestOptions{}, err
+	}
+	if !session.TwoFactorPending {
+		return webauthn.RequestOptions{}, ErrSessionInvalid
+	}
+
+	rp, err := as.relyingParty()
+	if err != nil {
+		return webauthn.RequestOptions{}, err
+	}
+
+	rows, err := as.queries.ListUserWebAuthnCredentials(ctx, session.UserId)
+	if err != nil {
+		return webauthn.RequestOptions{}, fmt.Errorf("failed to list passkeys: %w", err)
+	}
+	if len(rows) == 0 {
+		return webauthn.RequestOptions{}, ErrPasskeyNotFound
+	}
+	allow, err := passkeyCredentialIDs(rows)
+	if err != nil {
+		return webauthn.RequestOptions{}, err
+	}
+
+	challenge, err := as.createPasskeyChallenge(ctx, passkeyCeremonyTwoFactor, session.UserId)
+	if err != nil {
+		return webauthn.RequestOptions{}, err
+	}
+	// The password was the first factor, possession of the passkey is enough for the second
+	return rp.RequestOptions(challenge, allow, webauthn.UserVerificationDiscouraged), nil
+}
+
+// VerifyPasskeyTwoFactor accepts a passkey assertion in place of the authenticator code for a pending session
+func (as *Service) VerifyPasskeyTwoFactor(ctx context.Context, token string, response webauthn.AssertionResponse) (s model.Session, err error) {
+	session, user, err := as.validateSession(ctx, token)
+	if err != nil {
+		return model.Session{}, err
+	}
+	if !session.TwoFactorPending {
+		return session, nil
+	}
+	defer func() {
+		as.recordEvent(ctx, model.AuthEvent{Type: AuthEventTwoFactor, UserId: user.Id, Email: user.Email, Reason: "passkey"}, err)
+	}()
+
+	challenge, err := as.consumePasskeyChallenge(ctx, response.ClientData, passkeyCeremonyTwoFactor, session.UserId)
+	if err != nil {
+		return model.Session{}, err
+	}
+
+	row, err := as.queries.GetWebAuthnCredential(ctx, response.RawID.String())
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.Session{}, ErrInvalidPasskey
+	}
+	if err != nil {
+		return model.Session{}, fmt.Errorf("failed to get passkey: %w", err)
+	}
+	if row.UserID != session.UserId {
+		return model.Session{}, ErrInvalidPasskey
+	}
+
+	if err := as.verifyPasskeyAssertion(ctx, row, challenge, response, false); err != nil {
+		return model.Session{}, err
+	}
+
+	return as.completeTwoFactor(ctx, session)
+}
+
+// ListPasskeys returns the passkeys of the user, newest first
+func (as *Service) ListPasskeys(ctx context.Context, userId int64) ([]model.Passkey, error) {
+	rows, err := as.queries.ListUserWebAuthnCredentials(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list passkeys: %w", err)
+	}
+
+	passkeys := make([]model.Passkey, 0, len(rows))
+	for _, row := range rows {
+		passkeys = append(passkeys, model.Passkey{
+			Id:         row.ID,
+			Name:       row.Name,
+			CreatedAt:  row.CreatedAt,
+			LastUsedAt: row.LastUsedAt.Int64,
+		})
+	}
+	return passkeys, nil
+}
+
+// DeletePasskey removes a passkey of the user, it can no longer sign in
+func (as *Service) DeletePasskey(ctx context.Context, userId, passkeyId int64) (err error) {
+	defer func() {
+		as.recordEvent(ctx, model.AuthEvent{Type: AuthEventPasskeyRemove, UserId: userId, Reason: fmt.Sprintf("passkey %d", passkeyId)}, err)
+	}()
+
+	deleted, err := as.queries.DeleteUserWebAuthnCredential(ctx, db.DeleteUserWebAuthnCredentialParams{
+		ID:     passkeyId,
+		UserID: userId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete passkey: %w", err)
+	}
+	if deleted == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// verifyPasskeyAssertion checks the signature of the assertion and moves the stored counter forward
+func (as *Service) verifyPasskeyAssertion(ctx context.Context, row db.WebauthnCredential, challenge webauthn.Bytes, response webauthn.AssertionResponse, requireUserVerification bool) error {
+	rp, err := as.relyingParty()
+	if err != nil {
+		return err
+	}
+	id, err := base64.RawURLEncoding.DecodeString(row.CredentialID)
+	if err != nil {
+		return fmt.Errorf("invalid stored credential id: %w", err)
+	}
+
+	credential := webauthn.Credential{ID: id, PublicKey: row.PublicKey, SignCount: uint32(row.SignCount)}
+	signCount, err := rp.VerifyAssertion(challenge, credential, response, requireUserVerification)
+	if err != nil {
+		slog.Info("passkey assertion rejected", "userId", row.UserID, "passkeyId", row.ID, "error", err)
+		return ErrInvalidPasskey
+	}
+
+	// Only the request that moves the counter from the value it verified against gets in
+	updated, err := as.queries.UseWebAuthnCredential(ctx, db.UseWebAuthnCredentialParams{
+		SignCount:         int64(signCount),
+		LastUsedAt:        sql.NullInt64{Int64: time.Now().Unix(), Valid: true},
+		ID:                row.ID,
+		PreviousSignCount: row.SignCount,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update passkey: %w", err)
+	}
+	if updated == 0 {
+		return ErrInvalidPasskey
+	}
+	return nil
+}
+
+// createPasskeyChallenge stores a new challenge for the ceremony, userId is 0 when nobody is signed in yet
+func (as *Service) createPasskeyChallenge(ctx context.Context, ceremony string, userId int64) (webauthn.Bytes, error) {
+	now := time.Now()
+	if err := as.queries.DeleteExpiredWebAuthnChallenges(ctx, now.Unix()); err != nil {
+		return nil, fmt.Errorf("failed to delete expired passkey challenges: %w", err)
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+	err = as.queries.CreateWebAuthnChallenge(ctx, db.CreateWebAuthnChallengeParams{
+		ChallengeHash: hashToken(challenge.String()),
+		Ceremony:      ceremony,
+		UserID:        sql.NullInt64{Int64: userId, Valid: userId != 0},
+		ExpiresAt:     now.Add(passkeyChallengeDuration).Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store passkey challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// consumePasskeyChallenge deletes the challenge the browser answered and returns it when it was
+// issued for this ceremony and user and has not expired. Each challenge is accepted once.
+func (as *Service) consumePasskeyChallenge(ctx context.Context, clientData func() (webauthn.ClientData, error), ceremony string, userId int64) (webauthn.Bytes, error) {
+	data, err := clientData()
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	row, err := as.queries.ConsumeWebAuthnChallenge(ctx, hashToken(data.Challenge.String()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidPasskey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume passkey challenge: %w", err)
+	}
+
+	if row.Ceremony != ceremony || row.UserID.Int64 != userId || time.Now().Unix() >= row.ExpiresAt {
+		return nil, ErrInvalidPasskey
+	}
+	return data.Challenge, nil
+}
+
+// relyingParty describes the app to authenticators, passkeys are bound to the host of the base URL
+func (as *Service) relyingParty() (webauthn.RelyingParty, error) {
+	return webauthn.NewRelyingParty(as.Config.BaseURL, relyingPartyName)
+}
+
+// passkeyUserHandle is the opaque user id stored by authenticators along with a passkey
+func passkeyUserHandle(userId int64) webauthn.Bytes {
+	return binary.BigEndian.AppendUint64(nil, uint64(userId))
+}
+
+// passkeyCredentialIDs decodes the ids of stored credentials, they are kept in their base64url form
+func passkeyCredentialIDs(rows []db.WebauthnCredential) ([]webauthn.Bytes, error) {
+	ids := make([]webauthn.Bytes, 0, len(rows))
+	for _, row := range rows {
+		id, err := base64.RawURLEncoding.DecodeString(row.CredentialID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stored credential id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
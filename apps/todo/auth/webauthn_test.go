@@ -0,0 +1,333 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/store"
+	"github.com/AltSoyuz/soy-experiments/lib/webauthn"
+	"github.com/AltSoyuz/soy-experiments/lib/webauthn/webauthntest"
+)
+
+const testPasskeyOrigin = "http://localhost:8080"
+
+// givenPasskey registers a passkey of a new software authenticator for the user
+func givenPasskey(t *testing.T, as *Service, user model.User) *webauthntest.Authenticator {
+	t.Helper()
+
+	ctx := context.Background()
+	authenticator := webauthntest.NewAuthenticator(testPasskeyOrigin, webauthn.AlgES256)
+	options, err := as.BeginPasskeyRegistration(ctx, user)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	response, err := authenticator.Create(options)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := as.FinishPasskeyRegistration(ctx, user, "laptop", response); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	return authenticator
+}
+
+func givenPasskeyUser(t *testing.T) (*Service, *store.FakeQuerier, model.User, *webauthntest.Authenticator) {
+	t.Helper()
+
+	as, fakeQuerier := givenPasswordUser(t)
+	as.Config.BaseURL = testPasskeyOrigin
+	user := model.User{Id: 1, Email: "user@example.com", EmailVerified: true}
+	return as, fakeQuerier, user, givenPasskey(t, as, user)
+}
+
+// givenPasskeyLogin returns an assertion answering a new sign-in challenge
+func givenPasskeyLogin(t *testing.T, as *Service, authenticator *webauthntest.Authenticator) webauthn.AssertionResponse {
+	t.Helper()
+
+	options, err := as.BeginPasskeyLogin(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	response, err := authenticator.Get(options)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	return response
+}
+
+func TestPasskeyRegistration(t *testing.T) {
+	as, fakeQuerier, user, _ := givenPasskeyUser(t)
+	ctx := context.Background()
+
+	f := func(name string, prepare func(options *webauthn.CreationOptions) *webauthntest.Authenticator, expect error) {
+		t.Helper()
+
+		options, err := as.BeginPasskeyRegistration(ctx, user)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		authenticator := prepare(&options)
+		response, err := authenticator.Create(options)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if err := as.FinishPasskeyRegistration(ctx, user, name, response); !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+	}
+	newAuthenticator := func(options *webauthn.CreationOptions) *webauthntest.Authenticator {
+		return webauthntest.NewAuthenticator(testPasskeyOrigin, webauthn.AlgEdDSA)
+	}
+
+	// missing or too long names
+	f(" ", newAuthenticator, ErrPasskeyName)
+	f(strings.Repeat("a", maxPasskeyNameLength+1), newAuthenticator, ErrPasskeyName)
+
+	// a challenge that was not issued
+	f("phone", func(options *webauthn.CreationOptions) *webauthntest.Authenticator {
+		options.Challenge = []byte("forged challenge")
+		return newAuthenticator(options)
+	}, ErrInvalidPasskey)
+
+	// a page on another origin
+	f("phone", func(options *webauthn.CreationOptions) *webauthntest.Authenticator {
+		return webauthntest.NewAuthenticator("http://evil.example.com", webauthn.AlgEdDSA)
+	}, ErrInvalidPasskey)
+
+	// an authenticator without user verification
+	f("phone", func(options *webauthn.CreationOptions) *webauthntest.Authenticator {
+		authenticator := newAuthenticator(options)
+		authenticator.SkipUserVerification = true
+		return authenticator
+	}, ErrInvalidPasskey)
+
+	// valid passkey
+	f(" phone ", newAuthenticator, nil)
+
+	passkeys, err := as.ListPasskeys(ctx, user.Id)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(passkeys) != 2 || passkeys[0].Name != "phone" || passkeys[1].Name != "laptop" {
+		t.Fatalf("unexpected passkeys %+v", passkeys)
+	}
+
+	// The registered passkeys are excluded from new registrations
+	options, err := as.BeginPasskeyRegistration(ctx, user)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(options.ExcludeCredentials) != 2 || options.AuthenticatorSelection.UserVerification != webauthn.UserVerificationRequired {
+		t.Fatalf("unexpected creation options %+v", options)
+	}
+
+	// A challenge is only answered by the user it was issued for
+	other := model.User{Id: 2, Email: "other@example.com"}
+	response, err := newAuthenticator(&options).Create(options)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := as.FinishPasskeyRegistration(ctx, other, "phone", response); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("expected ErrInvalidPasskey, got: %v", err)
+	}
+	// and only once
+	if err := as.FinishPasskeyRegistration(ctx, user, "phone", response); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("expected ErrInvalidPasskey, got: %v", err)
+	}
+	if len(fakeQuerier.WebAuthnCredentials) != 2 {
+		t.Fatalf("unexpected stored passkeys %+v", fakeQuerier.WebAuthnCredentials)
+	}
+}
+
+func TestAuthenticateWithPasskey(t *testing.T) {
+	as, fakeQuerier, _, authenticator := givenPasskeyUser(t)
+	ctx := context.Background()
+
+	response := givenPasskeyLogin(t, as, authenticator)
+	session, token, err := as.AuthenticateWithPasskey(ctx, response, ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if session.UserId != 1 || session.TwoFactorPending || token == "" {
+		t.Fatalf("unexpected session %+v", session)
+	}
+	passkeys, _ := as.ListPasskeys(ctx, 1)
+	if passkeys[0].LastUsedAt == 0 {
+		t.Fatalf("expected the passkey use to be recorded")
+	}
+
+	// The same assertion cannot be replayed
+	if _, _, err := as.AuthenticateWithPasskey(ctx, response, ClientInfo{}); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("expected ErrInvalidPasskey, got: %v", err)
+	}
+
+	f := func(prepare func() webauthn.AssertionResponse) {
+		t.Helper()
+
+		if _, _, err := as.AuthenticateWithPasskey(ctx, prepare(), ClientInfo{}); !errors.Is(err, ErrInvalidPasskey) {
+			t.Fatalf("expected ErrInvalidPasskey, got: %v", err)
+		}
+	}
+
+	// an unknown passkey
+	f(func() webauthn.AssertionResponse {
+		stranger := webauthntest.NewAuthenticator(testPasskeyOrigin, webauthn.AlgES256)
+		options, _ := as.BeginPasskeyRegistration(ctx, model.User{Id: 1, Email: "user@example.com"})
+		if _, err := stranger.Create(options); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		return givenPasskeyLogin(t, as, stranger)
+	})
+
+	// a user handle of another account
+	f(func() webauthn.AssertionResponse {
+		response := givenPasskeyLogin(t, as, authenticator)
+		response.Response.UserHandle = passkeyUserHandle(2)
+		return response
+	})
+
+	// sign-in requires user verification
+	f(func() webauthn.AssertionResponse {
+		clone := authenticator.Clone()
+		clone.SkipUserVerification = true
+		return givenPasskeyLogin(t, as, clone)
+	})
+
+	// a cloned authenticator whose counter fell behind
+	clone := authenticator.Clone()
+	if _, _, err := as.AuthenticateWithPasskey(ctx, givenPasskeyLogin(t, as, authenticator), ClientInfo{}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	f(func() webauthn.AssertionResponse {
+		return givenPasskeyLogin(t, as, clone)
+	})
+
+	// A challenge issued for another ceremony is refused
+	creation, err := as.BeginPasskeyRegistration(ctx, model.User{Id: 1, Email: "user@example.com"})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	f(func() webauthn.AssertionResponse {
+		response, err := authenticator.Get(webauthn.RequestOptions{Challenge: creation.Challenge, RPID: "localhost"})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		return response
+	})
+
+	// A disabled account cannot sign in
+	user := fakeQuerier.Users[1]
+	user.Disabled = 1
+	fakeQuerier.Users[1] = user
+	if _, _, err := as.AuthenticateWithPasskey(ctx, givenPasskeyLogin(t, as, authenticator), ClientInfo{}); !errors.Is(err, ErrAccountDisabled) {
+		t.Fatalf("expected ErrAccountDisabled, got: %v", err)
+	}
+}
+
+func TestAuthenticateWithPasskeySkipsTwoFactor(t *testing.T) {
+	as, fakeQuerier, _ := givenTOTPUser(t)
+	as.Config.BaseURL = testPasskeyOrigin
+	ctx := context.Background()
+	authenticator := givenPasskey(t, as, model.User{Id: 1, Email: "user@example.com"})
+
+	session, _, err := as.AuthenticateWithPasskey(ctx, givenPasskeyLogin(t, as, authenticator), ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if session.TwoFactorPending || fakeQuerier.Sessions[session.Id].TwoFactorPending != 0 {
+		t.Fatalf("expected a verified passkey to complete the session, got %+v", session)
+	}
+}
+
+func TestVerifyPasskeyTwoFactor(t *testing.T) {
+	as, _, _ := givenTOTPUser(t)
+	as.Config.BaseURL = testPasskeyOrigin
+	ctx := context.Background()
+
+	givenPendingSession := func() string {
+		t.Helper()
+
+		_, token, err := as.AuthenticateWithPassword(ctx, "user@example.com", "Str0ngP@ssw0rd!", ClientInfo{})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		return token
+	}
+
+	// Without a passkey there is nothing to offer
+	if _, err := as.BeginPasskeyTwoFactor(ctx, givenPendingSession()); !errors.Is(err, ErrPasskeyNotFound) {
+		t.Fatalf("expected ErrPasskeyNotFound, got: %v", err)
+	}
+
+	authenticator := givenPasskey(t, as, model.User{Id: 1, Email: "user@example.com"})
+	// A security key without a PIN is enough as a second factor
+	authenticator.SkipUserVerification = true
+
+	token := givenPendingSession()
+	options, err := as.BeginPasskeyTwoFactor(ctx, token)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(options.AllowCredentials) != 1 {
+		t.Fatalf("expected the passkeys of the user to be allowed, got %+v", options.AllowCredentials)
+	}
+	response, err := authenticator.Get(options)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	// The challenge belongs to the pending session of the user, not to a sign-in
+	if _, _, err := as.AuthenticateWithPasskey(ctx, response, ClientInfo{}); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("expected ErrInvalidPasskey, got: %v", err)
+	}
+
+	options, err = as.BeginPasskeyTwoFactor(ctx, token)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	response, err = authenticator.Get(options)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	session, err := as.VerifyPasskeyTwoFactor(ctx, token, response)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if session.TwoFactorPending {
+		t.Fatalf("expected the session to be completed")
+	}
+
+	// Full sessions have nothing left to verify
+	if _, err := as.BeginPasskeyTwoFactor(ctx, token); !errors.Is(err, ErrSessionInvalid) {
+		t.Fatalf("expected ErrSessionInvalid, got: %v", err)
+	}
+}
+
+func TestDeletePasskey(t *testing.T) {
+	as, fakeQuerier, _, authenticator := givenPasskeyUser(t)
+	ctx := context.Background()
+
+	passkeys, err := as.ListPasskeys(ctx, 1)
+	if err != nil || len(passkeys) != 1 {
+		t.Fatalf("unexpected passkeys %+v, %v", passkeys, err)
+	}
+
+	// Only the owner can delete a passkey
+	if err := as.DeletePasskey(ctx, 2, passkeys[0].Id); !errors.Is(err, ErrPasskeyNotFound) {
+		t.Fatalf("expected ErrPasskeyNotFound, got: %v", err)
+	}
+	if err := as.DeletePasskey(ctx, 1, passkeys[0].Id); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(fakeQuerier.WebAuthnCredentials) != 0 {
+		t.Fatalf("expected the passkey to be deleted")
+	}
+
+	// A deleted passkey no longer signs in
+	if _, _, err := as.AuthenticateWithPasskey(ctx, givenPasskeyLogin(t, as, authenticator), ClientInfo{}); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("expected ErrInvalidPasskey, got: %v", err)
+	}
+}
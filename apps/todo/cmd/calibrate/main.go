@@ -0,0 +1,58 @@
+// Command calibrate times argon2id on this host and prints the password_hashing settings
+// that keep a password hash within the target latency
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/lib/argon2id"
+)
+
+var (
+	targetFlag        = flag.Duration("target", 250*time.Millisecond, "Longest a password hash should take")
+	minMemoryFlag     = flag.Uint("min-memory", 0, "Least memory per hash in KiB, 0 keeps the argon2id default")
+	maxMemoryFlag     = flag.Uint("max-memory", 0, "Most memory per hash in KiB, 0 means 64 MiB")
+	minIterationsFlag = flag.Uint("min-iterations", 0, "Least iterations per hash, 0 keeps the argon2id default")
+	parallelismFlag   = flag.Uint("parallelism", 1, "Threads used by each hash")
+	samplesFlag       = flag.Int("samples", 3, "Hashes timed per candidate, the median is kept")
+	concurrencyFlag   = flag.Int("concurrency", runtime.GOMAXPROCS(0), "Hashes computed at once by the server")
+)
+
+func main() {
+	flag.Parse()
+
+	if *minMemoryFlag > math.MaxUint32 || *maxMemoryFlag > math.MaxUint32 || *minIterationsFlag > math.MaxUint32 || *parallelismFlag > math.MaxUint8 {
+		fmt.Fprintln(os.Stderr, "error: memory and iterations must fit in 32 bits and parallelism must not exceed 255")
+		os.Exit(2)
+	}
+
+	result, err := argon2id.Calibrate(argon2id.CalibrationOptions{
+		Target:        *targetFlag,
+		MinMemory:     uint32(*minMemoryFlag),
+		MaxMemory:     uint32(*maxMemoryFlag),
+		MinIterations: uint32(*minIterationsFlag),
+		Parallelism:   uint8(*parallelismFlag),
+		Samples:       *samplesFlag,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+	if result.Duration > *targetFlag {
+		fmt.Fprintf(os.Stderr, "warning: the minimum parameters take %s, over the %s target\n", result.Duration, *targetFlag)
+	}
+
+	params := result.Params
+	fmt.Printf("# a hash takes %s on this host, at most %d MiB are used for hashing\n",
+		result.Duration.Round(time.Millisecond), uint64(params.Memory)*uint64(*concurrencyFlag)>>10)
+	fmt.Println("password_hashing:")
+	fmt.Printf("  memory: %d\n", params.Memory)
+	fmt.Printf("  iterations: %d\n", params.Iterations)
+	fmt.Printf("  parallelism: %d\n", params.Parallelism)
+	fmt.Printf("  concurrency: %d\n", *concurrencyFlag)
+}
@@ -1,10 +1,15 @@
 package config
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"math"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -17,8 +22,101 @@ type Config struct {
 	SenderPass  string `yaml:"sender_pass" env:"SENDER_PASS"`
 	Env         string `yaml:"env" env:"ENV"`
 	Port        string `yaml:"port" env:"PORT"`
+	BaseURL     string `yaml:"base_url" env:"BASE_URL"`
+	// TOTPEncryptionKey is the base64 encoded 32 byte key protecting stored TOTP secrets
+	TOTPEncryptionKey string `yaml:"totp_encryption_key" env:"TOTP_ENCRYPTION_KEY"`
+	// PasswordCheck selects the list of breached passwords new passwords are checked against:
+	// "pwned" queries the Pwned Passwords range API, "local" an index built from a downloaded hash list, "none" skips the check
+	PasswordCheck string `yaml:"password_check" env:"PASSWORD_CHECK"`
+	// PasswordIndexPath is the on-disk index used by the local check
+	PasswordIndexPath string `yaml:"password_index_path" env:"PASSWORD_INDEX_PATH"`
+	// PasswordHashListPath is the downloaded SHA-1 hash list the index is built from when it does not exist yet
+	PasswordHashListPath string `yaml:"password_hash_list_path" env:"PASSWORD_HASH_LIST_PATH"`
+	// PasswordCheckFailClosed rejects passwords when the check cannot be performed instead of accepting them
+	PasswordCheckFailClosed bool `yaml:"password_check_fail_closed" env:"PASSWORD_CHECK_FAIL_CLOSED"`
+	// AuthEventRetentionDays is how long authentication events are kept before being pruned
+	AuthEventRetentionDays int `yaml:"auth_event_retention_days" env:"AUTH_EVENT_RETENTION_DAYS"`
+	// AdminEmails are given the admin role once verified, which lets them manage every account
+	AdminEmails []string `yaml:"admin_emails" env:"ADMIN_EMAILS"`
+	// Registration controls who can create an account
+	Registration Registration `yaml:"registration"`
+	// Session bounds how long users stay signed in
+	Session SessionPolicy `yaml:"session"`
+	// PasswordHashing sets the argon2id cost of password hashes and how many are computed at once
+	PasswordHashing PasswordHashing `yaml:"password_hashing"`
+
+	OAuthProviders []OAuthProvider `yaml:"oauth_providers"`
+}
+
+// Registration configures how new accounts are created.
+// Mode is "open" to let anyone register, "invite_only" to require an invitation sent by an existing user,
+// or "closed" to refuse every new account. AllowedDomains, when set, limits new accounts to these email domains in any mode.
+type Registration struct {
+	Mode           string   `yaml:"mode" env:"REGISTRATION_MODE"`
+	AllowedDomains []string `yaml:"allowed_domains" env:"REGISTRATION_ALLOWED_DOMAINS"`
+}
+
+// SessionPolicy configures how long sessions last.
+// A session ends after IdleTimeout without being used or AbsoluteLifetime after sign-in, whichever comes first.
+// Its expiry is pushed back at most once per RenewalWindow, so a session left alone may end up to RenewalWindow before IdleTimeout.
+// Users who tick "remember me" when signing in get a session lasting RememberMeDuration instead, whether it is used or not.
+type SessionPolicy struct {
+	IdleTimeout        time.Duration `yaml:"idle_timeout" env:"SESSION_IDLE_TIMEOUT"`
+	AbsoluteLifetime   time.Duration `yaml:"absolute_lifetime" env:"SESSION_ABSOLUTE_LIFETIME"`
+	RenewalWindow      time.Duration `yaml:"renewal_window" env:"SESSION_RENEWAL_WINDOW"`
+	RememberMeDuration time.Duration `yaml:"remember_me_duration" env:"SESSION_REMEMBER_ME_DURATION"`
+}
+
+// PasswordHashing configures argon2id, the calibrate command picks Memory and Iterations for this host.
+// Each hash holds Memory KiB until it is done, so at most Concurrency × Memory KiB are used for hashing,
+// older hashes with a larger memory cost count for several and one larger than that budget runs alone.
+// Up to Queue more requests wait for a hash to finish, the ones after them are refused until the load drops.
+// Zero values keep the defaults of the argon2id package, GOMAXPROCS hashes at once and a queue of 64.
+type PasswordHashing struct {
+	Memory      int `yaml:"memory" env:"PASSWORD_HASH_MEMORY"`
+	Iterations  int `yaml:"iterations" env:"PASSWORD_HASH_ITERATIONS"`
+	Parallelism int `yaml:"parallelism" env:"PASSWORD_HASH_PARALLELISM"`
+	Concurrency int `yaml:"concurrency" env:"PASSWORD_HASH_CONCURRENCY"`
+	Queue       int `yaml:"queue" env:"PASSWORD_HASH_QUEUE"`
+}
+
+// OAuthProvider configures an OpenID Connect provider users can sign in with.
+// The client secret can be overridden with the OAUTH_<NAME>_CLIENT_SECRET variable.
+type OAuthProvider struct {
+	Name         string   `yaml:"name"`
+	Issuer       string   `yaml:"issuer"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
 }
 
+// Password checks selectable with Config.PasswordCheck
+const (
+	PasswordCheckPwned = "pwned"
+	PasswordCheckLocal = "local"
+	PasswordCheckNone  = "none"
+)
+
+// Registration modes selectable with Registration.Mode
+const (
+	RegistrationOpen       = "open"
+	RegistrationInviteOnly = "invite_only"
+	RegistrationClosed     = "closed"
+)
+
+// DefaultAuthEventRetentionDays is used when Config.AuthEventRetentionDays is not set
+const DefaultAuthEventRetentionDays = 90
+
+// Defaults of the SessionPolicy settings that are not configured
+const (
+	DefaultSessionIdleTimeout        = 24 * time.Hour
+	DefaultSessionAbsoluteLifetime   = 7 * 24 * time.Hour
+	DefaultSessionRenewalWindow      = time.Hour
+	DefaultSessionRememberMeDuration = 30 * 24 * time.Hour
+)
+
+var providerNameRegex = regexp.MustCompile(`^[a-z0-9-]+$`)
+
 func Init(filepath string) (*Config, error) {
 	config := &Config{}
 
@@ -41,7 +139,10 @@ func Init(filepath string) (*Config, error) {
 		return nil, fmt.Errorf("error applying env overrides: %w", err)
 	}
 
-	// Step 3: Validate final configuration
+	// Step 3: Fill in defaults derived from other settings
+	applyDefaults(config)
+
+	// Step 4: Validate final configuration
 	if err := validateConfig(config); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
@@ -88,6 +189,98 @@ func applyEnvOverrides(cfg *Config) error {
 		cfg.SenderPass = pass
 	}
 
+	if baseURL := os.Getenv("BASE_URL"); baseURL != "" {
+		cfg.BaseURL = baseURL
+	}
+
+	if key := os.Getenv("TOTP_ENCRYPTION_KEY"); key != "" {
+		cfg.TOTPEncryptionKey = key
+	}
+
+	if check := os.Getenv("PASSWORD_CHECK"); check != "" {
+		cfg.PasswordCheck = check
+	}
+
+	if path := os.Getenv("PASSWORD_INDEX_PATH"); path != "" {
+		cfg.PasswordIndexPath = path
+	}
+
+	if path := os.Getenv("PASSWORD_HASH_LIST_PATH"); path != "" {
+		cfg.PasswordHashListPath = path
+	}
+
+	if failClosed := os.Getenv("PASSWORD_CHECK_FAIL_CLOSED"); failClosed != "" {
+		b, err := strconv.ParseBool(failClosed)
+		if err != nil {
+			return errors.New("invalid PASSWORD_CHECK_FAIL_CLOSED value")
+		}
+		cfg.PasswordCheckFailClosed = b
+	}
+
+	if days := os.Getenv("AUTH_EVENT_RETENTION_DAYS"); days != "" {
+		d, err := strconv.Atoi(days)
+		if err != nil {
+			return errors.New("invalid AUTH_EVENT_RETENTION_DAYS value")
+		}
+		cfg.AuthEventRetentionDays = d
+	}
+
+	if emails := os.Getenv("ADMIN_EMAILS"); emails != "" {
+		cfg.AdminEmails = strings.Split(emails, ",")
+	}
+
+	if mode := os.Getenv("REGISTRATION_MODE"); mode != "" {
+		cfg.Registration.Mode = mode
+	}
+
+	if domains := os.Getenv("REGISTRATION_ALLOWED_DOMAINS"); domains != "" {
+		cfg.Registration.AllowedDomains = strings.Split(domains, ",")
+	}
+
+	for _, setting := range []struct {
+		key   string
+		value *time.Duration
+	}{
+		{"SESSION_IDLE_TIMEOUT", &cfg.Session.IdleTimeout},
+		{"SESSION_ABSOLUTE_LIFETIME", &cfg.Session.AbsoluteLifetime},
+		{"SESSION_RENEWAL_WINDOW", &cfg.Session.RenewalWindow},
+		{"SESSION_REMEMBER_ME_DURATION", &cfg.Session.RememberMeDuration},
+	} {
+		if value := os.Getenv(setting.key); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s value", setting.key)
+			}
+			*setting.value = d
+		}
+	}
+
+	for _, setting := range []struct {
+		key   string
+		value *int
+	}{
+		{"PASSWORD_HASH_MEMORY", &cfg.PasswordHashing.Memory},
+		{"PASSWORD_HASH_ITERATIONS", &cfg.PasswordHashing.Iterations},
+		{"PASSWORD_HASH_PARALLELISM", &cfg.PasswordHashing.Parallelism},
+		{"PASSWORD_HASH_CONCURRENCY", &cfg.PasswordHashing.Concurrency},
+		{"PASSWORD_HASH_QUEUE", &cfg.PasswordHashing.Queue},
+	} {
+		if value := os.Getenv(setting.key); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s value", setting.key)
+			}
+			*setting.value = n
+		}
+	}
+
+	for i, provider := range cfg.OAuthProviders {
+		key := "OAUTH_" + strings.ToUpper(strings.ReplaceAll(provider.Name, "-", "_")) + "_CLIENT_SECRET"
+		if secret := os.Getenv(key); secret != "" {
+			cfg.OAuthProviders[i].ClientSecret = secret
+		}
+	}
+
 	if env := os.Getenv("ENV"); env != "" {
 		cfg.Env = env
 	} else {
@@ -96,6 +289,45 @@ func applyEnvOverrides(cfg *Config) error {
 	return nil
 }
 
+// applyDefaults fills in optional settings that were not configured
+func applyDefaults(cfg *Config) {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "http://localhost:" + cfg.Port
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	if cfg.PasswordCheck == "" {
+		cfg.PasswordCheck = PasswordCheckPwned
+	}
+	if cfg.AuthEventRetentionDays == 0 {
+		cfg.AuthEventRetentionDays = DefaultAuthEventRetentionDays
+	}
+	for i, email := range cfg.AdminEmails {
+		cfg.AdminEmails[i] = strings.ToLower(strings.TrimSpace(email))
+	}
+	if cfg.Registration.Mode == "" {
+		cfg.Registration.Mode = RegistrationOpen
+	}
+	for i, domain := range cfg.Registration.AllowedDomains {
+		cfg.Registration.AllowedDomains[i] = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "@")
+	}
+	if cfg.Session.IdleTimeout == 0 {
+		cfg.Session.IdleTimeout = DefaultSessionIdleTimeout
+		// A short absolute lifetime on its own also shortens the idle timeout
+		if cfg.Session.AbsoluteLifetime > 0 {
+			cfg.Session.IdleTimeout = min(DefaultSessionIdleTimeout, cfg.Session.AbsoluteLifetime)
+		}
+	}
+	if cfg.Session.AbsoluteLifetime == 0 {
+		cfg.Session.AbsoluteLifetime = max(DefaultSessionAbsoluteLifetime, cfg.Session.IdleTimeout)
+	}
+	if cfg.Session.RenewalWindow == 0 {
+		cfg.Session.RenewalWindow = min(DefaultSessionRenewalWindow, cfg.Session.IdleTimeout/2)
+	}
+	if cfg.Session.RememberMeDuration == 0 {
+		cfg.Session.RememberMeDuration = max(DefaultSessionRememberMeDuration, cfg.Session.AbsoluteLifetime)
+	}
+}
+
 func validateConfig(cfg *Config) error {
 	if cfg.Port == "" {
 		return errors.New("Port is required")
@@ -112,5 +344,93 @@ func validateConfig(cfg *Config) error {
 	if cfg.SenderPass == "" {
 		return errors.New("SenderPass is required")
 	}
+	if cfg.TOTPEncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.TOTPEncryptionKey)
+		if err != nil || len(key) != 32 {
+			return errors.New("TOTPEncryptionKey must be 32 bytes encoded in base64")
+		}
+	} else if cfg.Env == "prod" {
+		return errors.New("TOTPEncryptionKey is required in prod")
+	}
+	switch cfg.PasswordCheck {
+	case "", PasswordCheckPwned, PasswordCheckNone:
+	case PasswordCheckLocal:
+		if cfg.PasswordIndexPath == "" {
+			return errors.New("PasswordIndexPath is required by the local password check")
+		}
+	default:
+		return fmt.Errorf("unknown password check %q", cfg.PasswordCheck)
+	}
+	if cfg.AuthEventRetentionDays < 0 {
+		return errors.New("AuthEventRetentionDays must be a positive number of days")
+	}
+	switch cfg.Registration.Mode {
+	case "", RegistrationOpen, RegistrationInviteOnly, RegistrationClosed:
+	default:
+		return fmt.Errorf("unknown registration mode %q", cfg.Registration.Mode)
+	}
+	for _, domain := range cfg.Registration.AllowedDomains {
+		if domain == "" || strings.Contains(domain, "@") {
+			return fmt.Errorf("invalid allowed registration domain %q", domain)
+		}
+	}
+	if err := validateSessionPolicy(cfg.Session); err != nil {
+		return err
+	}
+	if err := validatePasswordHashing(cfg.PasswordHashing); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, provider := range cfg.OAuthProviders {
+		if !providerNameRegex.MatchString(provider.Name) {
+			return fmt.Errorf("invalid oauth provider name %q", provider.Name)
+		}
+		if seen[provider.Name] {
+			return fmt.Errorf("duplicate oauth provider %q", provider.Name)
+		}
+		seen[provider.Name] = true
+		if provider.Issuer == "" {
+			return fmt.Errorf("oauth provider %q: issuer is required", provider.Name)
+		}
+		if provider.ClientID == "" {
+			return fmt.Errorf("oauth provider %q: client_id is required", provider.Name)
+		}
+	}
+	return nil
+}
+
+// validateSessionPolicy checks the durations against each other, applyDefaults already filled in the ones left out
+func validateSessionPolicy(policy SessionPolicy) error {
+	if policy.IdleTimeout <= 0 || policy.AbsoluteLifetime <= 0 || policy.RenewalWindow <= 0 || policy.RememberMeDuration <= 0 {
+		return errors.New("session durations must be positive")
+	}
+	if policy.IdleTimeout > policy.AbsoluteLifetime {
+		return errors.New("session idle timeout must not exceed the absolute lifetime")
+	}
+	if policy.RenewalWindow >= policy.IdleTimeout {
+		return errors.New("session renewal window must be shorter than the idle timeout")
+	}
+	if policy.RememberMeDuration < policy.IdleTimeout {
+		return errors.New("session remember me duration must not be shorter than the idle timeout")
+	}
+	return nil
+}
+
+// validatePasswordHashing checks the settings fit argon2id. applyDefaults leaves zero values alone,
+// they stand for the defaults of the argon2id package and of the hasher.
+func validatePasswordHashing(hashing PasswordHashing) error {
+	if hashing.Memory < 0 || hashing.Iterations < 0 || hashing.Parallelism < 0 || hashing.Concurrency < 0 || hashing.Queue < 0 {
+		return errors.New("password hashing settings must not be negative")
+	}
+	if hashing.Memory > math.MaxUint32 || hashing.Iterations > math.MaxUint32 {
+		return errors.New("password hashing memory and iterations must fit in 32 bits")
+	}
+	if hashing.Parallelism > math.MaxUint8 {
+		return errors.New("password hashing parallelism must not exceed 255")
+	}
+	// The argon2id default parallelism is one lane, and its default memory fits the 255 lanes allowed
+	if hashing.Memory > 0 && hashing.Memory < 8*max(hashing.Parallelism, 1) {
+		return errors.New("password hashing memory must be at least 8 KiB per lane of parallelism")
+	}
 	return nil
 }
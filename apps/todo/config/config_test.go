@@ -2,9 +2,18 @@ package config
 
 import (
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
+var defaultSessionPolicy = SessionPolicy{
+	IdleTimeout:        DefaultSessionIdleTimeout,
+	AbsoluteLifetime:   DefaultSessionAbsoluteLifetime,
+	RenewalWindow:      DefaultSessionRenewalWindow,
+	RememberMeDuration: DefaultSessionRememberMeDuration,
+}
+
 func TestInit(t *testing.T) {
 	f := func(yamlContent string, envVars map[string]string, wantConfig *Config, wantErr bool) {
 		t.Helper()
@@ -57,6 +66,46 @@ func TestInit(t *testing.T) {
 		if got.Port != wantConfig.Port {
 			t.Errorf("Port = %v, want %v", got.Port, wantConfig.Port)
 		}
+		if got.BaseURL != wantConfig.BaseURL {
+			t.Errorf("BaseURL = %v, want %v", got.BaseURL, wantConfig.BaseURL)
+		}
+		if got.PasswordCheck != wantConfig.PasswordCheck {
+			t.Errorf("PasswordCheck = %v, want %v", got.PasswordCheck, wantConfig.PasswordCheck)
+		}
+		if got.PasswordIndexPath != wantConfig.PasswordIndexPath {
+			t.Errorf("PasswordIndexPath = %v, want %v", got.PasswordIndexPath, wantConfig.PasswordIndexPath)
+		}
+		if got.PasswordCheckFailClosed != wantConfig.PasswordCheckFailClosed {
+			t.Errorf("PasswordCheckFailClosed = %v, want %v", got.PasswordCheckFailClosed, wantConfig.PasswordCheckFailClosed)
+		}
+		if got.AuthEventRetentionDays != wantConfig.AuthEventRetentionDays {
+			t.Errorf("AuthEventRetentionDays = %v, want %v", got.AuthEventRetentionDays, wantConfig.AuthEventRetentionDays)
+		}
+		if strings.Join(got.AdminEmails, ",") != strings.Join(wantConfig.AdminEmails, ",") {
+			t.Errorf("AdminEmails = %v, want %v", got.AdminEmails, wantConfig.AdminEmails)
+		}
+		if got.Registration.Mode != wantConfig.Registration.Mode {
+			t.Errorf("Registration.Mode = %v, want %v", got.Registration.Mode, wantConfig.Registration.Mode)
+		}
+		if strings.Join(got.Registration.AllowedDomains, ",") != strings.Join(wantConfig.Registration.AllowedDomains, ",") {
+			t.Errorf("Registration.AllowedDomains = %v, want %v", got.Registration.AllowedDomains, wantConfig.Registration.AllowedDomains)
+		}
+		if got.Session != wantConfig.Session {
+			t.Errorf("Session = %+v, want %+v", got.Session, wantConfig.Session)
+		}
+		if got.PasswordHashing != wantConfig.PasswordHashing {
+			t.Errorf("PasswordHashing = %+v, want %+v", got.PasswordHashing, wantConfig.PasswordHashing)
+		}
+		if len(got.OAuthProviders) != len(wantConfig.OAuthProviders) {
+			t.Fatalf("OAuthProviders = %v, want %v", got.OAuthProviders, wantConfig.OAuthProviders)
+		}
+		for i, provider := range got.OAuthProviders {
+			want := wantConfig.OAuthProviders[i]
+			if provider.Name != want.Name || provider.Issuer != want.Issuer ||
+				provider.ClientID != want.ClientID || provider.ClientSecret != want.ClientSecret {
+				t.Errorf("OAuthProviders[%d] = %+v, want %+v", i, provider, want)
+			}
+		}
 	}
 
 	tests := []struct {
@@ -77,11 +126,16 @@ sender_pass: password123
 `,
 			envVars: nil,
 			wantConfig: &Config{
-				Port:        "8080",
-				SMTPHost:    "smtp.example.com",
-				SMTPPort:    587,
-				SenderEmail: "test@example.com",
-				SenderPass:  "password123",
+				Port:                   "8080",
+				SMTPHost:               "smtp.example.com",
+				SMTPPort:               587,
+				SenderEmail:            "test@example.com",
+				SenderPass:             "password123",
+				BaseURL:                "http://localhost:8080",
+				PasswordCheck:          PasswordCheckPwned,
+				AuthEventRetentionDays: DefaultAuthEventRetentionDays,
+				Registration:           Registration{Mode: RegistrationOpen},
+				Session:                defaultSessionPolicy,
 			},
 			wantErr: false,
 		},
@@ -100,16 +154,271 @@ sender_pass: password123
 				"SMTP_PORT":    "465",
 				"SENDER_EMAIL": "override@example.com",
 				"SENDER_PASS":  "newpassword",
+				"BASE_URL":     "https://todo.example.com/",
+			},
+			wantConfig: &Config{
+				Port:                   "9090",
+				SMTPHost:               "smtp.override.com",
+				SMTPPort:               465,
+				SenderEmail:            "override@example.com",
+				SenderPass:             "newpassword",
+				BaseURL:                "https://todo.example.com",
+				PasswordCheck:          PasswordCheckPwned,
+				AuthEventRetentionDays: DefaultAuthEventRetentionDays,
+				Registration:           Registration{Mode: RegistrationOpen},
+				Session:                defaultSessionPolicy,
+			},
+			wantErr: false,
+		},
+		{
+			name: "OAuth providers with secret from env",
+			yamlContent: `
+port: 8080
+smtp_host: smtp.example.com
+smtp_port: 587
+sender_email: test@example.com
+sender_pass: password123
+oauth_providers:
+  - name: company-idp
+    issuer: https://idp.example.com
+    client_id: todo
+    client_secret: from-yaml
+`,
+			envVars: map[string]string{
+				"OAUTH_COMPANY_IDP_CLIENT_SECRET": "from-env",
+			},
+			wantConfig: &Config{
+				Port:                   "8080",
+				SMTPHost:               "smtp.example.com",
+				SMTPPort:               587,
+				SenderEmail:            "test@example.com",
+				SenderPass:             "password123",
+				BaseURL:                "http://localhost:8080",
+				PasswordCheck:          PasswordCheckPwned,
+				AuthEventRetentionDays: DefaultAuthEventRetentionDays,
+				Registration:           Registration{Mode: RegistrationOpen},
+				Session:                defaultSessionPolicy,
+				OAuthProviders: []OAuthProvider{
+					{Name: "company-idp", Issuer: "https://idp.example.com", ClientID: "todo", ClientSecret: "from-env"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Local password check from env",
+			yamlContent: `
+port: 8080
+smtp_host: smtp.example.com
+smtp_port: 587
+sender_email: test@example.com
+sender_pass: password123
+password_check: pwned
+`,
+			envVars: map[string]string{
+				"PASSWORD_CHECK":             "local",
+				"PASSWORD_INDEX_PATH":        "/var/lib/todo/pwned.idx",
+				"PASSWORD_CHECK_FAIL_CLOSED": "true",
+			},
+			wantConfig: &Config{
+				Port:                    "8080",
+				SMTPHost:                "smtp.example.com",
+				SMTPPort:                587,
+				SenderEmail:             "test@example.com",
+				SenderPass:              "password123",
+				BaseURL:                 "http://localhost:8080",
+				PasswordCheck:           PasswordCheckLocal,
+				PasswordIndexPath:       "/var/lib/todo/pwned.idx",
+				PasswordCheckFailClosed: true,
+				AuthEventRetentionDays:  DefaultAuthEventRetentionDays,
+				Registration:            Registration{Mode: RegistrationOpen},
+				Session:                 defaultSessionPolicy,
+			},
+			wantErr: false,
+		},
+		{
+			name: "Audit settings from env",
+			yamlContent: `
+port: 8080
+smtp_host: smtp.example.com
+smtp_port: 587
+sender_email: test@example.com
+sender_pass: password123
+auth_event_retention_days: 30
+`,
+			envVars: map[string]string{
+				"ADMIN_EMAILS": "Admin@Example.com, ops@example.com",
 			},
 			wantConfig: &Config{
-				Port:        "9090",
-				SMTPHost:    "smtp.override.com",
-				SMTPPort:    465,
-				SenderEmail: "override@example.com",
-				SenderPass:  "newpassword",
+				Port:                   "8080",
+				SMTPHost:               "smtp.example.com",
+				SMTPPort:               587,
+				SenderEmail:            "test@example.com",
+				SenderPass:             "password123",
+				BaseURL:                "http://localhost:8080",
+				PasswordCheck:          PasswordCheckPwned,
+				AuthEventRetentionDays: 30,
+				Registration:           Registration{Mode: RegistrationOpen},
+				Session:                defaultSessionPolicy,
+				AdminEmails:            []string{"admin@example.com", "ops@example.com"},
 			},
 			wantErr: false,
 		},
+		{
+			name: "Registration settings from YAML and env",
+			yamlContent: `
+port: 8080
+smtp_host: smtp.example.com
+smtp_port: 587
+sender_email: test@example.com
+sender_pass: password123
+registration:
+  mode: closed
+  allowed_domains:
+    - "@Example.com"
+`,
+			envVars: map[string]string{
+				"REGISTRATION_MODE": "invite_only",
+			},
+			wantConfig: &Config{
+				Port:                   "8080",
+				SMTPHost:               "smtp.example.com",
+				SMTPPort:               587,
+				SenderEmail:            "test@example.com",
+				SenderPass:             "password123",
+				BaseURL:                "http://localhost:8080",
+				PasswordCheck:          PasswordCheckPwned,
+				AuthEventRetentionDays: DefaultAuthEventRetentionDays,
+				Registration:           Registration{Mode: RegistrationInviteOnly, AllowedDomains: []string{"example.com"}},
+				Session:                defaultSessionPolicy,
+			},
+			wantErr: false,
+		},
+		{
+			name: "Session policy from YAML and env",
+			yamlContent: `
+port: 8080
+smtp_host: smtp.example.com
+smtp_port: 587
+sender_email: test@example.com
+sender_pass: password123
+session:
+  idle_timeout: 2h
+  renewal_window: 30m
+`,
+			envVars: map[string]string{
+				"SESSION_ABSOLUTE_LIFETIME":    "72h",
+				"SESSION_REMEMBER_ME_DURATION": "336h",
+			},
+			wantConfig: &Config{
+				Port:                   "8080",
+				SMTPHost:               "smtp.example.com",
+				SMTPPort:               587,
+				SenderEmail:            "test@example.com",
+				SenderPass:             "password123",
+				BaseURL:                "http://localhost:8080",
+				PasswordCheck:          PasswordCheckPwned,
+				AuthEventRetentionDays: DefaultAuthEventRetentionDays,
+				Registration:           Registration{Mode: RegistrationOpen},
+				Session: SessionPolicy{
+					IdleTimeout:        2 * time.Hour,
+					AbsoluteLifetime:   72 * time.Hour,
+					RenewalWindow:      30 * time.Minute,
+					RememberMeDuration: 14 * 24 * time.Hour,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Session absolute lifetime shorter than the default idle timeout",
+			yamlContent: `
+port: 8080
+smtp_host: smtp.example.com
+smtp_port: 587
+sender_email: test@example.com
+sender_pass: password123
+session:
+  absolute_lifetime: 1h
+`,
+			wantConfig: &Config{
+				Port:                   "8080",
+				SMTPHost:               "smtp.example.com",
+				SMTPPort:               587,
+				SenderEmail:            "test@example.com",
+				SenderPass:             "password123",
+				BaseURL:                "http://localhost:8080",
+				PasswordCheck:          PasswordCheckPwned,
+				AuthEventRetentionDays: DefaultAuthEventRetentionDays,
+				Registration:           Registration{Mode: RegistrationOpen},
+				Session: SessionPolicy{
+					IdleTimeout:        time.Hour,
+					AbsoluteLifetime:   time.Hour,
+					RenewalWindow:      30 * time.Minute,
+					RememberMeDuration: DefaultSessionRememberMeDuration,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Password hashing from YAML and env",
+			yamlContent: `
+port: 8080
+smtp_host: smtp.example.com
+smtp_port: 587
+sender_email: test@example.com
+sender_pass: password123
+password_hashing:
+  memory: 65536
+  iterations: 3
+`,
+			envVars: map[string]string{
+				"PASSWORD_HASH_CONCURRENCY": "4",
+				"PASSWORD_HASH_QUEUE":       "16",
+			},
+			wantConfig: &Config{
+				Port:                   "8080",
+				SMTPHost:               "smtp.example.com",
+				SMTPPort:               587,
+				SenderEmail:            "test@example.com",
+				SenderPass:             "password123",
+				BaseURL:                "http://localhost:8080",
+				PasswordCheck:          PasswordCheckPwned,
+				AuthEventRetentionDays: DefaultAuthEventRetentionDays,
+				Registration:           Registration{Mode: RegistrationOpen},
+				Session:                defaultSessionPolicy,
+				PasswordHashing:        PasswordHashing{Memory: 65536, Iterations: 3, Concurrency: 4, Queue: 16},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid password hashing setting in env vars",
+			yamlContent: `
+port: 8080
+smtp_host: smtp.example.com
+smtp_port: 587
+sender_email: test@example.com
+sender_pass: password123
+`,
+			envVars: map[string]string{
+				"PASSWORD_HASH_QUEUE": "many",
+			},
+			wantConfig: nil,
+			wantErr:    true,
+		},
+		{
+			name: "Invalid session duration in env vars",
+			yamlContent: `
+port: 8080
+smtp_host: smtp.example.com
+smtp_port: 587
+sender_email: test@example.com
+sender_pass: password123
+`,
+			envVars: map[string]string{
+				"SESSION_IDLE_TIMEOUT": "one day",
+			},
+			wantConfig: nil,
+			wantErr:    true,
+		},
 		{
 			name: "Invalid YAML",
 			yamlContent: `
@@ -156,6 +465,8 @@ func TestValidateConfig(t *testing.T) {
 	f := func(config Config, wantErr bool) {
 		t.Helper()
 
+		// Like Load, validate once the settings left out were filled in
+		applyDefaults(&config)
 		err := validateConfig(&config)
 		if (err != nil) != wantErr {
 			t.Errorf("validateConfig() error = %v, wantErr %v", err, wantErr)
@@ -206,6 +517,202 @@ func TestValidateConfig(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "OAuth provider without issuer",
+			config: Config{
+				Port:           "8080",
+				SMTPHost:       "smtp.example.com",
+				SMTPPort:       587,
+				SenderEmail:    "test@example.com",
+				SenderPass:     "password123",
+				OAuthProviders: []OAuthProvider{{Name: "idp", ClientID: "todo"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "OAuth provider with invalid name",
+			config: Config{
+				Port:           "8080",
+				SMTPHost:       "smtp.example.com",
+				SMTPPort:       587,
+				SenderEmail:    "test@example.com",
+				SenderPass:     "password123",
+				OAuthProviders: []OAuthProvider{{Name: "My IdP", Issuer: "https://idp.example.com", ClientID: "todo"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "TOTP encryption key with wrong length",
+			config: Config{
+				Port:              "8080",
+				SMTPHost:          "smtp.example.com",
+				SMTPPort:          587,
+				SenderEmail:       "test@example.com",
+				SenderPass:        "password123",
+				TOTPEncryptionKey: "c2hvcnQ=",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Unknown registration mode",
+			config: Config{
+				Port:         "8080",
+				SMTPHost:     "smtp.example.com",
+				SMTPPort:     587,
+				SenderEmail:  "test@example.com",
+				SenderPass:   "password123",
+				Registration: Registration{Mode: "anyone"},
+				Session:      defaultSessionPolicy,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Allowed registration domain with a local part",
+			config: Config{
+				Port:         "8080",
+				SMTPHost:     "smtp.example.com",
+				SMTPPort:     587,
+				SenderEmail:  "test@example.com",
+				SenderPass:   "password123",
+				Registration: Registration{AllowedDomains: []string{"user@example.com"}},
+				Session:      defaultSessionPolicy,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Missing TOTP encryption key in prod",
+			config: Config{
+				Port:        "8080",
+				SMTPHost:    "smtp.example.com",
+				SMTPPort:    587,
+				SenderEmail: "test@example.com",
+				SenderPass:  "password123",
+				Env:         "prod",
+			},
+			wantErr: true,
+		},
+		{
+			name: "TOTP encryption key in prod",
+			config: Config{
+				Port:              "8080",
+				SMTPHost:          "smtp.example.com",
+				SMTPPort:          587,
+				SenderEmail:       "test@example.com",
+				SenderPass:        "password123",
+				Env:               "prod",
+				TOTPEncryptionKey: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Local password check without index",
+			config: Config{
+				Port:          "8080",
+				SMTPHost:      "smtp.example.com",
+				SMTPPort:      587,
+				SenderEmail:   "test@example.com",
+				SenderPass:    "password123",
+				PasswordCheck: PasswordCheckLocal,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Unknown password check",
+			config: Config{
+				Port:          "8080",
+				SMTPHost:      "smtp.example.com",
+				SMTPPort:      587,
+				SenderEmail:   "test@example.com",
+				SenderPass:    "password123",
+				PasswordCheck: "hibp",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Negative auth event retention",
+			config: Config{
+				Port:                   "8080",
+				SMTPHost:               "smtp.example.com",
+				SMTPPort:               587,
+				SenderEmail:            "test@example.com",
+				SenderPass:             "password123",
+				AuthEventRetentionDays: -1,
+				Registration:           Registration{Mode: RegistrationOpen},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Session renewal window longer than the idle timeout",
+			config: Config{
+				Port:        "8080",
+				SMTPHost:    "smtp.example.com",
+				SMTPPort:    587,
+				SenderEmail: "test@example.com",
+				SenderPass:  "password123",
+				Session:     SessionPolicy{IdleTimeout: time.Hour, RenewalWindow: 2 * time.Hour},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Session idle timeout longer than the absolute lifetime",
+			config: Config{
+				Port:        "8080",
+				SMTPHost:    "smtp.example.com",
+				SMTPPort:    587,
+				SenderEmail: "test@example.com",
+				SenderPass:  "password123",
+				Session:     SessionPolicy{IdleTimeout: 48 * time.Hour, AbsoluteLifetime: 24 * time.Hour},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Negative session duration",
+			config: Config{
+				Port:        "8080",
+				SMTPHost:    "smtp.example.com",
+				SMTPPort:    587,
+				SenderEmail: "test@example.com",
+				SenderPass:  "password123",
+				Session:     SessionPolicy{RememberMeDuration: -time.Hour},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Negative password hashing queue",
+			config: Config{
+				Port:            "8080",
+				SMTPHost:        "smtp.example.com",
+				SMTPPort:        587,
+				SenderEmail:     "test@example.com",
+				SenderPass:      "password123",
+				PasswordHashing: PasswordHashing{Queue: -1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Password hashing parallelism over 255",
+			config: Config{
+				Port:            "8080",
+				SMTPHost:        "smtp.example.com",
+				SMTPPort:        587,
+				SenderEmail:     "test@example.com",
+				SenderPass:      "password123",
+				PasswordHashing: PasswordHashing{Parallelism: 256},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Password hashing memory below one lane",
+			config: Config{
+				Port:            "8080",
+				SMTPHost:        "smtp.example.com",
+				SMTPPort:        587,
+				SenderEmail:     "test@example.com",
+				SenderPass:      "password123",
+				PasswordHashing: PasswordHashing{Memory: 4},
+			},
+			wantErr: true,
+		},
 		{
 			name: "Missing sender password",
 			config: Config{
@@ -8,15 +8,101 @@ import (
 	"database/sql"
 )
 
-type EmailVerificationRequest struct {
+type AccessToken struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	TokenHash  string
+	Scopes     string
+	CreatedAt  int64
+	ExpiresAt  int64
+	LastUsedAt sql.NullInt64
+}
+
+type AuthEvent struct {
+	ID        int64
+	EventType string
+	UserID    sql.NullInt64
+	Email     string
+	Outcome   string
+	Reason    string
+	IpAddress string
+	UserAgent string
+	CreatedAt int64
+}
+
+type EmailChangeRequest struct {
+	ID              int64
+	UserID          int64
+	NewEmail        string
+	CodeHash        string
+	CancelTokenHash string
+	CreatedAt       int64
+	ExpiresAt       int64
+	Attempts        int64
+}
+
+type EmailChangeRevert struct {
+	ID        int64
 	UserID    int64
+	OldEmail  string
+	TokenHash string
+	ExpiresAt int64
+}
+
+type EmailVerificationRequest struct {
+	UserID              int64
+	CreatedAt           int64
+	ExpiresAt           int64
+	Code                string
+	Attempts            int64
+	SendCount           int64
+	SendWindowStartedAt int64
+}
+
+type Invitation struct {
+	ID        int64
+	Email     string
+	TokenHash string
+	InviterID int64
 	CreatedAt int64
 	ExpiresAt int64
-	Code      string
+	UsedAt    sql.NullInt64
+}
+
+type KnownDevice struct {
+	ID              int64
+	UserID          int64
+	TokenHash       string
+	Fingerprint     string
+	UserAgent       string
+	IpAddress       string
+	CreatedAt       int64
+	LastSeenAt      int64
+	ReportTokenHash sql.NullString
+	ReportExpiresAt int64
+}
+
+type LoginAttempt struct {
+	Email           string
+	FailedCount     int64
+	LastFailedAt    int64
+	LockedUntil     int64
+	UnlockTokenHash sql.NullString
+}
+
+type MagicLinkRequest struct {
+	ID          int64
+	UserID      int64
+	TokenHash   string
+	BrowserHash string
+	CreatedAt   int64
+	ExpiresAt   int64
 }
 
 type OauthAccount struct {
-	UserID         string
+	ID             int64
+	UserID         int64
 	Provider       string
 	ProviderUserID string
 	CreatedAt      sql.NullString
@@ -24,17 +110,34 @@ type OauthAccount struct {
 
 type PasswordResetRequest struct {
 	ID        int64
-	UserID    string
-	CreatedAt sql.NullString
+	UserID    int64
+	CreatedAt int64
 	ExpiresAt int64
 	CodeHash  string
 }
 
-type Session struct {
-	ID        string
+type RecoveryCode struct {
+	ID        int64
 	UserID    int64
-	ExpiresAt int64
-	CreatedAt sql.NullString
+	CodeHash  string
+	CreatedAt int64
+	UsedAt    sql.NullInt64
+}
+
+type Session struct {
+	ID                string
+	UserID            int64
+	ExpiresAt         int64
+	CreatedAt         sql.NullString
+	TwoFactorPending  int64
+	IpAddress         string
+	UserAgent         string
+	LastSeenAt        int64
+	ImpersonatorID    sql.NullInt64
+	RememberMe        int64
+	AbsoluteExpiresAt int64
+	DeviceID          sql.NullInt64
+	TwoFactorAttempts int64
 }
 
 type Todo struct {
@@ -45,11 +148,40 @@ type Todo struct {
 	IsComplete  int64
 }
 
+type TotpCredential struct {
+	UserID          int64
+	EncryptedSecret []byte
+	Enabled         int64
+	CreatedAt       int64
+	NextStep        int64
+}
+
 type User struct {
-	ID            int64
-	Email         string
-	PasswordHash  string
-	EmailVerified int64
-	CreatedAt     sql.NullString
-	UpdatedAt     sql.NullString
+	ID                    int64
+	Email                 string
+	PasswordHash          string
+	EmailVerified         int64
+	CreatedAt             sql.NullString
+	UpdatedAt             sql.NullString
+	Role                  string
+	Disabled              int64
+	PasswordResetRequired int64
+}
+
+type WebauthnChallenge struct {
+	ChallengeHash string
+	Ceremony      string
+	UserID        sql.NullInt64
+	ExpiresAt     int64
+}
+
+type WebauthnCredential struct {
+	ID           int64
+	UserID       int64
+	CredentialID string
+	PublicKey    []byte
+	SignCount    int64
+	Name         string
+	CreatedAt    int64
+	LastUsedAt   sql.NullInt64
 }
@@ -6,24 +6,109 @@ package db
 
 import (
 	"context"
+	"database/sql"
 )
 
 type Querier interface {
+	AdvanceTOTPCredentialStep(ctx context.Context, arg AdvanceTOTPCredentialStepParams) (int64, error)
+	CompleteSessionTwoFactor(ctx context.Context, arg CompleteSessionTwoFactorParams) (Session, error)
+	ConsumeWebAuthnChallenge(ctx context.Context, challengeHash string) (WebauthnChallenge, error)
+	CountUnusedRecoveryCodes(ctx context.Context, userID int64) (int64, error)
+	CountUserKnownDevices(ctx context.Context, userID int64) (int64, error)
+	CountUserTodos(ctx context.Context, userID int64) (int64, error)
+	CreateAccessToken(ctx context.Context, arg CreateAccessTokenParams) (AccessToken, error)
+	CreateAuthEvent(ctx context.Context, arg CreateAuthEventParams) error
+	CreateInvitation(ctx context.Context, arg CreateInvitationParams) (Invitation, error)
+	CreateKnownDevice(ctx context.Context, arg CreateKnownDeviceParams) (KnownDevice, error)
+	CreateOAuthAccount(ctx context.Context, arg CreateOAuthAccountParams) (OauthAccount, error)
+	CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) error
 	CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error)
 	CreateTodo(ctx context.Context, arg CreateTodoParams) (Todo, error)
 	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
+	CreateWebAuthnChallenge(ctx context.Context, arg CreateWebAuthnChallengeParams) error
+	CreateWebAuthnCredential(ctx context.Context, arg CreateWebAuthnCredentialParams) (WebauthnCredential, error)
+	DeleteAuthEventsBefore(ctx context.Context, createdAt int64) (int64, error)
+	DeleteDeviceSessions(ctx context.Context, arg DeleteDeviceSessionsParams) error
+	DeleteEmailChangeRequest(ctx context.Context, id int64) (int64, error)
+	DeleteEmailChangeRevertsSince(ctx context.Context, arg DeleteEmailChangeRevertsSinceParams) (int64, error)
+	DeleteExpiredWebAuthnChallenges(ctx context.Context, expiresAt int64) error
+	DeleteInvitation(ctx context.Context, arg DeleteInvitationParams) (int64, error)
+	DeleteLoginAttempt(ctx context.Context, email string) error
+	DeleteMagicLinkRequest(ctx context.Context, id int64) (int64, error)
+	DeleteOtherUserSessions(ctx context.Context, arg DeleteOtherUserSessionsParams) error
+	DeletePasswordResetRequest(ctx context.Context, userID int64) error
+	DeletePasswordResetRequestByCodeHash(ctx context.Context, codeHash string) (int64, error)
+	DeleteRecoveryCodes(ctx context.Context, userID int64) error
 	DeleteSession(ctx context.Context, id string) error
+	DeleteTOTPCredential(ctx context.Context, userID int64) error
 	DeleteTodo(ctx context.Context, arg DeleteTodoParams) error
+	DeleteUser(ctx context.Context, id int64) (int64, error)
+	DeleteUserAccessToken(ctx context.Context, arg DeleteUserAccessTokenParams) (int64, error)
+	DeleteUserEmailChangeRequest(ctx context.Context, userID int64) error
 	DeleteUserEmailVerificationRequest(ctx context.Context, userID int64) error
+	DeleteUserKnownDevice(ctx context.Context, arg DeleteUserKnownDeviceParams) (int64, error)
+	DeleteUserMagicLinkRequest(ctx context.Context, userID int64) error
+	DeleteUserSession(ctx context.Context, arg DeleteUserSessionParams) (int64, error)
+	DeleteUserSessions(ctx context.Context, userID int64) error
+	DeleteUserWebAuthnCredential(ctx context.Context, arg DeleteUserWebAuthnCredentialParams) (int64, error)
+	EnableTOTPCredential(ctx context.Context, userID int64) error
+	GetEmailChangeRequest(ctx context.Context, userID int64) (EmailChangeRequest, error)
+	GetEmailChangeRequestByCancelTokenHash(ctx context.Context, cancelTokenHash string) (EmailChangeRequest, error)
+	GetEmailChangeRevertByTokenHash(ctx context.Context, tokenHash string) (EmailChangeRevert, error)
+	GetInvitationByTokenHash(ctx context.Context, tokenHash string) (Invitation, error)
+	GetKnownDeviceByFingerprint(ctx context.Context, arg GetKnownDeviceByFingerprintParams) (KnownDevice, error)
+	GetKnownDeviceByReportTokenHash(ctx context.Context, reportTokenHash sql.NullString) (KnownDevice, error)
+	GetKnownDeviceByTokenHash(ctx context.Context, arg GetKnownDeviceByTokenHashParams) (KnownDevice, error)
+	GetLoginAttempt(ctx context.Context, email string) (LoginAttempt, error)
+	GetLoginAttemptByUnlockTokenHash(ctx context.Context, unlockTokenHash sql.NullString) (LoginAttempt, error)
+	GetMagicLinkRequestByTokenHash(ctx context.Context, tokenHash string) (MagicLinkRequest, error)
+	GetOAuthAccount(ctx context.Context, arg GetOAuthAccountParams) (OauthAccount, error)
+	GetPasswordResetRequestByCodeHash(ctx context.Context, codeHash string) (PasswordResetRequest, error)
+	GetTOTPCredential(ctx context.Context, userID int64) (TotpCredential, error)
 	GetTodo(ctx context.Context, arg GetTodoParams) (Todo, error)
 	GetTodos(ctx context.Context, userID int64) ([]Todo, error)
+	GetUnusedRecoveryCodes(ctx context.Context, userID int64) ([]RecoveryCode, error)
 	GetUserByEmail(ctx context.Context, email string) (User, error)
+	GetUserByID(ctx context.Context, id int64) (User, error)
+	GetUserByNormalizedEmail(ctx context.Context, email string) (User, error)
 	GetUserEmailVerificationRequest(ctx context.Context, userID int64) (EmailVerificationRequest, error)
+	GetWebAuthnCredential(ctx context.Context, credentialID string) (WebauthnCredential, error)
+	IncrementEmailChangeAttempts(ctx context.Context, userID int64) (EmailChangeRequest, error)
+	IncrementEmailVerificationAttempts(ctx context.Context, userID int64) (EmailVerificationRequest, error)
+	IncrementSessionTwoFactorAttempts(ctx context.Context, id string) (int64, error)
+	InsertEmailChangeRequest(ctx context.Context, arg InsertEmailChangeRequestParams) error
+	InsertEmailChangeRevert(ctx context.Context, arg InsertEmailChangeRevertParams) error
+	InsertMagicLinkRequest(ctx context.Context, arg InsertMagicLinkRequestParams) error
+	InsertPasswordResetRequest(ctx context.Context, arg InsertPasswordResetRequestParams) (PasswordResetRequest, error)
 	InsertUserEmailVerificationRequest(ctx context.Context, arg InsertUserEmailVerificationRequestParams) (EmailVerificationRequest, error)
+	ListAuthEvents(ctx context.Context, arg ListAuthEventsParams) ([]AuthEvent, error)
+	ListPendingInvitations(ctx context.Context, arg ListPendingInvitationsParams) ([]Invitation, error)
+	ListUserAccessTokens(ctx context.Context, userID int64) ([]AccessToken, error)
+	ListUserAuthEvents(ctx context.Context, arg ListUserAuthEventsParams) ([]AuthEvent, error)
+	ListUserKnownDevices(ctx context.Context, userID int64) ([]KnownDevice, error)
+	ListUserSessions(ctx context.Context, arg ListUserSessionsParams) ([]Session, error)
+	ListUserWebAuthnCredentials(ctx context.Context, userID int64) ([]WebauthnCredential, error)
+	ListUsers(ctx context.Context, arg ListUsersParams) ([]ListUsersRow, error)
+	LockLoginAttempt(ctx context.Context, arg LockLoginAttemptParams) error
+	MarkRecoveryCodeUsed(ctx context.Context, arg MarkRecoveryCodeUsedParams) (int64, error)
 	Ping(ctx context.Context) error
+	RecordLoginFailure(ctx context.Context, arg RecordLoginFailureParams) (LoginAttempt, error)
+	RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) (int64, error)
+	SetUserDisabled(ctx context.Context, arg SetUserDisabledParams) (int64, error)
 	SetUserEmailVerified(ctx context.Context, id int64) error
+	SetUserPasswordResetRequired(ctx context.Context, id int64) error
+	SetUserRoleByEmail(ctx context.Context, arg SetUserRoleByEmailParams) (int64, error)
+	TouchAccessToken(ctx context.Context, arg TouchAccessTokenParams) error
+	TouchKnownDevice(ctx context.Context, arg TouchKnownDeviceParams) error
+	TouchSession(ctx context.Context, arg TouchSessionParams) error
 	UpdateSession(ctx context.Context, arg UpdateSessionParams) (Session, error)
 	UpdateTodo(ctx context.Context, arg UpdateTodoParams) (Todo, error)
+	UpdateUserEmail(ctx context.Context, arg UpdateUserEmailParams) error
+	UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error
+	UpsertTOTPCredential(ctx context.Context, arg UpsertTOTPCredentialParams) error
+	UseInvitation(ctx context.Context, arg UseInvitationParams) (int64, error)
+	UseWebAuthnCredential(ctx context.Context, arg UseWebAuthnCredentialParams) (int64, error)
+	ValidateAccessToken(ctx context.Context, tokenHash string) (ValidateAccessTokenRow, error)
 	ValidateEmailVerificationRequest(ctx context.Context, arg ValidateEmailVerificationRequestParams) (EmailVerificationRequest, error)
 	ValidateSessionToken(ctx context.Context, id string) (ValidateSessionTokenRow, error)
 }
@@ -10,246 +10,1963 @@ import (
 	"database/sql"
 )
 
+const advanceTOTPCredentialStep = `-- name: AdvanceTOTPCredentialStep :execrows
+UPDATE totp_credential SET next_step = ?1
+WHERE user_id = ?2 AND next_step < ?1
+`
+
+type AdvanceTOTPCredentialStepParams struct {
+	NextStep int64
+	UserID   int64
+}
+
+func (q *Queries) AdvanceTOTPCredentialStep(ctx context.Context, arg AdvanceTOTPCredentialStepParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, advanceTOTPCredentialStep, arg.NextStep, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const completeSessionTwoFactor = `-- name: CompleteSessionTwoFactor :one
+UPDATE session SET two_factor_pending = 0, expires_at = ? WHERE id = ? RETURNING id, user_id, expires_at, created_at, two_factor_pending, ip_address, user_agent, last_seen_at, impersonator_id, remember_me, absolute_expires_at, device_id, two_factor_attempts
+`
+
+type CompleteSessionTwoFactorParams struct {
+	ExpiresAt int64
+	ID        string
+}
+
+func (q *Queries) CompleteSessionTwoFactor(ctx context.Context, arg CompleteSessionTwoFactorParams) (Session, error) {
+	row := q.db.QueryRowContext(ctx, completeSessionTwoFactor, arg.ExpiresAt, arg.ID)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.TwoFactorPending,
+		&i.IpAddress,
+		&i.UserAgent,
+		&i.LastSeenAt,
+		&i.ImpersonatorID,
+		&i.RememberMe,
+		&i.AbsoluteExpiresAt,
+		&i.DeviceID,
+		&i.TwoFactorAttempts,
+	)
+	return i, err
+}
+
+const consumeWebAuthnChallenge = `-- name: ConsumeWebAuthnChallenge :one
+DELETE FROM webauthn_challenge WHERE challenge_hash = ?
+RETURNING challenge_hash, ceremony, user_id, expires_at
+`
+
+func (q *Queries) ConsumeWebAuthnChallenge(ctx context.Context, challengeHash string) (WebauthnChallenge, error) {
+	row := q.db.QueryRowContext(ctx, consumeWebAuthnChallenge, challengeHash)
+	var i WebauthnChallenge
+	err := row.Scan(
+		&i.ChallengeHash,
+		&i.Ceremony,
+		&i.UserID,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const countUnusedRecoveryCodes = `-- name: CountUnusedRecoveryCodes :one
+SELECT COUNT(*) FROM recovery_code WHERE user_id = ? AND used_at IS NULL
+`
+
+func (q *Queries) CountUnusedRecoveryCodes(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUnusedRecoveryCodes, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUserKnownDevices = `-- name: CountUserKnownDevices :one
+SELECT COUNT(*) FROM known_device WHERE user_id = ?
+`
+
+func (q *Queries) CountUserKnownDevices(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUserKnownDevices, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUserTodos = `-- name: CountUserTodos :one
+SELECT COUNT(*) FROM todos WHERE user_id = ?
+`
+
+func (q *Queries) CountUserTodos(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUserTodos, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createAccessToken = `-- name: CreateAccessToken :one
+INSERT INTO access_token (user_id, name, token_hash, scopes, created_at, expires_at)
+VALUES (?, ?, ?, ?, ?, ?)
+RETURNING id, user_id, name, token_hash, scopes, created_at, expires_at, last_used_at
+`
+
+type CreateAccessTokenParams struct {
+	UserID    int64
+	Name      string
+	TokenHash string
+	Scopes    string
+	CreatedAt int64
+	ExpiresAt int64
+}
+
+func (q *Queries) CreateAccessToken(ctx context.Context, arg CreateAccessTokenParams) (AccessToken, error) {
+	row := q.db.QueryRowContext(ctx, createAccessToken,
+		arg.UserID,
+		arg.Name,
+		arg.TokenHash,
+		arg.Scopes,
+		arg.CreatedAt,
+		arg.ExpiresAt,
+	)
+	var i AccessToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.TokenHash,
+		&i.Scopes,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const createAuthEvent = `-- name: CreateAuthEvent :exec
+INSERT INTO auth_events (event_type, user_id, email, outcome, reason, ip_address, user_agent, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+type CreateAuthEventParams struct {
+	EventType string
+	UserID    sql.NullInt64
+	Email     string
+	Outcome   string
+	Reason    string
+	IpAddress string
+	UserAgent string
+	CreatedAt int64
+}
+
+func (q *Queries) CreateAuthEvent(ctx context.Context, arg CreateAuthEventParams) error {
+	_, err := q.db.ExecContext(ctx, createAuthEvent,
+		arg.EventType,
+		arg.UserID,
+		arg.Email,
+		arg.Outcome,
+		arg.Reason,
+		arg.IpAddress,
+		arg.UserAgent,
+		arg.CreatedAt,
+	)
+	return err
+}
+
+const createInvitation = `-- name: CreateInvitation :one
+INSERT INTO invitation (email, token_hash, inviter_id, created_at, expires_at)
+VALUES (?, ?, ?, ?, ?)
+RETURNING id, email, token_hash, inviter_id, created_at, expires_at, used_at
+`
+
+type CreateInvitationParams struct {
+	Email     string
+	TokenHash string
+	InviterID int64
+	CreatedAt int64
+	ExpiresAt int64
+}
+
+func (q *Queries) CreateInvitation(ctx context.Context, arg CreateInvitationParams) (Invitation, error) {
+	row := q.db.QueryRowContext(ctx, createInvitation,
+		arg.Email,
+		arg.TokenHash,
+		arg.InviterID,
+		arg.CreatedAt,
+		arg.ExpiresAt,
+	)
+	var i Invitation
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.TokenHash,
+		&i.InviterID,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.UsedAt,
+	)
+	return i, err
+}
+
+const createKnownDevice = `-- name: CreateKnownDevice :one
+INSERT INTO known_device (user_id, token_hash, fingerprint, user_agent, ip_address, created_at, last_seen_at, report_token_hash, report_expires_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING id, user_id, token_hash, fingerprint, user_agent, ip_address, created_at, last_seen_at, report_token_hash, report_expires_at
+`
+
+type CreateKnownDeviceParams struct {
+	UserID          int64
+	TokenHash       string
+	Fingerprint     string
+	UserAgent       string
+	IpAddress       string
+	CreatedAt       int64
+	LastSeenAt      int64
+	ReportTokenHash sql.NullString
+	ReportExpiresAt int64
+}
+
+func (q *Queries) CreateKnownDevice(ctx context.Context, arg CreateKnownDeviceParams) (KnownDevice, error) {
+	row := q.db.QueryRowContext(ctx, createKnownDevice,
+		arg.UserID,
+		arg.TokenHash,
+		arg.Fingerprint,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.CreatedAt,
+		arg.LastSeenAt,
+		arg.ReportTokenHash,
+		arg.ReportExpiresAt,
+	)
+	var i KnownDevice
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TokenHash,
+		&i.Fingerprint,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.CreatedAt,
+		&i.LastSeenAt,
+		&i.ReportTokenHash,
+		&i.ReportExpiresAt,
+	)
+	return i, err
+}
+
+const createOAuthAccount = `-- name: CreateOAuthAccount :one
+INSERT INTO oauth_accounts (user_id, provider, provider_user_id) VALUES (?, ?, ?) RETURNING id, user_id, provider, provider_user_id, created_at
+`
+
+type CreateOAuthAccountParams struct {
+	UserID         int64
+	Provider       string
+	ProviderUserID string
+}
+
+func (q *Queries) CreateOAuthAccount(ctx context.Context, arg CreateOAuthAccountParams) (OauthAccount, error) {
+	row := q.db.QueryRowContext(ctx, createOAuthAccount, arg.UserID, arg.Provider, arg.ProviderUserID)
+	var i OauthAccount
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Provider,
+		&i.ProviderUserID,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createRecoveryCode = `-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_code (user_id, code_hash, created_at) VALUES (?, ?, ?)
+`
+
+type CreateRecoveryCodeParams struct {
+	UserID    int64
+	CodeHash  string
+	CreatedAt int64
+}
+
+func (q *Queries) CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) error {
+	_, err := q.db.ExecContext(ctx, createRecoveryCode, arg.UserID, arg.CodeHash, arg.CreatedAt)
+	return err
+}
+
 const createSession = `-- name: CreateSession :one
-INSERT INTO session (id, user_id, expires_at) VALUES (?, ?, ?) RETURNING id, user_id, expires_at, created_at
+INSERT INTO session (id, user_id, expires_at, two_factor_pending, ip_address, user_agent, last_seen_at, impersonator_id, remember_me, absolute_expires_at, device_id)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING id, user_id, expires_at, created_at, two_factor_pending, ip_address, user_agent, last_seen_at, impersonator_id, remember_me, absolute_expires_at, device_id, two_factor_attempts
+`
+
+type CreateSessionParams struct {
+	ID                string
+	UserID            int64
+	ExpiresAt         int64
+	TwoFactorPending  int64
+	IpAddress         string
+	UserAgent         string
+	LastSeenAt        int64
+	ImpersonatorID    sql.NullInt64
+	RememberMe        int64
+	AbsoluteExpiresAt int64
+	DeviceID          sql.NullInt64
+}
+
+func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error) {
+	row := q.db.QueryRowContext(ctx, createSession,
+		arg.ID,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.TwoFactorPending,
+		arg.IpAddress,
+		arg.UserAgent,
+		arg.LastSeenAt,
+		arg.ImpersonatorID,
+		arg.RememberMe,
+		arg.AbsoluteExpiresAt,
+		arg.DeviceID,
+	)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.TwoFactorPending,
+		&i.IpAddress,
+		&i.UserAgent,
+		&i.LastSeenAt,
+		&i.ImpersonatorID,
+		&i.RememberMe,
+		&i.AbsoluteExpiresAt,
+		&i.DeviceID,
+		&i.TwoFactorAttempts,
+	)
+	return i, err
+}
+
+const createTodo = `-- name: CreateTodo :one
+INSERT INTO todos (name, user_id, description) VALUES (?, ?, ?) RETURNING id, user_id, name, description, is_complete
+`
+
+type CreateTodoParams struct {
+	Name        string
+	UserID      int64
+	Description sql.NullString
+}
+
+func (q *Queries) CreateTodo(ctx context.Context, arg CreateTodoParams) (Todo, error) {
+	row := q.db.QueryRowContext(ctx, createTodo, arg.Name, arg.UserID, arg.Description)
+	var i Todo
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Description,
+		&i.IsComplete,
+	)
+	return i, err
+}
+
+const createUser = `-- name: CreateUser :one
+INSERT INTO user (email, password_hash) VALUES (?, ?) RETURNING id, email, password_hash, email_verified, created_at, updated_at, role, disabled, password_reset_required
+`
+
+type CreateUserParams struct {
+	Email        string
+	PasswordHash string
+}
+
+func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.PasswordHash)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.PasswordHash,
+		&i.EmailVerified,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Role,
+		&i.Disabled,
+		&i.PasswordResetRequired,
+	)
+	return i, err
+}
+
+const createWebAuthnChallenge = `-- name: CreateWebAuthnChallenge :exec
+INSERT INTO webauthn_challenge (challenge_hash, ceremony, user_id, expires_at)
+VALUES (?, ?, ?, ?)
+`
+
+type CreateWebAuthnChallengeParams struct {
+	ChallengeHash string
+	Ceremony      string
+	UserID        sql.NullInt64
+	ExpiresAt     int64
+}
+
+func (q *Queries) CreateWebAuthnChallenge(ctx context.Context, arg CreateWebAuthnChallengeParams) error {
+	_, err := q.db.ExecContext(ctx, createWebAuthnChallenge,
+		arg.ChallengeHash,
+		arg.Ceremony,
+		arg.UserID,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const createWebAuthnCredential = `-- name: CreateWebAuthnCredential :one
+INSERT INTO webauthn_credential (user_id, credential_id, public_key, sign_count, name, created_at)
+VALUES (?, ?, ?, ?, ?, ?)
+RETURNING id, user_id, credential_id, public_key, sign_count, name, created_at, last_used_at
+`
+
+type CreateWebAuthnCredentialParams struct {
+	UserID       int64
+	CredentialID string
+	PublicKey    []byte
+	SignCount    int64
+	Name         string
+	CreatedAt    int64
+}
+
+func (q *Queries) CreateWebAuthnCredential(ctx context.Context, arg CreateWebAuthnCredentialParams) (WebauthnCredential, error) {
+	row := q.db.QueryRowContext(ctx, createWebAuthnCredential,
+		arg.UserID,
+		arg.CredentialID,
+		arg.PublicKey,
+		arg.SignCount,
+		arg.Name,
+		arg.CreatedAt,
+	)
+	var i WebauthnCredential
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CredentialID,
+		&i.PublicKey,
+		&i.SignCount,
+		&i.Name,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const deleteAuthEventsBefore = `-- name: DeleteAuthEventsBefore :execrows
+DELETE FROM auth_events WHERE created_at < ?
+`
+
+func (q *Queries) DeleteAuthEventsBefore(ctx context.Context, createdAt int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteAuthEventsBefore, createdAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteDeviceSessions = `-- name: DeleteDeviceSessions :exec
+DELETE FROM session WHERE device_id = ? AND user_id = ?
+`
+
+type DeleteDeviceSessionsParams struct {
+	DeviceID sql.NullInt64
+	UserID   int64
+}
+
+func (q *Queries) DeleteDeviceSessions(ctx context.Context, arg DeleteDeviceSessionsParams) error {
+	_, err := q.db.ExecContext(ctx, deleteDeviceSessions, arg.DeviceID, arg.UserID)
+	return err
+}
+
+const deleteEmailChangeRequest = `-- name: DeleteEmailChangeRequest :execrows
+DELETE FROM email_change_request WHERE id = ?
+`
+
+func (q *Queries) DeleteEmailChangeRequest(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteEmailChangeRequest, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteEmailChangeRevertsSince = `-- name: DeleteEmailChangeRevertsSince :execrows
+DELETE FROM email_change_revert WHERE user_id = ? AND id >= ?
+`
+
+type DeleteEmailChangeRevertsSinceParams struct {
+	UserID int64
+	ID     int64
+}
+
+func (q *Queries) DeleteEmailChangeRevertsSince(ctx context.Context, arg DeleteEmailChangeRevertsSinceParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteEmailChangeRevertsSince, arg.UserID, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteExpiredWebAuthnChallenges = `-- name: DeleteExpiredWebAuthnChallenges :exec
+DELETE FROM webauthn_challenge WHERE expires_at <= ?
+`
+
+func (q *Queries) DeleteExpiredWebAuthnChallenges(ctx context.Context, expiresAt int64) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredWebAuthnChallenges, expiresAt)
+	return err
+}
+
+const deleteInvitation = `-- name: DeleteInvitation :execrows
+DELETE FROM invitation WHERE id = ? AND inviter_id = ? AND used_at IS NULL
+`
+
+type DeleteInvitationParams struct {
+	ID        int64
+	InviterID int64
+}
+
+func (q *Queries) DeleteInvitation(ctx context.Context, arg DeleteInvitationParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteInvitation, arg.ID, arg.InviterID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteLoginAttempt = `-- name: DeleteLoginAttempt :exec
+DELETE FROM login_attempt WHERE email = ?
+`
+
+func (q *Queries) DeleteLoginAttempt(ctx context.Context, email string) error {
+	_, err := q.db.ExecContext(ctx, deleteLoginAttempt, email)
+	return err
+}
+
+const deleteMagicLinkRequest = `-- name: DeleteMagicLinkRequest :execrows
+DELETE FROM magic_link_request WHERE id = ?
+`
+
+func (q *Queries) DeleteMagicLinkRequest(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteMagicLinkRequest, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteOtherUserSessions = `-- name: DeleteOtherUserSessions :exec
+DELETE FROM session WHERE user_id = ? AND id != ?
+`
+
+type DeleteOtherUserSessionsParams struct {
+	UserID int64
+	ID     string
+}
+
+func (q *Queries) DeleteOtherUserSessions(ctx context.Context, arg DeleteOtherUserSessionsParams) error {
+	_, err := q.db.ExecContext(ctx, deleteOtherUserSessions, arg.UserID, arg.ID)
+	return err
+}
+
+const deletePasswordResetRequest = `-- name: DeletePasswordResetRequest :exec
+DELETE FROM password_reset_request WHERE user_id = ?
+`
+
+func (q *Queries) DeletePasswordResetRequest(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deletePasswordResetRequest, userID)
+	return err
+}
+
+const deletePasswordResetRequestByCodeHash = `-- name: DeletePasswordResetRequestByCodeHash :execrows
+DELETE FROM password_reset_request WHERE code_hash = ?
+`
+
+func (q *Queries) DeletePasswordResetRequestByCodeHash(ctx context.Context, codeHash string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deletePasswordResetRequestByCodeHash, codeHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteRecoveryCodes = `-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_code WHERE user_id = ?
+`
+
+func (q *Queries) DeleteRecoveryCodes(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteRecoveryCodes, userID)
+	return err
+}
+
+const deleteSession = `-- name: DeleteSession :exec
+DELETE FROM session WHERE id = ?
+`
+
+func (q *Queries) DeleteSession(ctx context.Context, id string) error {
+	_, err := q.db.ExecContext(ctx, deleteSession, id)
+	return err
+}
+
+const deleteTOTPCredential = `-- name: DeleteTOTPCredential :exec
+DELETE FROM totp_credential WHERE user_id = ?
+`
+
+func (q *Queries) DeleteTOTPCredential(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteTOTPCredential, userID)
+	return err
+}
+
+const deleteTodo = `-- name: DeleteTodo :exec
+DELETE FROM todos WHERE id = ? AND user_id = ?
+`
+
+type DeleteTodoParams struct {
+	ID     int64
+	UserID int64
+}
+
+func (q *Queries) DeleteTodo(ctx context.Context, arg DeleteTodoParams) error {
+	_, err := q.db.ExecContext(ctx, deleteTodo, arg.ID, arg.UserID)
+	return err
+}
+
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE FROM user WHERE id = ?
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUserAccessToken = `-- name: DeleteUserAccessToken :execrows
+DELETE FROM access_token WHERE id = ? AND user_id = ?
+`
+
+type DeleteUserAccessTokenParams struct {
+	ID     int64
+	UserID int64
+}
+
+func (q *Queries) DeleteUserAccessToken(ctx context.Context, arg DeleteUserAccessTokenParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUserAccessToken, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUserEmailChangeRequest = `-- name: DeleteUserEmailChangeRequest :exec
+DELETE FROM email_change_request WHERE user_id = ?
+`
+
+func (q *Queries) DeleteUserEmailChangeRequest(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserEmailChangeRequest, userID)
+	return err
+}
+
+const deleteUserEmailVerificationRequest = `-- name: DeleteUserEmailVerificationRequest :exec
+DELETE FROM email_verification_request WHERE user_id = ?
+`
+
+func (q *Queries) DeleteUserEmailVerificationRequest(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserEmailVerificationRequest, userID)
+	return err
+}
+
+const deleteUserKnownDevice = `-- name: DeleteUserKnownDevice :execrows
+DELETE FROM known_device WHERE id = ? AND user_id = ?
+`
+
+type DeleteUserKnownDeviceParams struct {
+	ID     int64
+	UserID int64
+}
+
+func (q *Queries) DeleteUserKnownDevice(ctx context.Context, arg DeleteUserKnownDeviceParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUserKnownDevice, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUserMagicLinkRequest = `-- name: DeleteUserMagicLinkRequest :exec
+DELETE FROM magic_link_request WHERE user_id = ?
+`
+
+func (q *Queries) DeleteUserMagicLinkRequest(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserMagicLinkRequest, userID)
+	return err
+}
+
+const deleteUserSession = `-- name: DeleteUserSession :execrows
+DELETE FROM session WHERE id = ? AND user_id = ?
+`
+
+type DeleteUserSessionParams struct {
+	ID     string
+	UserID int64
+}
+
+func (q *Queries) DeleteUserSession(ctx context.Context, arg DeleteUserSessionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUserSession, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUserSessions = `-- name: DeleteUserSessions :exec
+DELETE FROM session WHERE user_id = ?
+`
+
+func (q *Queries) DeleteUserSessions(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserSessions, userID)
+	return err
+}
+
+const deleteUserWebAuthnCredential = `-- name: DeleteUserWebAuthnCredential :execrows
+DELETE FROM webauthn_credential WHERE id = ? AND user_id = ?
+`
+
+type DeleteUserWebAuthnCredentialParams struct {
+	ID     int64
+	UserID int64
+}
+
+func (q *Queries) DeleteUserWebAuthnCredential(ctx context.Context, arg DeleteUserWebAuthnCredentialParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUserWebAuthnCredential, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const enableTOTPCredential = `-- name: EnableTOTPCredential :exec
+UPDATE totp_credential SET enabled = 1 WHERE user_id = ?
+`
+
+func (q *Queries) EnableTOTPCredential(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, enableTOTPCredential, userID)
+	return err
+}
+
+const getEmailChangeRequest = `-- name: GetEmailChangeRequest :one
+SELECT id, user_id, new_email, code_hash, cancel_token_hash, created_at, expires_at, attempts FROM email_change_request WHERE user_id = ?
+`
+
+func (q *Queries) GetEmailChangeRequest(ctx context.Context, userID int64) (EmailChangeRequest, error) {
+	row := q.db.QueryRowContext(ctx, getEmailChangeRequest, userID)
+	var i EmailChangeRequest
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.NewEmail,
+		&i.CodeHash,
+		&i.CancelTokenHash,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.Attempts,
+	)
+	return i, err
+}
+
+const getEmailChangeRequestByCancelTokenHash = `-- name: GetEmailChangeRequestByCancelTokenHash :one
+SELECT id, user_id, new_email, code_hash, cancel_token_hash, created_at, expires_at, attempts FROM email_change_request WHERE cancel_token_hash = ?
+`
+
+func (q *Queries) GetEmailChangeRequestByCancelTokenHash(ctx context.Context, cancelTokenHash string) (EmailChangeRequest, error) {
+	row := q.db.QueryRowContext(ctx, getEmailChangeRequestByCancelTokenHash, cancelTokenHash)
+	var i EmailChangeRequest
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.NewEmail,
+		&i.CodeHash,
+		&i.CancelTokenHash,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.Attempts,
+	)
+	return i, err
+}
+
+const getEmailChangeRevertByTokenHash = `-- name: GetEmailChangeRevertByTokenHash :one
+SELECT id, user_id, old_email, token_hash, expires_at FROM email_change_revert WHERE token_hash = ?
+`
+
+func (q *Queries) GetEmailChangeRevertByTokenHash(ctx context.Context, tokenHash string) (EmailChangeRevert, error) {
+	row := q.db.QueryRowContext(ctx, getEmailChangeRevertByTokenHash, tokenHash)
+	var i EmailChangeRevert
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.OldEmail,
+		&i.TokenHash,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const getInvitationByTokenHash = `-- name: GetInvitationByTokenHash :one
+SELECT id, email, token_hash, inviter_id, created_at, expires_at, used_at FROM invitation WHERE token_hash = ?
+`
+
+func (q *Queries) GetInvitationByTokenHash(ctx context.Context, tokenHash string) (Invitation, error) {
+	row := q.db.QueryRowContext(ctx, getInvitationByTokenHash, tokenHash)
+	var i Invitation
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.TokenHash,
+		&i.InviterID,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.UsedAt,
+	)
+	return i, err
+}
+
+const getKnownDeviceByFingerprint = `-- name: GetKnownDeviceByFingerprint :one
+SELECT id, user_id, token_hash, fingerprint, user_agent, ip_address, created_at, last_seen_at, report_token_hash, report_expires_at FROM known_device WHERE user_id = ? AND fingerprint = ? ORDER BY last_seen_at DESC LIMIT 1
+`
+
+type GetKnownDeviceByFingerprintParams struct {
+	UserID      int64
+	Fingerprint string
+}
+
+func (q *Queries) GetKnownDeviceByFingerprint(ctx context.Context, arg GetKnownDeviceByFingerprintParams) (KnownDevice, error) {
+	row := q.db.QueryRowContext(ctx, getKnownDeviceByFingerprint, arg.UserID, arg.Fingerprint)
+	var i KnownDevice
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TokenHash,
+		&i.Fingerprint,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.CreatedAt,
+		&i.LastSeenAt,
+		&i.ReportTokenHash,
+		&i.ReportExpiresAt,
+	)
+	return i, err
+}
+
+const getKnownDeviceByReportTokenHash = `-- name: GetKnownDeviceByReportTokenHash :one
+SELECT id, user_id, token_hash, fingerprint, user_agent, ip_address, created_at, last_seen_at, report_token_hash, report_expires_at FROM known_device WHERE report_token_hash = ?
+`
+
+func (q *Queries) GetKnownDeviceByReportTokenHash(ctx context.Context, reportTokenHash sql.NullString) (KnownDevice, error) {
+	row := q.db.QueryRowContext(ctx, getKnownDeviceByReportTokenHash, reportTokenHash)
+	var i KnownDevice
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TokenHash,
+		&i.Fingerprint,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.CreatedAt,
+		&i.LastSeenAt,
+		&i.ReportTokenHash,
+		&i.ReportExpiresAt,
+	)
+	return i, err
+}
+
+const getKnownDeviceByTokenHash = `-- name: GetKnownDeviceByTokenHash :one
+SELECT id, user_id, token_hash, fingerprint, user_agent, ip_address, created_at, last_seen_at, report_token_hash, report_expires_at FROM known_device WHERE user_id = ? AND token_hash = ?
+`
+
+type GetKnownDeviceByTokenHashParams struct {
+	UserID    int64
+	TokenHash string
+}
+
+func (q *Queries) GetKnownDeviceByTokenHash(ctx context.Context, arg GetKnownDeviceByTokenHashParams) (KnownDevice, error) {
+	row := q.db.QueryRowContext(ctx, getKnownDeviceByTokenHash, arg.UserID, arg.TokenHash)
+	var i KnownDevice
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TokenHash,
+		&i.Fingerprint,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.CreatedAt,
+		&i.LastSeenAt,
+		&i.ReportTokenHash,
+		&i.ReportExpiresAt,
+	)
+	return i, err
+}
+
+const getLoginAttempt = `-- name: GetLoginAttempt :one
+SELECT email, failed_count, last_failed_at, locked_until, unlock_token_hash FROM login_attempt WHERE email = ?
+`
+
+func (q *Queries) GetLoginAttempt(ctx context.Context, email string) (LoginAttempt, error) {
+	row := q.db.QueryRowContext(ctx, getLoginAttempt, email)
+	var i LoginAttempt
+	err := row.Scan(
+		&i.Email,
+		&i.FailedCount,
+		&i.LastFailedAt,
+		&i.LockedUntil,
+		&i.UnlockTokenHash,
+	)
+	return i, err
+}
+
+const getLoginAttemptByUnlockTokenHash = `-- name: GetLoginAttemptByUnlockTokenHash :one
+SELECT email, failed_count, last_failed_at, locked_until, unlock_token_hash FROM login_attempt WHERE unlock_token_hash = ?
+`
+
+func (q *Queries) GetLoginAttemptByUnlockTokenHash(ctx context.Context, unlockTokenHash sql.NullString) (LoginAttempt, error) {
+	row := q.db.QueryRowContext(ctx, getLoginAttemptByUnlockTokenHash, unlockTokenHash)
+	var i LoginAttempt
+	err := row.Scan(
+		&i.Email,
+		&i.FailedCount,
+		&i.LastFailedAt,
+		&i.LockedUntil,
+		&i.UnlockTokenHash,
+	)
+	return i, err
+}
+
+const getMagicLinkRequestByTokenHash = `-- name: GetMagicLinkRequestByTokenHash :one
+SELECT id, user_id, token_hash, browser_hash, created_at, expires_at FROM magic_link_request WHERE token_hash = ?
+`
+
+func (q *Queries) GetMagicLinkRequestByTokenHash(ctx context.Context, tokenHash string) (MagicLinkRequest, error) {
+	row := q.db.QueryRowContext(ctx, getMagicLinkRequestByTokenHash, tokenHash)
+	var i MagicLinkRequest
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.TokenHash,
+		&i.BrowserHash,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const getOAuthAccount = `-- name: GetOAuthAccount :one
+SELECT id, user_id, provider, provider_user_id, created_at FROM oauth_accounts WHERE provider = ? AND provider_user_id = ?
+`
+
+type GetOAuthAccountParams struct {
+	Provider       string
+	ProviderUserID string
+}
+
+func (q *Queries) GetOAuthAccount(ctx context.Context, arg GetOAuthAccountParams) (OauthAccount, error) {
+	row := q.db.QueryRowContext(ctx, getOAuthAccount, arg.Provider, arg.ProviderUserID)
+	var i OauthAccount
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Provider,
+		&i.ProviderUserID,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getPasswordResetRequestByCodeHash = `-- name: GetPasswordResetRequestByCodeHash :one
+SELECT id, user_id, created_at, expires_at, code_hash FROM password_reset_request WHERE code_hash = ?
+`
+
+func (q *Queries) GetPasswordResetRequestByCodeHash(ctx context.Context, codeHash string) (PasswordResetRequest, error) {
+	row := q.db.QueryRowContext(ctx, getPasswordResetRequestByCodeHash, codeHash)
+	var i PasswordResetRequest
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.CodeHash,
+	)
+	return i, err
+}
+
+const getTOTPCredential = `-- name: GetTOTPCredential :one
+SELECT user_id, encrypted_secret, enabled, created_at, next_step FROM totp_credential WHERE user_id = ?
+`
+
+func (q *Queries) GetTOTPCredential(ctx context.Context, userID int64) (TotpCredential, error) {
+	row := q.db.QueryRowContext(ctx, getTOTPCredential, userID)
+	var i TotpCredential
+	err := row.Scan(
+		&i.UserID,
+		&i.EncryptedSecret,
+		&i.Enabled,
+		&i.CreatedAt,
+		&i.NextStep,
+	)
+	return i, err
+}
+
+const getTodo = `-- name: GetTodo :one
+SELECT id, user_id, name, description, is_complete FROM todos WHERE id = ? AND user_id = ?
+`
+
+type GetTodoParams struct {
+	ID     int64
+	UserID int64
+}
+
+func (q *Queries) GetTodo(ctx context.Context, arg GetTodoParams) (Todo, error) {
+	row := q.db.QueryRowContext(ctx, getTodo, arg.ID, arg.UserID)
+	var i Todo
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Description,
+		&i.IsComplete,
+	)
+	return i, err
+}
+
+const getTodos = `-- name: GetTodos :many
+SELECT id, user_id, name, description, is_complete FROM todos WHERE user_id = ?
+`
+
+func (q *Queries) GetTodos(ctx context.Context, userID int64) ([]Todo, error) {
+	rows, err := q.db.QueryContext(ctx, getTodos, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Todo
+	for rows.Next() {
+		var i Todo
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.Description,
+			&i.IsComplete,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUnusedRecoveryCodes = `-- name: GetUnusedRecoveryCodes :many
+SELECT id, user_id, code_hash, created_at, used_at FROM recovery_code WHERE user_id = ? AND used_at IS NULL
+`
+
+func (q *Queries) GetUnusedRecoveryCodes(ctx context.Context, userID int64) ([]RecoveryCode, error) {
+	rows, err := q.db.QueryContext(ctx, getUnusedRecoveryCodes, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RecoveryCode
+	for rows.Next() {
+		var i RecoveryCode
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CodeHash,
+			&i.CreatedAt,
+			&i.UsedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserByEmail = `-- name: GetUserByEmail :one
+SELECT id, email, password_hash, email_verified, created_at, updated_at, role, disabled, password_reset_required FROM user WHERE email = ?
+`
+
+func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.PasswordHash,
+		&i.EmailVerified,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Role,
+		&i.Disabled,
+		&i.PasswordResetRequired,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, email, password_hash, email_verified, created_at, updated_at, role, disabled, password_reset_required FROM user WHERE id = ?
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id int64) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.PasswordHash,
+		&i.EmailVerified,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Role,
+		&i.Disabled,
+		&i.PasswordResetRequired,
+	)
+	return i, err
+}
+
+const getUserByNormalizedEmail = `-- name: GetUserByNormalizedEmail :one
+SELECT id, email, password_hash, email_verified, created_at, updated_at, role, disabled, password_reset_required FROM user WHERE lower(email) = ? ORDER BY id LIMIT 1
+`
+
+func (q *Queries) GetUserByNormalizedEmail(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByNormalizedEmail, email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.PasswordHash,
+		&i.EmailVerified,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Role,
+		&i.Disabled,
+		&i.PasswordResetRequired,
+	)
+	return i, err
+}
+
+const getUserEmailVerificationRequest = `-- name: GetUserEmailVerificationRequest :one
+SELECT user_id, created_at, expires_at, code, attempts, send_count, send_window_started_at FROM email_verification_request WHERE user_id = ?
+`
+
+func (q *Queries) GetUserEmailVerificationRequest(ctx context.Context, userID int64) (EmailVerificationRequest, error) {
+	row := q.db.QueryRowContext(ctx, getUserEmailVerificationRequest, userID)
+	var i EmailVerificationRequest
+	err := row.Scan(
+		&i.UserID,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.Code,
+		&i.Attempts,
+		&i.SendCount,
+		&i.SendWindowStartedAt,
+	)
+	return i, err
+}
+
+const getWebAuthnCredential = `-- name: GetWebAuthnCredential :one
+SELECT id, user_id, credential_id, public_key, sign_count, name, created_at, last_used_at FROM webauthn_credential WHERE credential_id = ?
+`
+
+func (q *Queries) GetWebAuthnCredential(ctx context.Context, credentialID string) (WebauthnCredential, error) {
+	row := q.db.QueryRowContext(ctx, getWebAuthnCredential, credentialID)
+	var i WebauthnCredential
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CredentialID,
+		&i.PublicKey,
+		&i.SignCount,
+		&i.Name,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const incrementEmailChangeAttempts = `-- name: IncrementEmailChangeAttempts :one
+UPDATE email_change_request SET attempts = attempts + 1 WHERE user_id = ? RETURNING id, user_id, new_email, code_hash, cancel_token_hash, created_at, expires_at, attempts
+`
+
+func (q *Queries) IncrementEmailChangeAttempts(ctx context.Context, userID int64) (EmailChangeRequest, error) {
+	row := q.db.QueryRowContext(ctx, incrementEmailChangeAttempts, userID)
+	var i EmailChangeRequest
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.NewEmail,
+		&i.CodeHash,
+		&i.CancelTokenHash,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.Attempts,
+	)
+	return i, err
+}
+
+const incrementEmailVerificationAttempts = `-- name: IncrementEmailVerificationAttempts :one
+UPDATE email_verification_request SET attempts = attempts + 1 WHERE user_id = ? RETURNING user_id, created_at, expires_at, code, attempts, send_count, send_window_started_at
+`
+
+func (q *Queries) IncrementEmailVerificationAttempts(ctx context.Context, userID int64) (EmailVerificationRequest, error) {
+	row := q.db.QueryRowContext(ctx, incrementEmailVerificationAttempts, userID)
+	var i EmailVerificationRequest
+	err := row.Scan(
+		&i.UserID,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.Code,
+		&i.Attempts,
+		&i.SendCount,
+		&i.SendWindowStartedAt,
+	)
+	return i, err
+}
+
+const incrementSessionTwoFactorAttempts = `-- name: IncrementSessionTwoFactorAttempts :one
+UPDATE session SET two_factor_attempts = two_factor_attempts + 1 WHERE id = ? AND two_factor_pending = 1 RETURNING two_factor_attempts
+`
+
+func (q *Queries) IncrementSessionTwoFactorAttempts(ctx context.Context, id string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, incrementSessionTwoFactorAttempts, id)
+	var two_factor_attempts int64
+	err := row.Scan(&two_factor_attempts)
+	return two_factor_attempts, err
+}
+
+const insertEmailChangeRequest = `-- name: InsertEmailChangeRequest :exec
+INSERT INTO email_change_request (user_id, new_email, code_hash, cancel_token_hash, created_at, expires_at)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT(user_id) DO UPDATE SET new_email = EXCLUDED.new_email, code_hash = EXCLUDED.code_hash, cancel_token_hash = EXCLUDED.cancel_token_hash, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at, attempts = 0
+`
+
+type InsertEmailChangeRequestParams struct {
+	UserID          int64
+	NewEmail        string
+	CodeHash        string
+	CancelTokenHash string
+	CreatedAt       int64
+	ExpiresAt       int64
+}
+
+func (q *Queries) InsertEmailChangeRequest(ctx context.Context, arg InsertEmailChangeRequestParams) error {
+	_, err := q.db.ExecContext(ctx, insertEmailChangeRequest,
+		arg.UserID,
+		arg.NewEmail,
+		arg.CodeHash,
+		arg.CancelTokenHash,
+		arg.CreatedAt,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const insertEmailChangeRevert = `-- name: InsertEmailChangeRevert :exec
+INSERT INTO email_change_revert (user_id, old_email, token_hash, expires_at)
+VALUES (?, ?, ?, ?)
+`
+
+type InsertEmailChangeRevertParams struct {
+	UserID    int64
+	OldEmail  string
+	TokenHash string
+	ExpiresAt int64
+}
+
+func (q *Queries) InsertEmailChangeRevert(ctx context.Context, arg InsertEmailChangeRevertParams) error {
+	_, err := q.db.ExecContext(ctx, insertEmailChangeRevert,
+		arg.UserID,
+		arg.OldEmail,
+		arg.TokenHash,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const insertMagicLinkRequest = `-- name: InsertMagicLinkRequest :exec
+INSERT INTO magic_link_request (user_id, token_hash, browser_hash, created_at, expires_at)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(user_id) DO UPDATE SET token_hash = EXCLUDED.token_hash, browser_hash = EXCLUDED.browser_hash, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+`
+
+type InsertMagicLinkRequestParams struct {
+	UserID      int64
+	TokenHash   string
+	BrowserHash string
+	CreatedAt   int64
+	ExpiresAt   int64
+}
+
+func (q *Queries) InsertMagicLinkRequest(ctx context.Context, arg InsertMagicLinkRequestParams) error {
+	_, err := q.db.ExecContext(ctx, insertMagicLinkRequest,
+		arg.UserID,
+		arg.TokenHash,
+		arg.BrowserHash,
+		arg.CreatedAt,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const insertPasswordResetRequest = `-- name: InsertPasswordResetRequest :one
+INSERT INTO password_reset_request (user_id, created_at, expires_at, code_hash)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(user_id) DO UPDATE SET created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at, code_hash = EXCLUDED.code_hash
+RETURNING id, user_id, created_at, expires_at, code_hash
+`
+
+type InsertPasswordResetRequestParams struct {
+	UserID    int64
+	CreatedAt int64
+	ExpiresAt int64
+	CodeHash  string
+}
+
+func (q *Queries) InsertPasswordResetRequest(ctx context.Context, arg InsertPasswordResetRequestParams) (PasswordResetRequest, error) {
+	row := q.db.QueryRowContext(ctx, insertPasswordResetRequest,
+		arg.UserID,
+		arg.CreatedAt,
+		arg.ExpiresAt,
+		arg.CodeHash,
+	)
+	var i PasswordResetRequest
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.CodeHash,
+	)
+	return i, err
+}
+
+const insertUserEmailVerificationRequest = `-- name: InsertUserEmailVerificationRequest :one
+INSERT INTO email_verification_request (user_id, created_at, expires_at, code, attempts, send_count, send_window_started_at)
+VALUES (?1, ?2, ?3, ?4, 0, 1, ?2)
+ON CONFLICT(user_id) DO UPDATE SET
+    created_at = EXCLUDED.created_at,
+    expires_at = EXCLUDED.expires_at,
+    code = EXCLUDED.code,
+    attempts = 0,
+    send_count = CASE WHEN email_verification_request.send_window_started_at <= ?5 THEN 1 ELSE email_verification_request.send_count + 1 END,
+    send_window_started_at = CASE WHEN email_verification_request.send_window_started_at <= ?5 THEN EXCLUDED.created_at ELSE email_verification_request.send_window_started_at END
+RETURNING user_id, created_at, expires_at, code, attempts, send_count, send_window_started_at
+`
+
+type InsertUserEmailVerificationRequestParams struct {
+	UserID      int64
+	CreatedAt   int64
+	ExpiresAt   int64
+	Code        string
+	WindowStart int64
+}
+
+func (q *Queries) InsertUserEmailVerificationRequest(ctx context.Context, arg InsertUserEmailVerificationRequestParams) (EmailVerificationRequest, error) {
+	row := q.db.QueryRowContext(ctx, insertUserEmailVerificationRequest,
+		arg.UserID,
+		arg.CreatedAt,
+		arg.ExpiresAt,
+		arg.Code,
+		arg.WindowStart,
+	)
+	var i EmailVerificationRequest
+	err := row.Scan(
+		&i.UserID,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.Code,
+		&i.Attempts,
+		&i.SendCount,
+		&i.SendWindowStartedAt,
+	)
+	return i, err
+}
+
+const listAuthEvents = `-- name: ListAuthEvents :many
+SELECT id, event_type, user_id, email, outcome, reason, ip_address, user_agent, created_at FROM auth_events
+WHERE (?1 IS NULL OR user_id = ?1)
+    AND (?2 IS NULL OR email = ?2)
+    AND (?3 IS NULL OR event_type = ?3)
+    AND (?4 IS NULL OR outcome = ?4)
+    AND (?5 IS NULL OR ip_address = ?5)
+    AND created_at >= ?6
+    AND created_at < ?7
+    AND id < ?8
+ORDER BY id DESC
+LIMIT ?9
+`
+
+type ListAuthEventsParams struct {
+	UserID    sql.NullInt64
+	Email     sql.NullString
+	EventType sql.NullString
+	Outcome   sql.NullString
+	IpAddress sql.NullString
+	Since     int64
+	Until     int64
+	BeforeID  int64
+	Limit     int64
+}
+
+func (q *Queries) ListAuthEvents(ctx context.Context, arg ListAuthEventsParams) ([]AuthEvent, error) {
+	rows, err := q.db.QueryContext(ctx, listAuthEvents,
+		arg.UserID,
+		arg.Email,
+		arg.EventType,
+		arg.Outcome,
+		arg.IpAddress,
+		arg.Since,
+		arg.Until,
+		arg.BeforeID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuthEvent
+	for rows.Next() {
+		var i AuthEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.EventType,
+			&i.UserID,
+			&i.Email,
+			&i.Outcome,
+			&i.Reason,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPendingInvitations = `-- name: ListPendingInvitations :many
+SELECT id, email, token_hash, inviter_id, created_at, expires_at, used_at FROM invitation
+WHERE inviter_id = ? AND used_at IS NULL AND expires_at > ?
+ORDER BY created_at DESC, id DESC
+`
+
+type ListPendingInvitationsParams struct {
+	InviterID int64
+	ExpiresAt int64
+}
+
+func (q *Queries) ListPendingInvitations(ctx context.Context, arg ListPendingInvitationsParams) ([]Invitation, error) {
+	rows, err := q.db.QueryContext(ctx, listPendingInvitations, arg.InviterID, arg.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Invitation
+	for rows.Next() {
+		var i Invitation
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.TokenHash,
+			&i.InviterID,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.UsedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserAccessTokens = `-- name: ListUserAccessTokens :many
+SELECT id, user_id, name, token_hash, scopes, created_at, expires_at, last_used_at FROM access_token WHERE user_id = ? ORDER BY created_at DESC, id DESC
+`
+
+func (q *Queries) ListUserAccessTokens(ctx context.Context, userID int64) ([]AccessToken, error) {
+	rows, err := q.db.QueryContext(ctx, listUserAccessTokens, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AccessToken
+	for rows.Next() {
+		var i AccessToken
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.TokenHash,
+			&i.Scopes,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserAuthEvents = `-- name: ListUserAuthEvents :many
+SELECT id, event_type, user_id, email, outcome, reason, ip_address, user_agent, created_at FROM auth_events WHERE user_id = ? ORDER BY id DESC LIMIT ?
+`
+
+type ListUserAuthEventsParams struct {
+	UserID sql.NullInt64
+	Limit  int64
+}
+
+func (q *Queries) ListUserAuthEvents(ctx context.Context, arg ListUserAuthEventsParams) ([]AuthEvent, error) {
+	rows, err := q.db.QueryContext(ctx, listUserAuthEvents, arg.UserID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuthEvent
+	for rows.Next() {
+		var i AuthEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.EventType,
+			&i.UserID,
+			&i.Email,
+			&i.Outcome,
+			&i.Reason,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserKnownDevices = `-- name: ListUserKnownDevices :many
+SELECT id, user_id, token_hash, fingerprint, user_agent, ip_address, created_at, last_seen_at, report_token_hash, report_expires_at FROM known_device WHERE user_id = ? ORDER BY last_seen_at DESC, id DESC
+`
+
+func (q *Queries) ListUserKnownDevices(ctx context.Context, userID int64) ([]KnownDevice, error) {
+	rows, err := q.db.QueryContext(ctx, listUserKnownDevices, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []KnownDevice
+	for rows.Next() {
+		var i KnownDevice
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.TokenHash,
+			&i.Fingerprint,
+			&i.UserAgent,
+			&i.IpAddress,
+			&i.CreatedAt,
+			&i.LastSeenAt,
+			&i.ReportTokenHash,
+			&i.ReportExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserSessions = `-- name: ListUserSessions :many
+SELECT id, user_id, expires_at, created_at, two_factor_pending, ip_address, user_agent, last_seen_at, impersonator_id, remember_me, absolute_expires_at, device_id, two_factor_attempts FROM session WHERE user_id = ? AND expires_at > ? ORDER BY last_seen_at DESC
+`
+
+type ListUserSessionsParams struct {
+	UserID    int64
+	ExpiresAt int64
+}
+
+func (q *Queries) ListUserSessions(ctx context.Context, arg ListUserSessionsParams) ([]Session, error) {
+	rows, err := q.db.QueryContext(ctx, listUserSessions, arg.UserID, arg.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Session
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+			&i.TwoFactorPending,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.LastSeenAt,
+			&i.ImpersonatorID,
+			&i.RememberMe,
+			&i.AbsoluteExpiresAt,
+			&i.DeviceID,
+			&i.TwoFactorAttempts,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserWebAuthnCredentials = `-- name: ListUserWebAuthnCredentials :many
+SELECT id, user_id, credential_id, public_key, sign_count, name, created_at, last_used_at FROM webauthn_credential WHERE user_id = ? ORDER BY created_at DESC, id DESC
+`
+
+func (q *Queries) ListUserWebAuthnCredentials(ctx context.Context, userID int64) ([]WebauthnCredential, error) {
+	rows, err := q.db.QueryContext(ctx, listUserWebAuthnCredentials, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebauthnCredential
+	for rows.Next() {
+		var i WebauthnCredential
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CredentialID,
+			&i.PublicKey,
+			&i.SignCount,
+			&i.Name,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsers = `-- name: ListUsers :many
+SELECT u.id, u.email, u.role, u.email_verified, u.disabled, u.created_at, COUNT(t.id) AS todo_count
+FROM user u
+LEFT JOIN todos t ON t.user_id = u.id
+WHERE u.email LIKE ? ESCAPE '\' AND u.id > ?
+GROUP BY u.id
+ORDER BY u.id
+LIMIT ?
+`
+
+type ListUsersParams struct {
+	Pattern string
+	AfterID int64
+	Limit   int64
+}
+
+type ListUsersRow struct {
+	ID            int64
+	Email         string
+	Role          string
+	EmailVerified int64
+	Disabled      int64
+	CreatedAt     sql.NullString
+	TodoCount     int64
+}
+
+func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]ListUsersRow, error) {
+	rows, err := q.db.QueryContext(ctx, listUsers, arg.Pattern, arg.AfterID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUsersRow
+	for rows.Next() {
+		var i ListUsersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.Role,
+			&i.EmailVerified,
+			&i.Disabled,
+			&i.CreatedAt,
+			&i.TodoCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockLoginAttempt = `-- name: LockLoginAttempt :exec
+UPDATE login_attempt SET locked_until = ?, unlock_token_hash = ? WHERE email = ?
 `
 
-type CreateSessionParams struct {
-	ID        string
-	UserID    int64
-	ExpiresAt int64
+type LockLoginAttemptParams struct {
+	LockedUntil     int64
+	UnlockTokenHash sql.NullString
+	Email           string
 }
 
-func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error) {
-	row := q.db.QueryRowContext(ctx, createSession, arg.ID, arg.UserID, arg.ExpiresAt)
-	var i Session
-	err := row.Scan(
-		&i.ID,
-		&i.UserID,
-		&i.ExpiresAt,
-		&i.CreatedAt,
-	)
-	return i, err
+func (q *Queries) LockLoginAttempt(ctx context.Context, arg LockLoginAttemptParams) error {
+	_, err := q.db.ExecContext(ctx, lockLoginAttempt, arg.LockedUntil, arg.UnlockTokenHash, arg.Email)
+	return err
 }
 
-const createTodo = `-- name: CreateTodo :one
-INSERT INTO todos (name, user_id, description) VALUES (?, ?, ?) RETURNING id, user_id, name, description, is_complete
+const markRecoveryCodeUsed = `-- name: MarkRecoveryCodeUsed :execrows
+UPDATE recovery_code SET used_at = ? WHERE id = ? AND used_at IS NULL
 `
 
-type CreateTodoParams struct {
-	Name        string
-	UserID      int64
-	Description sql.NullString
+type MarkRecoveryCodeUsedParams struct {
+	UsedAt sql.NullInt64
+	ID     int64
 }
 
-func (q *Queries) CreateTodo(ctx context.Context, arg CreateTodoParams) (Todo, error) {
-	row := q.db.QueryRowContext(ctx, createTodo, arg.Name, arg.UserID, arg.Description)
-	var i Todo
-	err := row.Scan(
-		&i.ID,
-		&i.UserID,
-		&i.Name,
-		&i.Description,
-		&i.IsComplete,
-	)
-	return i, err
+func (q *Queries) MarkRecoveryCodeUsed(ctx context.Context, arg MarkRecoveryCodeUsedParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markRecoveryCodeUsed, arg.UsedAt, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const createUser = `-- name: CreateUser :one
-INSERT INTO user (email, password_hash) VALUES (?, ?) RETURNING id, email, password_hash, email_verified, created_at, updated_at
+const ping = `-- name: Ping :exec
+SELECT 1
 `
 
-type CreateUserParams struct {
-	Email        string
-	PasswordHash string
+func (q *Queries) Ping(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, ping)
+	return err
 }
 
-func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.PasswordHash)
-	var i User
+const recordLoginFailure = `-- name: RecordLoginFailure :one
+INSERT INTO login_attempt (email, failed_count, last_failed_at)
+VALUES (?, 1, ?)
+ON CONFLICT(email) DO UPDATE SET
+    failed_count = CASE WHEN login_attempt.last_failed_at < ? THEN 1 ELSE login_attempt.failed_count + 1 END,
+    last_failed_at = EXCLUDED.last_failed_at
+RETURNING email, failed_count, last_failed_at, locked_until, unlock_token_hash
+`
+
+type RecordLoginFailureParams struct {
+	Email       string
+	FailedAt    int64
+	WindowStart int64
+}
+
+func (q *Queries) RecordLoginFailure(ctx context.Context, arg RecordLoginFailureParams) (LoginAttempt, error) {
+	row := q.db.QueryRowContext(ctx, recordLoginFailure, arg.Email, arg.FailedAt, arg.WindowStart)
+	var i LoginAttempt
 	err := row.Scan(
-		&i.ID,
 		&i.Email,
-		&i.PasswordHash,
-		&i.EmailVerified,
-		&i.CreatedAt,
-		&i.UpdatedAt,
+		&i.FailedCount,
+		&i.LastFailedAt,
+		&i.LockedUntil,
+		&i.UnlockTokenHash,
 	)
 	return i, err
 }
 
-const deleteSession = `-- name: DeleteSession :exec
-DELETE FROM session WHERE id = ?
+const rehashUserPassword = `-- name: RehashUserPassword :execrows
+UPDATE user SET password_hash = ?, updated_at = datetime('now')
+WHERE id = ? AND password_hash = ?
 `
 
-func (q *Queries) DeleteSession(ctx context.Context, id string) error {
-	_, err := q.db.ExecContext(ctx, deleteSession, id)
-	return err
+type RehashUserPasswordParams struct {
+	PasswordHash         string
+	ID                   int64
+	PreviousPasswordHash string
 }
 
-const deleteTodo = `-- name: DeleteTodo :exec
-DELETE FROM todos WHERE id = ? AND user_id = ?
+func (q *Queries) RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rehashUserPassword, arg.PasswordHash, arg.ID, arg.PreviousPasswordHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUserDisabled = `-- name: SetUserDisabled :execrows
+UPDATE user SET disabled = ?, updated_at = datetime('now') WHERE id = ?
 `
 
-type DeleteTodoParams struct {
-	ID     int64
-	UserID int64
+type SetUserDisabledParams struct {
+	Disabled int64
+	ID       int64
 }
 
-func (q *Queries) DeleteTodo(ctx context.Context, arg DeleteTodoParams) error {
-	_, err := q.db.ExecContext(ctx, deleteTodo, arg.ID, arg.UserID)
-	return err
+func (q *Queries) SetUserDisabled(ctx context.Context, arg SetUserDisabledParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserDisabled, arg.Disabled, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const deleteUserEmailVerificationRequest = `-- name: DeleteUserEmailVerificationRequest :exec
-DELETE FROM email_verification_request WHERE user_id = ?
+const setUserEmailVerified = `-- name: SetUserEmailVerified :exec
+UPDATE user SET email_verified = 1 WHERE id = ?
 `
 
-func (q *Queries) DeleteUserEmailVerificationRequest(ctx context.Context, userID int64) error {
-	_, err := q.db.ExecContext(ctx, deleteUserEmailVerificationRequest, userID)
+func (q *Queries) SetUserEmailVerified(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, setUserEmailVerified, id)
 	return err
 }
 
-const getTodo = `-- name: GetTodo :one
-SELECT id, user_id, name, description, is_complete FROM todos WHERE id = ? AND user_id = ?
+const setUserPasswordResetRequired = `-- name: SetUserPasswordResetRequired :exec
+UPDATE user SET password_reset_required = 1, updated_at = datetime('now') WHERE id = ?
 `
 
-type GetTodoParams struct {
-	ID     int64
-	UserID int64
-}
-
-func (q *Queries) GetTodo(ctx context.Context, arg GetTodoParams) (Todo, error) {
-	row := q.db.QueryRowContext(ctx, getTodo, arg.ID, arg.UserID)
-	var i Todo
-	err := row.Scan(
-		&i.ID,
-		&i.UserID,
-		&i.Name,
-		&i.Description,
-		&i.IsComplete,
-	)
-	return i, err
+func (q *Queries) SetUserPasswordResetRequired(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, setUserPasswordResetRequired, id)
+	return err
 }
 
-const getTodos = `-- name: GetTodos :many
-SELECT id, user_id, name, description, is_complete FROM todos WHERE user_id = ?
+const setUserRoleByEmail = `-- name: SetUserRoleByEmail :execrows
+UPDATE user SET role = ?, updated_at = datetime('now') WHERE lower(email) = ? AND email_verified = 1
 `
 
-func (q *Queries) GetTodos(ctx context.Context, userID int64) ([]Todo, error) {
-	rows, err := q.db.QueryContext(ctx, getTodos, userID)
+type SetUserRoleByEmailParams struct {
+	Role  string
+	Email string
+}
+
+func (q *Queries) SetUserRoleByEmail(ctx context.Context, arg SetUserRoleByEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserRoleByEmail, arg.Role, arg.Email)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []Todo
-	for rows.Next() {
-		var i Todo
-		if err := rows.Scan(
-			&i.ID,
-			&i.UserID,
-			&i.Name,
-			&i.Description,
-			&i.IsComplete,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Close(); err != nil {
-		return nil, err
+		return 0, err
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+	return result.RowsAffected()
 }
 
-const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, email, password_hash, email_verified, created_at, updated_at FROM user WHERE email = ?
+const touchAccessToken = `-- name: TouchAccessToken :exec
+UPDATE access_token SET last_used_at = ? WHERE id = ?
 `
 
-func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
-	var i User
-	err := row.Scan(
-		&i.ID,
-		&i.Email,
-		&i.PasswordHash,
-		&i.EmailVerified,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-	)
-	return i, err
+type TouchAccessTokenParams struct {
+	LastUsedAt sql.NullInt64
+	ID         int64
 }
 
-const getUserEmailVerificationRequest = `-- name: GetUserEmailVerificationRequest :one
-SELECT user_id, created_at, expires_at, code FROM email_verification_request WHERE user_id = ?
-`
-
-func (q *Queries) GetUserEmailVerificationRequest(ctx context.Context, userID int64) (EmailVerificationRequest, error) {
-	row := q.db.QueryRowContext(ctx, getUserEmailVerificationRequest, userID)
-	var i EmailVerificationRequest
-	err := row.Scan(
-		&i.UserID,
-		&i.CreatedAt,
-		&i.ExpiresAt,
-		&i.Code,
-	)
-	return i, err
+func (q *Queries) TouchAccessToken(ctx context.Context, arg TouchAccessTokenParams) error {
+	_, err := q.db.ExecContext(ctx, touchAccessToken, arg.LastUsedAt, arg.ID)
+	return err
 }
 
-const insertUserEmailVerificationRequest = `-- name: InsertUserEmailVerificationRequest :one
-INSERT INTO email_verification_request (user_id, created_at, expires_at, code) 
-VALUES (?, ?, ?, ?)
-ON CONFLICT(user_id) DO UPDATE SET created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at, code = EXCLUDED.code
-RETURNING user_id, created_at, expires_at, code
+const touchKnownDevice = `-- name: TouchKnownDevice :exec
+UPDATE known_device SET token_hash = ?, user_agent = ?, ip_address = ?, last_seen_at = ? WHERE id = ?
 `
 
-type InsertUserEmailVerificationRequestParams struct {
-	UserID    int64
-	CreatedAt int64
-	ExpiresAt int64
-	Code      string
+type TouchKnownDeviceParams struct {
+	TokenHash  string
+	UserAgent  string
+	IpAddress  string
+	LastSeenAt int64
+	ID         int64
 }
 
-func (q *Queries) InsertUserEmailVerificationRequest(ctx context.Context, arg InsertUserEmailVerificationRequestParams) (EmailVerificationRequest, error) {
-	row := q.db.QueryRowContext(ctx, insertUserEmailVerificationRequest,
-		arg.UserID,
-		arg.CreatedAt,
-		arg.ExpiresAt,
-		arg.Code,
-	)
-	var i EmailVerificationRequest
-	err := row.Scan(
-		&i.UserID,
-		&i.CreatedAt,
-		&i.ExpiresAt,
-		&i.Code,
+func (q *Queries) TouchKnownDevice(ctx context.Context, arg TouchKnownDeviceParams) error {
+	_, err := q.db.ExecContext(ctx, touchKnownDevice,
+		arg.TokenHash,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.LastSeenAt,
+		arg.ID,
 	)
-	return i, err
+	return err
 }
 
-const ping = `-- name: Ping :exec
-SELECT 1
+const touchSession = `-- name: TouchSession :exec
+UPDATE session SET last_seen_at = ? WHERE id = ?
 `
 
-func (q *Queries) Ping(ctx context.Context) error {
-	_, err := q.db.ExecContext(ctx, ping)
-	return err
+type TouchSessionParams struct {
+	LastSeenAt int64
+	ID         string
 }
 
-const setUserEmailVerified = `-- name: SetUserEmailVerified :exec
-UPDATE user SET email_verified = 1 WHERE id = ?
-`
-
-func (q *Queries) SetUserEmailVerified(ctx context.Context, id int64) error {
-	_, err := q.db.ExecContext(ctx, setUserEmailVerified, id)
+func (q *Queries) TouchSession(ctx context.Context, arg TouchSessionParams) error {
+	_, err := q.db.ExecContext(ctx, touchSession, arg.LastSeenAt, arg.ID)
 	return err
 }
 
 const updateSession = `-- name: UpdateSession :one
-UPDATE session SET expires_at = ? WHERE id = ? RETURNING id, user_id, expires_at, created_at
+UPDATE session SET expires_at = ? WHERE id = ? RETURNING id, user_id, expires_at, created_at, two_factor_pending, ip_address, user_agent, last_seen_at, impersonator_id, remember_me, absolute_expires_at, device_id, two_factor_attempts
 `
 
 type UpdateSessionParams struct {
@@ -265,6 +1982,15 @@ func (q *Queries) UpdateSession(ctx context.Context, arg UpdateSessionParams) (S
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.CreatedAt,
+		&i.TwoFactorPending,
+		&i.IpAddress,
+		&i.UserAgent,
+		&i.LastSeenAt,
+		&i.ImpersonatorID,
+		&i.RememberMe,
+		&i.AbsoluteExpiresAt,
+		&i.DeviceID,
+		&i.TwoFactorAttempts,
 	)
 	return i, err
 }
@@ -303,8 +2029,132 @@ func (q *Queries) UpdateTodo(ctx context.Context, arg UpdateTodoParams) (Todo, e
 	return i, err
 }
 
+const updateUserEmail = `-- name: UpdateUserEmail :exec
+UPDATE user SET email = ?, email_verified = 1, updated_at = datetime('now') WHERE id = ?
+`
+
+type UpdateUserEmailParams struct {
+	Email string
+	ID    int64
+}
+
+func (q *Queries) UpdateUserEmail(ctx context.Context, arg UpdateUserEmailParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserEmail, arg.Email, arg.ID)
+	return err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE user SET password_hash = ?, password_reset_required = 0, updated_at = datetime('now') WHERE id = ?
+`
+
+type UpdateUserPasswordParams struct {
+	PasswordHash string
+	ID           int64
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.PasswordHash, arg.ID)
+	return err
+}
+
+const upsertTOTPCredential = `-- name: UpsertTOTPCredential :exec
+INSERT INTO totp_credential (user_id, encrypted_secret, enabled, created_at)
+VALUES (?, ?, 0, ?)
+ON CONFLICT(user_id) DO UPDATE SET encrypted_secret = EXCLUDED.encrypted_secret, enabled = 0, created_at = EXCLUDED.created_at, next_step = 0
+`
+
+type UpsertTOTPCredentialParams struct {
+	UserID          int64
+	EncryptedSecret []byte
+	CreatedAt       int64
+}
+
+func (q *Queries) UpsertTOTPCredential(ctx context.Context, arg UpsertTOTPCredentialParams) error {
+	_, err := q.db.ExecContext(ctx, upsertTOTPCredential, arg.UserID, arg.EncryptedSecret, arg.CreatedAt)
+	return err
+}
+
+const useInvitation = `-- name: UseInvitation :execrows
+UPDATE invitation SET used_at = ?1
+WHERE id = ?2 AND used_at IS NULL AND expires_at > ?1
+`
+
+type UseInvitationParams struct {
+	UsedAt sql.NullInt64
+	ID     int64
+}
+
+func (q *Queries) UseInvitation(ctx context.Context, arg UseInvitationParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useInvitation, arg.UsedAt, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const useWebAuthnCredential = `-- name: UseWebAuthnCredential :execrows
+UPDATE webauthn_credential SET sign_count = ?, last_used_at = ?
+WHERE id = ? AND sign_count = ?
+`
+
+type UseWebAuthnCredentialParams struct {
+	SignCount         int64
+	LastUsedAt        sql.NullInt64
+	ID                int64
+	PreviousSignCount int64
+}
+
+func (q *Queries) UseWebAuthnCredential(ctx context.Context, arg UseWebAuthnCredentialParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useWebAuthnCredential,
+		arg.SignCount,
+		arg.LastUsedAt,
+		arg.ID,
+		arg.PreviousSignCount,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const validateAccessToken = `-- name: ValidateAccessToken :one
+SELECT t.id, t.user_id, t.scopes, t.expires_at, t.last_used_at, u.email, u.email_verified, u.role, u.disabled
+FROM access_token t
+INNER JOIN user u ON u.id = t.user_id
+WHERE t.token_hash = ?
+`
+
+type ValidateAccessTokenRow struct {
+	ID            int64
+	UserID        int64
+	Scopes        string
+	ExpiresAt     int64
+	LastUsedAt    sql.NullInt64
+	Email         string
+	EmailVerified int64
+	Role          string
+	Disabled      int64
+}
+
+func (q *Queries) ValidateAccessToken(ctx context.Context, tokenHash string) (ValidateAccessTokenRow, error) {
+	row := q.db.QueryRowContext(ctx, validateAccessToken, tokenHash)
+	var i ValidateAccessTokenRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Scopes,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.Email,
+		&i.EmailVerified,
+		&i.Role,
+		&i.Disabled,
+	)
+	return i, err
+}
+
 const validateEmailVerificationRequest = `-- name: ValidateEmailVerificationRequest :one
-DELETE FROM email_verification_request WHERE user_id = ? AND code = ? AND expires_at > ? RETURNING user_id, created_at, expires_at, code
+DELETE FROM email_verification_request WHERE user_id = ? AND code = ? AND expires_at > ? RETURNING user_id, created_at, expires_at, code, attempts, send_count, send_window_started_at
 `
 
 type ValidateEmailVerificationRequestParams struct {
@@ -321,23 +2171,37 @@ func (q *Queries) ValidateEmailVerificationRequest(ctx context.Context, arg Vali
 		&i.CreatedAt,
 		&i.ExpiresAt,
 		&i.Code,
+		&i.Attempts,
+		&i.SendCount,
+		&i.SendWindowStartedAt,
 	)
 	return i, err
 }
 
 const validateSessionToken = `-- name: ValidateSessionToken :one
-SELECT s.id, s.user_id as user_id, s.expires_at, u.email, u.email_verified
+SELECT s.id, s.user_id as user_id, s.expires_at, s.two_factor_pending, s.last_seen_at, s.impersonator_id, s.remember_me, s.absolute_expires_at, u.email, u.email_verified, u.role, u.disabled, a.email AS impersonator_email, a.role AS impersonator_role, a.disabled AS impersonator_disabled
 FROM session s 
 INNER JOIN user u ON u.id = s.user_id 
+LEFT JOIN user a ON a.id = s.impersonator_id
 WHERE s.id = ?
 `
 
 type ValidateSessionTokenRow struct {
-	ID            string
-	UserID        int64
-	ExpiresAt     int64
-	Email         string
-	EmailVerified int64
+	ID                   string
+	UserID               int64
+	ExpiresAt            int64
+	TwoFactorPending     int64
+	LastSeenAt           int64
+	ImpersonatorID       sql.NullInt64
+	RememberMe           int64
+	AbsoluteExpiresAt    int64
+	Email                string
+	EmailVerified        int64
+	Role                 string
+	Disabled             int64
+	ImpersonatorEmail    sql.NullString
+	ImpersonatorRole     sql.NullString
+	ImpersonatorDisabled sql.NullInt64
 }
 
 func (q *Queries) ValidateSessionToken(ctx context.Context, id string) (ValidateSessionTokenRow, error) {
@@ -347,8 +2211,18 @@ func (q *Queries) ValidateSessionToken(ctx context.Context, id string) (Validate
 		&i.ID,
 		&i.UserID,
 		&i.ExpiresAt,
+		&i.TwoFactorPending,
+		&i.LastSeenAt,
+		&i.ImpersonatorID,
+		&i.RememberMe,
+		&i.AbsoluteExpiresAt,
 		&i.Email,
 		&i.EmailVerified,
+		&i.Role,
+		&i.Disabled,
+		&i.ImpersonatorEmail,
+		&i.ImpersonatorRole,
+		&i.ImpersonatorDisabled,
 	)
 	return i, err
 }
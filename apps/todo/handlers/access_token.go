@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web/forms"
+	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
+)
+
+func handleRenderAccessTokensView(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		tokens, err := as.ListAccessTokens(r.Context(), user.Id)
+		if err != nil {
+			slog.Error("error listing access tokens", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		csrfToken := csrf.GenerateToken()
+		web.RenderAccessTokensPage(w, csrfToken, tokens)
+	}
+}
+
+// handleCreateAccessToken issues a token and shows its secret, this is the only time it is visible
+func handleCreateAccessToken(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		form, err := forms.AccessTokenFrom(r)
+		if err != nil {
+			csrfToken := csrf.GenerateToken()
+			web.RenderAccessTokenForm(w, csrfToken, err.Error())
+			return
+		}
+
+		lifetime := time.Duration(form.ExpiresInDays) * 24 * time.Hour
+		token, err := as.CreateAccessToken(r.Context(), user.Id, form.Name, form.Scopes, lifetime)
+		if err != nil {
+			slog.Error("error creating access token", "error", err)
+			csrfToken := csrf.GenerateToken()
+			web.RenderAccessTokenForm(w, csrfToken, err.Error())
+			return
+		}
+
+		web.RenderAccessTokenCreated(w, form.Name, token)
+	}
+}
+
+func handleRevokeAccessToken(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+
+		err = as.RevokeAccessToken(r.Context(), user.Id, id)
+		if errors.Is(err, auth.ErrAccessTokenNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.Error("error revoking access token", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
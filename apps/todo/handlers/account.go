@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web/forms"
+	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
+)
+
+func handleRenderAccountSettings(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		enabled, err := as.TOTPEnabled(r.Context(), user.Id)
+		if err != nil {
+			slog.Error("error checking two-factor status", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		csrfToken := csrf.GenerateToken()
+		web.RenderAccountSettingsPage(w, csrfToken, enabled)
+	}
+}
+
+// handleExportAccount downloads the personal data of the user as a JSON file
+func handleExportAccount(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		export, err := as.ExportAccount(r.Context(), user.Id)
+		if err != nil {
+			slog.Error("error exporting account", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		filename := fmt.Sprintf("todo-export-%s.json", export.ExportedAt.Format("2006-01-02"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.Header().Set("Cache-Control", "no-store")
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(export); err != nil {
+			slog.Error("error writing account export", "error", err)
+		}
+	}
+}
+
+func handleDeleteAccount(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		enabled, err := as.TOTPEnabled(r.Context(), user.Id)
+		if err != nil {
+			slog.Error("error checking two-factor status", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		form, err := forms.DeleteAccountFrom(r)
+		if err != nil {
+			csrfToken := csrf.GenerateToken()
+			web.RenderDeleteAccountForm(w, csrfToken, enabled, err.Error())
+			return
+		}
+
+		err = as.DeleteAccount(r.Context(), user.Id, form.Password, form.Code)
+		if err != nil {
+			slog.Error("error deleting account", "error", err)
+			csrfToken := csrf.GenerateToken()
+			web.RenderDeleteAccountForm(w, csrfToken, enabled, err.Error())
+			return
+		}
+
+		auth.DeleteSessionCookie(w)
+
+		w.Header().Set("HX-Redirect", "/register")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web/forms"
+	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
+)
+
+// handleRenderAdminConsole lists the accounts matching the search, a page at a time
+func handleRenderAdminConsole(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		form, err := forms.UserSearchFrom(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		users, err := as.ListUsers(r.Context(), auth.UserFilter{Query: form.Query, After: form.After})
+		if err != nil {
+			slog.Error("error listing users", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		items := make([]web.AdminUserComponentData, 0, len(users))
+		for _, user := range users {
+			items = append(items, adminUserItem(csrf, admin, user))
+		}
+		var nextAfter int64
+		if len(users) > 0 {
+			nextAfter = users[len(users)-1].Id
+		}
+
+		web.RenderAdminPage(w, form.Query, items, nextAfter)
+	}
+}
+
+// handleSetUserDisabled disables or re-enables an account and answers with its updated row
+func handleSetUserDisabled(as *auth.Service, csrf *httpserver.CSRFProtection, disabled bool) http.HandlerFunc {
+	return handleAdminUserAction(as, csrf, func(r *http.Request, admin model.User, userId int64) error {
+		return as.SetUserDisabled(r.Context(), admin, userId, disabled)
+	})
+}
+
+// handleForceVerifyEmail marks the email of an account as verified and answers with its updated row
+func handleForceVerifyEmail(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return handleAdminUserAction(as, csrf, func(r *http.Request, admin model.User, userId int64) error {
+		return as.ForceVerifyEmail(r.Context(), admin, userId)
+	})
+}
+
+func handleAdminUserAction(
+	as *auth.Service,
+	csrf *httpserver.CSRFProtection,
+	action func(r *http.Request, admin model.User, userId int64) error,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+
+		err = action(r, admin, userId)
+		if errors.Is(err, auth.ErrUserNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, auth.ErrCannotManageSelf) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			slog.Error("error managing user", "error", err, "userId", userId)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		user, err := as.GetUserSummary(r.Context(), userId)
+		if err != nil {
+			slog.Error("error getting user", "error", err, "userId", userId)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		web.RenderAdminUser(w, adminUserItem(csrf, admin, user))
+	}
+}
+
+func adminUserItem(csrf *httpserver.CSRFProtection, admin model.User, user model.UserSummary) web.AdminUserComponentData {
+	return web.AdminUserComponentData{
+		User:      user,
+		Self:      user.Id == admin.Id,
+		CSRFToken: csrf.GenerateToken(),
+	}
+}
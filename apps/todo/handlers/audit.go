@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web/forms"
+)
+
+// handleRenderSecurityActivity shows the user the recent authentication events of their account
+func handleRenderSecurityActivity(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		events, err := as.ListUserAuthEvents(r.Context(), user.Id)
+		if err != nil {
+			slog.Error("error listing auth events", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		web.RenderSecurityActivityPage(w, events)
+	}
+}
+
+type authEventsResponse struct {
+	Events []model.AuthEvent `json:"events"`
+	// NextBefore is passed as the before parameter to get the following page, until a page comes back empty
+	NextBefore int64 `json:"next_before,omitempty"`
+}
+
+// handleListAuthEvents returns the audit log of every user as JSON, filtered by the query string.
+// Only admins reach it.
+func handleListAuthEvents(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		form, err := forms.AuthEventFilterFrom(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		filter := auth.AuthEventFilter{
+			UserId:    form.UserId,
+			Email:     form.Email,
+			Type:      form.Type,
+			Outcome:   form.Outcome,
+			IPAddress: form.IPAddress,
+			Since:     form.Since,
+			Until:     form.Until,
+			Before:    form.Before,
+			Limit:     form.Limit,
+		}
+		events, err := as.ListAuthEvents(r.Context(), filter)
+		if err != nil {
+			slog.Error("error listing auth events", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := authEventsResponse{Events: events}
+		if len(events) > 0 {
+			response.NextBefore = events[len(events)-1].Id
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			slog.Error("error writing auth events", "error", err)
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package handlers
 
 import (
+	"errors"
 	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
 	"github.com/AltSoyuz/soy-experiments/apps/todo/web"
 	"github.com/AltSoyuz/soy-experiments/apps/todo/web/forms"
 	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
@@ -20,7 +25,7 @@ func handleLogout(as *auth.Service) http.HandlerFunc {
 			return
 		}
 
-		err = as.InvalidateSession(r.Context(), s.Id)
+		err = as.Logout(r.Context(), s)
 		if err != nil {
 			slog.Error("error invalidating session", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -28,6 +33,9 @@ func handleLogout(as *auth.Service) http.HandlerFunc {
 		}
 
 		auth.DeleteSessionCookie(w)
+		if s.Impersonation != nil {
+			auth.DeleteImpersonatorCookie(w)
+		}
 
 		w.Header().Set("HX-Redirect", "/login")
 	}
@@ -44,9 +52,15 @@ func handleAuthWithPassword(authService *auth.Service, csrf *httpserver.CSRFProt
 			return
 		}
 
-		session, token, err := authService.AuthenticateWithPassword(ctx, form.Email, form.Password)
+		client := auth.ClientInfoFrom(r)
+		client.RememberMe = form.RememberMe
+		session, token, err := authService.AuthenticateWithPassword(ctx, form.Email, form.Password, client)
 		if err != nil {
 			slog.Error("error authenticating with password", "error", err)
+			var throttled *auth.LoginThrottledError
+			if errors.As(err, &throttled) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			}
 			csrftoken := csrf.GenerateToken()
 			web.RenderLoginForm(w, csrftoken, err.Error())
 			return
@@ -54,29 +68,62 @@ func handleAuthWithPassword(authService *auth.Service, csrf *httpserver.CSRFProt
 
 		auth.SetSessionCookie(w, token, session.ExpiresAt)
 
-		w.Header().Set("HX-Redirect", "/")
+		w.Header().Set("HX-Redirect", loginRedirectLocation(session))
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-func handleRenderRegisterView(csrf *httpserver.CSRFProtection) http.HandlerFunc {
+// loginRedirectLocation sends sessions still waiting for their second factor to the code prompt
+func loginRedirectLocation(session model.Session) string {
+	if session.TwoFactorPending {
+		return "/login/two-factor"
+	}
+	return "/"
+}
+
+// handleRenderRegisterView shows the register form, filled in from the invitation when the link carries one.
+// When registering is not possible, the reason is shown instead of the form.
+func handleRenderRegisterView(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		invite := r.URL.Query().Get("invite")
+		invitation, err := as.PrepareRegistration(r.Context(), invite)
+		if err != nil {
+			if !errors.Is(err, auth.ErrRegistrationClosed) &&
+				!errors.Is(err, auth.ErrInvitationRequired) &&
+				!errors.Is(err, auth.ErrInvalidInvitation) {
+				slog.Error("error preparing registration", "error", err)
+			}
+			web.RenderRegisterPage(w, "", "", "", err.Error())
+			return
+		}
+
 		csrfToken := csrf.GenerateToken()
-		web.RenderRegisterPage(w, csrfToken)
+		web.RenderRegisterPage(w, csrfToken, invite, invitation.Email, "")
 	}
 }
 
-func handleRenderLoginView(csrf *httpserver.CSRFProtection) http.HandlerFunc {
+func handleRenderLoginView(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		csrfToken := csrf.GenerateToken()
-		web.RenderLoginPage(w, csrfToken)
+		passkeyCSRFToken := csrf.GenerateToken()
+		web.RenderLoginPage(w, csrfToken, passkeyCSRFToken, as.OAuthProviderNames())
 	}
 }
 
-func handleRenderVerifyEmail(csrf *httpserver.CSRFProtection) http.HandlerFunc {
+func handleRenderVerifyEmail(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// Without a session the code form still renders and reports the error on submit
+		var wait time.Duration
+		if token := auth.GetTokenFromCookie(r); token != "" {
+			var err error
+			wait, err = as.VerificationResendWait(r.Context(), token)
+			if err != nil {
+				slog.Warn("error getting verification resend wait", "error", err)
+			}
+		}
+
 		csrfToken := csrf.GenerateToken()
-		web.RenderVerifyEmail(w, csrfToken)
+		web.RenderVerifyEmail(w, csrfToken, resendIn(wait))
 	}
 }
 
@@ -105,3 +152,129 @@ func handleEmailVerification(as *auth.Service, csrf *httpserver.CSRFProtection)
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
+
+func handleResendVerificationEmail(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		token := auth.GetTokenFromCookie(r)
+
+		err := as.ResendVerificationEmail(ctx, token)
+		if err != nil {
+			slog.Error("error resending verification email", "error", err)
+			var throttled *auth.VerificationResendThrottledError
+			wait := ""
+			if errors.As(err, &throttled) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+				wait = resendIn(throttled.RetryAfter)
+			}
+			csrfToken := csrf.GenerateToken()
+			web.RenderResendVerificationForm(w, csrfToken, wait, err.Error(), "")
+			return
+		}
+
+		wait, err := as.VerificationResendWait(ctx, token)
+		if err != nil {
+			slog.Warn("error getting verification resend wait", "error", err)
+		}
+
+		csrfToken := csrf.GenerateToken()
+		web.RenderResendVerificationForm(w, csrfToken, resendIn(wait), "", "A new code was sent to your email address.")
+	}
+}
+
+// resendIn is the wait shown on the verify email page, empty once a new code can be sent
+func resendIn(wait time.Duration) string {
+	if wait <= 0 {
+		return ""
+	}
+	return auth.FormatRetryAfter(wait)
+}
+
+func handleRenderForgotPasswordView(csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		csrfToken := csrf.GenerateToken()
+		web.RenderForgotPasswordPage(w, csrfToken)
+	}
+}
+
+func handleForgotPassword(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		form, err := forms.EmailFrom(r)
+		if err != nil {
+			csrfToken := csrf.GenerateToken()
+			web.RenderForgotPasswordForm(w, csrfToken, err.Error(), "")
+			return
+		}
+
+		err = as.RequestPasswordReset(r.Context(), form.Email)
+		if err != nil {
+			slog.Error("error requesting password reset", "error", err)
+			csrfToken := csrf.GenerateToken()
+			web.RenderForgotPasswordForm(w, csrfToken, "could not send the reset link, please try again", "")
+			return
+		}
+
+		csrfToken := csrf.GenerateToken()
+		web.RenderForgotPasswordForm(w, csrfToken, "", "If an account exists for this email, a reset link is on its way.")
+	}
+}
+
+func handleRenderResetPasswordView(csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		csrfToken := csrf.GenerateToken()
+		web.RenderResetPasswordPage(w, csrfToken, r.URL.Query().Get("code"))
+	}
+}
+
+func handleResetPassword(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		form, err := forms.ResetPasswordFrom(r)
+		if err != nil {
+			csrfToken := csrf.GenerateToken()
+			web.RenderResetPasswordForm(w, csrfToken, form.Code, err.Error())
+			return
+		}
+
+		err = as.ResetPassword(r.Context(), form.Code, form.Password)
+		if err != nil {
+			slog.Error("error resetting password", "error", err)
+			csrfToken := csrf.GenerateToken()
+			web.RenderResetPasswordForm(w, csrfToken, form.Code, err.Error())
+			return
+		}
+
+		auth.DeleteSessionCookie(w)
+
+		w.Header().Set("HX-Redirect", "/login")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleRenderUnlockLogin asks for a click before unlocking, so mail scanners opening the link do nothing
+func handleRenderUnlockLogin(csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		csrfToken := csrf.GenerateToken()
+		web.RenderUnlockLoginPage(w, csrfToken, r.URL.Query().Get("code"), "", "")
+	}
+}
+
+func handleUnlockLogin(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		form, err := forms.CodeFrom(r)
+		if err != nil {
+			csrfToken := csrf.GenerateToken()
+			web.RenderUnlockLoginPage(w, csrfToken, "", err.Error(), "")
+			return
+		}
+
+		err = as.UnlockLogin(r.Context(), form.Code)
+		if err != nil {
+			slog.Error("error unlocking login", "error", err)
+			csrfToken := csrf.GenerateToken()
+			web.RenderUnlockLoginPage(w, csrfToken, form.Code, err.Error(), "")
+			return
+		}
+
+		web.RenderUnlockLoginPage(w, "", "", "", "Sign-in is unlocked, you can log in with your password again.")
+	}
+}
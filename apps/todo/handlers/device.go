@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web/forms"
+	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
+)
+
+// handleRenderDevicesView lists the devices the user signed in from
+func handleRenderDevicesView(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		devices, err := as.ListKnownDevices(r.Context(), user.Id, auth.GetDeviceTokenFromCookie(r))
+		if err != nil {
+			slog.Error("error listing known devices", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		items := make([]web.DeviceComponentData, 0, len(devices))
+		for _, device := range devices {
+			items = append(items, web.DeviceComponentData{
+				Device:    device,
+				FirstSeen: time.Unix(device.CreatedAt, 0).UTC(),
+				LastSeen:  time.Unix(device.LastSeenAt, 0).UTC(),
+				CSRFToken: csrf.GenerateToken(),
+			})
+		}
+
+		web.RenderDevicesPage(w, items)
+	}
+}
+
+// handleRemoveDevice forgets a device and signs it out, removing the current device also logs out this browser
+func handleRemoveDevice(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+
+		err = as.RemoveKnownDevice(r.Context(), user.Id, id)
+		if errors.Is(err, auth.ErrDeviceNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.Error("error removing known device", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := as.GetSessionFrom(r); err != nil {
+			auth.DeleteSessionCookie(w)
+			w.Header().Set("HX-Redirect", "/login")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleRenderDeviceReport asks for a click before locking the account, so mail scanners opening the link do nothing
+func handleRenderDeviceReport(csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		csrfToken := csrf.GenerateToken()
+		web.RenderDeviceReportPage(w, csrfToken, r.URL.Query().Get("code"), "")
+	}
+}
+
+// handleReportDevice signs every device out and sends the user on to choose a new password
+func handleReportDevice(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		form, err := forms.CodeFrom(r)
+		if err != nil {
+			csrfToken := csrf.GenerateToken()
+			web.RenderDeviceReportPage(w, csrfToken, "", err.Error())
+			return
+		}
+
+		resetCode, err := as.ReportUnknownDevice(r.Context(), form.Code)
+		if err != nil {
+			slog.Error("error reporting device", "error", err)
+			csrfToken := csrf.GenerateToken()
+			web.RenderDeviceReportPage(w, csrfToken, form.Code, err.Error())
+			return
+		}
+
+		auth.DeleteSessionCookie(w)
+		http.Redirect(w, r, "/reset-password?code="+url.QueryEscape(resetCode), http.StatusSeeOther)
+	}
+}
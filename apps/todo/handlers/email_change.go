@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web/forms"
+	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
+)
+
+func handleRenderEmailSettings(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		pendingEmail, err := as.PendingEmailChange(r.Context(), user.Id)
+		if err != nil {
+			slog.Error("error getting pending email change", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		csrfToken := csrf.GenerateToken()
+		web.RenderEmailSettingsPage(w, csrfToken, user.Email, pendingEmail)
+	}
+}
+
+func handleRequestEmailChange(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		form, err := forms.ChangeEmailFrom(r)
+		if err != nil {
+			csrfToken := csrf.GenerateToken()
+			web.RenderEmailChangeForm(w, csrfToken, err.Error())
+			return
+		}
+
+		err = as.RequestEmailChange(r.Context(), user.Id, form.Password, form.Email)
+		if err != nil {
+			slog.Error("error requesting email change", "error", err)
+			csrfToken := csrf.GenerateToken()
+			web.RenderEmailChangeForm(w, csrfToken, err.Error())
+			return
+		}
+
+		pendingEmail, err := as.PendingEmailChange(r.Context(), user.Id)
+		if err != nil {
+			slog.Error("error getting pending email change", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		csrfToken := csrf.GenerateToken()
+		web.RenderEmailChangeConfirmForm(w, csrfToken, pendingEmail, "")
+	}
+}
+
+func handleConfirmEmailChange(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		session, err := as.GetSessionFrom(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		pendingEmail, err := as.PendingEmailChange(r.Context(), user.Id)
+		if err != nil {
+			slog.Error("error getting pending email change", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		form, err := forms.CodeFrom(r)
+		if err != nil {
+			csrfToken := csrf.GenerateToken()
+			web.RenderEmailChangeConfirmForm(w, csrfToken, pendingEmail, err.Error())
+			return
+		}
+
+		err = as.ConfirmEmailChange(r.Context(), user.Id, session.Id, form.Code)
+		if err != nil {
+			slog.Error("error confirming email change", "error", err)
+			csrfToken := csrf.GenerateToken()
+			web.RenderEmailChangeConfirmForm(w, csrfToken, pendingEmail, err.Error())
+			return
+		}
+
+		w.Header().Set("HX-Redirect", "/settings/email")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleRenderCancelEmailChange asks for a click before cancelling, so mail scanners opening the link do nothing
+func handleRenderCancelEmailChange(csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		csrfToken := csrf.GenerateToken()
+		web.RenderCancelEmailChangePage(w, csrfToken, r.URL.Query().Get("code"), "", "")
+	}
+}
+
+func handleCancelEmailChange(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		form, err := forms.CodeFrom(r)
+		if err != nil {
+			csrfToken := csrf.GenerateToken()
+			web.RenderCancelEmailChangePage(w, csrfToken, "", err.Error(), "")
+			return
+		}
+
+		err = as.CancelEmailChange(r.Context(), form.Code)
+		if err != nil {
+			slog.Error("error cancelling email change", "error", err)
+			csrfToken := csrf.GenerateToken()
+			web.RenderCancelEmailChangePage(w, csrfToken, form.Code, err.Error(), "")
+			return
+		}
+
+		auth.DeleteSessionCookie(w)
+		web.RenderCancelEmailChangePage(w, "", "", "", "The email change was cancelled and every device was signed out.")
+	}
+}
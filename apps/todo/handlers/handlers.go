@@ -5,6 +5,7 @@ import (
 
 	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
 	"github.com/AltSoyuz/soy-experiments/apps/todo/config"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
 	"github.com/AltSoyuz/soy-experiments/apps/todo/todo"
 	"github.com/AltSoyuz/soy-experiments/apps/todo/web"
 	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
@@ -21,7 +22,17 @@ func AddRoutes(
 	limitRegister := authService.LimitRegisterMiddleware
 	limitLogin := authService.LimitLoginMiddleware
 	limitVerifyEmail := authService.LimitVerifyEmailMiddleware
-	protect := authService.ProtectedRouteMiddleware
+	limitReset := authService.LimitResetMiddleware
+	limitTwoFactor := authService.LimitTwoFactorMiddleware
+	protect := func(h http.Handler) http.HandlerFunc {
+		return authService.ProtectedRouteMiddleware(withImpersonationBanner(csrf, h))
+	}
+	protectAPI := func(h http.Handler) http.HandlerFunc {
+		return authService.ProtectedAPIRouteMiddleware(withImpersonationBanner(csrf, h))
+	}
+	requireAdmin := authService.RequireRole(model.RoleAdmin)
+	noImpersonation := authService.ForbidImpersonationMiddleware
+	deviceCookie := auth.DeviceCookieMiddleware
 
 	// Health check
 	mux.HandleFunc("GET /healthz", healthz)
@@ -31,25 +42,109 @@ func AddRoutes(
 
 	// Auth
 	mux.Handle("POST /users", limitRegister(handleCreateUser(authService, csrf)))
-	mux.Handle("GET /login", handleRenderLoginView(csrf))
-	mux.Handle("GET /register", handleRenderRegisterView(csrf))
+	mux.Handle("GET /login", handleRenderLoginView(authService, csrf))
+	mux.Handle("GET /register", handleRenderRegisterView(authService, csrf))
 	mux.Handle("POST /authenticate/password",
-		limitLogin(handleAuthWithPassword(authService, csrf)),
+		limitLogin(deviceCookie(handleAuthWithPassword(authService, csrf))),
 	)
 	mux.Handle("GET /logout", handleLogout(authService))
-	mux.Handle("GET /verify-email", limitVerifyEmail(handleRenderVerifyEmail(csrf)))
+	mux.Handle("GET /verify-email", limitVerifyEmail(handleRenderVerifyEmail(authService, csrf)))
 	mux.Handle(
 		"POST /email-verification-request",
 		limitVerifyEmail(handleEmailVerification(authService, csrf)),
 	)
+	mux.Handle(
+		"POST /email-verification-request/resend",
+		limitVerifyEmail(handleResendVerificationEmail(authService, csrf)),
+	)
+	mux.Handle("GET /forgot-password", handleRenderForgotPasswordView(csrf))
+	mux.Handle("POST /forgot-password", limitReset(handleForgotPassword(authService, csrf)))
+	mux.Handle("GET /reset-password", handleRenderResetPasswordView(csrf))
+	mux.Handle("POST /reset-password", limitReset(handleResetPassword(authService, csrf)))
+	mux.Handle("GET /login/unlock", handleRenderUnlockLogin(csrf))
+	mux.Handle("POST /login/unlock", limitLogin(handleUnlockLogin(authService, csrf)))
+	mux.Handle("GET /login/magic-link", handleRenderMagicLinkView(csrf))
+	mux.Handle("POST /login/magic-link", limitLogin(handleRequestMagicLink(authService, csrf)))
+	mux.Handle("GET /login/magic-link/verify", limitLogin(deviceCookie(handleVerifyMagicLink(authService, csrf))))
+	mux.Handle("GET /oauth/{provider}/start", limitLogin(handleOAuthStart(authService)))
+	mux.Handle("GET /oauth/{provider}/callback", limitLogin(deviceCookie(handleOAuthCallback(authService))))
+	mux.Handle("GET /login/device-report", handleRenderDeviceReport(csrf))
+	mux.Handle("POST /login/device-report", limitLogin(handleReportDevice(authService, csrf)))
+	mux.Handle("GET /login/two-factor", handleRenderTwoFactorView(authService, csrf))
+	mux.Handle("POST /login/two-factor", limitTwoFactor(handleVerifyTwoFactor(authService, csrf)))
+	mux.Handle("GET /login/recovery-code", handleRenderRecoveryCodeView(authService, csrf))
+	mux.Handle("POST /login/recovery-code", limitTwoFactor(handleVerifyRecoveryCode(authService, csrf)))
+	mux.Handle("POST /webauthn/login/options", limitLogin(handleBeginPasskeyLogin(authService, csrf)))
+	mux.Handle("POST /webauthn/login", limitLogin(deviceCookie(handleAuthWithPasskey(authService))))
+	mux.Handle("POST /webauthn/two-factor/options", limitTwoFactor(handleBeginPasskeyTwoFactor(authService, csrf)))
+	mux.Handle("POST /webauthn/two-factor", limitTwoFactor(handleVerifyPasskeyTwoFactor(authService)))
+
+	// Account
+	mux.Handle("GET /account/two-factor", protect(handleRenderTwoFactorSettings(authService, csrf)))
+	mux.Handle("POST /account/two-factor/enroll", protect(noImpersonation(handleBeginTwoFactorEnrollment(authService, csrf))))
+	mux.Handle("POST /account/two-factor/confirm",
+		protect(noImpersonation(limitTwoFactor(handleConfirmTwoFactorEnrollment(authService, csrf)))),
+	)
+	mux.Handle("POST /account/two-factor/disable",
+		protect(noImpersonation(limitTwoFactor(handleDisableTwoFactor(authService, csrf)))),
+	)
+	mux.Handle("GET /account/recovery-codes", protect(handleRenderRecoveryCodesSettings(authService, csrf)))
+	mux.Handle("POST /account/recovery-codes", protect(noImpersonation(handleRegenerateRecoveryCodes(authService))))
+
+	// Settings
+	mux.Handle("GET /settings/account", protect(handleRenderAccountSettings(authService, csrf)))
+	mux.Handle("GET /settings/account/export", protect(noImpersonation(handleExportAccount(authService))))
+	mux.Handle("POST /settings/account/delete",
+		protect(noImpersonation(limitTwoFactor(handleDeleteAccount(authService, csrf)))),
+	)
+	mux.Handle("GET /settings/email", protect(handleRenderEmailSettings(authService, csrf)))
+	mux.Handle("POST /settings/email", protect(noImpersonation(handleRequestEmailChange(authService, csrf))))
+	mux.Handle("POST /settings/email/confirm",
+		protect(noImpersonation(limitVerifyEmail(handleConfirmEmailChange(authService, csrf)))),
+	)
+	mux.Handle("GET /settings/email/cancel", handleRenderCancelEmailChange(csrf))
+	mux.Handle("POST /settings/email/cancel", limitVerifyEmail(handleCancelEmailChange(authService, csrf)))
+	mux.Handle("GET /settings/sessions", protect(handleRenderSessionsView(authService, csrf)))
+	mux.Handle("POST /settings/sessions/revoke-others", protect(noImpersonation(handleRevokeOtherSessions(authService))))
+	mux.Handle("DELETE /settings/sessions/{id}", protect(noImpersonation(handleRevokeSession(authService))))
+	mux.Handle("GET /settings/devices", protect(handleRenderDevicesView(authService, csrf)))
+	mux.Handle("DELETE /settings/devices/{id}", protect(noImpersonation(handleRemoveDevice(authService))))
+	mux.Handle("GET /settings/security", protect(handleRenderSecurityActivity(authService)))
+	mux.Handle("GET /settings/tokens", protect(handleRenderAccessTokensView(authService, csrf)))
+	mux.Handle("POST /settings/tokens", protect(noImpersonation(handleCreateAccessToken(authService, csrf))))
+	mux.Handle("DELETE /settings/tokens/{id}", protect(noImpersonation(handleRevokeAccessToken(authService))))
+	mux.Handle("GET /settings/invitations", protect(handleRenderInvitationsView(authService, csrf)))
+	mux.Handle("POST /settings/invitations", protect(noImpersonation(handleCreateInvitation(authService, csrf))))
+	mux.Handle("DELETE /settings/invitations/{id}", protect(noImpersonation(handleRevokeInvitation(authService))))
+	mux.Handle("GET /settings/passkeys", protect(handleRenderPasskeysView(authService, csrf)))
+	mux.Handle("DELETE /settings/passkeys/{id}", protect(noImpersonation(handleDeletePasskey(authService))))
+	mux.Handle("POST /webauthn/register/options", protect(noImpersonation(handleBeginPasskeyRegistration(authService, csrf))))
+	mux.Handle("POST /webauthn/register", protect(noImpersonation(handleFinishPasskeyRegistration(authService))))
+
+	// Admin
+	mux.Handle("GET /admin", protect(requireAdmin(handleRenderAdminConsole(authService, csrf))))
+	mux.Handle("POST /admin/users/{id}/disable",
+		protect(requireAdmin(handleSetUserDisabled(authService, csrf, true))),
+	)
+	mux.Handle("POST /admin/users/{id}/enable",
+		protect(requireAdmin(handleSetUserDisabled(authService, csrf, false))),
+	)
+	mux.Handle("POST /admin/users/{id}/verify-email",
+		protect(requireAdmin(handleForceVerifyEmail(authService, csrf))),
+	)
+	mux.Handle("POST /admin/users/{id}/impersonate",
+		protect(requireAdmin(handleStartImpersonation(authService))),
+	)
+	mux.Handle("GET /admin/auth-events", protect(requireAdmin(handleListAuthEvents(authService))))
+	mux.Handle("POST /impersonation/stop", protect(handleStopImpersonation(authService)))
 
-	// Todos
-	mux.Handle("GET /{$}", protect(handleRenderTodoList(todoStore, csrf)))
-	mux.Handle("POST /todos", protect(handleCreateTodoFragment(todoStore, csrf)))
-	mux.Handle("GET /todos/{id}/form", protect(handleGetTodoFormFragment(todoStore, csrf)))
-	mux.Handle("PUT /todos/{id}", protect(handleUpdateTodoFragment(todoStore, csrf)))
-	mux.Handle("DELETE /todos/{id}", protect(handleDeleteTodo(todoStore)))
-	mux.Handle("PUT /todos/{id}/complete", protect(handleCompleteTodoFragment(todoStore, csrf)))
+	// Todos, also reachable with a personal access token
+	mux.Handle("GET /{$}", protectAPI(handleRenderTodoList(authService, todoStore, csrf)))
+	mux.Handle("POST /todos", protectAPI(handleCreateTodoFragment(todoStore, csrf)))
+	mux.Handle("GET /todos/{id}/form", protectAPI(handleGetTodoFormFragment(todoStore, csrf)))
+	mux.Handle("PUT /todos/{id}", protectAPI(handleUpdateTodoFragment(todoStore, csrf)))
+	mux.Handle("DELETE /todos/{id}", protectAPI(handleDeleteTodo(todoStore)))
+	mux.Handle("PUT /todos/{id}/complete", protectAPI(handleCompleteTodoFragment(todoStore, csrf)))
 }
 
 func notFoundView() http.HandlerFunc {
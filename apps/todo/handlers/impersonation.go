@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web"
+	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
+)
+
+// handleStartImpersonation signs the admin in as the user, their own session is kept aside until they stop
+func handleStartImpersonation(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		adminSession, err := as.GetSessionFrom(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+
+		session, token, err := as.StartImpersonation(r.Context(), admin, userId, auth.ClientInfoFrom(r))
+		if errors.Is(err, auth.ErrUserNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, auth.ErrCannotImpersonate) || errors.Is(err, auth.ErrImpersonationForbidden) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			slog.Error("error starting impersonation", "error", err, "userId", userId)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		auth.SetImpersonatorCookie(w, auth.GetTokenFromCookie(r), adminSession.ExpiresAt)
+		auth.SetSessionCookie(w, token, session.ExpiresAt)
+
+		w.Header().Set("HX-Redirect", "/")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleStopImpersonation ends the impersonation and brings the admin back to the console,
+// or to the login page when their own session ended in the meantime
+func handleStopImpersonation(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, err := as.GetSessionFrom(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		adminSession, err := as.StopImpersonation(r.Context(), session, auth.GetImpersonatorTokenFromCookie(r))
+		if errors.Is(err, auth.ErrNotImpersonating) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			slog.Error("error stopping impersonation", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		auth.DeleteImpersonatorCookie(w)
+		if adminSession.Id == "" {
+			auth.DeleteSessionCookie(w)
+			w.Header().Set("HX-Redirect", "/login")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		auth.SetSessionCookie(w, auth.GetImpersonatorTokenFromCookie(r), adminSession.ExpiresAt)
+		w.Header().Set("HX-Redirect", "/admin")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// withImpersonationBanner shows the impersonation banner on the pages rendered for an admin impersonating a user.
+// It reads the user stored by ProtectedRouteMiddleware, so it must be wrapped by it.
+func withImpersonationBanner(csrf *httpserver.CSRFProtection, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if ok && user.Impersonation != nil {
+			w = web.WithImpersonationBanner(w, func() web.ImpersonationBanner {
+				return web.ImpersonationBanner{
+					AdminEmail: user.Impersonation.AdminEmail,
+					UserEmail:  user.Email,
+					EndsAt:     time.Unix(user.Impersonation.ExpiresAt, 0).UTC(),
+					CSRFToken:  csrf.GenerateToken(),
+				}
+			})
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/config"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web/forms"
+	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
+)
+
+func handleRenderInvitationsView(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		invitations, err := as.ListInvitations(r.Context(), user.Id)
+		if err != nil {
+			slog.Error("error listing invitations", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		items := make([]web.InvitationComponentData, 0, len(invitations))
+		for _, invitation := range invitations {
+			items = append(items, web.InvitationComponentData{
+				Invitation: invitation,
+				ExpiresAt:  time.Unix(invitation.ExpiresAt, 0).UTC(),
+				CSRFToken:  csrf.GenerateToken(),
+			})
+		}
+
+		csrfToken := csrf.GenerateToken()
+		enabled := as.Config.Registration.Mode != config.RegistrationClosed
+		web.RenderInvitationsPage(w, csrfToken, enabled, items)
+	}
+}
+
+// handleCreateInvitation emails an invitation and gives back an empty form for the next one
+func handleCreateInvitation(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		form, err := forms.EmailFrom(r)
+		if err != nil {
+			csrfToken := csrf.GenerateToken()
+			web.RenderInvitationForm(w, csrfToken, err.Error(), "")
+			return
+		}
+
+		err = as.InviteUser(r.Context(), user, form.Email)
+		if err != nil {
+			slog.Error("error inviting user", "error", err)
+			csrfToken := csrf.GenerateToken()
+			web.RenderInvitationForm(w, csrfToken, err.Error(), "")
+			return
+		}
+
+		csrfToken := csrf.GenerateToken()
+		web.RenderInvitationForm(w, csrfToken, "", "Invitation sent to "+form.Email+".")
+	}
+}
+
+func handleRevokeInvitation(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+
+		err = as.RevokeInvitation(r.Context(), user.Id, id)
+		if errors.Is(err, auth.ErrInvitationNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.Error("error revoking invitation", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
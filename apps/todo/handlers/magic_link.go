@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web/forms"
+	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
+)
+
+func handleRenderMagicLinkView(csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		csrfToken := csrf.GenerateToken()
+		web.RenderMagicLinkPage(w, csrfToken, "")
+	}
+}
+
+// handleRequestMagicLink emails a sign-in link and binds it to this browser with a cookie
+func handleRequestMagicLink(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		form, err := forms.EmailFrom(r)
+		if err != nil {
+			csrfToken := csrf.GenerateToken()
+			web.RenderMagicLinkForm(w, csrfToken, err.Error(), "")
+			return
+		}
+
+		browserToken, err := as.RequestMagicLink(r.Context(), form.Email)
+		if err != nil {
+			slog.Error("error requesting magic link", "error", err)
+			csrfToken := csrf.GenerateToken()
+			web.RenderMagicLinkForm(w, csrfToken, "could not send the sign-in link, please try again", "")
+			return
+		}
+
+		auth.SetMagicLinkCookie(w, browserToken)
+
+		csrfToken := csrf.GenerateToken()
+		web.RenderMagicLinkForm(w, csrfToken, "", "If an account exists for this email, a sign-in link is on its way. Open it in this browser.")
+	}
+}
+
+func handleVerifyMagicLink(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, token, err := as.AuthenticateWithMagicLink(
+			r.Context(),
+			r.URL.Query().Get("token"),
+			auth.GetMagicLinkFromCookie(r),
+			auth.ClientInfoFrom(r),
+		)
+		if err != nil {
+			slog.Error("error authenticating with magic link", "error", err)
+			csrfToken := csrf.GenerateToken()
+			web.RenderMagicLinkPage(w, csrfToken, auth.ErrInvalidMagicLink.Error())
+			return
+		}
+
+		auth.DeleteMagicLinkCookie(w)
+		auth.SetSessionCookie(w, token, session.ExpiresAt)
+
+		// The link is usually opened from a mail client, so the SameSite=Strict session
+		// cookie would not follow a redirect. Navigate from our own page instead.
+		web.RenderRedirectPage(w, loginRedirectLocation(session))
+	}
+}
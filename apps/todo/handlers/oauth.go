@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web"
+)
+
+// handleOAuthStart redirects the browser to the provider authorization endpoint.
+func handleOAuthStart(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authURL, state, err := as.StartOAuth(r.Context(), r.PathValue("provider"))
+		if err != nil {
+			if errors.Is(err, auth.ErrOAuthProviderUnknown) {
+				web.RenderNotFoundPage(w)
+				return
+			}
+			slog.Error("error starting oauth login", "error", err)
+			http.Error(w, "login provider unavailable", http.StatusBadGateway)
+			return
+		}
+
+		auth.SetOAuthStateCookie(w, state)
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// handleOAuthCallback completes the login when the provider redirects back.
+func handleOAuthCallback(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		query := r.URL.Query()
+
+		expected, ok := auth.GetOAuthStateFromCookie(r)
+		auth.DeleteOAuthStateCookie(w)
+		if !ok {
+			http.Error(w, auth.ErrOAuthStateMismatch.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if providerErr := query.Get("error"); providerErr != "" {
+			slog.Info("oauth login refused by provider", "error", providerErr)
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+
+		session, token, err := as.AuthenticateWithOAuth(
+			ctx,
+			r.PathValue("provider"),
+			expected,
+			query.Get("state"),
+			query.Get("code"),
+			auth.ClientInfoFrom(r),
+		)
+		if err != nil {
+			slog.Error("error authenticating with oauth", "error", err)
+			switch {
+			case errors.Is(err, auth.ErrOAuthStateMismatch):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, auth.ErrOAuthEmailNotVerified), errors.Is(err, auth.ErrOAuthAccountUnverified):
+				http.Error(w, err.Error(), http.StatusForbidden)
+			default:
+				http.Error(w, "login failed", http.StatusBadGateway)
+			}
+			return
+		}
+
+		auth.SetSessionCookie(w, token, session.ExpiresAt)
+
+		// The session cookie is SameSite=Strict, so it is not sent along a redirect chain
+		// started by the provider. Navigate from our own page instead.
+		web.RenderRedirectPage(w, loginRedirectLocation(session))
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web/forms"
+	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
+)
+
+// handleRenderRecoveryCodeView lets a session waiting for its second factor use a recovery code instead
+func handleRenderRecoveryCodeView(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, err := as.GetSessionFrom(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		if !session.TwoFactorPending {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+
+		csrfToken := csrf.GenerateToken()
+		web.RenderRecoveryCodePage(w, csrfToken)
+	}
+}
+
+func handleVerifyRecoveryCode(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := auth.GetTokenFromCookie(r)
+
+		form, err := forms.CodeFrom(r)
+		if err != nil {
+			csrfToken := csrf.GenerateToken()
+			web.RenderRecoveryCodeForm(w, csrfToken, err.Error())
+			return
+		}
+
+		session, err := as.VerifyRecoveryCode(r.Context(), token, form.Code)
+		if err != nil {
+			slog.Error("error verifying recovery code", "error", err)
+			csrfToken := csrf.GenerateToken()
+			web.RenderRecoveryCodeForm(w, csrfToken, err.Error())
+			return
+		}
+
+		auth.SetSessionCookie(w, token, session.ExpiresAt)
+
+		w.Header().Set("HX-Redirect", "/")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleRenderRecoveryCodesSettings(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		enabled, err := as.TOTPEnabled(r.Context(), user.Id)
+		if err != nil {
+			slog.Error("error getting two-factor status", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		remaining, err := as.RemainingRecoveryCodes(r.Context(), user.Id)
+		if err != nil {
+			slog.Error("error counting recovery codes", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		csrfToken := csrf.GenerateToken()
+		web.RenderRecoveryCodesPage(w, csrfToken, enabled, remaining)
+	}
+}
+
+// handleRegenerateRecoveryCodes replaces the recovery codes of the user, the previous set stops working
+func handleRegenerateRecoveryCodes(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		codes, err := as.GenerateRecoveryCodes(r.Context(), user.Id)
+		if err != nil {
+			slog.Error("error generating recovery codes", "error", err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		web.RenderRecoveryCodes(w, codes)
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web"
+	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
+)
+
+// handleRenderSessionsView lists the devices signed in to the account
+func handleRenderSessionsView(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		current, err := as.GetSessionFrom(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		sessions, err := as.ListSessions(r.Context(), user.Id)
+		if err != nil {
+			slog.Error("error listing sessions", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		csrfToken := csrf.GenerateToken()
+		web.RenderSessionsPage(w, csrfToken, sessions, current.Id)
+	}
+}
+
+// handleRevokeSession signs out one device, revoking the current session also logs out this browser
+func handleRevokeSession(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		current, err := as.GetSessionFrom(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		sessionId := r.PathValue("id")
+		err = as.RevokeSession(r.Context(), user.Id, sessionId)
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.Error("error revoking session", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if sessionId == current.Id {
+			auth.DeleteSessionCookie(w)
+			w.Header().Set("HX-Redirect", "/login")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleRevokeOtherSessions signs out every device except the one making the request
+func handleRevokeOtherSessions(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		current, err := as.GetSessionFrom(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := as.RevokeOtherSessions(r.Context(), user.Id, current.Id); err != nil {
+			slog.Error("error revoking other sessions", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("HX-Redirect", "/settings/sessions")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -13,7 +13,7 @@ import (
 	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
 )
 
-func handleRenderTodoList(todoStore *todo.TodoStore, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+func handleRenderTodoList(as *auth.Service, todoStore *todo.TodoStore, csrf *httpserver.CSRFProtection) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		user, ok := auth.GetSessionUserFrom(ctx)
@@ -41,6 +41,7 @@ func handleRenderTodoList(todoStore *todo.TodoStore, csrf *httpserver.CSRFProtec
 			Title:     "My Todo List",
 			Items:     todoViewModels,
 			Email:     user.Email,
+			IsAdmin:   as.IsAdmin(user),
 			CSRFToken: csrf.GenerateToken(),
 		}
 
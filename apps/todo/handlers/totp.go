@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web/forms"
+	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
+)
+
+// handleRenderTwoFactorView asks for the authenticator code of a session waiting for its second factor,
+// or for one of its passkeys when the user has some
+func handleRenderTwoFactorView(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, err := as.GetSessionFrom(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		if !session.TwoFactorPending {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+
+		passkeys, err := as.ListPasskeys(r.Context(), session.UserId)
+		if err != nil {
+			slog.Error("error listing passkeys", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		csrfToken := csrf.GenerateToken()
+		passkeyCSRFToken := csrf.GenerateToken()
+		web.RenderTwoFactorPage(w, csrfToken, passkeyCSRFToken, len(passkeys) > 0)
+	}
+}
+
+func handleVerifyTwoFactor(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := auth.GetTokenFromCookie(r)
+
+		form, err := forms.CodeFrom(r)
+		if err != nil {
+			csrfToken := csrf.GenerateToken()
+			web.RenderTwoFactorForm(w, csrfToken, err.Error())
+			return
+		}
+
+		session, err := as.VerifyTwoFactor(r.Context(), token, form.Code)
+		if err != nil {
+			slog.Error("error verifying second factor", "error", err)
+			csrfToken := csrf.GenerateToken()
+			web.RenderTwoFactorForm(w, csrfToken, err.Error())
+			return
+		}
+
+		auth.SetSessionCookie(w, token, session.ExpiresAt)
+
+		w.Header().Set("HX-Redirect", "/")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleRenderTwoFactorSettings(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		enabled, err := as.TOTPEnabled(r.Context(), user.Id)
+		if err != nil {
+			slog.Error("error getting two-factor status", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		csrfToken := csrf.GenerateToken()
+		web.RenderTwoFactorSettingsPage(w, csrfToken, enabled)
+	}
+}
+
+func handleBeginTwoFactorEnrollment(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		key, err := as.BeginTOTPEnrollment(r.Context(), user)
+		if err != nil {
+			slog.Error("error starting two-factor enrollment", "error", err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		csrfToken := csrf.GenerateToken()
+		web.RenderTwoFactorEnroll(w, csrfToken, key.EncodedSecret(), key.URL())
+	}
+}
+
+func handleConfirmTwoFactorEnrollment(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		form, err := forms.CodeFrom(r)
+		if err != nil {
+			csrfToken := csrf.GenerateToken()
+			web.RenderTwoFactorConfirmForm(w, csrfToken, err.Error())
+			return
+		}
+
+		err = as.ConfirmTOTPEnrollment(r.Context(), user.Id, form.Code)
+		if err != nil {
+			slog.Error("error confirming two-factor enrollment", "error", err)
+			csrfToken := csrf.GenerateToken()
+			web.RenderTwoFactorConfirmForm(w, csrfToken, err.Error())
+			return
+		}
+
+		// Hand out the first set of recovery codes right away, before the user can lose the device
+		codes, err := as.GenerateRecoveryCodes(r.Context(), user.Id)
+		if err != nil {
+			slog.Error("error generating recovery codes", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		web.RenderRecoveryCodes(w, codes)
+	}
+}
+
+func handleDisableTwoFactor(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		form, err := forms.DisableTwoFactorFrom(r)
+		if err != nil {
+			csrfToken := csrf.GenerateToken()
+			web.RenderTwoFactorDisableForm(w, csrfToken, err.Error())
+			return
+		}
+
+		err = as.DisableTOTP(r.Context(), user.Id, form.Password, form.Code)
+		if err != nil {
+			slog.Error("error disabling two-factor authentication", "error", err)
+			csrfToken := csrf.GenerateToken()
+			web.RenderTwoFactorDisableForm(w, csrfToken, err.Error())
+			return
+		}
+
+		w.Header().Set("HX-Redirect", "/account/two-factor")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -11,21 +11,22 @@ import (
 )
 
 // handleCreateUser creates a new user account and redirects to the login page.
+// The invitation token and email are kept in the re-rendered form so an invited user can fix a mistake.
 func handleCreateUser(authService *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		form, err := forms.RegisterFrom(r)
 		if err != nil {
 			csrfToken := csrf.GenerateToken()
-			web.RenderRegisterForm(w, csrfToken, err.Error())
+			web.RenderRegisterForm(w, csrfToken, r.FormValue("invite"), r.FormValue("email"), err.Error())
 			return
 		}
 
-		err = authService.RegisterUser(r.Context(), form.Email, form.Password)
+		err = authService.RegisterUser(r.Context(), form.Email, form.Password, form.Invite)
 
 		if err != nil {
 			slog.Error("error registering user", "error", err)
 			csrfToken := csrf.GenerateToken()
-			web.RenderRegisterForm(w, csrfToken, err.Error())
+			web.RenderRegisterForm(w, csrfToken, form.Invite, form.Email, err.Error())
 			return
 		}
 
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/web"
+	"github.com/AltSoyuz/soy-experiments/lib/httpserver"
+	"github.com/AltSoyuz/soy-experiments/lib/webauthn"
+)
+
+// maxPasskeyRequestSize bounds the JSON bodies of the passkey endpoints, credentials are a few kilobytes at most
+const maxPasskeyRequestSize = 64 << 10
+
+// passkeyOptionsResponse carries the options for the browser and the CSRF token of the request answering them
+type passkeyOptionsResponse struct {
+	Options   any    `json:"options"`
+	CSRFToken string `json:"csrfToken"`
+}
+
+type passkeyRegistrationRequest struct {
+	Name       string                        `json:"name"`
+	Credential webauthn.RegistrationResponse `json:"credential"`
+}
+
+type passkeyAssertionRequest struct {
+	Credential webauthn.AssertionResponse `json:"credential"`
+}
+
+// passkeyResultResponse tells the browser where to go once a ceremony succeeded
+type passkeyResultResponse struct {
+	Redirect string `json:"redirect,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func handleRenderPasskeysView(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		passkeys, err := as.ListPasskeys(r.Context(), user.Id)
+		if err != nil {
+			slog.Error("error listing passkeys", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		items := make([]web.PasskeyComponentData, 0, len(passkeys))
+		for _, passkey := range passkeys {
+			item := web.PasskeyComponentData{
+				Passkey:   passkey,
+				CreatedAt: time.Unix(passkey.CreatedAt, 0).UTC(),
+				CSRFToken: csrf.GenerateToken(),
+			}
+			if passkey.LastUsedAt != 0 {
+				item.LastUsedAt = time.Unix(passkey.LastUsedAt, 0).UTC()
+			}
+			items = append(items, item)
+		}
+
+		csrfToken := csrf.GenerateToken()
+		web.RenderPasskeysPage(w, csrfToken, items)
+	}
+}
+
+func handleBeginPasskeyRegistration(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		options, err := as.BeginPasskeyRegistration(r.Context(), user)
+		if err != nil {
+			slog.Error("error starting passkey registration", "error", err)
+			writePasskeyError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		writePasskeyJSON(w, http.StatusOK, passkeyOptionsResponse{Options: options, CSRFToken: csrf.GenerateToken()})
+	}
+}
+
+func handleFinishPasskeyRegistration(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var request passkeyRegistrationRequest
+		if err := decodePasskeyRequest(w, r, &request); err != nil {
+			writePasskeyError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		err := as.FinishPasskeyRegistration(r.Context(), user, request.Name, request.Credential)
+		if err != nil {
+			slog.Error("error registering passkey", "error", err)
+			writePasskeyError(w, passkeyErrorStatus(err), err)
+			return
+		}
+
+		writePasskeyJSON(w, http.StatusOK, passkeyResultResponse{Redirect: "/settings/passkeys"})
+	}
+}
+
+func handleBeginPasskeyLogin(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		options, err := as.BeginPasskeyLogin(r.Context())
+		if err != nil {
+			slog.Error("error starting passkey sign-in", "error", err)
+			writePasskeyError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		writePasskeyJSON(w, http.StatusOK, passkeyOptionsResponse{Options: options, CSRFToken: csrf.GenerateToken()})
+	}
+}
+
+// handleAuthWithPasskey signs in the owner of the passkey, it stands in for both the password and the second factor
+func handleAuthWithPasskey(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request passkeyAssertionRequest
+		if err := decodePasskeyRequest(w, r, &request); err != nil {
+			writePasskeyError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		session, token, err := as.AuthenticateWithPasskey(r.Context(), request.Credential, auth.ClientInfoFrom(r))
+		if err != nil {
+			slog.Error("error authenticating with passkey", "error", err)
+			writePasskeyError(w, passkeyErrorStatus(err), err)
+			return
+		}
+
+		auth.SetSessionCookie(w, token, session.ExpiresAt)
+		writePasskeyJSON(w, http.StatusOK, passkeyResultResponse{Redirect: loginRedirectLocation(session)})
+	}
+}
+
+func handleBeginPasskeyTwoFactor(as *auth.Service, csrf *httpserver.CSRFProtection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		options, err := as.BeginPasskeyTwoFactor(r.Context(), auth.GetTokenFromCookie(r))
+		if err != nil {
+			slog.Error("error starting passkey second factor", "error", err)
+			writePasskeyError(w, passkeyErrorStatus(err), err)
+			return
+		}
+
+		writePasskeyJSON(w, http.StatusOK, passkeyOptionsResponse{Options: options, CSRFToken: csrf.GenerateToken()})
+	}
+}
+
+func handleVerifyPasskeyTwoFactor(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := auth.GetTokenFromCookie(r)
+
+		var request passkeyAssertionRequest
+		if err := decodePasskeyRequest(w, r, &request); err != nil {
+			writePasskeyError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		session, err := as.VerifyPasskeyTwoFactor(r.Context(), token, request.Credential)
+		if err != nil {
+			slog.Error("error verifying passkey second factor", "error", err)
+			writePasskeyError(w, passkeyErrorStatus(err), err)
+			return
+		}
+
+		auth.SetSessionCookie(w, token, session.ExpiresAt)
+		writePasskeyJSON(w, http.StatusOK, passkeyResultResponse{Redirect: "/"})
+	}
+}
+
+func handleDeletePasskey(as *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.GetSessionUserFrom(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+
+		err = as.DeletePasskey(r.Context(), user.Id, id)
+		if errors.Is(err, auth.ErrPasskeyNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.Error("error deleting passkey", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func decodePasskeyRequest(w http.ResponseWriter, r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPasskeyRequestSize))
+	if err := decoder.Decode(v); err != nil {
+		return errors.New("invalid passkey request")
+	}
+	return nil
+}
+
+// passkeyErrorStatus maps the errors users can cause to client errors, anything else is a server error
+func passkeyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrInvalidPasskey), errors.Is(err, auth.ErrPasskeyName):
+		return http.StatusBadRequest
+	case errors.Is(err, auth.ErrPasskeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, auth.ErrSessionInvalid), errors.Is(err, auth.ErrSessionExpired):
+		return http.StatusUnauthorized
+	case errors.Is(err, auth.ErrAccountDisabled):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writePasskeyError(w http.ResponseWriter, status int, err error) {
+	writePasskeyJSON(w, status, passkeyResultResponse{Error: err.Error()})
+}
+
+func writePasskeyJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("error writing passkey response", "error", err)
+	}
+}
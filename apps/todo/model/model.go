@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type Todo struct {
 	Id          int64
 	Name        string
@@ -8,14 +10,108 @@ type Todo struct {
 	IsComplete  bool
 }
 
+// Session is a signed in device.
+// ExpiresAt is pushed back while the session is used, but never past AbsoluteExpiresAt.
 type Session struct {
-	Id        string
-	UserId    int64
+	Id                string
+	UserId            int64
+	ExpiresAt         int64
+	AbsoluteExpiresAt int64
+	RememberMe        bool
+	TwoFactorPending  bool
+	Impersonation     *Impersonation
+}
+
+// Impersonation describes an admin signed in as another user, it ends at ExpiresAt at the latest
+type Impersonation struct {
+	AdminId    int64
+	AdminEmail string
+	ExpiresAt  int64
+}
+
+// ActiveSession describes a signed in device of a user
+type ActiveSession struct {
+	Id           string
+	IPAddress    string
+	UserAgent    string
+	CreatedAt    string
+	LastSeenAt   int64
+	Impersonated bool
+}
+
+// Invitation is a pending invitation to create an account, its token is only known by the invited email
+type Invitation struct {
+	Id        int64
+	Email     string
+	CreatedAt int64
 	ExpiresAt int64
 }
 
+// AccessToken describes a personal access token, its secret is only known when created
+type AccessToken struct {
+	Id         int64
+	Name       string
+	Scopes     []string
+	CreatedAt  int64
+	ExpiresAt  int64
+	LastUsedAt int64
+}
+
+// Passkey describes a WebAuthn credential registered by a user, its private key never leaves the authenticator
+type Passkey struct {
+	Id         int64
+	Name       string
+	CreatedAt  int64
+	LastUsedAt int64
+}
+
+// KnownDevice is a browser the user signed in from before, Name describes its browser and operating system.
+// Current is set on the device making the request.
+type KnownDevice struct {
+	Id         int64
+	Name       string
+	IPAddress  string
+	CreatedAt  int64
+	LastSeenAt int64
+	Current    bool
+}
+
+// AuthEvent is an entry of the authentication audit log.
+// UserId is 0 when the operation could not be tied to an account, such as a login with an unknown email.
+type AuthEvent struct {
+	Id        int64     `json:"id"`
+	Type      string    `json:"type"`
+	UserId    int64     `json:"user_id,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Reason    string    `json:"reason,omitempty"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Roles a user can have, admins can manage every account from the admin console
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	Id            int64
 	Email         string
 	EmailVerified bool
+	Role          string
+	// Impersonation is set when an admin uses the app as this user
+	Impersonation *Impersonation
+}
+
+// UserSummary describes an account as listed in the admin console
+type UserSummary struct {
+	Id            int64
+	Email         string
+	Role          string
+	EmailVerified bool
+	Disabled      bool
+	TodoCount     int64
+	CreatedAt     string
 }
@@ -69,7 +69,7 @@ func Run(ctx context.Context) error {
 	}
 
 	// Initialize the CSRF protection
-	csrf, err := httpserver.NewCSRFProtection("http://localhost:" + cfg.Port)
+	csrf, err := httpserver.NewCSRFProtection(cfg.BaseURL)
 	if err != nil {
 		return err
 	}
@@ -77,6 +77,14 @@ func Run(ctx context.Context) error {
 	authService := auth.Init(cfg, queries)
 	todoStore := todo.Init(queries)
 
+	// Give the admin role to configured admins who already have a verified account
+	if err := authService.PromoteConfiguredAdmins(ctx); err != nil {
+		return err
+	}
+
+	// Prune old authentication events for as long as the server runs
+	go authService.RunAuthEventRetention(ctx)
+
 	srv := New(
 		cfg,
 		csrf,
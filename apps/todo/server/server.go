@@ -26,5 +26,5 @@ func New(
 		todoStore,
 	)
 
-	return csrf.Middleware(mux)
+	return csrf.Middleware(auth.ClientInfoMiddleware(mux))
 }
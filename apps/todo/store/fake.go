@@ -6,6 +6,9 @@ import (
 	"database/sql"
 	"errors"
 	"log/slog"
+	"maps"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
@@ -16,6 +19,30 @@ type FakeQuerier struct {
 	Todos                     map[int64]db.Todo
 	Users                     map[int64]db.User
 	EmailVerificationRequests map[int64]db.EmailVerificationRequest
+	PasswordResetRequests     map[int64]db.PasswordResetRequest
+	OAuthAccounts             map[int64]db.OauthAccount
+	TOTPCredentials           map[int64]db.TotpCredential
+	RecoveryCodes             map[int64]db.RecoveryCode
+	AccessTokens              map[int64]db.AccessToken
+	MagicLinkRequests         map[int64]db.MagicLinkRequest
+	EmailChangeRequests       map[int64]db.EmailChangeRequest
+	EmailChangeReverts        map[int64]db.EmailChangeRevert
+	LoginAttempts             map[string]db.LoginAttempt
+	AuthEvents                map[int64]db.AuthEvent
+	Invitations               map[int64]db.Invitation
+	WebAuthnCredentials       map[int64]db.WebauthnCredential
+	WebAuthnChallenges        map[string]db.WebauthnChallenge
+	KnownDevices              map[int64]db.KnownDevice
+	lastUserID                int64
+	lastRecoveryCodeID        int64
+	lastAccessTokenID         int64
+	lastMagicLinkRequestID    int64
+	lastEmailChangeRequestID  int64
+	lastEmailChangeRevertID   int64
+	lastAuthEventID           int64
+	lastInvitationID          int64
+	lastWebAuthnCredentialID  int64
+	lastKnownDeviceID         int64
 }
 
 func NewFakeQuerier() *FakeQuerier {
@@ -24,6 +51,20 @@ func NewFakeQuerier() *FakeQuerier {
 		Todos:                     make(map[int64]db.Todo),
 		Users:                     make(map[int64]db.User),
 		EmailVerificationRequests: make(map[int64]db.EmailVerificationRequest),
+		PasswordResetRequests:     make(map[int64]db.PasswordResetRequest),
+		OAuthAccounts:             make(map[int64]db.OauthAccount),
+		TOTPCredentials:           make(map[int64]db.TotpCredential),
+		RecoveryCodes:             make(map[int64]db.RecoveryCode),
+		AccessTokens:              make(map[int64]db.AccessToken),
+		MagicLinkRequests:         make(map[int64]db.MagicLinkRequest),
+		EmailChangeRequests:       make(map[int64]db.EmailChangeRequest),
+		EmailChangeReverts:        make(map[int64]db.EmailChangeRevert),
+		LoginAttempts:             make(map[string]db.LoginAttempt),
+		AuthEvents:                make(map[int64]db.AuthEvent),
+		Invitations:               make(map[int64]db.Invitation),
+		WebAuthnCredentials:       make(map[int64]db.WebauthnCredential),
+		WebAuthnChallenges:        make(map[string]db.WebauthnChallenge),
+		KnownDevices:              make(map[int64]db.KnownDevice),
 	}
 }
 func (f *FakeQuerier) Ping(ctx context.Context) error {
@@ -36,17 +77,20 @@ func (f *FakeQuerier) CreateSession(ctx context.Context, arg db.CreateSessionPar
 		return db.Session{}, errors.New("invalid session parameters")
 	}
 	f.Sessions[arg.ID] = db.Session{
-		ID:        arg.ID,
-		UserID:    arg.UserID,
-		ExpiresAt: arg.ExpiresAt,
-		CreatedAt: sql.NullString{String: time.Now().Format(time.RFC3339), Valid: true},
+		ID:                arg.ID,
+		UserID:            arg.UserID,
+		ExpiresAt:         arg.ExpiresAt,
+		CreatedAt:         sql.NullString{String: time.Now().Format(time.RFC3339), Valid: true},
+		TwoFactorPending:  arg.TwoFactorPending,
+		IpAddress:         arg.IpAddress,
+		UserAgent:         arg.UserAgent,
+		LastSeenAt:        arg.LastSeenAt,
+		ImpersonatorID:    arg.ImpersonatorID,
+		RememberMe:        arg.RememberMe,
+		AbsoluteExpiresAt: arg.AbsoluteExpiresAt,
+		DeviceID:          arg.DeviceID,
 	}
-	return db.Session{
-		ID:        arg.ID,
-		UserID:    arg.UserID,
-		ExpiresAt: arg.ExpiresAt,
-		CreatedAt: sql.NullString{String: time.Now().Format(time.RFC3339), Valid: true},
-	}, nil
+	return f.Sessions[arg.ID], nil
 }
 
 // Implement other methods as no-op or panics if not needed for this test
@@ -73,17 +117,20 @@ func (f *FakeQuerier) CreateTodo(ctx context.Context, arg db.CreateTodoParams) (
 }
 
 func (f *FakeQuerier) CreateUser(ctx context.Context, arg db.CreateUserParams) (db.User, error) {
-	if arg.Email == "" || arg.PasswordHash == "" {
+	if arg.Email == "" {
 		return db.User{}, errors.New("invalid user parameters")
 	}
-	randomInt := make([]byte, 8)
-	if _, err := rand.Read(randomInt); err != nil {
-		return db.User{}, err
+	for _, user := range f.Users {
+		if user.Email == arg.Email {
+			return db.User{}, errors.New("UNIQUE constraint failed: user.email")
+		}
 	}
+	f.lastUserID++
 	user := db.User{
-		ID:           int64(randomInt[0]),
+		ID:           f.lastUserID,
 		Email:        arg.Email,
 		PasswordHash: arg.PasswordHash,
+		Role:         "user",
 	}
 	f.Users[user.ID] = user
 	return user, nil
@@ -116,33 +163,51 @@ func (f *FakeQuerier) GetTodo(ctx context.Context, arg db.GetTodoParams) (db.Tod
 	panic("not implemented")
 }
 
+func (f *FakeQuerier) CountUserTodos(ctx context.Context, userID int64) (int64, error) {
+	var count int64
+	for _, todo := range f.Todos {
+		if todo.UserID == userID {
+			count++
+		}
+	}
+	return count, nil
+}
+
 func (f *FakeQuerier) GetTodos(ctx context.Context, userId int64) ([]db.Todo, error) {
+	var todos []db.Todo
 	for _, todo := range f.Todos {
 		if todo.UserID == userId {
-			return []db.Todo{
-				{
-					Name:        todo.Name,
-					Description: todo.Description,
-					UserID:      todo.UserID,
-					IsComplete:  todo.IsComplete,
-				},
-			}, nil
+			todos = append(todos, todo)
 		}
 	}
-	return nil, errors.New("todos not found")
+	sort.Slice(todos, func(i, j int) bool {
+		return todos[i].ID < todos[j].ID
+	})
+	return todos, nil
 }
 
 func (f *FakeQuerier) GetUserByEmail(ctx context.Context, username string) (db.User, error) {
 	for id, user := range f.Users {
 		if user.Email == username {
-			return db.User{
-				ID:           id,
-				Email:        user.Email,
-				PasswordHash: user.PasswordHash,
-			}, nil
+			user.ID = id
+			return user, nil
 		}
 	}
-	return db.User{}, errors.New("user not found")
+	return db.User{}, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) GetUserByNormalizedEmail(ctx context.Context, email string) (db.User, error) {
+	var found db.User
+	for id, user := range f.Users {
+		if strings.ToLower(user.Email) == email && (found.ID == 0 || id < found.ID) {
+			user.ID = id
+			found = user
+		}
+	}
+	if found.ID == 0 {
+		return db.User{}, sql.ErrNoRows
+	}
+	return found, nil
 }
 
 func (f *FakeQuerier) UpdateSession(ctx context.Context, arg db.UpdateSessionParams) (db.Session, error) {
@@ -188,27 +253,60 @@ func (f *FakeQuerier) ValidateSessionToken(ctx context.Context, id string) (db.V
 	if !exists {
 		return db.ValidateSessionTokenRow{}, errors.New("session not found")
 	}
-	return db.ValidateSessionTokenRow{
-		ID:            session.ID,
-		UserID:        session.UserID,
-		ExpiresAt:     session.ExpiresAt,
-		EmailVerified: 0,
-	}, nil
+	user := f.Users[session.UserID]
+	row := db.ValidateSessionTokenRow{
+		ID:                session.ID,
+		UserID:            session.UserID,
+		ExpiresAt:         session.ExpiresAt,
+		TwoFactorPending:  session.TwoFactorPending,
+		LastSeenAt:        session.LastSeenAt,
+		ImpersonatorID:    session.ImpersonatorID,
+		RememberMe:        session.RememberMe,
+		AbsoluteExpiresAt: session.AbsoluteExpiresAt,
+		Email:             user.Email,
+		EmailVerified:     user.EmailVerified,
+		Role:              user.Role,
+		Disabled:          user.Disabled,
+	}
+	if impersonator, ok := f.Users[session.ImpersonatorID.Int64]; ok && session.ImpersonatorID.Valid {
+		row.ImpersonatorEmail = sql.NullString{String: impersonator.Email, Valid: true}
+		row.ImpersonatorRole = sql.NullString{String: impersonator.Role, Valid: true}
+		row.ImpersonatorDisabled = sql.NullInt64{Int64: impersonator.Disabled, Valid: true}
+	}
+	return row, nil
 }
 
 func (f *FakeQuerier) InsertUserEmailVerificationRequest(ctx context.Context, arg db.InsertUserEmailVerificationRequestParams) (db.EmailVerificationRequest, error) {
 	if arg.UserID == 0 || arg.Code == "" {
 		return db.EmailVerificationRequest{}, errors.New("invalid email verification request parameters")
 	}
-	emailVerificationRequest := db.EmailVerificationRequest(arg)
-	f.EmailVerificationRequests[arg.UserID] = db.EmailVerificationRequest(arg)
+	emailVerificationRequest := db.EmailVerificationRequest{
+		UserID:              arg.UserID,
+		CreatedAt:           arg.CreatedAt,
+		ExpiresAt:           arg.ExpiresAt,
+		Code:                arg.Code,
+		SendCount:           1,
+		SendWindowStartedAt: arg.CreatedAt,
+	}
+	if existing, exists := f.EmailVerificationRequests[arg.UserID]; exists && existing.SendWindowStartedAt > arg.WindowStart {
+		emailVerificationRequest.SendCount = existing.SendCount + 1
+		emailVerificationRequest.SendWindowStartedAt = existing.SendWindowStartedAt
+	}
+	f.EmailVerificationRequests[arg.UserID] = emailVerificationRequest
 	return emailVerificationRequest, nil
 }
 
-func (f *FakeQuerier) DeleteUserEmailVerificationRequest(ctx context.Context, userId int64) error {
-	if _, exists := f.EmailVerificationRequests[userId]; !exists {
-		return errors.New("email verification request not found")
+func (f *FakeQuerier) IncrementEmailVerificationAttempts(ctx context.Context, userId int64) (db.EmailVerificationRequest, error) {
+	emailVerificationRequest, exists := f.EmailVerificationRequests[userId]
+	if !exists {
+		return db.EmailVerificationRequest{}, sql.ErrNoRows
 	}
+	emailVerificationRequest.Attempts++
+	f.EmailVerificationRequests[userId] = emailVerificationRequest
+	return emailVerificationRequest, nil
+}
+
+func (f *FakeQuerier) DeleteUserEmailVerificationRequest(ctx context.Context, userId int64) error {
 	delete(f.EmailVerificationRequests, userId)
 	return nil
 }
@@ -222,9 +320,12 @@ func (f *FakeQuerier) GetUserEmailVerificationRequest(ctx context.Context, userI
 }
 
 func (f *FakeQuerier) SetUserEmailVerified(ctx context.Context, userId int64) error {
-	if _, exists := f.Users[userId]; !exists {
+	user, exists := f.Users[userId]
+	if !exists {
 		return errors.New("user not found")
 	}
+	user.EmailVerified = 1
+	f.Users[userId] = user
 	return nil
 }
 
@@ -239,8 +340,993 @@ func (f *FakeQuerier) ValidateEmailVerificationRequest(ctx context.Context, arg
 	if emailVerificationRequest.Code != arg.Code {
 		return db.EmailVerificationRequest{}, errors.New("invalid code")
 	}
-	if emailVerificationRequest.ExpiresAt < time.Now().Unix() {
+	if emailVerificationRequest.ExpiresAt <= arg.ExpiresAt {
 		return db.EmailVerificationRequest{}, errors.New("email verification request expired")
 	}
-	return db.EmailVerificationRequest(emailVerificationRequest), nil
+	delete(f.EmailVerificationRequests, arg.UserID)
+	return emailVerificationRequest, nil
+}
+
+func (f *FakeQuerier) RehashUserPassword(ctx context.Context, arg db.RehashUserPasswordParams) (int64, error) {
+	user, exists := f.Users[arg.ID]
+	if !exists || user.PasswordHash != arg.PreviousPasswordHash {
+		return 0, nil
+	}
+	user.PasswordHash = arg.PasswordHash
+	f.Users[arg.ID] = user
+	return 1, nil
+}
+
+func (f *FakeQuerier) UpdateUserPassword(ctx context.Context, arg db.UpdateUserPasswordParams) error {
+	user, exists := f.Users[arg.ID]
+	if !exists {
+		return errors.New("user not found")
+	}
+	user.PasswordHash = arg.PasswordHash
+	user.PasswordResetRequired = 0
+	f.Users[arg.ID] = user
+	return nil
+}
+
+func (f *FakeQuerier) SetUserPasswordResetRequired(ctx context.Context, id int64) error {
+	user, exists := f.Users[id]
+	if !exists {
+		return errors.New("user not found")
+	}
+	user.PasswordResetRequired = 1
+	f.Users[id] = user
+	return nil
+}
+
+func (f *FakeQuerier) DeleteUserSessions(ctx context.Context, userId int64) error {
+	for id, session := range f.Sessions {
+		if session.UserID == userId {
+			delete(f.Sessions, id)
+		}
+	}
+	return nil
+}
+
+func (f *FakeQuerier) InsertPasswordResetRequest(ctx context.Context, arg db.InsertPasswordResetRequestParams) (db.PasswordResetRequest, error) {
+	if arg.UserID == 0 || arg.CodeHash == "" {
+		return db.PasswordResetRequest{}, errors.New("invalid password reset request parameters")
+	}
+	passwordResetRequest := db.PasswordResetRequest{
+		ID:        arg.UserID,
+		UserID:    arg.UserID,
+		CreatedAt: arg.CreatedAt,
+		ExpiresAt: arg.ExpiresAt,
+		CodeHash:  arg.CodeHash,
+	}
+	f.PasswordResetRequests[arg.UserID] = passwordResetRequest
+	return passwordResetRequest, nil
+}
+
+func (f *FakeQuerier) GetPasswordResetRequestByCodeHash(ctx context.Context, codeHash string) (db.PasswordResetRequest, error) {
+	for _, passwordResetRequest := range f.PasswordResetRequests {
+		if passwordResetRequest.CodeHash == codeHash {
+			return passwordResetRequest, nil
+		}
+	}
+	return db.PasswordResetRequest{}, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) DeletePasswordResetRequest(ctx context.Context, userId int64) error {
+	delete(f.PasswordResetRequests, userId)
+	return nil
+}
+
+func (f *FakeQuerier) DeletePasswordResetRequestByCodeHash(ctx context.Context, codeHash string) (int64, error) {
+	for userId, request := range f.PasswordResetRequests {
+		if request.CodeHash == codeHash {
+			delete(f.PasswordResetRequests, userId)
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (f *FakeQuerier) GetUserByID(ctx context.Context, id int64) (db.User, error) {
+	user, exists := f.Users[id]
+	if !exists {
+		return db.User{}, sql.ErrNoRows
+	}
+	user.ID = id
+	return user, nil
+}
+
+func (f *FakeQuerier) GetOAuthAccount(ctx context.Context, arg db.GetOAuthAccountParams) (db.OauthAccount, error) {
+	for _, account := range f.OAuthAccounts {
+		if account.Provider == arg.Provider && account.ProviderUserID == arg.ProviderUserID {
+			return account, nil
+		}
+	}
+	return db.OauthAccount{}, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) CreateOAuthAccount(ctx context.Context, arg db.CreateOAuthAccountParams) (db.OauthAccount, error) {
+	if arg.UserID == 0 || arg.Provider == "" || arg.ProviderUserID == "" {
+		return db.OauthAccount{}, errors.New("invalid oauth account parameters")
+	}
+	if _, err := f.GetOAuthAccount(ctx, db.GetOAuthAccountParams{Provider: arg.Provider, ProviderUserID: arg.ProviderUserID}); err == nil {
+		return db.OauthAccount{}, errors.New("UNIQUE constraint failed: oauth_accounts.provider, oauth_accounts.provider_user_id")
+	}
+	account := db.OauthAccount{
+		ID:             int64(len(f.OAuthAccounts) + 1),
+		UserID:         arg.UserID,
+		Provider:       arg.Provider,
+		ProviderUserID: arg.ProviderUserID,
+		CreatedAt:      sql.NullString{String: time.Now().Format(time.RFC3339), Valid: true},
+	}
+	f.OAuthAccounts[account.ID] = account
+	return account, nil
+}
+
+func (f *FakeQuerier) CompleteSessionTwoFactor(ctx context.Context, arg db.CompleteSessionTwoFactorParams) (db.Session, error) {
+	session, exists := f.Sessions[arg.ID]
+	if !exists {
+		return db.Session{}, sql.ErrNoRows
+	}
+	session.TwoFactorPending = 0
+	session.ExpiresAt = arg.ExpiresAt
+	f.Sessions[arg.ID] = session
+	return session, nil
+}
+
+func (f *FakeQuerier) IncrementSessionTwoFactorAttempts(ctx context.Context, id string) (int64, error) {
+	session, exists := f.Sessions[id]
+	if !exists || session.TwoFactorPending == 0 {
+		return 0, sql.ErrNoRows
+	}
+	session.TwoFactorAttempts++
+	f.Sessions[id] = session
+	return session.TwoFactorAttempts, nil
+}
+
+func (f *FakeQuerier) UpsertTOTPCredential(ctx context.Context, arg db.UpsertTOTPCredentialParams) error {
+	if arg.UserID == 0 || len(arg.EncryptedSecret) == 0 {
+		return errors.New("invalid totp credential parameters")
+	}
+	f.TOTPCredentials[arg.UserID] = db.TotpCredential{
+		UserID:          arg.UserID,
+		EncryptedSecret: arg.EncryptedSecret,
+		Enabled:         0,
+		CreatedAt:       arg.CreatedAt,
+	}
+	return nil
+}
+
+func (f *FakeQuerier) GetTOTPCredential(ctx context.Context, userId int64) (db.TotpCredential, error) {
+	credential, exists := f.TOTPCredentials[userId]
+	if !exists {
+		return db.TotpCredential{}, sql.ErrNoRows
+	}
+	return credential, nil
+}
+
+func (f *FakeQuerier) EnableTOTPCredential(ctx context.Context, userId int64) error {
+	credential, exists := f.TOTPCredentials[userId]
+	if !exists {
+		return nil
+	}
+	credential.Enabled = 1
+	f.TOTPCredentials[userId] = credential
+	return nil
+}
+
+func (f *FakeQuerier) AdvanceTOTPCredentialStep(ctx context.Context, arg db.AdvanceTOTPCredentialStepParams) (int64, error) {
+	credential, exists := f.TOTPCredentials[arg.UserID]
+	if !exists || credential.NextStep >= arg.NextStep {
+		return 0, nil
+	}
+	credential.NextStep = arg.NextStep
+	f.TOTPCredentials[arg.UserID] = credential
+	return 1, nil
+}
+
+func (f *FakeQuerier) DeleteTOTPCredential(ctx context.Context, userId int64) error {
+	delete(f.TOTPCredentials, userId)
+	return nil
+}
+
+func (f *FakeQuerier) CreateRecoveryCode(ctx context.Context, arg db.CreateRecoveryCodeParams) error {
+	if arg.UserID == 0 || arg.CodeHash == "" {
+		return errors.New("invalid recovery code parameters")
+	}
+	f.lastRecoveryCodeID++
+	f.RecoveryCodes[f.lastRecoveryCodeID] = db.RecoveryCode{
+		ID:        f.lastRecoveryCodeID,
+		UserID:    arg.UserID,
+		CodeHash:  arg.CodeHash,
+		CreatedAt: arg.CreatedAt,
+	}
+	return nil
+}
+
+func (f *FakeQuerier) GetUnusedRecoveryCodes(ctx context.Context, userId int64) ([]db.RecoveryCode, error) {
+	var codes []db.RecoveryCode
+	for _, code := range f.RecoveryCodes {
+		if code.UserID == userId && !code.UsedAt.Valid {
+			codes = append(codes, code)
+		}
+	}
+	return codes, nil
+}
+
+func (f *FakeQuerier) CountUnusedRecoveryCodes(ctx context.Context, userId int64) (int64, error) {
+	codes, err := f.GetUnusedRecoveryCodes(ctx, userId)
+	return int64(len(codes)), err
+}
+
+func (f *FakeQuerier) MarkRecoveryCodeUsed(ctx context.Context, arg db.MarkRecoveryCodeUsedParams) (int64, error) {
+	code, exists := f.RecoveryCodes[arg.ID]
+	if !exists || code.UsedAt.Valid {
+		return 0, nil
+	}
+	code.UsedAt = arg.UsedAt
+	f.RecoveryCodes[arg.ID] = code
+	return 1, nil
+}
+
+func (f *FakeQuerier) DeleteRecoveryCodes(ctx context.Context, userId int64) error {
+	for id, code := range f.RecoveryCodes {
+		if code.UserID == userId {
+			delete(f.RecoveryCodes, id)
+		}
+	}
+	return nil
+}
+
+func (f *FakeQuerier) ListUserSessions(ctx context.Context, arg db.ListUserSessionsParams) ([]db.Session, error) {
+	var sessions []db.Session
+	for _, session := range f.Sessions {
+		if session.UserID == arg.UserID && session.ExpiresAt > arg.ExpiresAt {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt > sessions[j].LastSeenAt
+	})
+	return sessions, nil
+}
+
+func (f *FakeQuerier) TouchSession(ctx context.Context, arg db.TouchSessionParams) error {
+	session, exists := f.Sessions[arg.ID]
+	if !exists {
+		return nil
+	}
+	session.LastSeenAt = arg.LastSeenAt
+	f.Sessions[arg.ID] = session
+	return nil
+}
+
+func (f *FakeQuerier) DeleteUserSession(ctx context.Context, arg db.DeleteUserSessionParams) (int64, error) {
+	session, exists := f.Sessions[arg.ID]
+	if !exists || session.UserID != arg.UserID {
+		return 0, nil
+	}
+	delete(f.Sessions, arg.ID)
+	return 1, nil
+}
+
+func (f *FakeQuerier) DeleteOtherUserSessions(ctx context.Context, arg db.DeleteOtherUserSessionsParams) error {
+	for id, session := range f.Sessions {
+		if session.UserID == arg.UserID && id != arg.ID {
+			delete(f.Sessions, id)
+		}
+	}
+	return nil
+}
+
+func (f *FakeQuerier) CreateAccessToken(ctx context.Context, arg db.CreateAccessTokenParams) (db.AccessToken, error) {
+	for _, token := range f.AccessTokens {
+		if token.TokenHash == arg.TokenHash {
+			return db.AccessToken{}, errors.New("UNIQUE constraint failed: access_token.token_hash")
+		}
+	}
+	f.lastAccessTokenID++
+	token := db.AccessToken{
+		ID:        f.lastAccessTokenID,
+		UserID:    arg.UserID,
+		Name:      arg.Name,
+		TokenHash: arg.TokenHash,
+		Scopes:    arg.Scopes,
+		CreatedAt: arg.CreatedAt,
+		ExpiresAt: arg.ExpiresAt,
+	}
+	f.AccessTokens[token.ID] = token
+	return token, nil
+}
+
+func (f *FakeQuerier) ValidateAccessToken(ctx context.Context, tokenHash string) (db.ValidateAccessTokenRow, error) {
+	for _, token := range f.AccessTokens {
+		if token.TokenHash != tokenHash {
+			continue
+		}
+		user, exists := f.Users[token.UserID]
+		if !exists {
+			return db.ValidateAccessTokenRow{}, sql.ErrNoRows
+		}
+		return db.ValidateAccessTokenRow{
+			ID:            token.ID,
+			UserID:        token.UserID,
+			Scopes:        token.Scopes,
+			ExpiresAt:     token.ExpiresAt,
+			LastUsedAt:    token.LastUsedAt,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			Role:          user.Role,
+			Disabled:      user.Disabled,
+		}, nil
+	}
+	return db.ValidateAccessTokenRow{}, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) ListUserAccessTokens(ctx context.Context, userId int64) ([]db.AccessToken, error) {
+	var tokens []db.AccessToken
+	for _, token := range f.AccessTokens {
+		if token.UserID == userId {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].ID > tokens[j].ID
+	})
+	return tokens, nil
+}
+
+func (f *FakeQuerier) TouchAccessToken(ctx context.Context, arg db.TouchAccessTokenParams) error {
+	token, exists := f.AccessTokens[arg.ID]
+	if !exists {
+		return nil
+	}
+	token.LastUsedAt = arg.LastUsedAt
+	f.AccessTokens[arg.ID] = token
+	return nil
+}
+
+func (f *FakeQuerier) DeleteUserAccessToken(ctx context.Context, arg db.DeleteUserAccessTokenParams) (int64, error) {
+	token, exists := f.AccessTokens[arg.ID]
+	if !exists || token.UserID != arg.UserID {
+		return 0, nil
+	}
+	delete(f.AccessTokens, arg.ID)
+	return 1, nil
+}
+
+func (f *FakeQuerier) InsertMagicLinkRequest(ctx context.Context, arg db.InsertMagicLinkRequestParams) error {
+	f.lastMagicLinkRequestID++
+	f.MagicLinkRequests[arg.UserID] = db.MagicLinkRequest{
+		ID:          f.lastMagicLinkRequestID,
+		UserID:      arg.UserID,
+		TokenHash:   arg.TokenHash,
+		BrowserHash: arg.BrowserHash,
+		CreatedAt:   arg.CreatedAt,
+		ExpiresAt:   arg.ExpiresAt,
+	}
+	return nil
+}
+
+func (f *FakeQuerier) GetMagicLinkRequestByTokenHash(ctx context.Context, tokenHash string) (db.MagicLinkRequest, error) {
+	for _, request := range f.MagicLinkRequests {
+		if request.TokenHash == tokenHash {
+			return request, nil
+		}
+	}
+	return db.MagicLinkRequest{}, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) DeleteMagicLinkRequest(ctx context.Context, id int64) (int64, error) {
+	for userId, request := range f.MagicLinkRequests {
+		if request.ID == id {
+			delete(f.MagicLinkRequests, userId)
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (f *FakeQuerier) DeleteUserMagicLinkRequest(ctx context.Context, userId int64) error {
+	delete(f.MagicLinkRequests, userId)
+	return nil
+}
+
+func (f *FakeQuerier) InsertEmailChangeRequest(ctx context.Context, arg db.InsertEmailChangeRequestParams) error {
+	f.lastEmailChangeRequestID++
+	f.EmailChangeRequests[arg.UserID] = db.EmailChangeRequest{
+		ID:              f.lastEmailChangeRequestID,
+		UserID:          arg.UserID,
+		NewEmail:        arg.NewEmail,
+		CodeHash:        arg.CodeHash,
+		CancelTokenHash: arg.CancelTokenHash,
+		CreatedAt:       arg.CreatedAt,
+		ExpiresAt:       arg.ExpiresAt,
+	}
+	return nil
+}
+
+func (f *FakeQuerier) GetEmailChangeRequest(ctx context.Context, userId int64) (db.EmailChangeRequest, error) {
+	request, exists := f.EmailChangeRequests[userId]
+	if !exists {
+		return db.EmailChangeRequest{}, sql.ErrNoRows
+	}
+	return request, nil
+}
+
+func (f *FakeQuerier) GetEmailChangeRequestByCancelTokenHash(ctx context.Context, cancelTokenHash string) (db.EmailChangeRequest, error) {
+	for _, request := range f.EmailChangeRequests {
+		if request.CancelTokenHash == cancelTokenHash {
+			return request, nil
+		}
+	}
+	return db.EmailChangeRequest{}, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) IncrementEmailChangeAttempts(ctx context.Context, userId int64) (db.EmailChangeRequest, error) {
+	request, exists := f.EmailChangeRequests[userId]
+	if !exists {
+		return db.EmailChangeRequest{}, sql.ErrNoRows
+	}
+	request.Attempts++
+	f.EmailChangeRequests[userId] = request
+	return request, nil
+}
+
+func (f *FakeQuerier) DeleteEmailChangeRequest(ctx context.Context, id int64) (int64, error) {
+	for userId, request := range f.EmailChangeRequests {
+		if request.ID == id {
+			delete(f.EmailChangeRequests, userId)
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (f *FakeQuerier) DeleteUserEmailChangeRequest(ctx context.Context, userId int64) error {
+	delete(f.EmailChangeRequests, userId)
+	return nil
+}
+
+func (f *FakeQuerier) InsertEmailChangeRevert(ctx context.Context, arg db.InsertEmailChangeRevertParams) error {
+	for _, revert := range f.EmailChangeReverts {
+		if revert.TokenHash == arg.TokenHash {
+			return errors.New("UNIQUE constraint failed: email_change_revert.token_hash")
+		}
+	}
+	f.lastEmailChangeRevertID++
+	f.EmailChangeReverts[f.lastEmailChangeRevertID] = db.EmailChangeRevert{
+		ID:        f.lastEmailChangeRevertID,
+		UserID:    arg.UserID,
+		OldEmail:  arg.OldEmail,
+		TokenHash: arg.TokenHash,
+		ExpiresAt: arg.ExpiresAt,
+	}
+	return nil
+}
+
+func (f *FakeQuerier) GetEmailChangeRevertByTokenHash(ctx context.Context, tokenHash string) (db.EmailChangeRevert, error) {
+	for _, revert := range f.EmailChangeReverts {
+		if revert.TokenHash == tokenHash {
+			return revert, nil
+		}
+	}
+	return db.EmailChangeRevert{}, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) DeleteEmailChangeRevertsSince(ctx context.Context, arg db.DeleteEmailChangeRevertsSinceParams) (int64, error) {
+	before := len(f.EmailChangeReverts)
+	maps.DeleteFunc(f.EmailChangeReverts, func(id int64, revert db.EmailChangeRevert) bool {
+		return revert.UserID == arg.UserID && id >= arg.ID
+	})
+	return int64(before - len(f.EmailChangeReverts)), nil
+}
+
+func (f *FakeQuerier) UpdateUserEmail(ctx context.Context, arg db.UpdateUserEmailParams) error {
+	user, exists := f.Users[arg.ID]
+	if !exists {
+		return errors.New("user not found")
+	}
+	for _, other := range f.Users {
+		if other.ID != arg.ID && other.Email == arg.Email {
+			return errors.New("UNIQUE constraint failed: user.email")
+		}
+	}
+	user.Email = arg.Email
+	user.EmailVerified = 1
+	f.Users[arg.ID] = user
+	return nil
+}
+
+func (f *FakeQuerier) DeleteUser(ctx context.Context, id int64) (int64, error) {
+	if _, exists := f.Users[id]; !exists {
+		return 0, nil
+	}
+	delete(f.Users, id)
+
+	// Like the cascading foreign keys, every row pointing to the user goes with it
+	maps.DeleteFunc(f.Sessions, func(_ string, session db.Session) bool {
+		return session.UserID == id || session.ImpersonatorID.Int64 == id
+	})
+	maps.DeleteFunc(f.Todos, func(_ int64, todo db.Todo) bool { return todo.UserID == id })
+	delete(f.EmailVerificationRequests, id)
+	maps.DeleteFunc(f.PasswordResetRequests, func(_ int64, request db.PasswordResetRequest) bool { return request.UserID == id })
+	maps.DeleteFunc(f.OAuthAccounts, func(_ int64, account db.OauthAccount) bool { return account.UserID == id })
+	delete(f.TOTPCredentials, id)
+	maps.DeleteFunc(f.RecoveryCodes, func(_ int64, code db.RecoveryCode) bool { return code.UserID == id })
+	maps.DeleteFunc(f.AccessTokens, func(_ int64, token db.AccessToken) bool { return token.UserID == id })
+	maps.DeleteFunc(f.MagicLinkRequests, func(_ int64, request db.MagicLinkRequest) bool { return request.UserID == id })
+	maps.DeleteFunc(f.EmailChangeRequests, func(_ int64, request db.EmailChangeRequest) bool { return request.UserID == id })
+	maps.DeleteFunc(f.EmailChangeReverts, func(_ int64, revert db.EmailChangeRevert) bool { return revert.UserID == id })
+	maps.DeleteFunc(f.AuthEvents, func(_ int64, event db.AuthEvent) bool { return event.UserID.Int64 == id })
+	maps.DeleteFunc(f.Invitations, func(_ int64, invitation db.Invitation) bool { return invitation.InviterID == id })
+	maps.DeleteFunc(f.WebAuthnCredentials, func(_ int64, credential db.WebauthnCredential) bool { return credential.UserID == id })
+	maps.DeleteFunc(f.WebAuthnChallenges, func(_ string, challenge db.WebauthnChallenge) bool { return challenge.UserID.Int64 == id })
+	maps.DeleteFunc(f.KnownDevices, func(_ int64, device db.KnownDevice) bool { return device.UserID == id })
+	return 1, nil
+}
+
+// InTx runs fn against the fake itself and restores every table if fn fails
+func (f *FakeQuerier) InTx(ctx context.Context, fn func(q db.Querier) error) error {
+	snapshot := *f
+	snapshot.Sessions = maps.Clone(f.Sessions)
+	snapshot.Todos = maps.Clone(f.Todos)
+	snapshot.Users = maps.Clone(f.Users)
+	snapshot.EmailVerificationRequests = maps.Clone(f.EmailVerificationRequests)
+	snapshot.PasswordResetRequests = maps.Clone(f.PasswordResetRequests)
+	snapshot.OAuthAccounts = maps.Clone(f.OAuthAccounts)
+	snapshot.TOTPCredentials = maps.Clone(f.TOTPCredentials)
+	snapshot.RecoveryCodes = maps.Clone(f.RecoveryCodes)
+	snapshot.AccessTokens = maps.Clone(f.AccessTokens)
+	snapshot.MagicLinkRequests = maps.Clone(f.MagicLinkRequests)
+	snapshot.EmailChangeRequests = maps.Clone(f.EmailChangeRequests)
+	snapshot.EmailChangeReverts = maps.Clone(f.EmailChangeReverts)
+	snapshot.LoginAttempts = maps.Clone(f.LoginAttempts)
+	snapshot.AuthEvents = maps.Clone(f.AuthEvents)
+	snapshot.Invitations = maps.Clone(f.Invitations)
+	snapshot.WebAuthnCredentials = maps.Clone(f.WebAuthnCredentials)
+	snapshot.WebAuthnChallenges = maps.Clone(f.WebAuthnChallenges)
+	snapshot.KnownDevices = maps.Clone(f.KnownDevices)
+
+	if err := fn(f); err != nil {
+		*f = snapshot
+		return err
+	}
+	return nil
+}
+
+func (f *FakeQuerier) GetLoginAttempt(ctx context.Context, email string) (db.LoginAttempt, error) {
+	attempt, exists := f.LoginAttempts[email]
+	if !exists {
+		return db.LoginAttempt{}, sql.ErrNoRows
+	}
+	return attempt, nil
+}
+
+func (f *FakeQuerier) RecordLoginFailure(ctx context.Context, arg db.RecordLoginFailureParams) (db.LoginAttempt, error) {
+	attempt, exists := f.LoginAttempts[arg.Email]
+	if !exists {
+		attempt = db.LoginAttempt{Email: arg.Email}
+	}
+	if attempt.LastFailedAt < arg.WindowStart {
+		attempt.FailedCount = 1
+	} else {
+		attempt.FailedCount++
+	}
+	attempt.LastFailedAt = arg.FailedAt
+	f.LoginAttempts[arg.Email] = attempt
+	return attempt, nil
+}
+
+func (f *FakeQuerier) LockLoginAttempt(ctx context.Context, arg db.LockLoginAttemptParams) error {
+	attempt, exists := f.LoginAttempts[arg.Email]
+	if !exists {
+		return nil
+	}
+	attempt.LockedUntil = arg.LockedUntil
+	attempt.UnlockTokenHash = arg.UnlockTokenHash
+	f.LoginAttempts[arg.Email] = attempt
+	return nil
+}
+
+func (f *FakeQuerier) GetLoginAttemptByUnlockTokenHash(ctx context.Context, unlockTokenHash sql.NullString) (db.LoginAttempt, error) {
+	for _, attempt := range f.LoginAttempts {
+		if attempt.UnlockTokenHash.Valid && attempt.UnlockTokenHash == unlockTokenHash {
+			return attempt, nil
+		}
+	}
+	return db.LoginAttempt{}, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) DeleteLoginAttempt(ctx context.Context, email string) error {
+	delete(f.LoginAttempts, email)
+	return nil
+}
+
+func (f *FakeQuerier) CreateAuthEvent(ctx context.Context, arg db.CreateAuthEventParams) error {
+	f.lastAuthEventID++
+	f.AuthEvents[f.lastAuthEventID] = db.AuthEvent{
+		ID:        f.lastAuthEventID,
+		EventType: arg.EventType,
+		UserID:    arg.UserID,
+		Email:     arg.Email,
+		Outcome:   arg.Outcome,
+		Reason:    arg.Reason,
+		IpAddress: arg.IpAddress,
+		UserAgent: arg.UserAgent,
+		CreatedAt: arg.CreatedAt,
+	}
+	return nil
+}
+
+// sortedAuthEvents returns the events matching keep, most recent first
+func (f *FakeQuerier) sortedAuthEvents(keep func(db.AuthEvent) bool, limit int64) []db.AuthEvent {
+	var events []db.AuthEvent
+	for _, event := range f.AuthEvents {
+		if keep(event) {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID > events[j].ID
+	})
+	if int64(len(events)) > limit {
+		events = events[:limit]
+	}
+	return events
+}
+
+func (f *FakeQuerier) ListUserAuthEvents(ctx context.Context, arg db.ListUserAuthEventsParams) ([]db.AuthEvent, error) {
+	return f.sortedAuthEvents(func(event db.AuthEvent) bool {
+		return event.UserID == arg.UserID
+	}, arg.Limit), nil
+}
+
+func (f *FakeQuerier) ListAuthEvents(ctx context.Context, arg db.ListAuthEventsParams) ([]db.AuthEvent, error) {
+	return f.sortedAuthEvents(func(event db.AuthEvent) bool {
+		return (!arg.UserID.Valid || event.UserID == arg.UserID) &&
+			(!arg.Email.Valid || event.Email == arg.Email.String) &&
+			(!arg.EventType.Valid || event.EventType == arg.EventType.String) &&
+			(!arg.Outcome.Valid || event.Outcome == arg.Outcome.String) &&
+			(!arg.IpAddress.Valid || event.IpAddress == arg.IpAddress.String) &&
+			event.CreatedAt >= arg.Since &&
+			event.CreatedAt < arg.Until &&
+			event.ID < arg.BeforeID
+	}, arg.Limit), nil
+}
+
+func (f *FakeQuerier) DeleteAuthEventsBefore(ctx context.Context, createdAt int64) (int64, error) {
+	var deleted int64
+	for id, event := range f.AuthEvents {
+		if event.CreatedAt < createdAt {
+			delete(f.AuthEvents, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// ListUsers only understands the "%term%" patterns built by the admin console
+func (f *FakeQuerier) ListUsers(ctx context.Context, arg db.ListUsersParams) ([]db.ListUsersRow, error) {
+	term := strings.TrimSuffix(strings.TrimPrefix(arg.Pattern, "%"), "%")
+	term = strings.NewReplacer(`\%`, "%", `\_`, "_", `\\`, `\`).Replace(term)
+
+	var rows []db.ListUsersRow
+	for _, user := range f.Users {
+		if user.ID <= arg.AfterID || !strings.Contains(strings.ToLower(user.Email), strings.ToLower(term)) {
+			continue
+		}
+		row := db.ListUsersRow{
+			ID:            user.ID,
+			Email:         user.Email,
+			Role:          user.Role,
+			EmailVerified: user.EmailVerified,
+			Disabled:      user.Disabled,
+			CreatedAt:     user.CreatedAt,
+		}
+		for _, todo := range f.Todos {
+			if todo.UserID == user.ID {
+				row.TodoCount++
+			}
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].ID < rows[j].ID
+	})
+	if int64(len(rows)) > arg.Limit {
+		rows = rows[:arg.Limit]
+	}
+	return rows, nil
+}
+
+func (f *FakeQuerier) SetUserDisabled(ctx context.Context, arg db.SetUserDisabledParams) (int64, error) {
+	user, exists := f.Users[arg.ID]
+	if !exists {
+		return 0, nil
+	}
+	user.Disabled = arg.Disabled
+	f.Users[arg.ID] = user
+	return 1, nil
+}
+
+func (f *FakeQuerier) SetUserRoleByEmail(ctx context.Context, arg db.SetUserRoleByEmailParams) (int64, error) {
+	var updated int64
+	for id, user := range f.Users {
+		if strings.ToLower(user.Email) == arg.Email && user.EmailVerified == 1 {
+			user.Role = arg.Role
+			f.Users[id] = user
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+func (f *FakeQuerier) CreateInvitation(ctx context.Context, arg db.CreateInvitationParams) (db.Invitation, error) {
+	for _, invitation := range f.Invitations {
+		if invitation.TokenHash == arg.TokenHash {
+			return db.Invitation{}, errors.New("UNIQUE constraint failed: invitation.token_hash")
+		}
+	}
+	f.lastInvitationID++
+	invitation := db.Invitation{
+		ID:        f.lastInvitationID,
+		Email:     arg.Email,
+		TokenHash: arg.TokenHash,
+		InviterID: arg.InviterID,
+		CreatedAt: arg.CreatedAt,
+		ExpiresAt: arg.ExpiresAt,
+	}
+	f.Invitations[invitation.ID] = invitation
+	return invitation, nil
+}
+
+func (f *FakeQuerier) GetInvitationByTokenHash(ctx context.Context, tokenHash string) (db.Invitation, error) {
+	for _, invitation := range f.Invitations {
+		if invitation.TokenHash == tokenHash {
+			return invitation, nil
+		}
+	}
+	return db.Invitation{}, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) UseInvitation(ctx context.Context, arg db.UseInvitationParams) (int64, error) {
+	invitation, exists := f.Invitations[arg.ID]
+	if !exists || invitation.UsedAt.Valid || invitation.ExpiresAt <= arg.UsedAt.Int64 {
+		return 0, nil
+	}
+	invitation.UsedAt = arg.UsedAt
+	f.Invitations[arg.ID] = invitation
+	return 1, nil
+}
+
+func (f *FakeQuerier) ListPendingInvitations(ctx context.Context, arg db.ListPendingInvitationsParams) ([]db.Invitation, error) {
+	var invitations []db.Invitation
+	for _, invitation := range f.Invitations {
+		if invitation.InviterID == arg.InviterID && !invitation.UsedAt.Valid && invitation.ExpiresAt > arg.ExpiresAt {
+			invitations = append(invitations, invitation)
+		}
+	}
+	sort.Slice(invitations, func(i, j int) bool {
+		return invitations[i].ID > invitations[j].ID
+	})
+	return invitations, nil
+}
+
+func (f *FakeQuerier) DeleteInvitation(ctx context.Context, arg db.DeleteInvitationParams) (int64, error) {
+	invitation, exists := f.Invitations[arg.ID]
+	if !exists || invitation.InviterID != arg.InviterID || invitation.UsedAt.Valid {
+		return 0, nil
+	}
+	delete(f.Invitations, arg.ID)
+	return 1, nil
+}
+
+func (f *FakeQuerier) CreateWebAuthnCredential(ctx context.Context, arg db.CreateWebAuthnCredentialParams) (db.WebauthnCredential, error) {
+	for _, credential := range f.WebAuthnCredentials {
+		if credential.CredentialID == arg.CredentialID {
+			return db.WebauthnCredential{}, errors.New("UNIQUE constraint failed: webauthn_credential.credential_id")
+		}
+	}
+	f.lastWebAuthnCredentialID++
+	credential := db.WebauthnCredential{
+		ID:           f.lastWebAuthnCredentialID,
+		UserID:       arg.UserID,
+		CredentialID: arg.CredentialID,
+		PublicKey:    arg.PublicKey,
+		SignCount:    arg.SignCount,
+		Name:         arg.Name,
+		CreatedAt:    arg.CreatedAt,
+	}
+	f.WebAuthnCredentials[credential.ID] = credential
+	return credential, nil
+}
+
+func (f *FakeQuerier) GetWebAuthnCredential(ctx context.Context, credentialID string) (db.WebauthnCredential, error) {
+	for _, credential := range f.WebAuthnCredentials {
+		if credential.CredentialID == credentialID {
+			return credential, nil
+		}
+	}
+	return db.WebauthnCredential{}, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) ListUserWebAuthnCredentials(ctx context.Context, userID int64) ([]db.WebauthnCredential, error) {
+	var credentials []db.WebauthnCredential
+	for _, credential := range f.WebAuthnCredentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, credential)
+		}
+	}
+	sort.Slice(credentials, func(i, j int) bool {
+		if credentials[i].CreatedAt != credentials[j].CreatedAt {
+			return credentials[i].CreatedAt > credentials[j].CreatedAt
+		}
+		return credentials[i].ID > credentials[j].ID
+	})
+	return credentials, nil
+}
+
+func (f *FakeQuerier) UseWebAuthnCredential(ctx context.Context, arg db.UseWebAuthnCredentialParams) (int64, error) {
+	credential, exists := f.WebAuthnCredentials[arg.ID]
+	if !exists || credential.SignCount != arg.PreviousSignCount {
+		return 0, nil
+	}
+	credential.SignCount = arg.SignCount
+	credential.LastUsedAt = arg.LastUsedAt
+	f.WebAuthnCredentials[arg.ID] = credential
+	return 1, nil
+}
+
+func (f *FakeQuerier) DeleteUserWebAuthnCredential(ctx context.Context, arg db.DeleteUserWebAuthnCredentialParams) (int64, error) {
+	credential, exists := f.WebAuthnCredentials[arg.ID]
+	if !exists || credential.UserID != arg.UserID {
+		return 0, nil
+	}
+	delete(f.WebAuthnCredentials, arg.ID)
+	return 1, nil
+}
+
+func (f *FakeQuerier) CreateWebAuthnChallenge(ctx context.Context, arg db.CreateWebAuthnChallengeParams) error {
+	if _, exists := f.WebAuthnChallenges[arg.ChallengeHash]; exists {
+		return errors.New("UNIQUE constraint failed: webauthn_challenge.challenge_hash")
+	}
+	f.WebAuthnChallenges[arg.ChallengeHash] = db.WebauthnChallenge{
+		ChallengeHash: arg.ChallengeHash,
+		Ceremony:      arg.Ceremony,
+		UserID:        arg.UserID,
+		ExpiresAt:     arg.ExpiresAt,
+	}
+	return nil
+}
+
+func (f *FakeQuerier) ConsumeWebAuthnChallenge(ctx context.Context, challengeHash string) (db.WebauthnChallenge, error) {
+	challenge, exists := f.WebAuthnChallenges[challengeHash]
+	if !exists {
+		return db.WebauthnChallenge{}, sql.ErrNoRows
+	}
+	delete(f.WebAuthnChallenges, challengeHash)
+	return challenge, nil
+}
+
+func (f *FakeQuerier) DeleteExpiredWebAuthnChallenges(ctx context.Context, expiresAt int64) error {
+	for hash, challenge := range f.WebAuthnChallenges {
+		if challenge.ExpiresAt <= expiresAt {
+			delete(f.WebAuthnChallenges, hash)
+		}
+	}
+	return nil
+}
+
+func (f *FakeQuerier) CreateKnownDevice(ctx context.Context, arg db.CreateKnownDeviceParams) (db.KnownDevice, error) {
+	for _, device := range f.KnownDevices {
+		if device.UserID == arg.UserID && device.TokenHash == arg.TokenHash {
+			return db.KnownDevice{}, errors.New("UNIQUE constraint failed: known_device.user_id, known_device.token_hash")
+		}
+		if arg.ReportTokenHash.Valid && device.ReportTokenHash == arg.ReportTokenHash {
+			return db.KnownDevice{}, errors.New("UNIQUE constraint failed: known_device.report_token_hash")
+		}
+	}
+	f.lastKnownDeviceID++
+	device := db.KnownDevice{
+		ID:              f.lastKnownDeviceID,
+		UserID:          arg.UserID,
+		TokenHash:       arg.TokenHash,
+		Fingerprint:     arg.Fingerprint,
+		UserAgent:       arg.UserAgent,
+		IpAddress:       arg.IpAddress,
+		CreatedAt:       arg.CreatedAt,
+		LastSeenAt:      arg.LastSeenAt,
+		ReportTokenHash: arg.ReportTokenHash,
+		ReportExpiresAt: arg.ReportExpiresAt,
+	}
+	f.KnownDevices[device.ID] = device
+	return device, nil
+}
+
+func (f *FakeQuerier) GetKnownDeviceByTokenHash(ctx context.Context, arg db.GetKnownDeviceByTokenHashParams) (db.KnownDevice, error) {
+	for _, device := range f.KnownDevices {
+		if device.UserID == arg.UserID && device.TokenHash == arg.TokenHash {
+			return device, nil
+		}
+	}
+	return db.KnownDevice{}, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) GetKnownDeviceByFingerprint(ctx context.Context, arg db.GetKnownDeviceByFingerprintParams) (db.KnownDevice, error) {
+	var found *db.KnownDevice
+	for _, device := range f.KnownDevices {
+		if device.UserID == arg.UserID && device.Fingerprint == arg.Fingerprint && (found == nil || device.LastSeenAt > found.LastSeenAt) {
+			found = &device
+		}
+	}
+	if found == nil {
+		return db.KnownDevice{}, sql.ErrNoRows
+	}
+	return *found, nil
+}
+
+func (f *FakeQuerier) GetKnownDeviceByReportTokenHash(ctx context.Context, reportTokenHash sql.NullString) (db.KnownDevice, error) {
+	for _, device := range f.KnownDevices {
+		if device.ReportTokenHash.Valid && device.ReportTokenHash == reportTokenHash {
+			return device, nil
+		}
+	}
+	return db.KnownDevice{}, sql.ErrNoRows
+}
+
+func (f *FakeQuerier) TouchKnownDevice(ctx context.Context, arg db.TouchKnownDeviceParams) error {
+	device, exists := f.KnownDevices[arg.ID]
+	if !exists {
+		return nil
+	}
+	device.TokenHash = arg.TokenHash
+	device.UserAgent = arg.UserAgent
+	device.IpAddress = arg.IpAddress
+	device.LastSeenAt = arg.LastSeenAt
+	f.KnownDevices[arg.ID] = device
+	return nil
+}
+
+func (f *FakeQuerier) CountUserKnownDevices(ctx context.Context, userID int64) (int64, error) {
+	var count int64
+	for _, device := range f.KnownDevices {
+		if device.UserID == userID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (f *FakeQuerier) ListUserKnownDevices(ctx context.Context, userID int64) ([]db.KnownDevice, error) {
+	var devices []db.KnownDevice
+	for _, device := range f.KnownDevices {
+		if device.UserID == userID {
+			devices = append(devices, device)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].LastSeenAt != devices[j].LastSeenAt {
+			return devices[i].LastSeenAt > devices[j].LastSeenAt
+		}
+		return devices[i].ID > devices[j].ID
+	})
+	return devices, nil
+}
+
+func (f *FakeQuerier) DeleteUserKnownDevice(ctx context.Context, arg db.DeleteUserKnownDeviceParams) (int64, error) {
+	device, exists := f.KnownDevices[arg.ID]
+	if !exists || device.UserID != arg.UserID {
+		return 0, nil
+	}
+	delete(f.KnownDevices, arg.ID)
+	return 1, nil
+}
+
+func (f *FakeQuerier) DeleteDeviceSessions(ctx context.Context, arg db.DeleteDeviceSessionsParams) error {
+	for id, session := range f.Sessions {
+		if session.DeviceID == arg.DeviceID && session.UserID == arg.UserID {
+			delete(f.Sessions, id)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS password_reset_request;
+
+CREATE TABLE IF NOT EXISTS password_reset_request (
+	id INTEGER NOT NULL UNIQUE PRIMARY KEY AUTOINCREMENT,
+    user_id TEXT NOT NULL UNIQUE REFERENCES user(id),
+    created_at TEXT DEFAULT (datetime('now')),
+    expires_at INTEGER NOT NULL,
+    code_hash TEXT NOT NULL UNIQUE
+);
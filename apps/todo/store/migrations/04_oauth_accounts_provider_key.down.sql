@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS oauth_accounts_user_id;
+
+DROP TABLE IF EXISTS oauth_accounts;
+
+CREATE TABLE IF NOT EXISTS oauth_accounts (
+    user_id TEXT NOT NULL UNIQUE PRIMARY KEY REFERENCES user(id),
+    provider TEXT NOT NULL,
+    provider_user_id TEXT NOT NULL,
+    created_at TEXT DEFAULT (datetime('now'))
+);
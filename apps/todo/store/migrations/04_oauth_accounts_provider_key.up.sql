@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS oauth_accounts;
+
+CREATE TABLE IF NOT EXISTS oauth_accounts (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES user(id),
+    provider TEXT NOT NULL,
+    provider_user_id TEXT NOT NULL,
+    created_at TEXT DEFAULT (datetime('now')),
+    UNIQUE (provider, provider_user_id)
+);
+
+CREATE INDEX IF NOT EXISTS oauth_accounts_user_id ON oauth_accounts(user_id);
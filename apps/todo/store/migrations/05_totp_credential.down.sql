@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS totp_credential;
+
+ALTER TABLE session DROP COLUMN two_factor_pending;
@@ -0,0 +1,8 @@
+ALTER TABLE session ADD COLUMN two_factor_pending INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS totp_credential (
+    user_id INTEGER NOT NULL PRIMARY KEY REFERENCES user(id),
+    encrypted_secret BLOB NOT NULL,
+    enabled INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL
+);
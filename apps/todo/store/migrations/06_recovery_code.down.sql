@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS recovery_code_user_id;
+
+DROP TABLE IF EXISTS recovery_code;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS recovery_code (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES user(id),
+    code_hash TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    used_at INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS recovery_code_user_id ON recovery_code(user_id);
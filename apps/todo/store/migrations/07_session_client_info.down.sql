@@ -0,0 +1,3 @@
+ALTER TABLE session DROP COLUMN last_seen_at;
+ALTER TABLE session DROP COLUMN user_agent;
+ALTER TABLE session DROP COLUMN ip_address;
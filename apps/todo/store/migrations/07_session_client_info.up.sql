@@ -0,0 +1,3 @@
+ALTER TABLE session ADD COLUMN ip_address TEXT NOT NULL DEFAULT '';
+ALTER TABLE session ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE session ADD COLUMN last_seen_at INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS access_token_user_id;
+
+DROP TABLE IF EXISTS access_token;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS magic_link_request (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL UNIQUE REFERENCES user(id),
+    token_hash TEXT NOT NULL UNIQUE,
+    browser_hash TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS email_change_request (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL UNIQUE REFERENCES user(id),
+    new_email TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    cancel_token_hash TEXT NOT NULL UNIQUE,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL
+);
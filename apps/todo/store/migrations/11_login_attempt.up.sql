@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS login_attempt (
+    email TEXT NOT NULL PRIMARY KEY,
+    failed_count INTEGER NOT NULL,
+    last_failed_at INTEGER NOT NULL,
+    locked_until INTEGER NOT NULL DEFAULT 0,
+    unlock_token_hash TEXT UNIQUE
+);
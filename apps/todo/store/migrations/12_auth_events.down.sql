@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS auth_events_created_at;
+DROP INDEX IF EXISTS auth_events_user_id;
+DROP TABLE IF EXISTS auth_events;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS auth_events (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    event_type TEXT NOT NULL,
+    user_id INTEGER,
+    email TEXT NOT NULL DEFAULT '',
+    outcome TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS auth_events_user_id ON auth_events (user_id, id);
+CREATE INDEX IF NOT EXISTS auth_events_created_at ON auth_events (created_at);
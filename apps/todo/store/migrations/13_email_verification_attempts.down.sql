@@ -0,0 +1,3 @@
+ALTER TABLE email_verification_request DROP COLUMN send_window_started_at;
+ALTER TABLE email_verification_request DROP COLUMN send_count;
+ALTER TABLE email_verification_request DROP COLUMN attempts;
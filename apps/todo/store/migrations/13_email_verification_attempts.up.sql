@@ -0,0 +1,3 @@
+ALTER TABLE email_verification_request ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE email_verification_request ADD COLUMN send_count INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE email_verification_request ADD COLUMN send_window_started_at INTEGER NOT NULL DEFAULT 0;
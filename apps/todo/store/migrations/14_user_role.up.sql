@@ -0,0 +1,2 @@
+ALTER TABLE user ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+ALTER TABLE user ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0;
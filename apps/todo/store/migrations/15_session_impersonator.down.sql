@@ -0,0 +1 @@
+ALTER TABLE session DROP COLUMN impersonator_id;
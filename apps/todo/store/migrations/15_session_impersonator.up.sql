@@ -0,0 +1 @@
+ALTER TABLE session ADD COLUMN impersonator_id INTEGER;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS invitation_inviter_id;
+
+DROP TABLE IF EXISTS invitation;
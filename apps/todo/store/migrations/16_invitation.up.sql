@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS invitation (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    email TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    inviter_id INTEGER NOT NULL REFERENCES user(id),
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used_at INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS invitation_inviter_id ON invitation(inviter_id);
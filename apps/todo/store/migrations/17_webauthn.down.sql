@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS webauthn_challenge;
+
+DROP INDEX IF EXISTS webauthn_credential_user_id;
+
+DROP TABLE IF EXISTS webauthn_credential;
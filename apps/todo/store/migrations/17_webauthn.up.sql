@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS webauthn_credential (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES user(id),
+    credential_id TEXT NOT NULL UNIQUE,
+    public_key BLOB NOT NULL,
+    sign_count INTEGER NOT NULL DEFAULT 0,
+    name TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    last_used_at INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS webauthn_credential_user_id ON webauthn_credential(user_id);
+
+CREATE TABLE IF NOT EXISTS webauthn_challenge (
+    challenge_hash TEXT NOT NULL PRIMARY KEY,
+    ceremony TEXT NOT NULL,
+    user_id INTEGER REFERENCES user(id),
+    expires_at INTEGER NOT NULL
+);
@@ -0,0 +1,2 @@
+ALTER TABLE session DROP COLUMN absolute_expires_at;
+ALTER TABLE session DROP COLUMN remember_me;
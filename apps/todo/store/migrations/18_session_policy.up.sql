@@ -0,0 +1,5 @@
+ALTER TABLE session ADD COLUMN remember_me INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE session ADD COLUMN absolute_expires_at INTEGER NOT NULL DEFAULT 0;
+
+-- Existing sessions keep their current expiry as their maximum lifetime
+UPDATE session SET absolute_expires_at = expires_at;
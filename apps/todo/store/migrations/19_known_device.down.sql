@@ -0,0 +1,6 @@
+ALTER TABLE session DROP COLUMN device_id;
+
+DROP INDEX IF EXISTS known_device_user_id_fingerprint;
+DROP INDEX IF EXISTS known_device_user_id_token_hash;
+
+DROP TABLE IF EXISTS known_device;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS known_device (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES user(id),
+    token_hash TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    user_agent TEXT NOT NULL,
+    ip_address TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    last_seen_at INTEGER NOT NULL,
+    report_token_hash TEXT UNIQUE,
+    report_expires_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS known_device_user_id_token_hash ON known_device(user_id, token_hash);
+CREATE INDEX IF NOT EXISTS known_device_user_id_fingerprint ON known_device(user_id, fingerprint);
+
+ALTER TABLE session ADD COLUMN device_id INTEGER REFERENCES known_device(id);
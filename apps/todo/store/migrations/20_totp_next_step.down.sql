@@ -0,0 +1 @@
+ALTER TABLE totp_credential DROP COLUMN next_step;
@@ -0,0 +1 @@
+ALTER TABLE totp_credential ADD COLUMN next_step INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,203 @@
+-- SQLite cannot change a foreign key, every table pointing to a user is rebuilt with cascading deletes.
+-- Rows already pointing to a deleted user are dropped on the way.
+-- Dropping known_device while sessions point to it fails with foreign keys on, so they are turned off for the
+-- rebuild as https://www.sqlite.org/lang_altertable.html describes, and foreign_key_check lists any row left dangling.
+-- The migration runs its own transaction because PRAGMA foreign_keys has no effect inside one.
+PRAGMA foreign_keys = OFF;
+BEGIN;
+
+CREATE TABLE known_device_new (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES user(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    user_agent TEXT NOT NULL,
+    ip_address TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    last_seen_at INTEGER NOT NULL,
+    report_token_hash TEXT UNIQUE,
+    report_expires_at INTEGER NOT NULL DEFAULT 0
+);
+INSERT INTO known_device_new SELECT * FROM known_device WHERE user_id IN (SELECT id FROM user);
+DROP TABLE known_device;
+ALTER TABLE known_device_new RENAME TO known_device;
+CREATE UNIQUE INDEX known_device_user_id_token_hash ON known_device(user_id, token_hash);
+CREATE INDEX known_device_user_id_fingerprint ON known_device(user_id, fingerprint);
+
+CREATE TABLE session_new (
+    id TEXT NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES user(id) ON DELETE CASCADE,
+    expires_at INTEGER NOT NULL,
+    created_at TEXT DEFAULT (datetime('now')),
+    two_factor_pending INTEGER NOT NULL DEFAULT 0,
+    ip_address TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    last_seen_at INTEGER NOT NULL DEFAULT 0,
+    impersonator_id INTEGER REFERENCES user(id) ON DELETE CASCADE,
+    remember_me INTEGER NOT NULL DEFAULT 0,
+    absolute_expires_at INTEGER NOT NULL DEFAULT 0,
+    device_id INTEGER REFERENCES known_device(id) ON DELETE SET NULL
+);
+INSERT INTO session_new SELECT * FROM session WHERE user_id IN (SELECT id FROM user) AND (impersonator_id IS NULL OR impersonator_id IN (SELECT id FROM user));
+DROP TABLE session;
+ALTER TABLE session_new RENAME TO session;
+
+CREATE TABLE email_verification_request_new (
+    user_id INTEGER NOT NULL UNIQUE PRIMARY KEY REFERENCES user(id) ON DELETE CASCADE,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    code TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    send_count INTEGER NOT NULL DEFAULT 1,
+    send_window_started_at INTEGER NOT NULL DEFAULT 0
+);
+INSERT INTO email_verification_request_new SELECT * FROM email_verification_request WHERE user_id IN (SELECT id FROM user);
+DROP TABLE email_verification_request;
+ALTER TABLE email_verification_request_new RENAME TO email_verification_request;
+
+CREATE TABLE todos_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES user(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    description TEXT,
+    is_complete INTEGER NOT NULL DEFAULT 0
+);
+INSERT INTO todos_new SELECT * FROM todos WHERE user_id IN (SELECT id FROM user);
+DROP TABLE todos;
+ALTER TABLE todos_new RENAME TO todos;
+
+CREATE TABLE password_reset_request_new (
+    id INTEGER NOT NULL UNIQUE PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL UNIQUE REFERENCES user(id) ON DELETE CASCADE,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    code_hash TEXT NOT NULL UNIQUE
+);
+INSERT INTO password_reset_request_new SELECT * FROM password_reset_request WHERE user_id IN (SELECT id FROM user);
+DROP TABLE password_reset_request;
+ALTER TABLE password_reset_request_new RENAME TO password_reset_request;
+
+CREATE TABLE oauth_accounts_new (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES user(id) ON DELETE CASCADE,
+    provider TEXT NOT NULL,
+    provider_user_id TEXT NOT NULL,
+    created_at TEXT DEFAULT (datetime('now')),
+    UNIQUE (provider, provider_user_id)
+);
+INSERT INTO oauth_accounts_new SELECT * FROM oauth_accounts WHERE user_id IN (SELECT id FROM user);
+DROP TABLE oauth_accounts;
+ALTER TABLE oauth_accounts_new RENAME TO oauth_accounts;
+CREATE INDEX oauth_accounts_user_id ON oauth_accounts(user_id);
+
+CREATE TABLE totp_credential_new (
+    user_id INTEGER NOT NULL PRIMARY KEY REFERENCES user(id) ON DELETE CASCADE,
+    encrypted_secret BLOB NOT NULL,
+    enabled INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL,
+    next_step INTEGER NOT NULL DEFAULT 0
+);
+INSERT INTO totp_credential_new SELECT * FROM totp_credential WHERE user_id IN (SELECT id FROM user);
+DROP TABLE totp_credential;
+ALTER TABLE totp_credential_new RENAME TO totp_credential;
+
+CREATE TABLE recovery_code_new (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES user(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    used_at INTEGER
+);
+INSERT INTO recovery_code_new SELECT * FROM recovery_code WHERE user_id IN (SELECT id FROM user);
+DROP TABLE recovery_code;
+ALTER TABLE recovery_code_new RENAME TO recovery_code;
+CREATE INDEX recovery_code_user_id ON recovery_code(user_id);
+
+CREATE TABLE access_token_new (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES user(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    last_used_at INTEGER
+);
+INSERT INTO access_token_new SELECT * FROM access_token WHERE user_id IN (SELECT id FROM user);
+DROP TABLE access_token;
+ALTER TABLE access_token_new RENAME TO access_token;
+CREATE INDEX access_token_user_id ON access_token(user_id);
+
+CREATE TABLE magic_link_request_new (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL UNIQUE REFERENCES user(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    browser_hash TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+INSERT INTO magic_link_request_new SELECT * FROM magic_link_request WHERE user_id IN (SELECT id FROM user);
+DROP TABLE magic_link_request;
+ALTER TABLE magic_link_request_new RENAME TO magic_link_request;
+
+CREATE TABLE email_change_request_new (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL UNIQUE REFERENCES user(id) ON DELETE CASCADE,
+    new_email TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    cancel_token_hash TEXT NOT NULL UNIQUE,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+INSERT INTO email_change_request_new SELECT * FROM email_change_request WHERE user_id IN (SELECT id FROM user);
+DROP TABLE email_change_request;
+ALTER TABLE email_change_request_new RENAME TO email_change_request;
+
+CREATE TABLE invitation_new (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    email TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    inviter_id INTEGER NOT NULL REFERENCES user(id) ON DELETE CASCADE,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used_at INTEGER
+);
+INSERT INTO invitation_new SELECT * FROM invitation WHERE inviter_id IN (SELECT id FROM user);
+DROP TABLE invitation;
+ALTER TABLE invitation_new RENAME TO invitation;
+CREATE INDEX invitation_inviter_id ON invitation(inviter_id);
+
+CREATE TABLE webauthn_credential_new (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES user(id) ON DELETE CASCADE,
+    credential_id TEXT NOT NULL UNIQUE,
+    public_key BLOB NOT NULL,
+    sign_count INTEGER NOT NULL DEFAULT 0,
+    name TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    last_used_at INTEGER
+);
+INSERT INTO webauthn_credential_new SELECT * FROM webauthn_credential WHERE user_id IN (SELECT id FROM user);
+DROP TABLE webauthn_credential;
+ALTER TABLE webauthn_credential_new RENAME TO webauthn_credential;
+CREATE INDEX webauthn_credential_user_id ON webauthn_credential(user_id);
+
+CREATE TABLE webauthn_challenge_new (
+    challenge_hash TEXT NOT NULL PRIMARY KEY,
+    ceremony TEXT NOT NULL,
+    user_id INTEGER REFERENCES user(id) ON DELETE CASCADE,
+    expires_at INTEGER NOT NULL
+);
+INSERT INTO webauthn_challenge_new SELECT * FROM webauthn_challenge WHERE user_id IS NULL OR user_id IN (SELECT id FROM user);
+DROP TABLE webauthn_challenge;
+ALTER TABLE webauthn_challenge_new RENAME TO webauthn_challenge;
+
+-- auth_events keeps no foreign key so the deletion of an account can still be recorded once it is gone
+CREATE TRIGGER auth_events_user_delete AFTER DELETE ON user
+BEGIN
+    DELETE FROM auth_events WHERE user_id = OLD.id;
+END;
+
+PRAGMA foreign_key_check;
+COMMIT;
+PRAGMA foreign_keys = ON;
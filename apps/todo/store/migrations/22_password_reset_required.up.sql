@@ -0,0 +1 @@
+ALTER TABLE user ADD COLUMN password_reset_required INTEGER NOT NULL DEFAULT 0;
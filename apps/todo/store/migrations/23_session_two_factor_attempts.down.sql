@@ -0,0 +1 @@
+ALTER TABLE session DROP COLUMN two_factor_attempts;
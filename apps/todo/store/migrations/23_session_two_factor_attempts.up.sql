@@ -0,0 +1 @@
+ALTER TABLE session ADD COLUMN two_factor_attempts INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS email_change_revert;
+ALTER TABLE email_change_request DROP COLUMN attempts;
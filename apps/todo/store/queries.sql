@@ -20,12 +20,15 @@ RETURNING *;
 DELETE FROM todos WHERE id = ? AND user_id = ?;
 
 -- name: CreateSession :one
-INSERT INTO session (id, user_id, expires_at) VALUES (?, ?, ?) RETURNING *;
+INSERT INTO session (id, user_id, expires_at, two_factor_pending, ip_address, user_agent, last_seen_at, impersonator_id, remember_me, absolute_expires_at, device_id)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING *;
 
 -- name: ValidateSessionToken :one
-SELECT s.id, s.user_id as user_id, s.expires_at, u.email, u.email_verified
+SELECT s.id, s.user_id as user_id, s.expires_at, s.two_factor_pending, s.last_seen_at, s.impersonator_id, s.remember_me, s.absolute_expires_at, u.email, u.email_verified, u.role, u.disabled, a.email AS impersonator_email, a.role AS impersonator_role, a.disabled AS impersonator_disabled
 FROM session s 
 INNER JOIN user u ON u.id = s.user_id 
+LEFT JOIN user a ON a.id = s.impersonator_id
 WHERE s.id = ?;
 
 -- name: DeleteSession :exec
@@ -40,12 +43,24 @@ INSERT INTO user (email, password_hash) VALUES (?, ?) RETURNING *;
 -- name: GetUserByEmail :one
 SELECT * FROM user WHERE email = ?;
 
+-- name: GetUserByNormalizedEmail :one
+SELECT * FROM user WHERE lower(email) = sqlc.arg(email) ORDER BY id LIMIT 1;
+
 -- name: InsertUserEmailVerificationRequest :one
-INSERT INTO email_verification_request (user_id, created_at, expires_at, code) 
-VALUES (?, ?, ?, ?)
-ON CONFLICT(user_id) DO UPDATE SET created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at, code = EXCLUDED.code
+INSERT INTO email_verification_request (user_id, created_at, expires_at, code, attempts, send_count, send_window_started_at)
+VALUES (sqlc.arg(user_id), sqlc.arg(created_at), sqlc.arg(expires_at), sqlc.arg(code), 0, 1, sqlc.arg(created_at))
+ON CONFLICT(user_id) DO UPDATE SET
+    created_at = EXCLUDED.created_at,
+    expires_at = EXCLUDED.expires_at,
+    code = EXCLUDED.code,
+    attempts = 0,
+    send_count = CASE WHEN email_verification_request.send_window_started_at <= sqlc.arg(window_start) THEN 1 ELSE email_verification_request.send_count + 1 END,
+    send_window_started_at = CASE WHEN email_verification_request.send_window_started_at <= sqlc.arg(window_start) THEN EXCLUDED.created_at ELSE email_verification_request.send_window_started_at END
 RETURNING *;
 
+-- name: IncrementEmailVerificationAttempts :one
+UPDATE email_verification_request SET attempts = attempts + 1 WHERE user_id = ? RETURNING *;
+
 -- name: GetUserEmailVerificationRequest :one
 SELECT * FROM email_verification_request WHERE user_id = ?;
 
@@ -57,3 +72,300 @@ DELETE FROM email_verification_request WHERE user_id = ? AND code = ? AND expire
 
 -- name: SetUserEmailVerified :exec
 UPDATE user SET email_verified = 1 WHERE id = ?;
+
+-- name: UpdateUserPassword :exec
+UPDATE user SET password_hash = ?, password_reset_required = 0, updated_at = datetime('now') WHERE id = ?;
+
+-- name: SetUserPasswordResetRequired :exec
+UPDATE user SET password_reset_required = 1, updated_at = datetime('now') WHERE id = ?;
+
+-- name: RehashUserPassword :execrows
+UPDATE user SET password_hash = sqlc.arg(password_hash), updated_at = datetime('now')
+WHERE id = sqlc.arg(id) AND password_hash = sqlc.arg(previous_password_hash);
+
+-- name: DeleteUserSessions :exec
+DELETE FROM session WHERE user_id = ?;
+
+-- name: InsertPasswordResetRequest :one
+INSERT INTO password_reset_request (user_id, created_at, expires_at, code_hash)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(user_id) DO UPDATE SET created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at, code_hash = EXCLUDED.code_hash
+RETURNING *;
+
+-- name: GetPasswordResetRequestByCodeHash :one
+SELECT * FROM password_reset_request WHERE code_hash = ?;
+
+-- name: DeletePasswordResetRequest :exec
+DELETE FROM password_reset_request WHERE user_id = ?;
+
+-- name: DeletePasswordResetRequestByCodeHash :execrows
+DELETE FROM password_reset_request WHERE code_hash = ?;
+
+-- name: GetUserByID :one
+SELECT * FROM user WHERE id = ?;
+
+-- name: GetOAuthAccount :one
+SELECT * FROM oauth_accounts WHERE provider = ? AND provider_user_id = ?;
+
+-- name: CreateOAuthAccount :one
+INSERT INTO oauth_accounts (user_id, provider, provider_user_id) VALUES (?, ?, ?) RETURNING *;
+
+-- name: CompleteSessionTwoFactor :one
+UPDATE session SET two_factor_pending = 0, expires_at = ? WHERE id = ? RETURNING *;
+
+-- name: IncrementSessionTwoFactorAttempts :one
+UPDATE session SET two_factor_attempts = two_factor_attempts + 1 WHERE id = ? AND two_factor_pending = 1 RETURNING two_factor_attempts;
+
+-- name: UpsertTOTPCredential :exec
+INSERT INTO totp_credential (user_id, encrypted_secret, enabled, created_at)
+VALUES (?, ?, 0, ?)
+ON CONFLICT(user_id) DO UPDATE SET encrypted_secret = EXCLUDED.encrypted_secret, enabled = 0, created_at = EXCLUDED.created_at, next_step = 0;
+
+-- name: GetTOTPCredential :one
+SELECT * FROM totp_credential WHERE user_id = ?;
+
+-- name: EnableTOTPCredential :exec
+UPDATE totp_credential SET enabled = 1 WHERE user_id = ?;
+
+-- name: AdvanceTOTPCredentialStep :execrows
+UPDATE totp_credential SET next_step = sqlc.arg(next_step)
+WHERE user_id = sqlc.arg(user_id) AND next_step < sqlc.arg(next_step);
+
+-- name: DeleteTOTPCredential :exec
+DELETE FROM totp_credential WHERE user_id = ?;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_code (user_id, code_hash, created_at) VALUES (?, ?, ?);
+
+-- name: GetUnusedRecoveryCodes :many
+SELECT * FROM recovery_code WHERE user_id = ? AND used_at IS NULL;
+
+-- name: CountUnusedRecoveryCodes :one
+SELECT COUNT(*) FROM recovery_code WHERE user_id = ? AND used_at IS NULL;
+
+-- name: MarkRecoveryCodeUsed :execrows
+UPDATE recovery_code SET used_at = ? WHERE id = ? AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_code WHERE user_id = ?;
+
+-- name: ListUserSessions :many
+SELECT * FROM session WHERE user_id = ? AND expires_at > ? ORDER BY last_seen_at DESC;
+
+-- name: TouchSession :exec
+UPDATE session SET last_seen_at = ? WHERE id = ?;
+
+-- name: DeleteUserSession :execrows
+DELETE FROM session WHERE id = ? AND user_id = ?;
+
+-- name: DeleteOtherUserSessions :exec
+DELETE FROM session WHERE user_id = ? AND id != ?;
+
+-- name: CreateAccessToken :one
+INSERT INTO access_token (user_id, name, token_hash, scopes, created_at, expires_at)
+VALUES (?, ?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: ValidateAccessToken :one
+SELECT t.id, t.user_id, t.scopes, t.expires_at, t.last_used_at, u.email, u.email_verified, u.role, u.disabled
+FROM access_token t
+INNER JOIN user u ON u.id = t.user_id
+WHERE t.token_hash = ?;
+
+-- name: ListUserAccessTokens :many
+SELECT * FROM access_token WHERE user_id = ? ORDER BY created_at DESC, id DESC;
+
+-- name: TouchAccessToken :exec
+UPDATE access_token SET last_used_at = ? WHERE id = ?;
+
+-- name: DeleteUserAccessToken :execrows
+DELETE FROM access_token WHERE id = ? AND user_id = ?;
+
+-- name: InsertMagicLinkRequest :exec
+INSERT INTO magic_link_request (user_id, token_hash, browser_hash, created_at, expires_at)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(user_id) DO UPDATE SET token_hash = EXCLUDED.token_hash, browser_hash = EXCLUDED.browser_hash, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at;
+
+-- name: GetMagicLinkRequestByTokenHash :one
+SELECT * FROM magic_link_request WHERE token_hash = ?;
+
+-- name: DeleteMagicLinkRequest :execrows
+DELETE FROM magic_link_request WHERE id = ?;
+
+-- name: DeleteUserMagicLinkRequest :exec
+DELETE FROM magic_link_request WHERE user_id = ?;
+
+-- name: InsertEmailChangeRequest :exec
+INSERT INTO email_change_request (user_id, new_email, code_hash, cancel_token_hash, created_at, expires_at)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT(user_id) DO UPDATE SET new_email = EXCLUDED.new_email, code_hash = EXCLUDED.code_hash, cancel_token_hash = EXCLUDED.cancel_token_hash, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at, attempts = 0;
+
+-- name: GetEmailChangeRequest :one
+SELECT * FROM email_change_request WHERE user_id = ?;
+
+-- name: GetEmailChangeRequestByCancelTokenHash :one
+SELECT * FROM email_change_request WHERE cancel_token_hash = ?;
+
+-- name: IncrementEmailChangeAttempts :one
+UPDATE email_change_request SET attempts = attempts + 1 WHERE user_id = ? RETURNING *;
+
+-- name: DeleteEmailChangeRequest :execrows
+DELETE FROM email_change_request WHERE id = ?;
+
+-- name: DeleteUserEmailChangeRequest :exec
+DELETE FROM email_change_request WHERE user_id = ?;
+
+-- name: InsertEmailChangeRevert :exec
+INSERT INTO email_change_revert (user_id, old_email, token_hash, expires_at)
+VALUES (?, ?, ?, ?);
+
+-- name: GetEmailChangeRevertByTokenHash :one
+SELECT * FROM email_change_revert WHERE token_hash = ?;
+
+-- name: DeleteEmailChangeRevertsSince :execrows
+DELETE FROM email_change_revert WHERE user_id = ? AND id >= ?;
+
+-- name: UpdateUserEmail :exec
+UPDATE user SET email = ?, email_verified = 1, updated_at = datetime('now') WHERE id = ?;
+
+-- name: DeleteUser :execrows
+DELETE FROM user WHERE id = ?;
+
+-- name: GetLoginAttempt :one
+SELECT * FROM login_attempt WHERE email = ?;
+
+-- name: RecordLoginFailure :one
+INSERT INTO login_attempt (email, failed_count, last_failed_at)
+VALUES (sqlc.arg(email), 1, sqlc.arg(failed_at))
+ON CONFLICT(email) DO UPDATE SET
+    failed_count = CASE WHEN login_attempt.last_failed_at < sqlc.arg(window_start) THEN 1 ELSE login_attempt.failed_count + 1 END,
+    last_failed_at = EXCLUDED.last_failed_at
+RETURNING *;
+
+-- name: LockLoginAttempt :exec
+UPDATE login_attempt SET locked_until = ?, unlock_token_hash = ? WHERE email = ?;
+
+-- name: GetLoginAttemptByUnlockTokenHash :one
+SELECT * FROM login_attempt WHERE unlock_token_hash = ?;
+
+-- name: DeleteLoginAttempt :exec
+DELETE FROM login_attempt WHERE email = ?;
+
+-- name: CreateAuthEvent :exec
+INSERT INTO auth_events (event_type, user_id, email, outcome, reason, ip_address, user_agent, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: ListUserAuthEvents :many
+SELECT * FROM auth_events WHERE user_id = ? ORDER BY id DESC LIMIT ?;
+
+-- name: ListAuthEvents :many
+SELECT * FROM auth_events
+WHERE (sqlc.narg(user_id) IS NULL OR user_id = sqlc.narg(user_id))
+    AND (sqlc.narg(email) IS NULL OR email = sqlc.narg(email))
+    AND (sqlc.narg(event_type) IS NULL OR event_type = sqlc.narg(event_type))
+    AND (sqlc.narg(outcome) IS NULL OR outcome = sqlc.narg(outcome))
+    AND (sqlc.narg(ip_address) IS NULL OR ip_address = sqlc.narg(ip_address))
+    AND created_at >= sqlc.arg(since)
+    AND created_at < sqlc.arg(until)
+    AND id < sqlc.arg(before_id)
+ORDER BY id DESC
+LIMIT sqlc.arg(limit);
+
+-- name: DeleteAuthEventsBefore :execrows
+DELETE FROM auth_events WHERE created_at < ?;
+
+-- name: ListUsers :many
+SELECT u.id, u.email, u.role, u.email_verified, u.disabled, u.created_at, COUNT(t.id) AS todo_count
+FROM user u
+LEFT JOIN todos t ON t.user_id = u.id
+WHERE u.email LIKE sqlc.arg(pattern) ESCAPE '\' AND u.id > sqlc.arg(after_id)
+GROUP BY u.id
+ORDER BY u.id
+LIMIT sqlc.arg(limit);
+
+-- name: CountUserTodos :one
+SELECT COUNT(*) FROM todos WHERE user_id = ?;
+
+-- name: SetUserDisabled :execrows
+UPDATE user SET disabled = ?, updated_at = datetime('now') WHERE id = ?;
+
+-- name: SetUserRoleByEmail :execrows
+UPDATE user SET role = ?, updated_at = datetime('now') WHERE lower(email) = ? AND email_verified = 1;
+
+-- name: CreateInvitation :one
+INSERT INTO invitation (email, token_hash, inviter_id, created_at, expires_at)
+VALUES (?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: GetInvitationByTokenHash :one
+SELECT * FROM invitation WHERE token_hash = ?;
+
+-- name: UseInvitation :execrows
+UPDATE invitation SET used_at = sqlc.arg(used_at)
+WHERE id = sqlc.arg(id) AND used_at IS NULL AND expires_at > sqlc.arg(used_at);
+
+-- name: ListPendingInvitations :many
+SELECT * FROM invitation
+WHERE inviter_id = ? AND used_at IS NULL AND expires_at > ?
+ORDER BY created_at DESC, id DESC;
+
+-- name: DeleteInvitation :execrows
+DELETE FROM invitation WHERE id = ? AND inviter_id = ? AND used_at IS NULL;
+
+-- name: CreateWebAuthnCredential :one
+INSERT INTO webauthn_credential (user_id, credential_id, public_key, sign_count, name, created_at)
+VALUES (?, ?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: GetWebAuthnCredential :one
+SELECT * FROM webauthn_credential WHERE credential_id = ?;
+
+-- name: ListUserWebAuthnCredentials :many
+SELECT * FROM webauthn_credential WHERE user_id = ? ORDER BY created_at DESC, id DESC;
+
+-- name: UseWebAuthnCredential :execrows
+UPDATE webauthn_credential SET sign_count = sqlc.arg(sign_count), last_used_at = sqlc.arg(last_used_at)
+WHERE id = sqlc.arg(id) AND sign_count = sqlc.arg(previous_sign_count);
+
+-- name: DeleteUserWebAuthnCredential :execrows
+DELETE FROM webauthn_credential WHERE id = ? AND user_id = ?;
+
+-- name: CreateWebAuthnChallenge :exec
+INSERT INTO webauthn_challenge (challenge_hash, ceremony, user_id, expires_at)
+VALUES (?, ?, ?, ?);
+
+-- name: ConsumeWebAuthnChallenge :one
+DELETE FROM webauthn_challenge WHERE challenge_hash = ?
+RETURNING *;
+
+-- name: DeleteExpiredWebAuthnChallenges :exec
+DELETE FROM webauthn_challenge WHERE expires_at <= ?;
+
+-- name: CreateKnownDevice :one
+INSERT INTO known_device (user_id, token_hash, fingerprint, user_agent, ip_address, created_at, last_seen_at, report_token_hash, report_expires_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: GetKnownDeviceByTokenHash :one
+SELECT * FROM known_device WHERE user_id = ? AND token_hash = ?;
+
+-- name: GetKnownDeviceByFingerprint :one
+SELECT * FROM known_device WHERE user_id = ? AND fingerprint = ? ORDER BY last_seen_at DESC LIMIT 1;
+
+-- name: GetKnownDeviceByReportTokenHash :one
+SELECT * FROM known_device WHERE report_token_hash = ?;
+
+-- name: TouchKnownDevice :exec
+UPDATE known_device SET token_hash = ?, user_agent = ?, ip_address = ?, last_seen_at = ? WHERE id = ?;
+
+-- name: CountUserKnownDevices :one
+SELECT COUNT(*) FROM known_device WHERE user_id = ?;
+
+-- name: ListUserKnownDevices :many
+SELECT * FROM known_device WHERE user_id = ? ORDER BY last_seen_at DESC, id DESC;
+
+-- name: DeleteUserKnownDevice :execrows
+DELETE FROM known_device WHERE id = ? AND user_id = ?;
+
+-- name: DeleteDeviceSessions :exec
+DELETE FROM session WHERE device_id = ? AND user_id = ?;
@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -15,21 +16,59 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Querier runs the generated queries and can group several of them in a transaction
+type Querier interface {
+	db.Querier
+	// InTx runs fn with queries bound to a single transaction, which is rolled back if fn returns an error
+	InTx(ctx context.Context, fn func(q db.Querier) error) error
+}
+
+// Queries is the Querier backed by the SQLite database
+type Queries struct {
+	*db.Queries
+	sqlite *sql.DB
+}
+
+func (q *Queries) InTx(ctx context.Context, fn func(q db.Querier) error) error {
+	tx, err := q.sqlite.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(q.Queries.WithTx(tx)); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
 type migrationApplier struct {
 	Db *sql.DB
 }
 
 func (ma *migrationApplier) applyMigrations() error {
+	migrationPaths, err := upMigrationPaths()
+	if err != nil {
+		return err
+	}
+	return ma.apply(migrationPaths)
+}
+
+// upMigrationPaths lists the up migrations in the order they are applied
+func upMigrationPaths() ([]string, error) {
 	cwd, err := os.Getwd()
 	if err != nil {
-		return fmt.Errorf("failed to get current working directory: %w", err)
+		return nil, fmt.Errorf("failed to get current working directory: %w", err)
 	}
 
 	migrationsDir := filepath.Join(cwd, "../store/migrations")
 
 	entries, err := os.ReadDir(migrationsDir)
 	if err != nil {
-		return fmt.Errorf("failed to read migrations directory: %w", err)
+		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
 	}
 
 	var migrationPaths []string
@@ -42,7 +81,10 @@ func (ma *migrationApplier) applyMigrations() error {
 	}
 
 	sort.Strings(migrationPaths)
+	return migrationPaths, nil
+}
 
+func (ma *migrationApplier) apply(migrationPaths []string) error {
 	for _, path := range migrationPaths {
 		migrationBytes, err := os.ReadFile(path)
 		if err != nil {
@@ -59,16 +101,18 @@ func (ma *migrationApplier) applyMigrations() error {
 }
 
 // Init creates a new in-memory SQLite database and runs the schema.sql file to create the tables
-// It returns a new db.Queries instance connected to the in-memory database
-func Init(config *config.Config) (*db.Queries, error) {
+// It returns a new Queries instance connected to the in-memory database
+func Init(config *config.Config) (*Queries, error) {
 	var sqlite *sql.DB
 	var err error
 
 	if config.Env == "test" {
-		sqlite, err = sql.Open("sqlite3", ":memory:")
+		sqlite, err = sql.Open("sqlite3", ":memory:?_foreign_keys=on")
 		if err != nil {
 			return nil, err
 		}
+		// Every connection to :memory: opens its own empty database, so the pool must not open a second one
+		sqlite.SetMaxOpenConns(1)
 
 		// Create migration applier and apply migrations only in test
 		migrationApp := &migrationApplier{Db: sqlite}
@@ -76,13 +120,13 @@ func Init(config *config.Config) (*db.Queries, error) {
 			return nil, fmt.Errorf("migration failed: %w", err)
 		}
 	} else {
-		sqlite, err = sql.Open("sqlite3", "./todo.db")
+		sqlite, err = sql.Open("sqlite3", "./todo.db?_foreign_keys=on")
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	q := db.New(sqlite)
+	q := &Queries{Queries: db.New(sqlite), sqlite: sqlite}
 	err = q.Ping(context.Background())
 	if err != nil {
 		return nil, err
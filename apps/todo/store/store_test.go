@@ -1,9 +1,17 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/AltSoyuz/soy-experiments/apps/todo/config"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/gen/db"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -36,3 +44,690 @@ func TestApplyMigrations(t *testing.T) {
 		t.Fatalf("expected table 'your_table_name' to exist, but it does not")
 	}
 }
+
+func TestCascadeMigrationKeepsDevices(t *testing.T) {
+	sqlite, err := sql.Open("sqlite3", ":memory:?_foreign_keys=on")
+	if err != nil {
+		t.Fatalf("failed to open in-memory SQLite database: %v", err)
+	}
+	defer sqlite.Close()
+	sqlite.SetMaxOpenConns(1)
+
+	migrationPaths, err := upMigrationPaths()
+	if err != nil {
+		t.Fatalf("failed to list migrations: %v", err)
+	}
+	cascade := slices.IndexFunc(migrationPaths, func(path string) bool {
+		return strings.HasSuffix(path, "21_cascade_user_delete.up.sql")
+	})
+	if cascade < 0 {
+		t.Fatalf("missing the cascade migration in %v", migrationPaths)
+	}
+	migrationApp := &migrationApplier{Db: sqlite}
+	if err := migrationApp.apply(migrationPaths[:cascade]); err != nil {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+
+	_, err = sqlite.Exec(`
+INSERT INTO user (id, email, password_hash) VALUES (1, 'user@example.com', 'hash');
+INSERT INTO known_device (id, user_id, token_hash, fingerprint, user_agent, ip_address, created_at, last_seen_at)
+VALUES (1, 1, 'device', 'fingerprint', 'agent', '192.0.2.1', 0, 0);
+INSERT INTO session (id, user_id, expires_at, device_id) VALUES ('session', 1, 0, 1);`)
+	if err != nil {
+		t.Fatalf("failed to insert rows: %v", err)
+	}
+
+	f := func(path string) {
+		t.Helper()
+
+		if err := migrationApp.apply([]string{path}); err != nil {
+			t.Fatalf("failed to apply migration: %v", err)
+		}
+		var deviceId sql.NullInt64
+		if err := sqlite.QueryRow("SELECT device_id FROM session WHERE id = 'session'").Scan(&deviceId); err != nil {
+			t.Fatalf("failed to read session: %v", err)
+		}
+		if deviceId.Int64 != 1 {
+			t.Fatalf("expected the session to keep its device, got %v", deviceId)
+		}
+		var leftovers int
+		if err := sqlite.QueryRow("SELECT count(*) FROM sqlite_master WHERE name LIKE '%_new'").Scan(&leftovers); err != nil {
+			t.Fatalf("failed to list tables: %v", err)
+		}
+		if leftovers != 0 {
+			t.Fatalf("expected no table left from the rebuild, got %d", leftovers)
+		}
+		rows, err := sqlite.Query("PRAGMA foreign_key_check")
+		if err != nil {
+			t.Fatalf("failed to check foreign keys: %v", err)
+		}
+		defer rows.Close()
+		if rows.Next() {
+			t.Fatalf("expected no foreign key violation")
+		}
+		var foreignKeys int
+		if err := sqlite.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+			t.Fatalf("failed to read foreign_keys: %v", err)
+		}
+		if foreignKeys != 1 {
+			t.Fatalf("expected foreign keys to be turned back on")
+		}
+	}
+
+	f(migrationPaths[cascade])
+	f(strings.TrimSuffix(migrationPaths[cascade], ".up.sql") + ".down.sql")
+}
+
+func TestQueriesInTx(t *testing.T) {
+	queries, err := Init(&config.Config{Env: "test"})
+	if err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+	ctx := context.Background()
+
+	user, err := queries.CreateUser(ctx, db.CreateUserParams{Email: "user@example.com", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	// A failing function rolls back every query it ran
+	errAbort := errors.New("abort")
+	err = queries.InTx(ctx, func(q db.Querier) error {
+		if _, err := q.DeleteUser(ctx, user.ID); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected the function error, got: %v", err)
+	}
+	if _, err := queries.GetUserByID(ctx, user.ID); err != nil {
+		t.Fatalf("expected the user to remain after rollback, got: %v", err)
+	}
+
+	err = queries.InTx(ctx, func(q db.Querier) error {
+		_, err := q.DeleteUser(ctx, user.ID)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := queries.GetUserByID(ctx, user.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected the user to be deleted, got: %v", err)
+	}
+}
+
+func TestQueriesDeleteUserCascades(t *testing.T) {
+	queries, err := Init(&config.Config{Env: "test"})
+	if err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+	ctx := context.Background()
+
+	user, err := queries.CreateUser(ctx, db.CreateUserParams{Email: "user@example.com", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	admin, err := queries.CreateUser(ctx, db.CreateUserParams{Email: "admin@example.com", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	device, err := queries.CreateKnownDevice(ctx, db.CreateKnownDeviceParams{UserID: user.ID, TokenHash: "device"})
+	if err != nil {
+		t.Fatalf("failed to create known device: %v", err)
+	}
+	sessions := []db.CreateSessionParams{
+		{ID: "user", UserID: user.ID, DeviceID: sql.NullInt64{Int64: device.ID, Valid: true}},
+		{ID: "admin", UserID: admin.ID},
+		// the admin impersonating the other user
+		{ID: "impersonation", UserID: user.ID, ImpersonatorID: sql.NullInt64{Int64: admin.ID, Valid: true}},
+	}
+	for _, session := range sessions {
+		if _, err := queries.CreateSession(ctx, session); err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+	}
+	if _, err := queries.CreateTodo(ctx, db.CreateTodoParams{Name: "todo", UserID: user.ID}); err != nil {
+		t.Fatalf("failed to create todo: %v", err)
+	}
+	err = queries.CreateAuthEvent(ctx, db.CreateAuthEventParams{EventType: "login", UserID: sql.NullInt64{Int64: user.ID, Valid: true}, Outcome: "success"})
+	if err != nil {
+		t.Fatalf("failed to create auth event: %v", err)
+	}
+
+	f := func(table string, expectCount int) {
+		t.Helper()
+
+		var count int
+		if err := queries.sqlite.QueryRowContext(ctx, "SELECT count(*) FROM "+table).Scan(&count); err != nil {
+			t.Fatalf("failed to count %s: %v", table, err)
+		}
+		if count != expectCount {
+			t.Fatalf("unexpected number of rows in %s; got %d; want %d", table, count, expectCount)
+		}
+	}
+
+	// the sessions opened by a deleted admin end with it
+	if _, err := queries.DeleteUser(ctx, admin.ID); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	f("session", 1)
+
+	if _, err := queries.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	f("session", 0)
+	f("known_device", 0)
+	f("todos", 0)
+	f("auth_events", 0)
+}
+
+func TestFakeQuerierInTx(t *testing.T) {
+	fakeQuerier := NewFakeQuerier()
+	fakeQuerier.Users[1] = db.User{ID: 1, Email: "user@example.com"}
+	ctx := context.Background()
+
+	errAbort := errors.New("abort")
+	err := fakeQuerier.InTx(ctx, func(q db.Querier) error {
+		if _, err := q.DeleteUser(ctx, 1); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected the function error, got: %v", err)
+	}
+	if _, ok := fakeQuerier.Users[1]; !ok {
+		t.Fatalf("expected the user to remain after rollback")
+	}
+}
+
+func TestQueriesListAuthEvents(t *testing.T) {
+	queries, err := Init(&config.Config{Env: "test"})
+	if err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+	ctx := context.Background()
+
+	events := []db.CreateAuthEventParams{
+		{EventType: "login", UserID: sql.NullInt64{Int64: 1, Valid: true}, Email: "a@example.com", Outcome: "failure", IpAddress: "10.0.0.1", CreatedAt: 100},
+		{EventType: "login", UserID: sql.NullInt64{Int64: 1, Valid: true}, Email: "a@example.com", Outcome: "success", IpAddress: "10.0.0.1", CreatedAt: 200},
+		{EventType: "logout", UserID: sql.NullInt64{Int64: 1, Valid: true}, Email: "", Outcome: "success", IpAddress: "10.0.0.2", CreatedAt: 300},
+		{EventType: "login", Email: "unknown@example.com", Outcome: "failure", IpAddress: "10.0.0.3", CreatedAt: 400},
+	}
+	for _, event := range events {
+		if err := queries.CreateAuthEvent(ctx, event); err != nil {
+			t.Fatalf("failed to create auth event: %v", err)
+		}
+	}
+
+	f := func(params db.ListAuthEventsParams, expectIDs ...int64) {
+		t.Helper()
+
+		if params.Until == 0 {
+			params.Until = 1000
+		}
+		if params.BeforeID == 0 {
+			params.BeforeID = 1000
+		}
+		if params.Limit == 0 {
+			params.Limit = 10
+		}
+		rows, err := queries.ListAuthEvents(ctx, params)
+		if err != nil {
+			t.Fatalf("failed to list auth events: %v", err)
+		}
+		var got []int64
+		for _, row := range rows {
+			got = append(got, row.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(expectIDs) {
+			t.Fatalf("unexpected events; got %v; want %v", got, expectIDs)
+		}
+	}
+
+	// no filter, most recent first
+	f(db.ListAuthEventsParams{}, 4, 3, 2, 1)
+
+	// every filter matches on its own column
+	f(db.ListAuthEventsParams{UserID: sql.NullInt64{Int64: 1, Valid: true}}, 3, 2, 1)
+	f(db.ListAuthEventsParams{Email: sql.NullString{String: "unknown@example.com", Valid: true}}, 4)
+	f(db.ListAuthEventsParams{EventType: sql.NullString{String: "login", Valid: true}, Outcome: sql.NullString{String: "failure", Valid: true}}, 4, 1)
+	f(db.ListAuthEventsParams{IpAddress: sql.NullString{String: "10.0.0.1", Valid: true}}, 2, 1)
+
+	// time range, paging and limit
+	f(db.ListAuthEventsParams{Since: 200, Until: 400}, 3, 2)
+	f(db.ListAuthEventsParams{BeforeID: 3, Limit: 1}, 2)
+}
+
+func TestQueriesEmailVerificationSendCount(t *testing.T) {
+	queries, err := Init(&config.Config{Env: "test"})
+	if err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := queries.CreateUser(ctx, db.CreateUserParams{Email: "user@example.com", PasswordHash: "hash"}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	f := func(createdAt, windowStart, expectSendCount, expectWindowStartedAt int64) {
+		t.Helper()
+
+		request, err := queries.InsertUserEmailVerificationRequest(ctx, db.InsertUserEmailVerificationRequestParams{
+			UserID:      1,
+			CreatedAt:   createdAt,
+			ExpiresAt:   createdAt + 600,
+			Code:        "CODE",
+			WindowStart: windowStart,
+		})
+		if err != nil {
+			t.Fatalf("failed to insert email verification request: %v", err)
+		}
+		if request.Attempts != 0 || request.SendCount != expectSendCount || request.SendWindowStartedAt != expectWindowStartedAt {
+			t.Fatalf("unexpected request %+v", request)
+		}
+
+		request, err = queries.IncrementEmailVerificationAttempts(ctx, 1)
+		if err != nil || request.Attempts != 1 {
+			t.Fatalf("unexpected attempts %d, %v", request.Attempts, err)
+		}
+	}
+
+	// first code
+	f(100, 0, 1, 100)
+
+	// codes within the window are counted and reset the attempts
+	f(200, 50, 2, 100)
+	f(300, 99, 3, 100)
+
+	// a code after the window starts a new one
+	f(400, 100, 1, 400)
+}
+
+func TestQueriesListUsers(t *testing.T) {
+	queries, err := Init(&config.Config{Env: "test"})
+	if err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+	ctx := context.Background()
+
+	for _, email := range []string{"alice@example.com", "bob_smith@example.com", "bobxsmith@example.com"} {
+		if _, err := queries.CreateUser(ctx, db.CreateUserParams{Email: email, PasswordHash: "hash"}); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+	for _, name := range []string{"first", "second"} {
+		if _, err := queries.CreateTodo(ctx, db.CreateTodoParams{UserID: 1, Name: name}); err != nil {
+			t.Fatalf("failed to create todo: %v", err)
+		}
+	}
+
+	f := func(pattern string, afterID int64, expectIDs ...int64) []db.ListUsersRow {
+		t.Helper()
+
+		rows, err := queries.ListUsers(ctx, db.ListUsersParams{Pattern: pattern, AfterID: afterID, Limit: 10})
+		if err != nil {
+			t.Fatalf("failed to list users: %v", err)
+		}
+		var ids []int64
+		for _, row := range rows {
+			ids = append(ids, row.ID)
+		}
+		if !slices.Equal(ids, expectIDs) {
+			t.Fatalf("unexpected users %v; want %v", ids, expectIDs)
+		}
+		return rows
+	}
+
+	rows := f("%", 0, 1, 2, 3)
+	if rows[0].TodoCount != 2 || rows[1].TodoCount != 0 || rows[0].Role != "user" {
+		t.Fatalf("unexpected rows %+v", rows)
+	}
+
+	// LIKE ignores case and escaped wildcards only match themselves
+	f("%BOB%", 0, 2, 3)
+	f(`%bob\_smith%`, 0, 2)
+	f("%", 1, 2, 3)
+}
+
+func TestQueriesUseInvitation(t *testing.T) {
+	queries, err := Init(&config.Config{Env: "test"})
+	if err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err := queries.CreateUser(ctx, db.CreateUserParams{Email: "inviter@example.com", PasswordHash: "hash"}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	invitation, err := queries.CreateInvitation(ctx, db.CreateInvitationParams{
+		Email:     "friend@example.com",
+		TokenHash: "hash",
+		InviterID: 1,
+		CreatedAt: 100,
+		ExpiresAt: 200,
+	})
+	if err != nil {
+		t.Fatalf("failed to create invitation: %v", err)
+	}
+
+	f := func(usedAt, expectUsed int64) {
+		t.Helper()
+
+		used, err := queries.UseInvitation(ctx, db.UseInvitationParams{
+			UsedAt: sql.NullInt64{Int64: usedAt, Valid: true},
+			ID:     invitation.ID,
+		})
+		if err != nil {
+			t.Fatalf("failed to use invitation: %v", err)
+		}
+		if used != expectUsed {
+			t.Fatalf("unexpected used rows %d; want %d", used, expectUsed)
+		}
+	}
+
+	// expired invitations cannot be used
+	f(200, 0)
+
+	// an invitation is used only once
+	f(150, 1)
+	f(160, 0)
+
+	pending, err := queries.ListPendingInvitations(ctx, db.ListPendingInvitationsParams{InviterID: 1, ExpiresAt: 100})
+	if err != nil || len(pending) != 0 {
+		t.Fatalf("unexpected pending invitations %+v, %v", pending, err)
+	}
+}
+
+func TestQueriesWebAuthn(t *testing.T) {
+	queries, err := Init(&config.Config{Env: "test"})
+	if err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err := queries.CreateUser(ctx, db.CreateUserParams{Email: "user@example.com", PasswordHash: "hash"}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	credential, err := queries.CreateWebAuthnCredential(ctx, db.CreateWebAuthnCredentialParams{
+		UserID:       1,
+		CredentialID: "credential",
+		PublicKey:    []byte{1, 2, 3},
+		Name:         "laptop",
+		CreatedAt:    100,
+	})
+	if err != nil {
+		t.Fatalf("failed to create credential: %v", err)
+	}
+
+	f := func(previous, signCount, expectUpdated int64) {
+		t.Helper()
+
+		updated, err := queries.UseWebAuthnCredential(ctx, db.UseWebAuthnCredentialParams{
+			SignCount:         signCount,
+			LastUsedAt:        sql.NullInt64{Int64: 150, Valid: true},
+			ID:                credential.ID,
+			PreviousSignCount: previous,
+		})
+		if err != nil {
+			t.Fatalf("failed to use credential: %v", err)
+		}
+		if updated != expectUpdated {
+			t.Fatalf("unexpected updated rows %d; want %d", updated, expectUpdated)
+		}
+	}
+
+	// the counter only moves from the value it was verified against
+	f(0, 1, 1)
+	f(0, 2, 0)
+	f(1, 2, 1)
+
+	stored, err := queries.GetWebAuthnCredential(ctx, "credential")
+	if err != nil || stored.SignCount != 2 || stored.LastUsedAt.Int64 != 150 {
+		t.Fatalf("unexpected credential %+v, %v", stored, err)
+	}
+
+	// a challenge is consumed once
+	err = queries.CreateWebAuthnChallenge(ctx, db.CreateWebAuthnChallengeParams{
+		ChallengeHash: "hash",
+		Ceremony:      "login",
+		ExpiresAt:     200,
+	})
+	if err != nil {
+		t.Fatalf("failed to create challenge: %v", err)
+	}
+	challenge, err := queries.ConsumeWebAuthnChallenge(ctx, "hash")
+	if err != nil || challenge.Ceremony != "login" || challenge.UserID.Valid {
+		t.Fatalf("unexpected challenge %+v, %v", challenge, err)
+	}
+	if _, err := queries.ConsumeWebAuthnChallenge(ctx, "hash"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows, got: %v", err)
+	}
+}
+
+func TestQueriesKnownDevices(t *testing.T) {
+	queries, err := Init(&config.Config{Env: "test"})
+	if err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err := queries.CreateUser(ctx, db.CreateUserParams{Email: "user@example.com", PasswordHash: "hash"}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	for i, lastSeenAt := range []int64{100, 200} {
+		_, err := queries.CreateKnownDevice(ctx, db.CreateKnownDeviceParams{
+			UserID:          1,
+			TokenHash:       fmt.Sprintf("token-%d", i),
+			Fingerprint:     "fingerprint",
+			CreatedAt:       lastSeenAt,
+			LastSeenAt:      lastSeenAt,
+			ReportTokenHash: sql.NullString{String: fmt.Sprintf("report-%d", i), Valid: true},
+		})
+		if err != nil {
+			t.Fatalf("failed to create known device: %v", err)
+		}
+	}
+
+	// the fingerprint finds the most recently seen device
+	device, err := queries.GetKnownDeviceByFingerprint(ctx, db.GetKnownDeviceByFingerprintParams{UserID: 1, Fingerprint: "fingerprint"})
+	if err != nil || device.TokenHash != "token-1" {
+		t.Fatalf("unexpected device %+v, %v", device, err)
+	}
+
+	// a cookie is known to one user only
+	_, err = queries.GetKnownDeviceByTokenHash(ctx, db.GetKnownDeviceByTokenHashParams{UserID: 2, TokenHash: "token-0"})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows, got: %v", err)
+	}
+
+	// removing a device signs out its sessions only
+	for i, deviceId := range []int64{1, 2} {
+		_, err := queries.CreateSession(ctx, db.CreateSessionParams{
+			ID:                fmt.Sprintf("session-%d", i),
+			UserID:            1,
+			ExpiresAt:         1000,
+			AbsoluteExpiresAt: 1000,
+			DeviceID:          sql.NullInt64{Int64: deviceId, Valid: true},
+		})
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+	}
+	if err := queries.DeleteDeviceSessions(ctx, db.DeleteDeviceSessionsParams{DeviceID: sql.NullInt64{Int64: 1, Valid: true}, UserID: 1}); err != nil {
+		t.Fatalf("failed to delete device sessions: %v", err)
+	}
+	deleted, err := queries.DeleteUserKnownDevice(ctx, db.DeleteUserKnownDeviceParams{ID: 1, UserID: 1})
+	if err != nil || deleted != 1 {
+		t.Fatalf("unexpected deleted rows %d, %v", deleted, err)
+	}
+	sessions, err := queries.ListUserSessions(ctx, db.ListUserSessionsParams{UserID: 1})
+	if err != nil || len(sessions) != 1 || sessions[0].ID != "session-1" {
+		t.Fatalf("unexpected sessions %+v, %v", sessions, err)
+	}
+
+	count, err := queries.CountUserKnownDevices(ctx, 1)
+	if err != nil || count != 1 {
+		t.Fatalf("unexpected known devices %d, %v", count, err)
+	}
+}
+
+func TestQueriesRehashUserPassword(t *testing.T) {
+	queries, err := Init(&config.Config{Env: "test"})
+	if err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err := queries.CreateUser(ctx, db.CreateUserParams{Email: "user@example.com", PasswordHash: "old"}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	f := func(previous string, expectUpdated int64) {
+		t.Helper()
+
+		updated, err := queries.RehashUserPassword(ctx, db.RehashUserPasswordParams{
+			PasswordHash:         "new",
+			ID:                   1,
+			PreviousPasswordHash: previous,
+		})
+		if err != nil {
+			t.Fatalf("failed to rehash password: %v", err)
+		}
+		if updated != expectUpdated {
+			t.Fatalf("unexpected updated rows %d; want %d", updated, expectUpdated)
+		}
+	}
+
+	// a password changed since it was verified is kept
+	f("changed", 0)
+	f("old", 1)
+
+	user, err := queries.GetUserByID(ctx, 1)
+	if err != nil || user.PasswordHash != "new" {
+		t.Fatalf("unexpected user %+v, %v", user, err)
+	}
+}
+
+func TestQueriesDeletePasswordResetRequestByCodeHash(t *testing.T) {
+	queries, err := Init(&config.Config{Env: "test"})
+	if err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err := queries.CreateUser(ctx, db.CreateUserParams{Email: "user@example.com", PasswordHash: "hash"}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	request := db.InsertPasswordResetRequestParams{UserID: 1, CreatedAt: 1, ExpiresAt: 2, CodeHash: "old"}
+	if _, err := queries.InsertPasswordResetRequest(ctx, request); err != nil {
+		t.Fatalf("failed to insert password reset request: %v", err)
+	}
+	// a new code replaces the old one
+	request.CodeHash = "new"
+	if _, err := queries.InsertPasswordResetRequest(ctx, request); err != nil {
+		t.Fatalf("failed to insert password reset request: %v", err)
+	}
+
+	f := func(codeHash string, expectDeleted int64) {
+		t.Helper()
+
+		deleted, err := queries.DeletePasswordResetRequestByCodeHash(ctx, codeHash)
+		if err != nil {
+			t.Fatalf("failed to delete password reset request: %v", err)
+		}
+		if deleted != expectDeleted {
+			t.Fatalf("unexpected deleted rows for %q; got %d; want %d", codeHash, deleted, expectDeleted)
+		}
+	}
+
+	f("old", 0)
+	f("new", 1)
+	// a code is consumed once
+	f("new", 0)
+}
+
+func TestQueriesPasswordResetRequired(t *testing.T) {
+	queries, err := Init(&config.Config{Env: "test"})
+	if err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+	ctx := context.Background()
+
+	user, err := queries.CreateUser(ctx, db.CreateUserParams{Email: "user@example.com", PasswordHash: "hash"})
+	if err != nil || user.PasswordResetRequired != 0 {
+		t.Fatalf("unexpected user %+v, %v", user, err)
+	}
+	f := func(expect int64) {
+		t.Helper()
+
+		user, err := queries.GetUserByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("failed to get user: %v", err)
+		}
+		if user.PasswordResetRequired != expect {
+			t.Fatalf("unexpected password_reset_required; got %d; want %d", user.PasswordResetRequired, expect)
+		}
+	}
+
+	if err := queries.SetUserPasswordResetRequired(ctx, user.ID); err != nil {
+		t.Fatalf("failed to require a password reset: %v", err)
+	}
+	f(1)
+	// rehashing the same password does not count as a reset
+	if _, err := queries.RehashUserPassword(ctx, db.RehashUserPasswordParams{PasswordHash: "rehashed", ID: user.ID, PreviousPasswordHash: "hash"}); err != nil {
+		t.Fatalf("failed to rehash password: %v", err)
+	}
+	f(1)
+	if err := queries.UpdateUserPassword(ctx, db.UpdateUserPasswordParams{PasswordHash: "new", ID: user.ID}); err != nil {
+		t.Fatalf("failed to update password: %v", err)
+	}
+	f(0)
+}
+
+func TestQueriesAdvanceTOTPCredentialStep(t *testing.T) {
+	queries, err := Init(&config.Config{Env: "test"})
+	if err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err := queries.CreateUser(ctx, db.CreateUserParams{Email: "user@example.com", PasswordHash: "hash"}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	upsert := func() {
+		t.Helper()
+
+		err := queries.UpsertTOTPCredential(ctx, db.UpsertTOTPCredentialParams{UserID: 1, EncryptedSecret: []byte("secret"), CreatedAt: 1})
+		if err != nil {
+			t.Fatalf("failed to store totp credential: %v", err)
+		}
+	}
+	f := func(next int64, expectUpdated int64) {
+		t.Helper()
+
+		updated, err := queries.AdvanceTOTPCredentialStep(ctx, db.AdvanceTOTPCredentialStepParams{NextStep: next, UserID: 1})
+		if err != nil {
+			t.Fatalf("failed to advance step: %v", err)
+		}
+		if updated != expectUpdated {
+			t.Fatalf("unexpected updated rows advancing to %d; got %d; want %d", next, updated, expectUpdated)
+		}
+	}
+
+	upsert()
+	f(10, 1)
+	// a step is used once and never goes back
+	f(10, 0)
+	f(9, 0)
+	f(11, 1)
+
+	credential, err := queries.GetTOTPCredential(ctx, 1)
+	if err != nil || credential.NextStep != 11 {
+		t.Fatalf("unexpected credential %+v, %v", credential, err)
+	}
+
+	// a new secret starts over
+	upsert()
+	f(1, 1)
+}
@@ -2,11 +2,25 @@ package tests
 
 import (
 	"context"
+	"encoding/base32"
+	"encoding/json"
 	"errors"
+	"flag"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/AltSoyuz/soy-experiments/apps/todo/auth"
+	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+	"github.com/AltSoyuz/soy-experiments/lib/oidc/oidctest"
+	"github.com/AltSoyuz/soy-experiments/lib/otp"
+	"github.com/AltSoyuz/soy-experiments/lib/webauthn"
+	"github.com/AltSoyuz/soy-experiments/lib/webauthn/webauthntest"
 )
 
 func TestRegistrationRateLimit(t *testing.T) {
@@ -72,13 +86,21 @@ func TestWeakPasswordRegistration(t *testing.T) {
 	resp := server.sendRequest(http.MethodGet, "/register", RequestOptions{}).assertStatus(http.StatusOK)
 
 	csrfToken := extractCSRFToken(resp.body)
-	server.sendRequest(http.MethodPost, "/users", RequestOptions{
+	resp = server.sendRequest(http.MethodPost, "/users", RequestOptions{
 		Body:      "email=" + randomEmail() + "&password=test&confirm-password=test",
 		HTMX:      false,
 		CSRFToken: csrfToken,
 	}).assertStatus(http.StatusOK).
 		assertContains("Password too weak or compromised")
 
+	// Long passwords are still refused when they appear in a breach
+	server.sendRequest(http.MethodPost, "/users", RequestOptions{
+		Body:      "email=" + randomEmail() + "&password=" + auth.TestBreachedPassword + "&confirm-password=" + auth.TestBreachedPassword,
+		HTMX:      false,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("Password too weak or compromised")
+
 	checkServerErrors(t, errChan)
 }
 
@@ -195,6 +217,1083 @@ func TestTodoCRUD(t *testing.T) {
 	checkServerErrors(t, errChan)
 }
 
+func TestPasswordReset(t *testing.T) {
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	user := server.givenNewAuthenticatedUser()
+	newPassword := "N3wStr0ngP@ssw0rd!"
+
+	// Request reset link
+	resp := server.sendRequest(http.MethodGet, "/forgot-password", RequestOptions{}).assertStatus(http.StatusOK)
+	server.sendRequest(http.MethodPost, "/forgot-password", RequestOptions{
+		Body:      "email=" + user.Email,
+		HTMX:      true,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("reset link is on its way")
+
+	// Follow the link and choose a new password
+	resp = server.sendRequest(http.MethodGet, "/reset-password?code="+auth.TestPasswordResetCode, RequestOptions{}).
+		assertStatus(http.StatusOK).
+		assertContains(auth.TestPasswordResetCode)
+	server.sendRequest(http.MethodPost, "/reset-password", RequestOptions{
+		Body: "code=" + auth.TestPasswordResetCode +
+			"&password=" + newPassword +
+			"&confirm-password=" + newPassword,
+		HTMX:      true,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent).
+		assertRedirect("/login")
+
+	// Existing sessions are revoked
+	server.sendRequest(http.MethodGet, "/", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusUnauthorized)
+
+	// The code cannot be used twice
+	resp = server.sendRequest(http.MethodGet, "/reset-password?code="+auth.TestPasswordResetCode, RequestOptions{}).assertStatus(http.StatusOK)
+	server.sendRequest(http.MethodPost, "/reset-password", RequestOptions{
+		Body: "code=" + auth.TestPasswordResetCode +
+			"&password=" + newPassword +
+			"&confirm-password=" + newPassword,
+		HTMX:      true,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("Invalid or expired password reset code")
+
+	// Login with the new password
+	resp = server.sendRequest(http.MethodGet, "/login", RequestOptions{}).assertStatus(http.StatusOK)
+	server.sendRequest(http.MethodPost, "/authenticate/password", RequestOptions{
+		Body:      "email=" + user.Email + "&password=" + newPassword,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent).
+		assertRedirect("/")
+
+	checkServerErrors(t, errChan)
+}
+
+func TestOAuthLogin(t *testing.T) {
+	provider := oidctest.NewProvider("todo", "secret")
+	defer provider.Close()
+
+	configPath := filepath.Join(t.TempDir(), "config.yml")
+	configYAML := "oauth_providers:\n" +
+		"  - name: idp\n" +
+		"    issuer: " + provider.Issuer() + "\n" +
+		"    client_id: todo\n" +
+		"    client_secret: secret\n"
+	if err := os.WriteFile(configPath, []byte(configYAML), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	previousConfig := flag.Lookup("config").Value.String()
+	flag.Set("config", configPath)
+	defer flag.Set("config", previousConfig)
+
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	email := randomEmail()
+	provider.SetUser(oidctest.User{Subject: "oauth-" + email, Email: email, EmailVerified: true})
+
+	server.sendRequest(http.MethodGet, "/login", RequestOptions{}).
+		assertStatus(http.StatusOK).
+		assertContains("/oauth/idp/start")
+
+	// Start redirects to the provider and stores the state in a cookie
+	start := server.sendRequest(http.MethodGet, "/oauth/idp/start", RequestOptions{NoRedirect: true}).
+		assertStatus(http.StatusFound)
+	authorizeURL := start.Header.Get("Location")
+	if !strings.HasPrefix(authorizeURL, provider.Issuer()+"/authorize") {
+		t.Fatalf("unexpected authorization redirect %q", authorizeURL)
+	}
+
+	// The provider approves and redirects back to the callback
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(authorizeURL)
+	if err != nil {
+		t.Fatalf("authorization request failed: %v", err)
+	}
+	res.Body.Close()
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid callback redirect: %v", err)
+	}
+
+	// A callback without the state cookie is rejected
+	server.sendRequest(http.MethodGet, callback.RequestURI(), RequestOptions{NoRedirect: true}).
+		assertStatus(http.StatusBadRequest)
+
+	resp := server.sendRequest(http.MethodGet, callback.RequestURI(), RequestOptions{
+		Cookies:    start.Cookies(),
+		NoRedirect: true,
+	}).assertStatus(http.StatusOK)
+
+	// The session lets the user in without a password
+	server.sendRequest(http.MethodGet, "/", RequestOptions{
+		Cookies: resp.Cookies(),
+	}).assertStatus(http.StatusOK).
+		assertContains(email)
+
+	checkServerErrors(t, errChan)
+}
+
+func TestTwoFactorAuthentication(t *testing.T) {
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	user := server.givenNewAuthenticatedUser()
+	password := "Str0ngP@ssw0rd!"
+
+	// Enroll an authenticator
+	resp := server.sendRequest(http.MethodGet, "/account/two-factor", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains("Set up two-factor authentication")
+	resp = server.sendRequest(http.MethodPost, "/account/two-factor/enroll", RequestOptions{
+		HTMX:      true,
+		Cookies:   user.Cookies,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK)
+
+	matches := regexp.MustCompile(`<pre id="totp-secret">([A-Z2-7]+)</pre>`).FindStringSubmatch(resp.body)
+	if len(matches) != 2 {
+		t.Fatalf("expected enrollment to show the secret")
+	}
+	if !strings.Contains(resp.body, `href="otpauth://totp/Todo:`) {
+		t.Fatalf("expected enrollment to link the otpauth URL")
+	}
+	if !strings.Contains(resp.body, `<div id="totp-qrcode" style="width: 200px"><svg`) {
+		t.Fatalf("expected enrollment to show a qr code")
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(matches[1])
+	if err != nil {
+		t.Fatalf("invalid secret: %v", err)
+	}
+	// Each time step is accepted once, the skew leaves room for three codes
+	codeAt := func(offset time.Duration) string {
+		code, err := otp.GenerateTOTP(key, time.Now().Add(offset), otp.Options{})
+		if err != nil {
+			t.Fatalf("failed to generate code: %v", err)
+		}
+		return code
+	}
+	enrollCode, code, disableCode := codeAt(-30*time.Second), codeAt(0), codeAt(30*time.Second)
+
+	// Confirming shows the first recovery codes
+	resp = server.sendRequest(http.MethodPost, "/account/two-factor/confirm", RequestOptions{
+		Body:      "code=" + enrollCode,
+		HTMX:      true,
+		Cookies:   user.Cookies,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("recovery-codes.txt")
+	recoveryCodes := regexp.MustCompile(`<code>([a-z0-9]{5}-[a-z0-9]{5})</code>`).FindAllStringSubmatch(resp.body, -1)
+	if len(recoveryCodes) != 10 {
+		t.Fatalf("expected 10 recovery codes, got %d", len(recoveryCodes))
+	}
+
+	// The password alone only yields a pending session
+	resp = server.sendRequest(http.MethodGet, "/login", RequestOptions{}).assertStatus(http.StatusOK)
+	login := server.sendRequest(http.MethodPost, "/authenticate/password", RequestOptions{
+		Body:      "email=" + user.Email + "&password=" + password,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent).
+		assertRedirect("/login/two-factor")
+
+	resp = server.sendRequest(http.MethodGet, "/", RequestOptions{
+		Cookies:    login.Cookies(),
+		NoRedirect: true,
+	}).assertStatus(http.StatusFound)
+	if location := resp.Header.Get("Location"); location != "/login/two-factor" {
+		t.Fatalf("expected redirect to the code prompt, got %q", location)
+	}
+
+	// A wrong code keeps the session pending
+	resp = server.sendRequest(http.MethodGet, "/login/two-factor", RequestOptions{
+		Cookies: login.Cookies(),
+	}).assertStatus(http.StatusOK)
+	resp = server.sendRequest(http.MethodPost, "/login/two-factor", RequestOptions{
+		Body:      "code=abcdef",
+		HTMX:      true,
+		Cookies:   login.Cookies(),
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("Invalid authentication code")
+
+	server.sendRequest(http.MethodPost, "/login/two-factor", RequestOptions{
+		Body:      "code=" + code,
+		HTMX:      true,
+		Cookies:   login.Cookies(),
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent).
+		assertRedirect("/")
+
+	server.sendRequest(http.MethodGet, "/", RequestOptions{
+		Cookies: login.Cookies(),
+	}).assertStatus(http.StatusOK).
+		assertContains(user.Email)
+
+	// A recovery code stands in for the authenticator once
+	resp = server.sendRequest(http.MethodGet, "/login", RequestOptions{}).assertStatus(http.StatusOK)
+	recoveryLogin := server.sendRequest(http.MethodPost, "/authenticate/password", RequestOptions{
+		Body:      "email=" + user.Email + "&password=" + password,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent).
+		assertRedirect("/login/two-factor")
+	resp = server.sendRequest(http.MethodGet, "/login/recovery-code", RequestOptions{
+		Cookies: recoveryLogin.Cookies(),
+	}).assertStatus(http.StatusOK)
+	server.sendRequest(http.MethodPost, "/login/recovery-code", RequestOptions{
+		Body:      "code=" + recoveryCodes[0][1],
+		HTMX:      true,
+		Cookies:   recoveryLogin.Cookies(),
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent).
+		assertRedirect("/")
+	server.sendRequest(http.MethodGet, "/account/recovery-codes", RequestOptions{
+		Cookies: recoveryLogin.Cookies(),
+	}).assertStatus(http.StatusOK).
+		assertContains("You have 9 unused recovery codes")
+
+	// Disabling asks for the password again
+	resp = server.sendRequest(http.MethodGet, "/account/two-factor", RequestOptions{
+		Cookies: login.Cookies(),
+	}).assertStatus(http.StatusOK)
+	server.sendRequest(http.MethodPost, "/account/two-factor/disable", RequestOptions{
+		Body:      "password=" + password + "&code=" + disableCode,
+		HTMX:      true,
+		Cookies:   login.Cookies(),
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent).
+		assertRedirect("/account/two-factor")
+
+	checkServerErrors(t, errChan)
+}
+
+func TestSessionRevocation(t *testing.T) {
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	laptop := server.givenNewAuthenticatedUser()
+	password := "Str0ngP@ssw0rd!"
+
+	// Sign in from a second device
+	resp := server.sendRequest(http.MethodGet, "/login", RequestOptions{}).assertStatus(http.StatusOK)
+	phone := server.sendRequest(http.MethodPost, "/authenticate/password", RequestOptions{
+		Body:      "email=" + laptop.Email + "&password=" + password,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent).
+		assertRedirect("/")
+
+	resp = server.sendRequest(http.MethodGet, "/settings/sessions", RequestOptions{
+		Cookies: phone.Cookies(),
+	}).assertStatus(http.StatusOK).
+		assertContains("This device", "Go-http-client")
+
+	ids := regexp.MustCompile(`hx-delete="/settings/sessions/([0-9a-f]+)"`).FindAllStringSubmatch(resp.body, -1)
+	if len(ids) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(ids))
+	}
+
+	// Sign out the laptop from the phone
+	server.sendRequest(http.MethodPost, "/settings/sessions/revoke-others", RequestOptions{
+		HTMX:      true,
+		Cookies:   phone.Cookies(),
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent).
+		assertRedirect("/settings/sessions")
+
+	server.sendRequest(http.MethodGet, "/settings/sessions", RequestOptions{
+		Cookies: laptop.Cookies,
+	}).assertStatus(http.StatusUnauthorized)
+
+	// Revoking the current session signs this browser out
+	resp = server.sendRequest(http.MethodGet, "/settings/sessions", RequestOptions{
+		Cookies: phone.Cookies(),
+	}).assertStatus(http.StatusOK)
+	ids = regexp.MustCompile(`hx-delete="/settings/sessions/([0-9a-f]+)"`).FindAllStringSubmatch(resp.body, -1)
+	if len(ids) != 1 {
+		t.Fatalf("expected 1 session, got %d", len(ids))
+	}
+	server.sendRequest(http.MethodDelete, "/settings/sessions/"+ids[0][1], RequestOptions{
+		HTMX:      true,
+		Cookies:   phone.Cookies(),
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent).
+		assertRedirect("/login").
+		assertSessionCookieDestroyed()
+
+	checkServerErrors(t, errChan)
+}
+
+func TestPersonalAccessTokens(t *testing.T) {
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	user := server.givenNewAuthenticatedUser()
+
+	// Create a token from the settings page, its secret is shown once
+	resp := server.sendRequest(http.MethodGet, "/settings/tokens", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains("No access tokens yet")
+	resp = server.sendRequest(http.MethodPost, "/settings/tokens", RequestOptions{
+		Body:      "name=script&scope=read&scope=write&expires-in-days=30",
+		HTMX:      true,
+		Cookies:   user.Cookies,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK)
+
+	matches := regexp.MustCompile(`<pre id="access-token">([^<]+)</pre>`).FindStringSubmatch(resp.body)
+	if len(matches) != 2 {
+		t.Fatalf("expected access token in response")
+	}
+	token := matches[1]
+
+	// Scripts use the token without a session or CSRF token
+	server.sendRequest(http.MethodPost, "/todos", RequestOptions{
+		Body:        "name=Scripted&description=From+a+script",
+		BearerToken: token,
+	}).assertStatus(http.StatusOK).
+		assertContains("Scripted")
+	server.sendRequest(http.MethodGet, "/", RequestOptions{
+		BearerToken: token,
+	}).assertStatus(http.StatusOK).
+		assertContains("Scripted")
+
+	// Account settings stay cookie only
+	server.sendRequest(http.MethodGet, "/settings/tokens", RequestOptions{
+		BearerToken: token,
+	}).assertStatus(http.StatusUnauthorized)
+
+	// Revoke the token
+	resp = server.sendRequest(http.MethodGet, "/settings/tokens", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains("script", "read write")
+	ids := regexp.MustCompile(`hx-delete="/settings/tokens/(\d+)"`).FindStringSubmatch(resp.body)
+	if len(ids) != 2 {
+		t.Fatalf("expected token in list")
+	}
+	server.sendRequest(http.MethodDelete, "/settings/tokens/"+ids[1], RequestOptions{
+		HTMX:      true,
+		Cookies:   user.Cookies,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK)
+
+	server.sendRequest(http.MethodGet, "/", RequestOptions{
+		BearerToken: token,
+	}).assertStatus(http.StatusUnauthorized)
+
+	checkServerErrors(t, errChan)
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	email := randomEmail()
+	server.givenNewUser(email, "Str0ngP@ssw0rd!")
+
+	// Ask for a link, the browser gets the cookie binding it
+	resp := server.sendRequest(http.MethodGet, "/login/magic-link", RequestOptions{}).assertStatus(http.StatusOK)
+	requested := server.sendRequest(http.MethodPost, "/login/magic-link", RequestOptions{
+		Body:      "email=" + email,
+		HTMX:      true,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("a sign-in link is on its way")
+
+	// The link does not work from another browser
+	link := "/login/magic-link/verify?token=" + auth.TestMagicLinkToken
+	server.sendRequest(http.MethodGet, link, RequestOptions{}).
+		assertStatus(http.StatusOK).
+		assertContains("opened in another browser")
+
+	// It does in the browser that asked for it, once
+	login := server.sendRequest(http.MethodGet, link, RequestOptions{
+		Cookies: requested.Cookies(),
+	}).assertStatus(http.StatusOK).
+		assertContains("Signed in")
+	server.sendRequest(http.MethodGet, "/", RequestOptions{
+		Cookies: login.Cookies(),
+	}).assertStatus(http.StatusOK).
+		assertContains(email)
+	server.sendRequest(http.MethodGet, link, RequestOptions{
+		Cookies: requested.Cookies(),
+	}).assertStatus(http.StatusOK).
+		assertContains("invalid")
+
+	checkServerErrors(t, errChan)
+}
+
+func TestChangeEmail(t *testing.T) {
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	user := server.givenNewAuthenticatedUser()
+	newEmail := randomEmail()
+
+	// The current password is required
+	resp := server.sendRequest(http.MethodGet, "/settings/email", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains(user.Email)
+	resp = server.sendRequest(http.MethodPost, "/settings/email", RequestOptions{
+		Body:      "email=" + newEmail + "&password=wrong-password",
+		HTMX:      true,
+		Cookies:   user.Cookies,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("Invalid password")
+
+	// A code is sent to the new address, which only replaces the old one once confirmed
+	resp = server.sendRequest(http.MethodPost, "/settings/email", RequestOptions{
+		Body:      "email=" + newEmail + "&password=Str0ngP@ssw0rd!",
+		HTMX:      true,
+		Cookies:   user.Cookies,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("Enter the code we sent to", newEmail)
+	resp = server.sendRequest(http.MethodPost, "/settings/email/confirm", RequestOptions{
+		Body:      "code=00000000",
+		HTMX:      true,
+		Cookies:   user.Cookies,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("Invalid or expired email change code")
+	server.sendRequest(http.MethodPost, "/settings/email/confirm", RequestOptions{
+		Body:      "code=" + auth.TestEmailVerificationCode,
+		HTMX:      true,
+		Cookies:   user.Cookies,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent).
+		assertRedirect("/settings/email")
+	server.sendRequest(http.MethodGet, "/", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains(newEmail)
+
+	// The old address can cancel a change it did not ask for, which signs every device out
+	resp = server.sendRequest(http.MethodGet, "/settings/email", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK)
+	server.sendRequest(http.MethodPost, "/settings/email", RequestOptions{
+		Body:      "email=" + randomEmail() + "&password=Str0ngP@ssw0rd!",
+		HTMX:      true,
+		Cookies:   user.Cookies,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK)
+	resp = server.sendRequest(http.MethodGet, "/settings/email/cancel?code="+auth.TestEmailChangeCancelCode, RequestOptions{}).
+		assertStatus(http.StatusOK).
+		assertContains("Cancel the email change")
+	server.sendRequest(http.MethodPost, "/settings/email/cancel", RequestOptions{
+		Body:      "code=" + auth.TestEmailChangeCancelCode,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("The email change was cancelled")
+	server.sendRequest(http.MethodGet, "/settings/email", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusUnauthorized)
+
+	checkServerErrors(t, errChan)
+}
+
+func TestAccountExportAndDeletion(t *testing.T) {
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	user := server.givenNewAuthenticatedUser()
+	server.givenNewTodo(user, "Exported todo", "Part of the archive")
+
+	// The archive holds the account and the todos but no secrets
+	resp := server.sendRequest(http.MethodGet, "/settings/account/export", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains(user.Email, "Exported todo", "Go-http-client")
+	if !strings.Contains(resp.Header.Get("Content-Disposition"), "attachment") {
+		t.Fatalf("expected the export to be downloaded, got %q", resp.Header.Get("Content-Disposition"))
+	}
+	if strings.Contains(resp.body, "argon2id") {
+		t.Fatalf("expected the password hash to be left out of the export")
+	}
+
+	// Deleting requires the password
+	resp = server.sendRequest(http.MethodGet, "/settings/account", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains("Delete my account")
+	resp = server.sendRequest(http.MethodPost, "/settings/account/delete", RequestOptions{
+		Body:      "password=wrong-password",
+		HTMX:      true,
+		Cookies:   user.Cookies,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("Invalid password")
+	server.sendRequest(http.MethodPost, "/settings/account/delete", RequestOptions{
+		Body:      "password=Str0ngP@ssw0rd!",
+		HTMX:      true,
+		Cookies:   user.Cookies,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent).
+		assertRedirect("/register").
+		assertSessionCookieDestroyed()
+
+	// The session is gone and the address can be registered again
+	server.sendRequest(http.MethodGet, "/settings/account", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusUnauthorized)
+	server.givenNewUser(user.Email, "Str0ngP@ssw0rd!")
+
+	checkServerErrors(t, errChan)
+}
+
+func TestLoginLockout(t *testing.T) {
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	email := randomEmail()
+	server.givenNewUser(email, "Str0ngP@ssw0rd!")
+
+	// The first failures only report invalid credentials
+	resp := server.sendRequest(http.MethodGet, "/login", RequestOptions{}).assertStatus(http.StatusOK)
+	for i := 0; i < 2; i++ {
+		resp = server.sendRequest(http.MethodPost, "/authenticate/password", RequestOptions{
+			Body:      "email=" + email + "&password=wrong-password",
+			HTMX:      true,
+			CSRFToken: extractCSRFToken(resp.body),
+		}).assertStatus(http.StatusOK).
+			assertContains("Invalid email or password")
+	}
+
+	// Then each attempt has to wait
+	resp = server.sendRequest(http.MethodPost, "/authenticate/password", RequestOptions{
+		Body:      "email=" + email + "&password=wrong-password",
+		HTMX:      true,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("try again in 1 second")
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "1" {
+		t.Fatalf("expected a Retry-After header, got %q", retryAfter)
+	}
+
+	// Unlock links that were never sent are refused
+	resp = server.sendRequest(http.MethodGet, "/login/unlock?code="+auth.TestLoginUnlockToken, RequestOptions{}).
+		assertStatus(http.StatusOK).
+		assertContains("Unlock sign-in")
+	server.sendRequest(http.MethodPost, "/login/unlock", RequestOptions{
+		Body:      "code=" + auth.TestLoginUnlockToken,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("This unlock link is invalid or was already used")
+
+	checkServerErrors(t, errChan)
+}
+
+func TestAuthEvents(t *testing.T) {
+	adminEmail := randomEmail()
+	os.Setenv("ADMIN_EMAILS", adminEmail)
+	defer os.Unsetenv("ADMIN_EMAILS")
+
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	user := server.givenNewAuthenticatedUser()
+
+	// The user sees their own sign-in
+	server.sendRequest(http.MethodGet, "/settings/security", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains("Security Activity", "login (password)", "email_verification", "register", "Go-http-client")
+
+	// The audit log of every user is only for admins
+	server.sendRequest(http.MethodGet, "/admin/auth-events", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusForbidden)
+
+	admin := server.givenNewAuthenticatedUserWithEmail(adminEmail)
+	resp := server.sendRequest(http.MethodGet, "/admin/auth-events?type=login&email="+url.QueryEscape(user.Email), RequestOptions{
+		Cookies: admin.Cookies,
+	}).assertStatus(http.StatusOK)
+
+	var page struct {
+		Events []model.AuthEvent `json:"events"`
+	}
+	if err := json.Unmarshal([]byte(resp.body), &page); err != nil {
+		t.Fatalf("failed to decode auth events: %v", err)
+	}
+	if len(page.Events) != 1 || page.Events[0].Email != user.Email || page.Events[0].Outcome != auth.AuthOutcomeSuccess {
+		t.Fatalf("unexpected auth events %+v", page.Events)
+	}
+
+	server.sendRequest(http.MethodGet, "/admin/auth-events?since=yesterday", RequestOptions{
+		Cookies: admin.Cookies,
+	}).assertStatus(http.StatusBadRequest)
+
+	checkServerErrors(t, errChan)
+}
+
+func TestResendVerificationCode(t *testing.T) {
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	email := randomEmail()
+	password := "Str0ngP@ssw0rd!"
+	server.givenNewUser(email, password)
+
+	resp := server.sendRequest(http.MethodGet, "/login", RequestOptions{}).assertStatus(http.StatusOK)
+	login := server.sendRequest(http.MethodPost, "/authenticate/password", RequestOptions{
+		Body:      "email=" + email + "&password=" + password,
+		HTMX:      true,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent)
+
+	// The code sent at registration starts the cooldown, which shows 59 seconds once the second it was sent in is over
+	resp = server.sendRequest(http.MethodGet, "/verify-email", RequestOptions{
+		Cookies: login.Cookies(),
+	}).assertStatus(http.StatusOK).
+		assertContains("You can ask for a new one in")
+
+	resp = server.sendRequest(http.MethodPost, "/email-verification-request/resend", RequestOptions{
+		HTMX:      true,
+		Cookies:   login.Cookies(),
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("A new code can be sent in")
+	if resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected a Retry-After header")
+	}
+
+	// Without a session nothing is sent
+	server.sendRequest(http.MethodPost, "/email-verification-request/resend", RequestOptions{
+		HTMX:      true,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("Invalid session")
+
+	checkServerErrors(t, errChan)
+}
+
+func TestAdminConsole(t *testing.T) {
+	adminEmail := randomEmail()
+	os.Setenv("ADMIN_EMAILS", adminEmail)
+	defer os.Unsetenv("ADMIN_EMAILS")
+
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	password := "Str0ngP@ssw0rd!"
+	user := server.givenNewAuthenticatedUser()
+	unverifiedEmail := randomEmail()
+	server.givenNewUser(unverifiedEmail, password)
+
+	// Regular users cannot reach the console
+	server.sendRequest(http.MethodGet, "/admin", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusForbidden)
+
+	admin := server.givenNewAuthenticatedUserWithEmail(adminEmail)
+	server.sendRequest(http.MethodGet, "/", RequestOptions{
+		Cookies: admin.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains(`href="/admin"`)
+
+	// Search the user and disable them
+	resp := server.sendRequest(http.MethodGet, "/admin?q="+url.QueryEscape(user.Email), RequestOptions{
+		Cookies: admin.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains(user.Email, "Active", "Disable")
+	if strings.Contains(resp.body, unverifiedEmail) {
+		t.Fatalf("expected the search to only list %s", user.Email)
+	}
+	userPath := "/admin/users/" + regexp.MustCompile(`/admin/users/(\d+)/disable`).FindStringSubmatch(resp.body)[1]
+
+	resp = server.sendRequest(http.MethodPost, userPath+"/disable", RequestOptions{
+		HTMX:      true,
+		Cookies:   admin.Cookies,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("Disabled", "Enable")
+
+	// Their session is gone and they cannot sign in again
+	server.sendRequest(http.MethodGet, "/settings/sessions", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusUnauthorized)
+	login := server.sendRequest(http.MethodGet, "/login", RequestOptions{}).assertStatus(http.StatusOK)
+	login = server.sendRequest(http.MethodPost, "/authenticate/password", RequestOptions{
+		Body:      "email=" + user.Email + "&password=" + password,
+		HTMX:      true,
+		CSRFToken: extractCSRFToken(login.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("This account is disabled")
+
+	// Enabled again, they can sign in
+	server.sendRequest(http.MethodPost, userPath+"/enable", RequestOptions{
+		HTMX:      true,
+		Cookies:   admin.Cookies,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("Active")
+	server.sendRequest(http.MethodPost, "/authenticate/password", RequestOptions{
+		Body:      "email=" + user.Email + "&password=" + password,
+		HTMX:      true,
+		CSRFToken: extractCSRFToken(login.body),
+	}).assertStatus(http.StatusNoContent)
+
+	// Force the verification of a user who never got the code
+	resp = server.sendRequest(http.MethodGet, "/admin?q="+url.QueryEscape(unverifiedEmail), RequestOptions{
+		Cookies: admin.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains(unverifiedEmail, "Mark email verified")
+	unverifiedPath := "/admin/users/" + regexp.MustCompile(`/admin/users/(\d+)/verify-email`).FindStringSubmatch(resp.body)[1]
+	resp = server.sendRequest(http.MethodPost, unverifiedPath+"/verify-email", RequestOptions{
+		HTMX:      true,
+		Cookies:   admin.Cookies,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK)
+	if strings.Contains(resp.body, "Mark email verified") {
+		t.Fatalf("expected the email to be verified, got %s", resp.body)
+	}
+
+	checkServerErrors(t, errChan)
+}
+
+func TestImpersonation(t *testing.T) {
+	adminEmail := randomEmail()
+	os.Setenv("ADMIN_EMAILS", adminEmail)
+	defer os.Unsetenv("ADMIN_EMAILS")
+
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	user := server.givenNewAuthenticatedUser()
+	admin := server.givenNewAuthenticatedUserWithEmail(adminEmail)
+
+	resp := server.sendRequest(http.MethodGet, "/admin?q="+url.QueryEscape(user.Email), RequestOptions{
+		Cookies: admin.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains("Impersonate")
+	userPath := "/admin/users/" + regexp.MustCompile(`/admin/users/(\d+)/impersonate`).FindStringSubmatch(resp.body)[1]
+
+	// The admin now uses the app as the user, with a banner on every page
+	resp = server.sendRequest(http.MethodPost, userPath+"/impersonate", RequestOptions{
+		HTMX:      true,
+		Cookies:   admin.Cookies,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent)
+	if location := resp.Header.Get("HX-Redirect"); location != "/" {
+		t.Fatalf("unexpected redirect %q", location)
+	}
+	impersonation := latestCookies(resp)
+
+	resp = server.sendRequest(http.MethodGet, "/", RequestOptions{
+		Cookies: impersonation,
+	}).assertStatus(http.StatusOK).
+		assertContains(`id="impersonation-banner"`, user.Email, adminEmail, "Stop impersonating")
+
+	// Security sensitive actions and the admin console are off limits
+	server.sendRequest(http.MethodPost, "/settings/email", RequestOptions{
+		Body:      "email=" + url.QueryEscape(randomEmail()),
+		HTMX:      true,
+		Cookies:   impersonation,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusForbidden).
+		assertContains("not allowed while impersonating")
+	server.sendRequest(http.MethodGet, "/admin", RequestOptions{
+		Cookies: impersonation,
+	}).assertStatus(http.StatusForbidden)
+
+	// Stopping brings the admin back to their own session
+	resp = server.sendRequest(http.MethodPost, "/impersonation/stop", RequestOptions{
+		HTMX:      true,
+		Cookies:   impersonation,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent)
+	if location := resp.Header.Get("HX-Redirect"); location != "/admin" {
+		t.Fatalf("unexpected redirect %q", location)
+	}
+	server.sendRequest(http.MethodGet, "/admin", RequestOptions{
+		Cookies: latestCookies(resp),
+	}).assertStatus(http.StatusOK)
+	server.sendRequest(http.MethodGet, "/settings/sessions", RequestOptions{
+		Cookies: impersonation,
+	}).assertStatus(http.StatusUnauthorized)
+
+	// The user can see both ends of the impersonation
+	server.sendRequest(http.MethodGet, "/settings/security", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains("impersonation_start (by "+adminEmail+")", "impersonation_stop (by "+adminEmail+")")
+
+	checkServerErrors(t, errChan)
+}
+
+func TestInvitation(t *testing.T) {
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	inviter := server.givenNewAuthenticatedUser()
+	email := randomEmail()
+
+	resp := server.sendRequest(http.MethodGet, "/settings/invitations", RequestOptions{
+		Cookies: inviter.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains("No pending invitations")
+	server.sendRequest(http.MethodPost, "/settings/invitations", RequestOptions{
+		Body:      "email=" + url.QueryEscape(email),
+		HTMX:      true,
+		Cookies:   inviter.Cookies,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("Invitation sent to " + email)
+	server.sendRequest(http.MethodGet, "/settings/invitations", RequestOptions{
+		Cookies: inviter.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains(email, "Revoke")
+
+	// The link fills in the invited email, and only works for it
+	invitePath := "/register?invite=" + url.QueryEscape(auth.TestInvitationToken+"-"+email)
+	resp = server.sendRequest(http.MethodGet, invitePath, RequestOptions{}).
+		assertStatus(http.StatusOK).
+		assertContains(`name="invite"`, `value="`+email+`"`)
+	invite := url.QueryEscape(auth.TestInvitationToken + "-" + email)
+	password := "Str0ngP@ssw0rd!"
+	resp = server.sendRequest(http.MethodPost, "/users", RequestOptions{
+		Body:      "email=" + url.QueryEscape(randomEmail()) + "&password=" + password + "&confirm-password=" + password + "&invite=" + invite,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("This invitation is invalid")
+	server.sendRequest(http.MethodPost, "/users", RequestOptions{
+		Body:      "email=" + url.QueryEscape(email) + "&password=" + password + "&confirm-password=" + password + "&invite=" + invite,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent).
+		assertRedirect("/login")
+
+	// It cannot be used twice
+	server.sendRequest(http.MethodGet, invitePath, RequestOptions{}).
+		assertStatus(http.StatusOK).
+		assertContains("This invitation is invalid")
+
+	checkServerErrors(t, errChan)
+}
+
+func TestPasskeys(t *testing.T) {
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	user := server.givenNewAuthenticatedUser()
+	authenticator := webauthntest.NewAuthenticator(server.baseURL, webauthn.AlgES256)
+
+	postJSON := func(path, csrfToken string, cookies []*http.Cookie, body any) *TestResponse {
+		t.Helper()
+
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("failed to encode request: %v", err)
+		}
+		return server.sendRequest(http.MethodPost, path, RequestOptions{
+			Body:      string(data),
+			JSON:      true,
+			Cookies:   cookies,
+			CSRFToken: csrfToken,
+		})
+	}
+	type optionsResponse[T any] struct {
+		Options   T      `json:"options"`
+		CSRFToken string `json:"csrfToken"`
+	}
+
+	// Register a passkey from the settings
+	resp := server.sendRequest(http.MethodGet, "/settings/passkeys", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains("No passkeys yet")
+	resp = postJSON("/webauthn/register/options", extractPasskeyCSRFToken(resp.body), user.Cookies, struct{}{}).
+		assertStatus(http.StatusOK)
+	var creation optionsResponse[webauthn.CreationOptions]
+	if err := json.Unmarshal([]byte(resp.body), &creation); err != nil {
+		t.Fatalf("invalid creation options %s: %v", resp.body, err)
+	}
+	registration, err := authenticator.Create(creation.Options)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	postJSON("/webauthn/register", creation.CSRFToken, user.Cookies, map[string]any{
+		"name":       "laptop",
+		"credential": registration,
+	}).assertStatus(http.StatusOK).
+		assertContains(`"redirect":"/settings/passkeys"`)
+	server.sendRequest(http.MethodGet, "/settings/passkeys", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains("laptop", "Never used")
+
+	// Sign in without a password
+	resp = server.sendRequest(http.MethodGet, "/login", RequestOptions{}).assertStatus(http.StatusOK)
+	resp = postJSON("/webauthn/login/options", extractPasskeyCSRFToken(resp.body), nil, struct{}{}).
+		assertStatus(http.StatusOK)
+	var request optionsResponse[webauthn.RequestOptions]
+	if err := json.Unmarshal([]byte(resp.body), &request); err != nil {
+		t.Fatalf("invalid request options %s: %v", resp.body, err)
+	}
+	assertion, err := authenticator.Get(request.Options)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	login := postJSON("/webauthn/login", request.CSRFToken, nil, map[string]any{"credential": assertion}).
+		assertStatus(http.StatusOK).
+		assertContains(`"redirect":"/"`)
+	server.sendRequest(http.MethodGet, "/", RequestOptions{
+		Cookies: login.Cookies(),
+	}).assertStatus(http.StatusOK).
+		assertContains(user.Email)
+
+	// The assertion cannot be replayed
+	resp = server.sendRequest(http.MethodGet, "/login", RequestOptions{}).assertStatus(http.StatusOK)
+	postJSON("/webauthn/login", extractPasskeyCSRFToken(resp.body), nil, map[string]any{"credential": assertion}).
+		assertStatus(http.StatusBadRequest).
+		assertContains("this passkey could not be verified")
+
+	server.sendRequest(http.MethodGet, "/settings/passkeys", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains("Last used")
+
+	checkServerErrors(t, errChan)
+}
+
+func TestRememberMe(t *testing.T) {
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	user := server.givenNewAuthenticatedUser()
+	password := "Str0ngP@ssw0rd!"
+
+	sessionCookieExpiry := func(remember string) time.Duration {
+		t.Helper()
+		resp := server.sendRequest(http.MethodGet, "/login", RequestOptions{}).assertStatus(http.StatusOK).
+			assertContains(`name="remember_me"`)
+		login := server.sendRequest(http.MethodPost, "/authenticate/password", RequestOptions{
+			Body:      "email=" + user.Email + "&password=" + password + remember,
+			CSRFToken: extractCSRFToken(resp.body),
+		}).assertStatus(http.StatusNoContent).
+			assertRedirect("/")
+		for _, cookie := range login.Cookies() {
+			if cookie.Name == "session" {
+				return time.Until(cookie.Expires)
+			}
+		}
+		t.Fatalf("expected a session cookie")
+		return 0
+	}
+
+	if expiry := sessionCookieExpiry(""); expiry > 24*time.Hour {
+		t.Fatalf("expected the session to follow the idle timeout, got %v", expiry)
+	}
+	if expiry := sessionCookieExpiry("&remember_me=on"); expiry < 29*24*time.Hour {
+		t.Fatalf("expected a remembered session, got %v", expiry)
+	}
+
+	checkServerErrors(t, errChan)
+}
+
+func TestNewDeviceReport(t *testing.T) {
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	user := server.givenNewAuthenticatedUser()
+	password := "Str0ngP@ssw0rd!"
+
+	// The first device is known without any alert
+	server.sendRequest(http.MethodGet, "/settings/devices", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains("Go-http-client", "This device")
+
+	// Signing in from another browser is a new device
+	resp := server.sendRequest(http.MethodGet, "/login", RequestOptions{}).assertStatus(http.StatusOK)
+	server.sendRequest(http.MethodPost, "/authenticate/password", RequestOptions{
+		Body:      "email=" + user.Email + "&password=" + password,
+		CSRFToken: extractCSRFToken(resp.body),
+		UserAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0",
+	}).assertStatus(http.StatusNoContent)
+	server.sendRequest(http.MethodGet, "/settings/devices", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK).
+		assertContains("Firefox on Linux")
+
+	// The link of the alert asks before acting
+	reportCode := auth.TestDeviceReportToken + "-" + user.Email
+	resp = server.sendRequest(http.MethodGet, "/login/device-report?code="+url.QueryEscape(reportCode), RequestOptions{}).
+		assertStatus(http.StatusOK).
+		assertContains("This wasn't me")
+	resp = server.sendRequest(http.MethodPost, "/login/device-report", RequestOptions{
+		Body:       "code=" + url.QueryEscape(reportCode),
+		CSRFToken:  extractCSRFToken(resp.body),
+		NoRedirect: true,
+	}).assertStatus(http.StatusSeeOther)
+	if location := resp.Header.Get("Location"); location != "/reset-password?code="+auth.TestPasswordResetCode {
+		t.Fatalf("unexpected location %q", location)
+	}
+
+	// Only the reported device is signed out, and the password must be reset before it signs in again
+	resp = server.sendRequest(http.MethodGet, "/settings/devices", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK)
+	if strings.Contains(resp.body, "Firefox on Linux") {
+		t.Fatalf("expected the reported device to be forgotten")
+	}
+	resp = server.sendRequest(http.MethodGet, "/login", RequestOptions{}).assertStatus(http.StatusOK)
+	server.sendRequest(http.MethodPost, "/authenticate/password", RequestOptions{
+		Body:      "email=" + user.Email + "&password=" + password,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("This password must be changed")
+
+	// The link works once
+	resp = server.sendRequest(http.MethodGet, "/login/device-report?code="+url.QueryEscape(reportCode), RequestOptions{}).
+		assertStatus(http.StatusOK)
+	server.sendRequest(http.MethodPost, "/login/device-report", RequestOptions{
+		Body:      "code=" + url.QueryEscape(reportCode),
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusOK).
+		assertContains("This link is invalid")
+
+	checkServerErrors(t, errChan)
+}
+
+func TestRemoveKnownDevice(t *testing.T) {
+	server, errChan := setupServer(t, defaultTestConfig)
+	defer server.cancel()
+
+	user := server.givenNewAuthenticatedUser()
+
+	resp := server.sendRequest(http.MethodGet, "/settings/devices", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusOK)
+	id := regexp.MustCompile(`/settings/devices/(\d+)`).FindStringSubmatch(resp.body)
+	if id == nil {
+		t.Fatalf("expected a removable device")
+	}
+
+	// Removing the current device signs this browser out
+	server.sendRequest(http.MethodDelete, "/settings/devices/"+id[1], RequestOptions{
+		Cookies:   user.Cookies,
+		HTMX:      true,
+		CSRFToken: extractCSRFToken(resp.body),
+	}).assertStatus(http.StatusNoContent).
+		assertRedirect("/login").
+		assertSessionCookieDestroyed()
+	server.sendRequest(http.MethodGet, "/", RequestOptions{
+		Cookies: user.Cookies,
+	}).assertStatus(http.StatusUnauthorized)
+
+	checkServerErrors(t, errChan)
+}
+
 func checkServerErrors(t *testing.T, errChan chan error) {
 	t.Helper()
 	select {
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -75,7 +76,11 @@ func (s *testServer) givenNewUser(email, password string) {
 
 func (s *testServer) givenNewAuthenticatedUser() AuthenticatedUser {
 	s.t.Helper()
-	email := randomEmail()
+	return s.givenNewAuthenticatedUserWithEmail(randomEmail())
+}
+
+func (s *testServer) givenNewAuthenticatedUserWithEmail(email string) AuthenticatedUser {
+	s.t.Helper()
 	password := "Str0ngP@ssw0rd!"
 
 	s.givenNewUser(email, password)
@@ -208,6 +213,14 @@ type RequestOptions struct {
 	HTMX      bool
 	Cookies   []*http.Cookie
 	CSRFToken string
+	// NoRedirect returns redirect responses instead of following them
+	NoRedirect bool
+	// BearerToken is sent in the Authorization header, without Origin and CSRF headers
+	BearerToken string
+	// JSON sends the body as application/json instead of a form
+	JSON bool
+	// UserAgent replaces the user agent of the Go client
+	UserAgent string
 }
 
 func (s *testServer) sendRequest(method, path string, opts RequestOptions) *TestResponse {
@@ -223,10 +236,16 @@ func (s *testServer) sendRequest(method, path string, opts RequestOptions) *Test
 		s.t.Fatalf("failed to create request: %v", err)
 	}
 
-	req.Header.Set("Origin", s.baseURL)
-	req.Header.Set("X-CSRF-Token", opts.CSRFToken)
+	if opts.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.BearerToken)
+	} else {
+		req.Header.Set("Origin", s.baseURL)
+		req.Header.Set("X-CSRF-Token", opts.CSRFToken)
+	}
 
-	if opts.Body != "" {
+	if opts.JSON {
+		req.Header.Set("Content-Type", "application/json")
+	} else if opts.Body != "" {
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
 
@@ -234,11 +253,25 @@ func (s *testServer) sendRequest(method, path string, opts RequestOptions) *Test
 		req.Header.Set("HX-Request", "true")
 	}
 
+	if opts.UserAgent != "" {
+		req.Header.Set("User-Agent", opts.UserAgent)
+	}
+
 	for _, cookie := range opts.Cookies {
 		req.AddCookie(cookie)
 	}
 
-	resp, err := s.client.Do(req)
+	client := s.client
+	if opts.NoRedirect {
+		client = &http.Client{
+			Timeout: s.client.Timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
 		s.t.Fatalf("failed to send %s request: %v", method, err)
 	}
@@ -256,6 +289,17 @@ func (s *testServer) sendRequest(method, path string, opts RequestOptions) *Test
 	}
 }
 
+// latestCookies returns the cookies of the response as a browser would keep them,
+// the last one wins when a cookie is set several times
+func latestCookies(resp *TestResponse) []*http.Cookie {
+	var cookies []*http.Cookie
+	for _, cookie := range resp.Cookies() {
+		cookies = slices.DeleteFunc(cookies, func(c *http.Cookie) bool { return c.Name == cookie.Name })
+		cookies = append(cookies, cookie)
+	}
+	return cookies
+}
+
 func randomEmail() string {
 	// Get current timestamp
 	timestamp := time.Now().UnixNano()
@@ -292,3 +336,12 @@ func extractCSRFToken(body string) string {
 
 	return ""
 }
+
+// extractPasskeyCSRFToken returns the CSRF token of the first passkey section of the page
+func extractPasskeyCSRFToken(body string) string {
+	matches := regexp.MustCompile(`data-csrf-token="([^"]+)"`).FindStringSubmatch(body)
+	if len(matches) > 1 {
+		return matches[1]
+	}
+	return ""
+}
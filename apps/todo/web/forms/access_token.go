@@ -0,0 +1,40 @@
+package forms
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+type AccessTokenForm struct {
+	Name          string   `form:"name"`
+	Scopes        []string `form:"scope"`
+	ExpiresInDays int      `form:"expires-in-days"`
+}
+
+func AccessTokenFrom(r *http.Request) (AccessTokenForm, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return AccessTokenForm{}, err
+	}
+
+	form := AccessTokenForm{
+		Name:   r.FormValue("name"),
+		Scopes: r.Form["scope"],
+	}
+
+	if form.Name == "" {
+		return AccessTokenForm{}, fmt.Errorf("name is required")
+	}
+	if len(form.Scopes) == 0 {
+		return AccessTokenForm{}, fmt.Errorf("at least one scope is required")
+	}
+
+	days, err := strconv.Atoi(r.FormValue("expires-in-days"))
+	if err != nil || days <= 0 {
+		return AccessTokenForm{}, fmt.Errorf("expiration is required")
+	}
+	form.ExpiresInDays = days
+
+	return form, nil
+}
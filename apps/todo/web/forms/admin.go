@@ -0,0 +1,31 @@
+package forms
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+type UserSearchForm struct {
+	Query string `form:"q"`
+	After int64  `form:"after"`
+}
+
+// UserSearchFrom reads the search of the admin console from the query string, every parameter is optional
+func UserSearchFrom(r *http.Request) (UserSearchForm, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return UserSearchForm{}, err
+	}
+
+	form := UserSearchForm{Query: r.FormValue("q")}
+	if value := r.FormValue("after"); value != "" {
+		after, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || after < 0 {
+			return UserSearchForm{}, fmt.Errorf("after must be a positive integer")
+		}
+		form.After = after
+	}
+
+	return form, nil
+}
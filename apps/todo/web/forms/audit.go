@@ -0,0 +1,66 @@
+package forms
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type AuthEventFilterForm struct {
+	UserId    int64     `form:"user_id"`
+	Email     string    `form:"email"`
+	Type      string    `form:"type"`
+	Outcome   string    `form:"outcome"`
+	IPAddress string    `form:"ip"`
+	Since     time.Time `form:"since"`
+	Until     time.Time `form:"until"`
+	Before    int64     `form:"before"`
+	Limit     int       `form:"limit"`
+}
+
+// AuthEventFilterFrom reads the filters of the audit log from the query string.
+// Times are RFC 3339 and every parameter is optional.
+func AuthEventFilterFrom(r *http.Request) (AuthEventFilterForm, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return AuthEventFilterForm{}, err
+	}
+
+	form := AuthEventFilterForm{
+		Email:     r.FormValue("email"),
+		Type:      r.FormValue("type"),
+		Outcome:   r.FormValue("outcome"),
+		IPAddress: r.FormValue("ip"),
+	}
+
+	for name, dst := range map[string]*int64{"user_id": &form.UserId, "before": &form.Before} {
+		if value := r.FormValue(name); value != "" {
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return AuthEventFilterForm{}, fmt.Errorf("%s must be a positive integer", name)
+			}
+			*dst = id
+		}
+	}
+
+	for name, dst := range map[string]*time.Time{"since": &form.Since, "until": &form.Until} {
+		if value := r.FormValue(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return AuthEventFilterForm{}, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*dst = t
+		}
+	}
+
+	if value := r.FormValue("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return AuthEventFilterForm{}, fmt.Errorf("limit must be a positive integer")
+		}
+		form.Limit = limit
+	}
+
+	return form, nil
+}
@@ -9,6 +9,7 @@ type RegisterForm struct {
 	Email           string `form:"email"`
 	Password        string `form:"password"`
 	ConfirmPassword string `form:"confirm-password"`
+	Invite          string `form:"invite"`
 }
 
 func RegisterFrom(r *http.Request) (RegisterForm, error) {
@@ -21,6 +22,7 @@ func RegisterFrom(r *http.Request) (RegisterForm, error) {
 		Email:           r.FormValue("email"),
 		Password:        r.FormValue("password"),
 		ConfirmPassword: r.FormValue("confirm-password"),
+		Invite:          r.FormValue("invite"),
 	}
 
 	if form.Email == "" {
@@ -40,8 +42,9 @@ func RegisterFrom(r *http.Request) (RegisterForm, error) {
 }
 
 type LoginForm struct {
-	Email    string `form:"email"`
-	Password string `form:"password"`
+	Email      string `form:"email"`
+	Password   string `form:"password"`
+	RememberMe bool   `form:"remember_me"`
 }
 
 func LoginFrom(r *http.Request) (LoginForm, error) {
@@ -52,8 +55,9 @@ func LoginFrom(r *http.Request) (LoginForm, error) {
 	}
 
 	form := LoginForm{
-		Email:    r.FormValue("email"),
-		Password: r.FormValue("password"),
+		Email:      r.FormValue("email"),
+		Password:   r.FormValue("password"),
+		RememberMe: r.FormValue("remember_me") == "on",
 	}
 
 	if form.Email == "" {
@@ -65,3 +69,131 @@ func LoginFrom(r *http.Request) (LoginForm, error) {
 
 	return form, nil
 }
+
+type EmailForm struct {
+	Email string `form:"email"`
+}
+
+func EmailFrom(r *http.Request) (EmailForm, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return EmailForm{}, err
+	}
+
+	form := EmailForm{
+		Email: r.FormValue("email"),
+	}
+
+	if form.Email == "" {
+		return EmailForm{}, fmt.Errorf("email is required")
+	}
+
+	return form, nil
+}
+
+type ResetPasswordForm struct {
+	Code            string `form:"code"`
+	Password        string `form:"password"`
+	ConfirmPassword string `form:"confirm-password"`
+}
+
+func ResetPasswordFrom(r *http.Request) (ResetPasswordForm, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return ResetPasswordForm{}, err
+	}
+
+	form := ResetPasswordForm{
+		Code:            r.FormValue("code"),
+		Password:        r.FormValue("password"),
+		ConfirmPassword: r.FormValue("confirm-password"),
+	}
+
+	if form.Code == "" {
+		return form, fmt.Errorf("reset code is required")
+	}
+	if form.Password == "" {
+		return form, fmt.Errorf("password is required")
+	}
+	if form.Password != form.ConfirmPassword {
+		return form, fmt.Errorf("passwords do not match")
+	}
+
+	return form, nil
+}
+
+type DisableTwoFactorForm struct {
+	Password string `form:"password"`
+	Code     string `form:"code"`
+}
+
+func DisableTwoFactorFrom(r *http.Request) (DisableTwoFactorForm, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return DisableTwoFactorForm{}, err
+	}
+
+	form := DisableTwoFactorForm{
+		Password: r.FormValue("password"),
+		Code:     r.FormValue("code"),
+	}
+
+	if form.Password == "" {
+		return DisableTwoFactorForm{}, fmt.Errorf("password is required")
+	}
+	if form.Code == "" {
+		return DisableTwoFactorForm{}, fmt.Errorf("authentication code is required")
+	}
+
+	return form, nil
+}
+
+type ChangeEmailForm struct {
+	Password string `form:"password"`
+	Email    string `form:"email"`
+}
+
+func ChangeEmailFrom(r *http.Request) (ChangeEmailForm, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return ChangeEmailForm{}, err
+	}
+
+	form := ChangeEmailForm{
+		Password: r.FormValue("password"),
+		Email:    r.FormValue("email"),
+	}
+
+	if form.Password == "" {
+		return ChangeEmailForm{}, fmt.Errorf("password is required")
+	}
+	if form.Email == "" {
+		return ChangeEmailForm{}, fmt.Errorf("new email is required")
+	}
+
+	return form, nil
+}
+
+type DeleteAccountForm struct {
+	Password string `form:"password"`
+	Code     string `form:"code"`
+}
+
+// DeleteAccountFrom parses the deletion form, the code is only required when two-factor authentication is enabled
+func DeleteAccountFrom(r *http.Request) (DeleteAccountForm, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return DeleteAccountForm{}, err
+	}
+
+	form := DeleteAccountForm{
+		Password: r.FormValue("password"),
+		Code:     r.FormValue("code"),
+	}
+
+	if form.Password == "" {
+		return DeleteAccountForm{}, fmt.Errorf("password is required")
+	}
+
+	return form, nil
+}
@@ -1,54 +1,451 @@
 package web
 
 import (
+	"html/template"
 	"io"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/AltSoyuz/soy-experiments/apps/todo/model"
+	"github.com/AltSoyuz/soy-experiments/lib/qrcode"
 )
 
 type pageData struct {
 	Title     string
 	CSRFToken string
 	Error     string
+	Message   string
 }
 
 func RenderNotFoundPage(w io.Writer) {
 	RenderPage(w, "404", nil)
 }
 
-func RenderRegisterPage(w io.Writer, csrfToken string) {
-	RenderPage(w, "register", pageData{Title: "Register", CSRFToken: csrfToken})
+// RegisterData is shared by the register page and its form.
+// Invite carries the invitation token and Email the invited address, Notice replaces the form when registering is not possible.
+type RegisterData struct {
+	Title     string
+	CSRFToken string
+	Invite    string
+	Email     string
+	Notice    string
+	Error     string
+}
+
+func RenderRegisterPage(w io.Writer, csrfToken, invite, email, notice string) {
+	RenderPage(w, "register", RegisterData{Title: "Register", CSRFToken: csrfToken, Invite: invite, Email: email, Notice: notice})
 }
 
-func RenderLoginPage(w io.Writer, csrfToken string) {
-	RenderPage(w, "login", pageData{Title: "Login", CSRFToken: csrfToken})
+// LoginPageData has a CSRF token of its own for the passkey sign-in, so it does not spend the one of the password form
+type LoginPageData struct {
+	Title            string
+	CSRFToken        string
+	PasskeyCSRFToken string
+	Error            string
+	OAuthProviders   []string
+}
+
+func RenderLoginPage(w io.Writer, csrfToken, passkeyCSRFToken string, oauthProviders []string) {
+	RenderPage(w, "login", LoginPageData{
+		Title:            "Login",
+		CSRFToken:        csrfToken,
+		PasskeyCSRFToken: passkeyCSRFToken,
+		OAuthProviders:   oauthProviders,
+	})
+}
+
+type RedirectPageData struct {
+	Title    string
+	Location string
+}
+
+func RenderRedirectPage(w io.Writer, location string) {
+	RenderPage(w, "redirect", RedirectPageData{Title: "Redirecting", Location: location})
 }
 
 type FormData struct {
 	CSRFToken string
 	Error     string
+	Message   string
 }
 
 func RenderLoginForm(w io.Writer, csrfToken, error string) {
 	RenderComponent(w, "login-form", "login-form", FormData{CSRFToken: csrfToken, Error: error})
 }
 
-func RenderRegisterForm(w io.Writer, csrfToken, error string) {
-	RenderComponent(w, "register-form", "register-form", FormData{CSRFToken: csrfToken, Error: error})
+func RenderRegisterForm(w io.Writer, csrfToken, invite, email, error string) {
+	RenderComponent(w, "register-form", "register-form", RegisterData{CSRFToken: csrfToken, Invite: invite, Email: email, Error: error})
 }
 
 func RenderVerifyEmailForm(w io.Writer, csrfToken, error string) {
 	RenderComponent(w, "verify-email-form", "verify-email-form", FormData{CSRFToken: csrfToken, Error: error})
 }
 
-func RenderVerifyEmail(w io.Writer, csrfToken string) {
+// VerifyEmailData is shared by the verify email page and its resend form.
+// ResendIn is empty once a new code can be sent.
+type VerifyEmailData struct {
+	Title     string
+	CSRFToken string
+	ResendIn  string
+	Error     string
+	Message   string
+}
+
+func RenderVerifyEmail(w io.Writer, csrfToken, resendIn string) {
 	RenderPage(
 		w,
 		"verify-email",
-		pageData{Title: "Verify Email", CSRFToken: csrfToken},
+		VerifyEmailData{Title: "Verify Email", CSRFToken: csrfToken, ResendIn: resendIn},
 	)
 }
 
+func RenderResendVerificationForm(w io.Writer, csrfToken, resendIn, error, message string) {
+	RenderComponent(w, "resend-verification-form", "resend-verification-form", VerifyEmailData{CSRFToken: csrfToken, ResendIn: resendIn, Error: error, Message: message})
+}
+
+func RenderForgotPasswordPage(w io.Writer, csrfToken string) {
+	RenderPage(w, "forgot-password", pageData{Title: "Forgot Password", CSRFToken: csrfToken})
+}
+
+func RenderForgotPasswordForm(w io.Writer, csrfToken, error, message string) {
+	RenderComponent(w, "forgot-password-form", "forgot-password-form", FormData{CSRFToken: csrfToken, Error: error, Message: message})
+}
+
+func RenderMagicLinkPage(w io.Writer, csrfToken, error string) {
+	RenderPage(w, "magic-link", pageData{Title: "Sign in with Email", CSRFToken: csrfToken, Error: error})
+}
+
+func RenderMagicLinkForm(w io.Writer, csrfToken, error, message string) {
+	RenderComponent(w, "magic-link-form", "magic-link-form", FormData{CSRFToken: csrfToken, Error: error, Message: message})
+}
+
+type ResetPasswordData struct {
+	Title     string
+	CSRFToken string
+	Code      string
+	Error     string
+}
+
+func RenderResetPasswordPage(w io.Writer, csrfToken, code string) {
+	RenderPage(w, "reset-password", ResetPasswordData{Title: "Reset Password", CSRFToken: csrfToken, Code: code})
+}
+
+func RenderResetPasswordForm(w io.Writer, csrfToken, code, error string) {
+	RenderComponent(w, "reset-password-form", "reset-password-form", ResetPasswordData{CSRFToken: csrfToken, Code: code, Error: error})
+}
+
+// TwoFactorPageData offers a passkey in place of the code when the user has one
+type TwoFactorPageData struct {
+	Title            string
+	CSRFToken        string
+	PasskeyCSRFToken string
+	Error            string
+	Passkeys         bool
+}
+
+func RenderTwoFactorPage(w io.Writer, csrfToken, passkeyCSRFToken string, passkeys bool) {
+	RenderPage(w, "two-factor", TwoFactorPageData{
+		Title:            "Two-Factor Authentication",
+		CSRFToken:        csrfToken,
+		PasskeyCSRFToken: passkeyCSRFToken,
+		Passkeys:         passkeys,
+	})
+}
+
+func RenderTwoFactorForm(w io.Writer, csrfToken, error string) {
+	RenderComponent(w, "two-factor-form", "two-factor-form", FormData{CSRFToken: csrfToken, Error: error})
+}
+
+type TwoFactorSettingsData struct {
+	Title     string
+	CSRFToken string
+	Enabled   bool
+	Secret    string
+	// KeyURL is the otpauth:// URL of the secret, html/template would otherwise reject its scheme
+	KeyURL template.URL
+	// QRCode is the key URL as an inline SVG for authenticator apps to scan
+	QRCode template.HTML
+	Error  string
+}
+
+func RenderTwoFactorSettingsPage(w io.Writer, csrfToken string, enabled bool) {
+	RenderPage(w, "account-two-factor", TwoFactorSettingsData{Title: "Two-Factor Authentication", CSRFToken: csrfToken, Enabled: enabled})
+}
+
+func RenderTwoFactorEnroll(w io.Writer, csrfToken, secret, keyURL string) {
+	data := TwoFactorSettingsData{CSRFToken: csrfToken, Secret: secret, KeyURL: template.URL(keyURL)}
+	// Without a QR code the secret can still be typed in
+	if code, err := qrcode.Encode([]byte(keyURL), qrcode.LevelM); err != nil {
+		slog.Error("error encoding totp qr code", "error", err)
+	} else {
+		data.QRCode = template.HTML(code.SVG())
+	}
+	RenderComponent(w, "two-factor-enroll", "two-factor-enroll", data)
+}
+
+func RenderTwoFactorConfirmForm(w io.Writer, csrfToken, error string) {
+	RenderComponent(w, "two-factor-confirm-form", "two-factor-confirm-form", FormData{CSRFToken: csrfToken, Error: error})
+}
+
+func RenderTwoFactorDisableForm(w io.Writer, csrfToken, error string) {
+	RenderComponent(w, "two-factor-disable-form", "two-factor-disable-form", FormData{CSRFToken: csrfToken, Error: error})
+}
+
+func RenderRecoveryCodePage(w io.Writer, csrfToken string) {
+	RenderPage(w, "recovery-code", pageData{Title: "Recovery Code", CSRFToken: csrfToken})
+}
+
+func RenderRecoveryCodeForm(w io.Writer, csrfToken, error string) {
+	RenderComponent(w, "recovery-code-form", "recovery-code-form", FormData{CSRFToken: csrfToken, Error: error})
+}
+
+type RecoveryCodesData struct {
+	Title       string
+	CSRFToken   string
+	Enabled     bool
+	Remaining   int64
+	Codes       []string
+	DownloadURL template.URL
+}
+
+func RenderRecoveryCodesPage(w io.Writer, csrfToken string, enabled bool, remaining int64) {
+	RenderPage(w, "account-recovery-codes", RecoveryCodesData{
+		Title:     "Recovery Codes",
+		CSRFToken: csrfToken,
+		Enabled:   enabled,
+		Remaining: remaining,
+	})
+}
+
+func RenderRecoveryCodes(w io.Writer, codes []string) {
+	RenderComponent(w, "recovery-codes", "recovery-codes", RecoveryCodesData{
+		Codes: codes,
+		// The codes are only known while rendering this response, so the download is built in place
+		DownloadURL: template.URL("data:text/plain;charset=utf-8," + url.PathEscape(RecoveryCodesText(codes))),
+	})
+}
+
+// RecoveryCodesText formats recovery codes for the plain text download
+func RecoveryCodesText(codes []string) string {
+	var b strings.Builder
+	b.WriteString("Recovery codes\n")
+	b.WriteString("Each code can be used once to sign in without your authenticator.\n\n")
+	for _, code := range codes {
+		b.WriteString(code)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+type EmailSettingsData struct {
+	Title        string
+	CSRFToken    string
+	Email        string
+	PendingEmail string
+	Error        string
+}
+
+func RenderEmailSettingsPage(w io.Writer, csrfToken, email, pendingEmail string) {
+	RenderPage(w, "settings-email", EmailSettingsData{
+		Title:        "Email Address",
+		CSRFToken:    csrfToken,
+		Email:        email,
+		PendingEmail: pendingEmail,
+	})
+}
+
+func RenderEmailChangeForm(w io.Writer, csrfToken, error string) {
+	RenderComponent(w, "email-change-form", "email-change-form", FormData{CSRFToken: csrfToken, Error: error})
+}
+
+func RenderEmailChangeConfirmForm(w io.Writer, csrfToken, pendingEmail, error string) {
+	RenderComponent(w, "email-change-confirm-form", "email-change-confirm-form", EmailSettingsData{
+		CSRFToken:    csrfToken,
+		PendingEmail: pendingEmail,
+		Error:        error,
+	})
+}
+
+type CancelEmailChangeData struct {
+	Title     string
+	CSRFToken string
+	Code      string
+	Error     string
+	Message   string
+}
+
+func RenderCancelEmailChangePage(w io.Writer, csrfToken, code, error, message string) {
+	RenderPage(w, "cancel-email-change", CancelEmailChangeData{
+		Title:     "Cancel Email Change",
+		CSRFToken: csrfToken,
+		Code:      code,
+		Error:     error,
+		Message:   message,
+	})
+}
+
+type SessionsPageData struct {
+	Title     string
+	CSRFToken string
+	Sessions  []SessionComponentData
+}
+
+type SessionComponentData struct {
+	Session   model.ActiveSession
+	LastSeen  time.Time
+	Current   bool
+	CSRFToken string
+}
+
+func RenderSessionsPage(w io.Writer, csrfToken string, sessions []model.ActiveSession, currentSessionId string) {
+	items := make([]SessionComponentData, 0, len(sessions))
+	for _, session := range sessions {
+		items = append(items, SessionComponentData{
+			Session:   session,
+			LastSeen:  time.Unix(session.LastSeenAt, 0).UTC(),
+			Current:   session.Id == currentSessionId,
+			CSRFToken: csrfToken,
+		})
+	}
+	RenderPage(w, "settings-sessions", SessionsPageData{Title: "Active Sessions", CSRFToken: csrfToken, Sessions: items})
+}
+
+type SecurityActivityPageData struct {
+	Title  string
+	Events []model.AuthEvent
+}
+
+func RenderSecurityActivityPage(w io.Writer, events []model.AuthEvent) {
+	RenderPage(w, "settings-security", SecurityActivityPageData{Title: "Security Activity", Events: events})
+}
+
+type AccessTokensPageData struct {
+	Title     string
+	CSRFToken string
+	Error     string
+	Tokens    []AccessTokenComponentData
+}
+
+type AccessTokenComponentData struct {
+	Token      model.AccessToken
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+	CSRFToken  string
+}
+
+func RenderAccessTokensPage(w io.Writer, csrfToken string, tokens []model.AccessToken) {
+	items := make([]AccessTokenComponentData, 0, len(tokens))
+	for _, token := range tokens {
+		item := AccessTokenComponentData{
+			Token:     token,
+			CreatedAt: time.Unix(token.CreatedAt, 0).UTC(),
+			ExpiresAt: time.Unix(token.ExpiresAt, 0).UTC(),
+			CSRFToken: csrfToken,
+		}
+		if token.LastUsedAt != 0 {
+			item.LastUsedAt = time.Unix(token.LastUsedAt, 0).UTC()
+		}
+		items = append(items, item)
+	}
+	RenderPage(w, "settings-tokens", AccessTokensPageData{Title: "Personal Access Tokens", CSRFToken: csrfToken, Tokens: items})
+}
+
+func RenderAccessTokenForm(w io.Writer, csrfToken, error string) {
+	RenderComponent(w, "access-token-form", "access-token-form", FormData{CSRFToken: csrfToken, Error: error})
+}
+
+type AccessTokenCreatedData struct {
+	Name  string
+	Token string
+}
+
+func RenderAccessTokenCreated(w io.Writer, name, token string) {
+	RenderComponent(w, "access-token-created", "access-token-created", AccessTokenCreatedData{Name: name, Token: token})
+}
+
+type InvitationsPageData struct {
+	Title       string
+	CSRFToken   string
+	Error       string
+	Message     string
+	Enabled     bool
+	Invitations []InvitationComponentData
+}
+
+// InvitationComponentData is a pending invitation, with its own CSRF token to revoke it
+type InvitationComponentData struct {
+	Invitation model.Invitation
+	ExpiresAt  time.Time
+	CSRFToken  string
+}
+
+func RenderInvitationsPage(w io.Writer, csrfToken string, enabled bool, invitations []InvitationComponentData) {
+	RenderPage(w, "settings-invitations", InvitationsPageData{
+		Title:       "Invitations",
+		CSRFToken:   csrfToken,
+		Enabled:     enabled,
+		Invitations: invitations,
+	})
+}
+
+func RenderInvitationForm(w io.Writer, csrfToken, error, message string) {
+	RenderComponent(w, "invitation-form", "invitation-form", FormData{CSRFToken: csrfToken, Error: error, Message: message})
+}
+
+type PasskeysPageData struct {
+	Title     string
+	CSRFToken string
+	Passkeys  []PasskeyComponentData
+}
+
+// PasskeyComponentData is a registered passkey, with its own CSRF token to remove it
+type PasskeyComponentData struct {
+	Passkey    model.Passkey
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	CSRFToken  string
+}
+
+func RenderPasskeysPage(w io.Writer, csrfToken string, passkeys []PasskeyComponentData) {
+	RenderPage(w, "settings-passkeys", PasskeysPageData{Title: "Passkeys", CSRFToken: csrfToken, Passkeys: passkeys})
+}
+
+type DevicesPageData struct {
+	Title   string
+	Devices []DeviceComponentData
+}
+
+// DeviceComponentData is a known device, with its own CSRF token to remove it
+type DeviceComponentData struct {
+	Device    model.KnownDevice
+	FirstSeen time.Time
+	LastSeen  time.Time
+	CSRFToken string
+}
+
+func RenderDevicesPage(w io.Writer, devices []DeviceComponentData) {
+	RenderPage(w, "settings-devices", DevicesPageData{Title: "Known Devices", Devices: devices})
+}
+
+type DeviceReportData struct {
+	Title     string
+	CSRFToken string
+	Code      string
+	Error     string
+}
+
+func RenderDeviceReportPage(w io.Writer, csrfToken, code, error string) {
+	RenderPage(w, "device-report", DeviceReportData{
+		Title:     "Report a Sign-in",
+		CSRFToken: csrfToken,
+		Code:      code,
+		Error:     error,
+	})
+}
+
 func RenderAbout(w io.Writer) {
 	RenderPage(w, "about", pageData{Title: "About"})
 }
@@ -61,6 +458,7 @@ type TodoPageData struct {
 	Title     string
 	Items     []TodoComponentData
 	Email     string
+	IsAdmin   bool
 	CSRFToken string
 }
 
@@ -80,3 +478,68 @@ func RenderFormFragment(w io.Writer, todo model.Todo, csrfToken string) {
 func RenderTodoFragment(w io.Writer, todo model.Todo, csrfToken string) {
 	RenderComponent(w, "todo", "todo", TodoComponentData{Todo: todo, CSRFToken: csrfToken})
 }
+
+type UnlockLoginData struct {
+	Title     string
+	CSRFToken string
+	Code      string
+	Error     string
+	Message   string
+}
+
+func RenderUnlockLoginPage(w io.Writer, csrfToken, code, error, message string) {
+	RenderPage(w, "unlock-login", UnlockLoginData{
+		Title:     "Unlock Sign-in",
+		CSRFToken: csrfToken,
+		Code:      code,
+		Error:     error,
+		Message:   message,
+	})
+}
+
+type AccountSettingsData struct {
+	Title            string
+	CSRFToken        string
+	TwoFactorEnabled bool
+	Error            string
+}
+
+func RenderAccountSettingsPage(w io.Writer, csrfToken string, twoFactorEnabled bool) {
+	RenderPage(w, "settings-account", AccountSettingsData{
+		Title:            "Your Data",
+		CSRFToken:        csrfToken,
+		TwoFactorEnabled: twoFactorEnabled,
+	})
+}
+
+func RenderDeleteAccountForm(w io.Writer, csrfToken string, twoFactorEnabled bool, error string) {
+	RenderComponent(w, "delete-account-form", "delete-account-form", AccountSettingsData{
+		CSRFToken:        csrfToken,
+		TwoFactorEnabled: twoFactorEnabled,
+		Error:            error,
+	})
+}
+
+type AdminPageData struct {
+	Title string
+	Query string
+	Users []AdminUserComponentData
+	// NextAfter starts the next page, it is 0 on the last one
+	NextAfter int64
+}
+
+// AdminUserComponentData is a row of the admin console.
+// Every row has its own CSRF token since tokens are used up by each action.
+type AdminUserComponentData struct {
+	User      model.UserSummary
+	Self      bool
+	CSRFToken string
+}
+
+func RenderAdminPage(w io.Writer, query string, users []AdminUserComponentData, nextAfter int64) {
+	RenderPage(w, "admin", AdminPageData{Title: "Admin", Query: query, Users: users, NextAfter: nextAfter})
+}
+
+func RenderAdminUser(w io.Writer, user AdminUserComponentData) {
+	RenderComponent(w, "admin-user", "admin-user", user)
+}
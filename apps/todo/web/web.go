@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"maps"
+	"net/http"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -43,6 +45,8 @@ func NewTemplateCache(additionalFuncs ...template.FuncMap) *TemplateCache {
 		"formatDate": func(t time.Time, format string) string {
 			return t.Format(format)
 		},
+		// Replaced while rendering the pages of an impersonation, see WithImpersonationBanner
+		"impersonation": func() *ImpersonationBanner { return nil },
 	}
 
 	for _, fm := range additionalFuncs {
@@ -156,11 +160,59 @@ func (tc *TemplateCache) RenderTemplate(w io.Writer, name, block string, data an
 	}
 }
 
+// renderPageWithBanner renders a full page whose impersonation func returns the banner.
+// Templates cannot be cloned once executed, so the page is parsed again,
+// impersonations are rare enough for this not to matter.
+func (tc *TemplateCache) renderPageWithBanner(w io.Writer, name string, data any, banner ImpersonationBanner) {
+	funcMap := maps.Clone(tc.funcMap)
+	funcMap["impersonation"] = func() *ImpersonationBanner { return &banner }
+
+	files := []string{"layouts/" + defaultLayout, "components/*.html", "pages/" + name + ".html"}
+	tmpl, err := template.New(name).Funcs(funcMap).ParseFS(htmlFiles, files...)
+	if err != nil {
+		fmt.Printf("error parsing template: %v\n", err)
+		return
+	}
+
+	if err := tmpl.ExecuteTemplate(w, defaultLayout, data); err != nil {
+		fmt.Printf("error executing template: %v\n", err)
+		return
+	}
+}
+
 // Singleton cache for global access
 var TemplateSystem = NewTemplateCache()
 
+// ImpersonationBanner is shown at the top of every page while an admin uses the app as another user
+type ImpersonationBanner struct {
+	AdminEmail string
+	UserEmail  string
+	EndsAt     time.Time
+	CSRFToken  string
+}
+
+// impersonationWriter carries the banner of a response down to RenderPage
+type impersonationWriter struct {
+	http.ResponseWriter
+	banner func() ImpersonationBanner
+}
+
+func (w *impersonationWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// WithImpersonationBanner makes the pages rendered to w show the banner.
+// banner is only called for full pages, fragments swapped by HTMX do not need it.
+func WithImpersonationBanner(w http.ResponseWriter, banner func() ImpersonationBanner) http.ResponseWriter {
+	return &impersonationWriter{ResponseWriter: w, banner: banner}
+}
+
 // RenderPage renders a full page with the base layout
 func RenderPage(w io.Writer, name string, data any) {
+	if iw, ok := w.(*impersonationWriter); ok {
+		TemplateSystem.renderPageWithBanner(w, name, data, iw.banner())
+		return
+	}
 	TemplateSystem.RenderTemplate(w, name, defaultLayout, data)
 }
 
@@ -0,0 +1,114 @@
+package argon2id
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// CalibrationOptions bound the parameters Calibrate may pick
+type CalibrationOptions struct {
+	// Target is the longest a hash should take on this host
+	Target time.Duration
+	// MinMemory in KiB defaults to the memory of DefaultParams, calibration never goes below it
+	MinMemory uint32
+	// MaxMemory in KiB is the most a single hash may use, it defaults to 64 MiB
+	MaxMemory uint32
+	// MinIterations defaults to the iterations of DefaultParams
+	MinIterations uint32
+	// Parallelism defaults to 1, raise it only when hashes may use several cores each
+	Parallelism uint8
+	// Samples is the number of hashes timed per candidate, the median is kept. It defaults to 3.
+	Samples int
+}
+
+// Calibration is the outcome of Calibrate
+type Calibration struct {
+	Params Params
+	// Duration is the median time a hash took with Params
+	Duration time.Duration
+}
+
+const defaultCalibrationMaxMemory = 64 << 10
+
+// Calibrate times hashes on this host to find the costliest parameters within the target latency.
+// Memory is doubled first, it is what makes guessing expensive on GPUs, then iterations are added with the time left.
+// When even the minimum parameters are over the target they are returned with their duration, the caller decides.
+func Calibrate(opts CalibrationOptions) (Calibration, error) {
+	return calibrate(opts, func(params Params) time.Duration {
+		salt := make([]byte, params.SaltLength)
+		start := time.Now()
+		argon2.IDKey([]byte("calibration password"), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		return time.Since(start)
+	})
+}
+
+func calibrate(opts CalibrationOptions, measure func(Params) time.Duration) (Calibration, error) {
+	if opts.Target <= 0 {
+		return Calibration{}, errors.New("argon2id: a positive target duration is required")
+	}
+	if opts.MinMemory == 0 {
+		opts.MinMemory = DefaultParams.Memory
+	}
+	if opts.MaxMemory == 0 {
+		opts.MaxMemory = max(defaultCalibrationMaxMemory, opts.MinMemory)
+	}
+	if opts.MinIterations == 0 {
+		opts.MinIterations = DefaultParams.Iterations
+	}
+	if opts.Parallelism == 0 {
+		opts.Parallelism = 1
+	}
+	if opts.Samples <= 0 {
+		opts.Samples = 3
+	}
+	if opts.MaxMemory < opts.MinMemory {
+		return Calibration{}, errors.New("argon2id: the maximum memory is below the minimum")
+	}
+
+	params := Params{
+		Memory:      opts.MinMemory,
+		Iterations:  opts.MinIterations,
+		Parallelism: opts.Parallelism,
+		SaltLength:  DefaultParams.SaltLength,
+		KeyLength:   DefaultParams.KeyLength,
+	}
+	if err := params.Validate(); err != nil {
+		return Calibration{}, err
+	}
+	median := func(params Params) time.Duration {
+		durations := make([]time.Duration, opts.Samples)
+		for i := range durations {
+			durations[i] = measure(params)
+		}
+		slices.Sort(durations)
+		return durations[len(durations)/2]
+	}
+
+	best := Calibration{Params: params, Duration: median(params)}
+	if best.Duration > opts.Target {
+		return best, nil
+	}
+
+	for best.Params.Memory*2 <= opts.MaxMemory {
+		candidate := best.Params
+		candidate.Memory *= 2
+		duration := median(candidate)
+		if duration > opts.Target {
+			break
+		}
+		best = Calibration{Params: candidate, Duration: duration}
+	}
+	for {
+		candidate := best.Params
+		candidate.Iterations++
+		duration := median(candidate)
+		if duration > opts.Target {
+			break
+		}
+		best = Calibration{Params: candidate, Duration: duration}
+	}
+	return best, nil
+}
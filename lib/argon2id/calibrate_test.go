@@ -0,0 +1,57 @@
+package argon2id
+
+import (
+	"testing"
+	"time"
+)
+
+// linearCost models a host where a hash takes a microsecond per KiB and iteration
+func linearCost(params Params) time.Duration {
+	return time.Duration(params.Memory) * time.Duration(params.Iterations) * time.Microsecond
+}
+
+func TestCalibrate(t *testing.T) {
+	f := func(opts CalibrationOptions, expectMemory, expectIterations uint32) {
+		t.Helper()
+
+		result, err := calibrate(opts, linearCost)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if result.Params.Memory != expectMemory || result.Params.Iterations != expectIterations {
+			t.Fatalf("unexpected params m=%d,t=%d; want m=%d,t=%d", result.Params.Memory, result.Params.Iterations, expectMemory, expectIterations)
+		}
+		if result.Duration != linearCost(result.Params) {
+			t.Fatalf("unexpected duration %s", result.Duration)
+		}
+		if err := result.Params.Validate(); err != nil {
+			t.Fatalf("expected valid params, got: %v", err)
+		}
+	}
+
+	// memory is doubled up to its maximum, then iterations fill the time left
+	f(CalibrationOptions{Target: 500 * time.Millisecond}, 38912, 12)
+	f(CalibrationOptions{Target: 500 * time.Millisecond, MaxMemory: 32768}, 19456, 25)
+	// memory stops growing once the target is reached
+	f(CalibrationOptions{Target: 100 * time.Millisecond}, 38912, 2)
+	f(CalibrationOptions{Target: 100 * time.Millisecond, MaxMemory: 1 << 20, MinIterations: 1}, 77824, 1)
+	// the minimum parameters are kept even over the target
+	f(CalibrationOptions{Target: time.Millisecond}, 19456, 2)
+
+	if _, err := calibrate(CalibrationOptions{}, linearCost); err == nil {
+		t.Fatalf("expected a target to be required")
+	}
+	if _, err := calibrate(CalibrationOptions{Target: time.Second, MinMemory: 65536, MaxMemory: 1024}, linearCost); err == nil {
+		t.Fatalf("expected inconsistent memory bounds to be rejected")
+	}
+}
+
+func TestCalibrateHost(t *testing.T) {
+	result, err := Calibrate(CalibrationOptions{Target: 20 * time.Millisecond, MinMemory: 64, MaxMemory: 1024, MinIterations: 1, Samples: 1})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if result.Params.Memory < 64 || result.Params.Memory > 1024 || result.Duration <= 0 {
+		t.Fatalf("unexpected calibration %+v", result)
+	}
+}
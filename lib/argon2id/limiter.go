@@ -0,0 +1,117 @@
+package argon2id
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrOverloaded is returned instead of queueing more hashes than the limiter allows
+var ErrOverloaded = errors.New("argon2id: too many hashes in progress")
+
+// Limiter bounds the hashes computed at once, each one holds its memory cost until it is done.
+// Calls beyond the concurrency wait in a queue of bounded length, calls beyond the queue fail with ErrOverloaded.
+type Limiter struct {
+	params Params
+	// admitted counts running and queued calls, running holds one slot per params.Memory KiB in use
+	admitted chan struct{}
+	running  chan struct{}
+	// acquiring lets one call at a time take its slots, so calls needing several cannot starve each other
+	acquiring sync.Mutex
+}
+
+// NewLimiter returns a limiter hashing with params, running at most concurrency hashes with up to queue more waiting.
+// At most concurrency × params.Memory KiB are used for hashing at any time. Verifying a hash stored with a larger
+// memory cost counts as several hashes, one needing more than the whole budget runs alone.
+func NewLimiter(params Params, concurrency, queue int) (*Limiter, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	if concurrency < 1 || queue < 0 {
+		return nil, errors.New("argon2id: concurrency must be at least 1 and the queue cannot be negative")
+	}
+	return &Limiter{
+		params:   params,
+		admitted: make(chan struct{}, concurrency+queue),
+		running:  make(chan struct{}, concurrency),
+	}, nil
+}
+
+// Params returns the parameters of new hashes
+func (l *Limiter) Params() Params {
+	return l.params
+}
+
+// Hash hashes the password with the parameters of the limiter
+func (l *Limiter) Hash(ctx context.Context, password string) (string, error) {
+	var hash string
+	err := l.do(ctx, l.params.Memory, func() (err error) {
+		hash, err = HashWithParams(password, l.params)
+		return err
+	})
+	return hash, err
+}
+
+// Verify reports whether the password matches the hash, with the parameters encoded in the hash
+func (l *Limiter) Verify(ctx context.Context, hash, password string) (bool, error) {
+	params, _, _, err := decode(hash)
+	if err != nil {
+		return false, err
+	}
+	var valid bool
+	err = l.do(ctx, params.Memory, func() (err error) {
+		valid, err = Verify(hash, password)
+		return err
+	})
+	return valid, err
+}
+
+// NeedsRehash reports whether the hash was created with other parameters than the ones of the limiter
+func (l *Limiter) NeedsRehash(hash string) (bool, error) {
+	return NeedsRehash(hash, l.params)
+}
+
+// do runs fn once memory KiB fit in the budget of the limiter
+func (l *Limiter) do(ctx context.Context, memory uint32, fn func() error) error {
+	select {
+	case l.admitted <- struct{}{}:
+	default:
+		return ErrOverloaded
+	}
+	defer func() { <-l.admitted }()
+
+	slots := l.slots(memory)
+	if err := l.acquire(ctx, slots); err != nil {
+		return err
+	}
+	defer l.release(slots)
+
+	return fn()
+}
+
+// slots returns the running slots a hash using memory KiB holds, at least one and at most all of them
+func (l *Limiter) slots(memory uint32) int {
+	n := int((uint64(memory) + uint64(l.params.Memory) - 1) / uint64(l.params.Memory))
+	return min(max(n, 1), cap(l.running))
+}
+
+func (l *Limiter) acquire(ctx context.Context, slots int) error {
+	l.acquiring.Lock()
+	defer l.acquiring.Unlock()
+
+	for i := 0; i < slots; i++ {
+		select {
+		case l.running <- struct{}{}:
+		case <-ctx.Done():
+			l.release(i)
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (l *Limiter) release(slots int) {
+	for i := 0; i < slots; i++ {
+		<-l.running
+	}
+}
@@ -0,0 +1,175 @@
+package argon2id
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var testParams = Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestLimiter(t *testing.T) {
+	l, err := NewLimiter(testParams, 2, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	ctx := context.Background()
+
+	hash, err := l.Hash(ctx, "password")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if valid, err := l.Verify(ctx, hash, "password"); err != nil || !valid {
+		t.Fatalf("expected the password to match, got %v, %v", valid, err)
+	}
+	if needsRehash, err := l.NeedsRehash(hash); err != nil || needsRehash {
+		t.Fatalf("expected no rehash, got %v, %v", needsRehash, err)
+	}
+
+	// two running hashes and a queued one fill the limiter
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	done := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			done <- l.do(ctx, testParams.Memory, func() error {
+				started <- struct{}{}
+				<-release
+				return nil
+			})
+		}()
+	}
+	<-started
+	<-started
+	waitFor(t, func() bool { return len(l.admitted) == 3 })
+
+	if _, err := l.Hash(ctx, "password"); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expected ErrOverloaded, got: %v", err)
+	}
+	if _, err := l.Verify(ctx, hash, "password"); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expected ErrOverloaded, got: %v", err)
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	if _, err := l.Hash(ctx, "password"); err != nil {
+		t.Fatalf("expected the limiter to accept hashes again, got: %v", err)
+	}
+}
+
+func TestLimiterCanceledWhileQueued(t *testing.T) {
+	l, err := NewLimiter(testParams, 1, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		_ = l.do(context.Background(), testParams.Memory, func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Hash(ctx, "password"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the queued hash to give up, got: %v", err)
+	}
+	// the queue slot is released
+	if len(l.admitted) != 1 {
+		t.Fatalf("expected one admitted call, got %d", len(l.admitted))
+	}
+}
+
+func TestLimiterCountsStoredMemory(t *testing.T) {
+	l, err := NewLimiter(testParams, 2, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	f := func(memory uint32, expect int) {
+		t.Helper()
+
+		if got := l.slots(memory); got != expect {
+			t.Fatalf("unexpected slots for %d KiB; got %d; want %d", memory, got, expect)
+		}
+	}
+
+	f(testParams.Memory, 1)
+	f(testParams.Memory/2, 1)
+	f(testParams.Memory+1, 2)
+
+	// more than the whole budget runs alone
+	f(maxMemory, 2)
+
+	// a hash from before the memory cost was lowered waits for both slots
+	older := testParams
+	older.Memory = 2 * testParams.Memory
+	hash, err := HashWithParams("password", older)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		_ = l.do(context.Background(), testParams.Memory, func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Verify(ctx, hash, "password"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the verification to wait for memory, got: %v", err)
+	}
+	// the slot taken while waiting is given back
+	if len(l.running) != 1 {
+		t.Fatalf("expected one running slot, got %d", len(l.running))
+	}
+
+	close(release)
+	waitFor(t, func() bool { return len(l.running) == 0 })
+	if valid, err := l.Verify(context.Background(), hash, "password"); err != nil || !valid {
+		t.Fatalf("expected the password to match, got %v, %v", valid, err)
+	}
+}
+
+func TestNewLimiterInvalid(t *testing.T) {
+	f := func(params Params, concurrency, queue int) {
+		t.Helper()
+
+		if _, err := NewLimiter(params, concurrency, queue); err == nil {
+			t.Fatalf("expected an error for %+v, %d, %d", params, concurrency, queue)
+		}
+	}
+
+	f(testParams, 0, 1)
+	f(testParams, 1, -1)
+	f(Params{}, 1, 1)
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -11,48 +11,134 @@ import (
 	"golang.org/x/crypto/argon2"
 )
 
+var (
+	ErrInvalidHash         = errors.New("invalid hash")
+	ErrInvalidAlgorithm    = errors.New("invalid algorithm")
+	ErrIncompatibleVersion = errors.New("unsupported hash")
+	ErrInvalidParams       = errors.New("invalid argon2id parameters")
+)
+
+// maxMemory bounds the memory a stored hash may ask for, in KiB, so a forged hash cannot exhaust the server
+const maxMemory = 4 << 20
+
+// Params are the cost parameters of a hash, they are encoded in the hash itself
+type Params struct {
+	// Memory in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultParams follow the OWASP recommendation of 19 MiB of memory and 2 iterations
+var DefaultParams = Params{
+	Memory:      19456,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Validate reports whether the parameters can produce a hash
+func (p Params) Validate() error {
+	switch {
+	case p.Iterations < 1:
+		return fmt.Errorf("%w: at least one iteration is required", ErrInvalidParams)
+	case p.Parallelism < 1:
+		return fmt.Errorf("%w: parallelism must be at least 1", ErrInvalidParams)
+	case p.Memory < 8*uint32(p.Parallelism):
+		return fmt.Errorf("%w: memory must be at least 8 KiB per lane", ErrInvalidParams)
+	case p.Memory > maxMemory:
+		return fmt.Errorf("%w: memory must be at most %d KiB", ErrInvalidParams, maxMemory)
+	case p.SaltLength < 8:
+		return fmt.Errorf("%w: salts must be at least 8 bytes", ErrInvalidParams)
+	case p.KeyLength < 16:
+		return fmt.Errorf("%w: keys must be at least 16 bytes", ErrInvalidParams)
+	}
+	return nil
+}
+
+// Hash hashes the password with the default parameters
 func Hash(password string) (string, error) {
-	salt := make([]byte, 16)
+	return HashWithParams(password, DefaultParams)
+}
+
+// HashWithParams hashes the password with a random salt and returns it in the PHC string format
+func HashWithParams(password string, params Params) (string, error) {
+	if err := params.Validate(); err != nil {
+		return "", err
+	}
+	salt := make([]byte, params.SaltLength)
 	_, err := rand.Read(salt)
 	if err != nil {
 		return "", err
 	}
-	key := argon2.IDKey([]byte(password), salt, 2, 19456, 1, 32)
-	hash := fmt.Sprintf("$argon2id$v=19$m=19456,t=2,p=1$%s$%s",
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	hash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		params.Memory,
+		params.Iterations,
+		params.Parallelism,
 		base64.RawStdEncoding.EncodeToString(salt),
 		base64.RawStdEncoding.EncodeToString(key),
 	)
 	return hash, nil
 }
 
+// Verify reports whether the password matches the hash, using the parameters encoded in the hash
 func Verify(hash string, password string) (bool, error) {
+	params, salt, key1, err := decode(hash)
+	if err != nil {
+		return false, err
+	}
+	key2 := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	valid := subtle.ConstantTimeCompare(key1, key2)
+	return valid == 1, nil
+}
+
+// NeedsRehash reports whether the hash was created with other parameters than params,
+// it should then be replaced the next time the password is known
+func NeedsRehash(hash string, params Params) (bool, error) {
+	current, _, _, err := decode(hash)
+	if err != nil {
+		return false, err
+	}
+	return current != params, nil
+}
+
+// decode parses a hash in the PHC string format, the salt and key lengths of the params are the decoded ones
+func decode(hash string) (Params, []byte, []byte, error) {
 	parts := strings.Split(hash, "$")
 	if len(parts) != 6 {
-		return false, errors.New("invalid hash")
+		return Params{}, nil, nil, ErrInvalidHash
 	}
 	if parts[0] != "" {
-		return false, errors.New("invalid hash")
+		return Params{}, nil, nil, ErrInvalidHash
 	}
 	if parts[1] != "argon2id" {
-		return false, errors.New("invalid algorithm")
+		return Params{}, nil, nil, ErrInvalidAlgorithm
 	}
-	if parts[2] != "v=19" {
-		return false, errors.New("unsupported hash")
+	if parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return Params{}, nil, nil, ErrIncompatibleVersion
 	}
-	var m, t, p int32
-	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &m, &t, &p)
+	var params Params
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
 	if err != nil {
-		return false, errors.New("invalid hash")
+		return Params{}, nil, nil, ErrInvalidHash
 	}
 	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
 	if err != nil {
-		return false, errors.New("invalid hash")
+		return Params{}, nil, nil, ErrInvalidHash
 	}
-	key1, err := base64.RawStdEncoding.DecodeString(parts[5])
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
 	if err != nil {
-		return false, errors.New("invalid hash")
+		return Params{}, nil, nil, ErrInvalidHash
 	}
-	key2 := argon2.IDKey([]byte(password), salt, 2, 19456, 1, uint32(len(key1)))
-	valid := subtle.ConstantTimeCompare(key1, key2)
-	return valid == 1, nil
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	if err := params.Validate(); err != nil {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+	return params, salt, key, nil
 }
@@ -1,6 +1,10 @@
 package argon2id
 
-import "testing"
+import (
+	"errors"
+	"strings"
+	"testing"
+)
 
 func Test(t *testing.T) {
 	hash, err := Hash("123456")
@@ -22,3 +26,109 @@ func Test(t *testing.T) {
 		t.Fatalf("Expected hash to not match")
 	}
 }
+
+func TestVerifyUsesEncodedParams(t *testing.T) {
+	f := func(hash, password string, expect bool) {
+		t.Helper()
+
+		valid, err := Verify(hash, password)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if valid != expect {
+			t.Fatalf("unexpected result for %q; got %v; want %v", password, valid, expect)
+		}
+	}
+
+	// test vector of the reference implementation: t=2, m=2^16, p=1 with the salt "somesalt"
+	reference := "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"
+	f(reference, "password", true)
+	f(reference, "Password", false)
+
+	// hashes of other parameters
+	params := Params{Memory: 8192, Iterations: 3, Parallelism: 2, SaltLength: 8, KeyLength: 16}
+	hash, err := HashWithParams("123456", params)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=8192,t=3,p=2$") {
+		t.Fatalf("unexpected hash %s", hash)
+	}
+	f(hash, "123456", true)
+	f(hash, "12345", false)
+}
+
+func TestVerifyRejectsInvalidHashes(t *testing.T) {
+	f := func(hash string, expect error) {
+		t.Helper()
+
+		if _, err := Verify(hash, "password"); !errors.Is(err, expect) {
+			t.Fatalf("unexpected error for %q; got %v; want %v", hash, err, expect)
+		}
+	}
+
+	f("", ErrInvalidHash)
+	f("$argon2i$v=19$m=65536,t=2,p=4$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG", ErrInvalidAlgorithm)
+	f("$argon2id$v=16$m=65536,t=2,p=4$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG", ErrIncompatibleVersion)
+	f("$argon2id$v=19$m=65536,t=0,p=4$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG", ErrInvalidHash)
+	f("$argon2id$v=19$m=65536,t=2,p=300$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG", ErrInvalidHash)
+	// memory beyond what the server allows
+	f("$argon2id$v=19$m=99999999,t=2,p=4$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG", ErrInvalidHash)
+	f("$argon2id$v=19$m=65536,t=2,p=4$not base64$RdescudvJCsgt3ub+b+dWRWJTmaaJObG", ErrInvalidHash)
+}
+
+func TestHashWithParamsRejectsInvalidParams(t *testing.T) {
+	f := func(params Params) {
+		t.Helper()
+
+		if _, err := HashWithParams("password", params); !errors.Is(err, ErrInvalidParams) {
+			t.Fatalf("expected ErrInvalidParams for %+v, got: %v", params, err)
+		}
+	}
+
+	valid := DefaultParams
+	f(Params{})
+	for _, tamper := range []func(p *Params){
+		func(p *Params) { p.Iterations = 0 },
+		func(p *Params) { p.Parallelism = 0 },
+		func(p *Params) { p.Memory = 8*uint32(p.Parallelism) - 1 },
+		func(p *Params) { p.Memory = maxMemory + 1 },
+		func(p *Params) { p.SaltLength = 4 },
+		func(p *Params) { p.KeyLength = 8 },
+	} {
+		params := valid
+		tamper(&params)
+		f(params)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	hash, err := Hash("password")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	f := func(params Params, expect bool) {
+		t.Helper()
+
+		needsRehash, err := NeedsRehash(hash, params)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if needsRehash != expect {
+			t.Fatalf("unexpected result for %+v; got %v; want %v", params, needsRehash, expect)
+		}
+	}
+
+	f(DefaultParams, false)
+	stronger := DefaultParams
+	stronger.Iterations++
+	f(stronger, true)
+	longer := DefaultParams
+	longer.KeyLength = 64
+	f(longer, true)
+
+	if _, err := NeedsRehash("not a hash", DefaultParams); !errors.Is(err, ErrInvalidHash) {
+		t.Fatalf("expected ErrInvalidHash, got: %v", err)
+	}
+}
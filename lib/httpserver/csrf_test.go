@@ -20,6 +20,7 @@ func TestCSRFMiddleware(t *testing.T) {
 		origin         string
 		token          string
 		tokenLocation  string // "header", "form", "cookie"
+		authorization  string
 		expectedStatus int
 	}{
 		{
@@ -60,6 +61,19 @@ func TestCSRFMiddleware(t *testing.T) {
 			tokenLocation:  "header",
 			expectedStatus: http.StatusForbidden,
 		},
+		{
+			name:           "Bearer authenticated POST without origin or token",
+			method:         "POST",
+			authorization:  "Bearer some-access-token",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Basic authenticated POST still needs a token",
+			method:         "POST",
+			origin:         "http://localhost:8080",
+			authorization:  "Basic dXNlcjpwYXNz",
+			expectedStatus: http.StatusForbidden,
+		},
 		{
 			name:           "Missing token",
 			method:         "POST",
@@ -87,6 +101,10 @@ func TestCSRFMiddleware(t *testing.T) {
 				req.Header.Set("Origin", tt.origin)
 			}
 
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
 			if tt.token != "" {
 				switch tt.tokenLocation {
 				case "header":
@@ -111,3 +129,33 @@ func TestCSRFMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestBearerToken(t *testing.T) {
+	f := func(header, expectToken string, expectOk bool) {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		token, ok := BearerToken(req)
+		if token != expectToken || ok != expectOk {
+			t.Fatalf("unexpected result; got %q, %v; want %q, %v", token, ok, expectToken, expectOk)
+		}
+	}
+
+	// no header
+	f("", "", false)
+
+	// other scheme
+	f("Basic dXNlcjpwYXNz", "", false)
+
+	// bearer token
+	f("Bearer abc", "abc", true)
+
+	// the scheme is case insensitive
+	f("bearer abc", "abc", true)
+
+	// empty bearer token is still a bearer request
+	f("Bearer ", "", true)
+}
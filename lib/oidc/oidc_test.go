@@ -0,0 +1,181 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/AltSoyuz/soy-experiments/lib/oidc"
+	"github.com/AltSoyuz/soy-experiments/lib/oidc/oidctest"
+)
+
+func TestCodeChallengeS256(t *testing.T) {
+	// RFC 7636 Appendix B
+	got := oidc.CodeChallengeS256("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got != want {
+		t.Fatalf("unexpected code challenge; got %s; want %s", got, want)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	fake := oidctest.NewProvider("client", "secret")
+	defer fake.Close()
+
+	ctx := context.Background()
+	p, err := oidc.Discover(ctx, fake.Issuer(), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if p.TokenEndpoint != fake.URL+"/token" {
+		t.Fatalf("unexpected token endpoint %s", p.TokenEndpoint)
+	}
+
+	// The discovered issuer must match the configured one
+	if _, err := oidc.Discover(ctx, fake.Issuer()+"/other", nil); err == nil {
+		t.Fatalf("expected error for mismatched issuer")
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	fake := oidctest.NewProvider("client", "secret")
+	defer fake.Close()
+	fake.SetUser(oidctest.User{Subject: "42", Email: "jane@example.com", EmailVerified: true})
+
+	ctx := context.Background()
+	p, err := oidc.Discover(ctx, fake.Issuer(), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	cfg := oidc.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/callback",
+	}
+
+	authorize := func(nonce, verifier string) string {
+		t.Helper()
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		res, err := client.Get(p.AuthCodeURL(cfg, "state", nonce, verifier))
+		if err != nil {
+			t.Fatalf("authorization request failed: %v", err)
+		}
+		res.Body.Close()
+
+		location, err := url.Parse(res.Header.Get("Location"))
+		if err != nil {
+			t.Fatalf("invalid redirect: %v", err)
+		}
+		if location.Query().Get("state") != "state" {
+			t.Fatalf("expected state to be returned")
+		}
+		return location.Query().Get("code")
+	}
+
+	// Wrong PKCE verifier
+	code := authorize("nonce", "verifier")
+	if _, err := p.Exchange(ctx, cfg, code, "other-verifier"); err == nil {
+		t.Fatalf("expected exchange with wrong verifier to fail")
+	}
+
+	// Valid exchange
+	code = authorize("nonce", "verifier")
+	token, err := p.Exchange(ctx, cfg, code, "verifier")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	// Codes are single use
+	if _, err := p.Exchange(ctx, cfg, code, "verifier"); err == nil {
+		t.Fatalf("expected code reuse to fail")
+	}
+
+	claims, err := p.VerifyIDToken(ctx, cfg, token.IDToken, "nonce")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if claims.Subject != "42" || claims.Email != "jane@example.com" || !claims.EmailVerified {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	if _, err := p.VerifyIDToken(ctx, cfg, token.IDToken, "other-nonce"); !errors.Is(err, oidc.ErrNonceMismatch) {
+		t.Fatalf("expected ErrNonceMismatch, got: %v", err)
+	}
+
+	info, err := p.UserInfo(ctx, token.AccessToken)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if info.Subject != "42" || info.Email != "jane@example.com" {
+		t.Fatalf("unexpected userinfo %+v", info)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	fake := oidctest.NewProvider("client", "secret")
+	defer fake.Close()
+
+	ctx := context.Background()
+	p, err := oidc.Discover(ctx, fake.Issuer(), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	cfg := oidc.Config{ClientID: "client"}
+
+	f := func(rawIDToken string, expect error) {
+		t.Helper()
+
+		_, err := p.VerifyIDToken(ctx, cfg, rawIDToken, "nonce")
+		if !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+	}
+
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{
+			"iss":   fake.Issuer(),
+			"sub":   "42",
+			"aud":   "client",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": "nonce",
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	f(fake.SignIDToken(claims(nil)), nil)
+	f(fake.SignIDToken(claims(map[string]any{"aud": []string{"other", "client"}, "azp": "client"})), nil)
+
+	// malformed
+	f("not-a-jwt", oidc.ErrInvalidToken)
+
+	// tampered payload
+	valid := fake.SignIDToken(claims(nil))
+	other := fake.SignIDToken(claims(map[string]any{"sub": "43"}))
+	f(valid[:len(valid)-10]+other[len(other)-10:], oidc.ErrInvalidToken)
+
+	// wrong issuer
+	f(fake.SignIDToken(claims(map[string]any{"iss": "https://evil.example.com"})), oidc.ErrInvalidToken)
+
+	// wrong audience
+	f(fake.SignIDToken(claims(map[string]any{"aud": "other"})), oidc.ErrInvalidToken)
+
+	// multiple audiences without authorized party
+	f(fake.SignIDToken(claims(map[string]any{"aud": []string{"other", "client"}})), oidc.ErrInvalidToken)
+
+	// expired
+	f(fake.SignIDToken(claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()})), oidc.ErrTokenExpired)
+
+	// nonce mismatch
+	f(fake.SignIDToken(claims(map[string]any{"nonce": "replayed"})), oidc.ErrNonceMismatch)
+}
@@ -0,0 +1,235 @@
+package otp
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TypeTOTP = "totp"
+	TypeHOTP = "hotp"
+)
+
+var (
+	ErrInvalidURL    = errors.New("otp: invalid otpauth URL")
+	ErrInvalidSecret = errors.New("otp: invalid base32 secret")
+	ErrInvalidIssuer = errors.New("otp: the issuer cannot contain a colon")
+)
+
+// secretEncoding is the base32 alphabet authenticator apps expect, without padding
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Key is a shared secret with the settings an authenticator app needs to produce its codes,
+// as carried by otpauth:// URLs
+type Key struct {
+	// Type is TypeTOTP or TypeHOTP
+	Type        string
+	Issuer      string
+	AccountName string
+	Secret      []byte
+	// Options holds the algorithm, digits and period, the skew and T0 are not part of the URL
+	Options Options
+	// Counter is the initial counter of HOTP keys
+	Counter uint64
+}
+
+// KeyOptions configure a generated key
+type KeyOptions struct {
+	// Type defaults to TypeTOTP
+	Type        string
+	Issuer      string
+	AccountName string
+	// SecretSize in bytes defaults to the output size of the algorithm, as RFC 4226 recommends
+	SecretSize int
+	Options    Options
+}
+
+// GenerateKey creates a key with a random secret
+func GenerateKey(opts KeyOptions) (Key, error) {
+	if opts.Type == "" {
+		opts.Type = TypeTOTP
+	}
+	if opts.Type != TypeTOTP && opts.Type != TypeHOTP {
+		return Key{}, fmt.Errorf("otp: unknown key type %q", opts.Type)
+	}
+	if opts.AccountName == "" {
+		return Key{}, errors.New("otp: an account name is required")
+	}
+	// Apps split the label on the first colon, see Key.URL
+	if strings.Contains(opts.Issuer, ":") {
+		return Key{}, ErrInvalidIssuer
+	}
+	options, err := opts.Options.withDefaults()
+	if err != nil {
+		return Key{}, err
+	}
+	if opts.SecretSize == 0 {
+		opts.SecretSize = options.Algorithm.size()
+	}
+	// RFC 4226 requires at least 128 bits
+	if opts.SecretSize < 16 {
+		return Key{}, errors.New("otp: secrets must be at least 16 bytes")
+	}
+
+	secret := make([]byte, opts.SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, fmt.Errorf("otp: failed to generate secret: %w", err)
+	}
+	return Key{
+		Type:        opts.Type,
+		Issuer:      opts.Issuer,
+		AccountName: opts.AccountName,
+		Secret:      secret,
+		Options:     options,
+	}, nil
+}
+
+// EncodedSecret returns the secret in base32 without padding, the form users type into authenticator apps
+func (k Key) EncodedSecret() string {
+	return EncodeSecret(k.Secret)
+}
+
+// URL returns the otpauth:// URL of the key, the label is "Issuer:account" when the key has an issuer.
+// Apps split the label on the first colon, so an issuer containing one is only passed as the issuer parameter.
+func (k Key) URL() string {
+	label := k.AccountName
+	if k.Issuer != "" && !strings.Contains(k.Issuer, ":") {
+		label = k.Issuer + ":" + k.AccountName
+	}
+	options, err := k.Options.withDefaults()
+	if err != nil {
+		options = k.Options
+	}
+
+	query := url.Values{}
+	query.Set("secret", k.EncodedSecret())
+	if k.Issuer != "" {
+		query.Set("issuer", k.Issuer)
+	}
+	query.Set("algorithm", options.Algorithm.String())
+	query.Set("digits", strconv.Itoa(options.Digits))
+	if k.Type == TypeHOTP {
+		query.Set("counter", strconv.FormatUint(k.Counter, 10))
+	} else {
+		query.Set("period", strconv.Itoa(int(options.Period/time.Second)))
+	}
+
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   k.Type,
+		Path:   "/" + label,
+		// Some apps show a "+" literally, spaces are escaped as %20 like in the label
+		RawQuery: strings.ReplaceAll(query.Encode(), "+", "%20"),
+	}
+	return u.String()
+}
+
+// ParseURL reads an otpauth:// URL, missing parameters take the defaults authenticator apps assume
+func ParseURL(rawURL string) (Key, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Key{}, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if u.Scheme != "otpauth" {
+		return Key{}, fmt.Errorf("%w: scheme must be otpauth", ErrInvalidURL)
+	}
+	key := Key{Type: strings.ToLower(u.Host)}
+	if key.Type != TypeTOTP && key.Type != TypeHOTP {
+		return Key{}, fmt.Errorf("%w: unknown type %q", ErrInvalidURL, u.Host)
+	}
+
+	label := strings.TrimPrefix(u.Path, "/")
+	if issuer, account, ok := strings.Cut(label, ":"); ok {
+		key.Issuer = strings.TrimSpace(issuer)
+		key.AccountName = strings.TrimSpace(account)
+	} else {
+		key.AccountName = strings.TrimSpace(label)
+	}
+	if key.AccountName == "" {
+		return Key{}, fmt.Errorf("%w: missing account name", ErrInvalidURL)
+	}
+
+	query := u.Query()
+	// The parameter wins over the label prefix, which older apps did not write
+	if issuer := query.Get("issuer"); issuer != "" {
+		if key.Issuer != "" && key.Issuer != issuer {
+			return Key{}, fmt.Errorf("%w: issuer %q does not match the label", ErrInvalidURL, issuer)
+		}
+		key.Issuer = issuer
+	}
+
+	key.Secret, err = DecodeSecret(query.Get("secret"))
+	if err != nil {
+		return Key{}, err
+	}
+	if len(key.Secret) == 0 {
+		return Key{}, fmt.Errorf("%w: missing secret", ErrInvalidURL)
+	}
+
+	switch strings.ToUpper(query.Get("algorithm")) {
+	case "", "SHA1":
+		key.Options.Algorithm = AlgorithmSHA1
+	case "SHA256":
+		key.Options.Algorithm = AlgorithmSHA256
+	case "SHA512":
+		key.Options.Algorithm = AlgorithmSHA512
+	default:
+		return Key{}, fmt.Errorf("%w: %q", ErrInvalidAlgorithm, query.Get("algorithm"))
+	}
+	if digits := query.Get("digits"); digits != "" {
+		key.Options.Digits, err = strconv.Atoi(digits)
+		if err != nil || key.Options.Digits == 0 {
+			return Key{}, ErrInvalidDigits
+		}
+	}
+	if period := query.Get("period"); period != "" {
+		seconds, err := strconv.Atoi(period)
+		if err != nil || seconds < 1 {
+			return Key{}, ErrInvalidPeriod
+		}
+		key.Options.Period = time.Duration(seconds) * time.Second
+	}
+	if key.Type == TypeHOTP {
+		counter := query.Get("counter")
+		if counter == "" {
+			return Key{}, fmt.Errorf("%w: hotp keys need a counter", ErrInvalidURL)
+		}
+		key.Counter, err = strconv.ParseUint(counter, 10, 64)
+		if err != nil {
+			return Key{}, fmt.Errorf("%w: invalid counter", ErrInvalidURL)
+		}
+	}
+
+	key.Options, err = key.Options.withDefaults()
+	if err != nil {
+		return Key{}, err
+	}
+	return key, nil
+}
+
+// EncodeSecret returns the secret in base32 without padding
+func EncodeSecret(secret []byte) string {
+	return secretEncoding.EncodeToString(secret)
+}
+
+// DecodeSecret reads a base32 secret as users copy it: in any case, with spaces, dashes or padding
+func DecodeSecret(secret string) ([]byte, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '=':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(secret))
+	decoded, err := secretEncoding.DecodeString(cleaned)
+	if err != nil {
+		return nil, ErrInvalidSecret
+	}
+	return decoded, nil
+}
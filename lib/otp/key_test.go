@@ -0,0 +1,183 @@
+package otp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGenerateKey(t *testing.T) {
+	f := func(opts KeyOptions, expectSize int) {
+		t.Helper()
+
+		key, err := GenerateKey(opts)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if len(key.Secret) != expectSize {
+			t.Fatalf("unexpected secret size; got %d; want %d", len(key.Secret), expectSize)
+		}
+		if key.Type != TypeTOTP || key.Options.Digits != DefaultDigits || key.Options.Period != DefaultPeriod {
+			t.Fatalf("expected defaults, got %+v", key)
+		}
+	}
+
+	f(KeyOptions{AccountName: "alice@example.com"}, 20)
+	f(KeyOptions{AccountName: "alice@example.com", Options: Options{Algorithm: AlgorithmSHA256}}, 32)
+	f(KeyOptions{AccountName: "alice@example.com", Options: Options{Algorithm: AlgorithmSHA512}}, 64)
+	f(KeyOptions{AccountName: "alice@example.com", SecretSize: 16}, 16)
+
+	if _, err := GenerateKey(KeyOptions{}); err == nil {
+		t.Fatalf("expected an account name to be required")
+	}
+	if _, err := GenerateKey(KeyOptions{AccountName: "alice@example.com", SecretSize: 10}); err == nil {
+		t.Fatalf("expected short secrets to be rejected")
+	}
+	if _, err := GenerateKey(KeyOptions{AccountName: "alice@example.com", Options: Options{Digits: 4}}); !errors.Is(err, ErrInvalidDigits) {
+		t.Fatalf("expected ErrInvalidDigits, got: %v", err)
+	}
+	if _, err := GenerateKey(KeyOptions{Issuer: "Acme:Dev", AccountName: "alice@example.com"}); !errors.Is(err, ErrInvalidIssuer) {
+		t.Fatalf("expected ErrInvalidIssuer, got: %v", err)
+	}
+
+	a, _ := GenerateKey(KeyOptions{AccountName: "alice@example.com"})
+	b, _ := GenerateKey(KeyOptions{AccountName: "alice@example.com"})
+	if bytes.Equal(a.Secret, b.Secret) {
+		t.Fatalf("expected random secrets")
+	}
+}
+
+func TestKeyURL(t *testing.T) {
+	f := func(key Key, expect string) {
+		t.Helper()
+
+		if result := key.URL(); result != expect {
+			t.Fatalf("unexpected url;\ngot  %s\nwant %s", result, expect)
+		}
+	}
+
+	secret := []byte("12345678901234567890")
+	f(Key{Type: TypeTOTP, Issuer: "Example", AccountName: "alice@google.com", Secret: secret},
+		"otpauth://totp/Example:alice@google.com?algorithm=SHA1&digits=6&issuer=Example&period=30&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	f(Key{Type: TypeTOTP, Issuer: "ACME Co", AccountName: "john doe", Secret: secret, Options: Options{Algorithm: AlgorithmSHA256, Digits: 8, Period: 60 * time.Second}},
+		"otpauth://totp/ACME%20Co:john%20doe?algorithm=SHA256&digits=8&issuer=ACME%20Co&period=60&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	f(Key{Type: TypeHOTP, AccountName: "alice", Secret: secret, Counter: 42},
+		"otpauth://hotp/alice?algorithm=SHA1&counter=42&digits=6&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	// an issuer with a colon stays out of the label
+	f(Key{Type: TypeTOTP, Issuer: "Acme:Dev", AccountName: "alice", Secret: secret},
+		"otpauth://totp/alice?algorithm=SHA1&digits=6&issuer=Acme%3ADev&period=30&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+}
+
+func TestParseURL(t *testing.T) {
+	f := func(rawURL string, expect Key) {
+		t.Helper()
+
+		key, err := ParseURL(rawURL)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if key.Type != expect.Type || key.Issuer != expect.Issuer || key.AccountName != expect.AccountName ||
+			!bytes.Equal(key.Secret, expect.Secret) || key.Options != expect.Options || key.Counter != expect.Counter {
+			t.Fatalf("unexpected key for %s;\ngot  %+v\nwant %+v", rawURL, key, expect)
+		}
+	}
+
+	defaults := Options{Digits: DefaultDigits, Period: DefaultPeriod}
+	secret := []byte("12345678901234567890")
+
+	// the example of the Google Authenticator key URI format
+	f("otpauth://totp/Example:alice@google.com?secret=JBSWY3DPEHPK3PXP&issuer=Example",
+		Key{Type: TypeTOTP, Issuer: "Example", AccountName: "alice@google.com", Secret: []byte("Hello!\xde\xad\xbe\xef"), Options: defaults})
+	// no issuer at all, lowercase and padded secret
+	f("otpauth://totp/alice?secret=gezdgnbvgy3tqojqgezdgnbvgy3tqojq====",
+		Key{Type: TypeTOTP, AccountName: "alice", Secret: secret, Options: defaults})
+	// issuer only as a parameter, encoded spaces in the label
+	f("otpauth://totp/john%20doe?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&issuer=ACME+Co&algorithm=sha512&digits=8&period=60",
+		Key{Type: TypeTOTP, Issuer: "ACME Co", AccountName: "john doe", Secret: secret, Options: Options{Algorithm: AlgorithmSHA512, Digits: 8, Period: 60 * time.Second}})
+	f("otpauth://hotp/Example:alice?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&counter=7",
+		Key{Type: TypeHOTP, Issuer: "Example", AccountName: "alice", Secret: secret, Options: defaults, Counter: 7})
+}
+
+func TestParseURLRoundTrip(t *testing.T) {
+	f := func(opts KeyOptions) {
+		t.Helper()
+
+		key, err := GenerateKey(opts)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		parsed, err := ParseURL(key.URL())
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if parsed.Type != key.Type || parsed.Issuer != key.Issuer || parsed.AccountName != key.AccountName ||
+			!bytes.Equal(parsed.Secret, key.Secret) || parsed.Options != key.Options {
+			t.Fatalf("unexpected key after a round trip;\ngot  %+v\nwant %+v", parsed, key)
+		}
+	}
+
+	f(KeyOptions{Issuer: "Todo", AccountName: "alice@example.com"})
+	f(KeyOptions{Issuer: "A&B Co?", AccountName: "bob+tag@example.com", Options: Options{Algorithm: AlgorithmSHA256, Digits: 8, Period: 45 * time.Second}})
+	f(KeyOptions{Type: TypeHOTP, AccountName: "carol", Options: Options{Algorithm: AlgorithmSHA512, Digits: 7}})
+
+	// keys from elsewhere may still carry a colon in the issuer
+	key := Key{Type: TypeTOTP, Issuer: "Acme:Dev", AccountName: "alice@example.com", Secret: []byte("12345678901234567890")}
+	parsed, err := ParseURL(key.URL())
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if parsed.Issuer != key.Issuer || parsed.AccountName != key.AccountName {
+		t.Fatalf("unexpected label after a round trip; got %q:%q; want %q:%q", parsed.Issuer, parsed.AccountName, key.Issuer, key.AccountName)
+	}
+}
+
+func TestParseURLErrors(t *testing.T) {
+	f := func(rawURL string, expect error) {
+		t.Helper()
+
+		if _, err := ParseURL(rawURL); !errors.Is(err, expect) {
+			t.Fatalf("unexpected error for %s; got %v; want %v", rawURL, err, expect)
+		}
+	}
+
+	f("https://example.com/?secret=JBSWY3DPEHPK3PXP", ErrInvalidURL)
+	f("otpauth://motp/alice?secret=JBSWY3DPEHPK3PXP", ErrInvalidURL)
+	f("otpauth://totp/?secret=JBSWY3DPEHPK3PXP", ErrInvalidURL)
+	f("otpauth://totp/alice", ErrInvalidURL)
+	f("otpauth://totp/alice?secret=not-base32!", ErrInvalidSecret)
+	f("otpauth://totp/Example:alice?secret=JBSWY3DPEHPK3PXP&issuer=Other", ErrInvalidURL)
+	f("otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&algorithm=MD5", ErrInvalidAlgorithm)
+	f("otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&digits=10", ErrInvalidDigits)
+	f("otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&digits=six", ErrInvalidDigits)
+	f("otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&digits=0", ErrInvalidDigits)
+	f("otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&period=0", ErrInvalidPeriod)
+	f("otpauth://hotp/alice?secret=JBSWY3DPEHPK3PXP", ErrInvalidURL)
+}
+
+func TestSecretEncoding(t *testing.T) {
+	f := func(encoded string, expect []byte) {
+		t.Helper()
+
+		decoded, err := DecodeSecret(encoded)
+		if err != nil {
+			t.Fatalf("expected no error for %q, got: %v", encoded, err)
+		}
+		if !bytes.Equal(decoded, expect) {
+			t.Fatalf("unexpected secret for %q; got %x; want %x", encoded, decoded, expect)
+		}
+	}
+
+	secret := []byte("12345678901234567890")
+	f(EncodeSecret(secret), secret)
+	f("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", secret)
+	f("GEZD-GNBV-GY3T-QOJQ-GEZD-GNBV-GY3T-QOJQ", secret)
+	// secrets whose length is not a multiple of 5 bytes, with and without padding
+	f("JBSWY3DP", []byte("Hello"))
+	f("JBSWY3DPEE======", []byte("Hello!"))
+	f("JBSWY3DPEE", []byte("Hello!"))
+
+	if _, err := DecodeSecret("JBSWY3D1"); !errors.Is(err, ErrInvalidSecret) {
+		t.Fatalf("expected ErrInvalidSecret, got: %v", err)
+	}
+}
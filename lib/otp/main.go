@@ -3,74 +3,265 @@ package otp
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/subtle"
 	"encoding/binary"
-	"math"
-	"strconv"
+	"errors"
+	"fmt"
+	"hash"
 	"time"
 )
 
-func GenerateTOTP(now time.Time, key []byte, interval time.Duration, digits int) string {
-	counter := uint64(now.Unix()) / uint64(interval.Seconds())
-	return GenerateHOTP(key, counter, digits)
+// Algorithm is the HMAC hash function of the codes, RFC 6238 allows SHA-1, SHA-256 and SHA-512
+type Algorithm int
+
+const (
+	AlgorithmSHA1 Algorithm = iota
+	AlgorithmSHA256
+	AlgorithmSHA512
+)
+
+const (
+	DefaultDigits = 6
+	DefaultPeriod = 30 * time.Second
+	// MaxSkew bounds Options.Skew, every extra step is another code accepted for a guess
+	MaxSkew = 10
+)
+
+var (
+	ErrInvalidAlgorithm = errors.New("otp: invalid algorithm")
+	ErrInvalidDigits    = errors.New("otp: digits must be between 6 and 8")
+	ErrInvalidPeriod    = errors.New("otp: period must be a positive whole number of seconds")
+	ErrInvalidSkew      = fmt.Errorf("otp: skew must be at most %d steps", MaxSkew)
+	ErrBeforeT0         = errors.New("otp: time is before T0")
+)
+
+// String returns the name of the algorithm as written in otpauth URLs
+func (a Algorithm) String() string {
+	switch a {
+	case AlgorithmSHA1:
+		return "SHA1"
+	case AlgorithmSHA256:
+		return "SHA256"
+	case AlgorithmSHA512:
+		return "SHA512"
+	default:
+		return fmt.Sprintf("Algorithm(%d)", int(a))
+	}
 }
 
-func VerifyTOTP(now time.Time, key []byte, interval time.Duration, digits int, otp string) bool {
-	if len(otp) != digits {
-		return false
+// size returns the output size of the hash in bytes
+func (a Algorithm) size() int {
+	switch a {
+	case AlgorithmSHA256:
+		return sha256.Size
+	case AlgorithmSHA512:
+		return sha512.Size
+	default:
+		return sha1.Size
 	}
-	generated := GenerateTOTP(now, key, interval, digits)
-	valid := subtle.ConstantTimeCompare([]byte(generated), []byte(otp)) == 1
-	return valid
 }
 
-func VerifyTOTPWithGracePeriod(now time.Time, key []byte, interval time.Duration, digits int, otp string, gracePeriod time.Duration) bool {
-	counter1 := uint64(now.Add(-1*gracePeriod).Unix()) / uint64(interval.Seconds())
-	generated := GenerateHOTP(key, counter1, digits)
-	valid := subtle.ConstantTimeCompare([]byte(generated), []byte(otp)) == 1
-	if valid {
-		return true
-	}
-	counter2 := uint64(now.Unix()) / uint64(interval.Seconds())
-	if counter2 != counter1 {
-		generated = GenerateHOTP(key, counter2, digits)
-		valid = subtle.ConstantTimeCompare([]byte(generated), []byte(otp)) == 1
-		if valid {
-			return true
+func (a Algorithm) hash() (func() hash.Hash, error) {
+	switch a {
+	case AlgorithmSHA1:
+		return sha1.New, nil
+	case AlgorithmSHA256:
+		return sha256.New, nil
+	case AlgorithmSHA512:
+		return sha512.New, nil
+	default:
+		return nil, ErrInvalidAlgorithm
+	}
+}
+
+// Options configure the codes, the zero value is the common authenticator setup:
+// HMAC-SHA1, 6 digits, 30 second steps counted from the Unix epoch and no skew
+type Options struct {
+	Algorithm Algorithm
+	// Digits defaults to DefaultDigits
+	Digits int
+	// Period is the length of a time step, it defaults to DefaultPeriod
+	Period time.Duration
+	// T0 is the Unix time steps are counted from
+	T0 int64
+	// Skew is the number of steps accepted before and after the current one, or after the counter for HOTP.
+	// It cannot exceed MaxSkew.
+	Skew uint
+}
+
+// Match is the step a valid code was generated for
+type Match struct {
+	// Step is the time step, or the counter for HOTP
+	Step uint64
+	// Drift is the distance between Step and the expected step, negative when the code is late
+	Drift int
+}
+
+func (o Options) withDefaults() (Options, error) {
+	if o.Digits == 0 {
+		o.Digits = DefaultDigits
+	}
+	if o.Period == 0 {
+		o.Period = DefaultPeriod
+	}
+	if o.Digits < 6 || o.Digits > 8 {
+		return o, ErrInvalidDigits
+	}
+	if o.Period < time.Second || o.Period%time.Second != 0 {
+		return o, ErrInvalidPeriod
+	}
+	if o.Skew > MaxSkew {
+		return o, ErrInvalidSkew
+	}
+	if _, err := o.Algorithm.hash(); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// TimeStep returns the step of now, the number of periods elapsed since T0
+func TimeStep(now time.Time, opts Options) (uint64, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return 0, err
+	}
+	return timeStep(now, opts)
+}
+
+func timeStep(now time.Time, opts Options) (uint64, error) {
+	elapsed := now.Unix() - opts.T0
+	if elapsed < 0 {
+		return 0, ErrBeforeT0
+	}
+	return uint64(elapsed) / uint64(opts.Period/time.Second), nil
+}
+
+// GenerateHOTP returns the RFC 4226 code of the counter
+func GenerateHOTP(key []byte, counter uint64, opts Options) (string, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return "", err
+	}
+	return generate(key, counter, opts), nil
+}
+
+// GenerateTOTP returns the RFC 6238 code of the time step of now
+func GenerateTOTP(key []byte, now time.Time, opts Options) (string, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return "", err
+	}
+	step, err := timeStep(now, opts)
+	if err != nil {
+		return "", err
+	}
+	return generate(key, step, opts), nil
+}
+
+// ValidateHOTP checks the code against the counter and the Skew counters after it,
+// the match tells which counter was used so the caller can move past it
+func ValidateHOTP(key []byte, counter uint64, code string, opts Options) (Match, bool, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return Match{}, false, err
+	}
+	if len(code) != opts.Digits {
+		return Match{}, false, nil
+	}
+	for drift := 0; drift <= int(opts.Skew); drift++ {
+		step := counter + uint64(drift)
+		if step < counter {
+			break
 		}
+		if equal(generate(key, step, opts), code) {
+			return Match{Step: step, Drift: drift}, true, nil
+		}
+	}
+	return Match{}, false, nil
+}
+
+// ValidateTOTP checks the code against the time step of now and the Skew steps around it, nearest first.
+// The match tells which step was used, its drift shows how far the clock of the authenticator is off.
+func ValidateTOTP(key []byte, now time.Time, code string, opts Options) (Match, bool, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return Match{}, false, err
+	}
+	current, err := timeStep(now, opts)
+	if err != nil {
+		return Match{}, false, err
+	}
+	if len(code) != opts.Digits {
+		return Match{}, false, nil
 	}
-	counter3 := uint64(now.Add(gracePeriod).Unix()) / uint64(interval.Seconds())
-	if counter3 != counter1 && counter3 != counter2 {
-		generated = GenerateHOTP(key, counter3, digits)
-		valid = subtle.ConstantTimeCompare([]byte(generated), []byte(otp)) == 1
-		if valid {
+	for _, drift := range window(opts.Skew) {
+		if drift < 0 && uint64(-drift) > current {
+			continue
+		}
+		step := current + uint64(drift)
+		if equal(generate(key, step, opts), code) {
+			return Match{Step: step, Drift: drift}, true, nil
+		}
+	}
+	return Match{}, false, nil
+}
+
+// VerifyHOTP reports whether the code is the one of the counter.
+//
+// Deprecated: use ValidateHOTP, which also supports look-ahead and reports invalid options.
+func VerifyHOTP(key []byte, counter uint64, digits int, otp string) bool {
+	_, valid, err := ValidateHOTP(key, counter, otp, Options{Digits: digits})
+	return err == nil && valid
+}
+
+// VerifyTOTP reports whether the code is the one of the time step of now.
+//
+// Deprecated: use ValidateTOTP, which also reports the matching step and invalid options.
+func VerifyTOTP(now time.Time, key []byte, interval time.Duration, digits int, otp string) bool {
+	_, valid, err := ValidateTOTP(key, now, otp, Options{Period: interval, Digits: digits})
+	return err == nil && valid
+}
+
+// VerifyTOTPWithGracePeriod also accepts the codes of the time steps gracePeriod before and after now.
+//
+// Deprecated: use ValidateTOTP with Options.Skew, which counts steps instead of a duration.
+func VerifyTOTPWithGracePeriod(now time.Time, key []byte, interval time.Duration, digits int, otp string, gracePeriod time.Duration) bool {
+	for _, at := range []time.Time{now.Add(-gracePeriod), now, now.Add(gracePeriod)} {
+		if VerifyTOTP(at, key, interval, digits, otp) {
 			return true
 		}
 	}
 	return false
 }
 
-func GenerateHOTP(key []byte, counter uint64, digits int) string {
-	if digits < 6 || digits > 8 {
-		panic("invalid totp digits")
+// window lists the drifts within skew, nearest first: 0, -1, 1, -2, 2...
+func window(skew uint) []int {
+	drifts := []int{0}
+	for i := 1; i <= int(skew); i++ {
+		drifts = append(drifts, -i, i)
 	}
+	return drifts
+}
+
+func generate(key []byte, counter uint64, opts Options) string {
+	newHash, _ := opts.Algorithm.hash()
 	counterBytes := make([]byte, 8)
 	binary.BigEndian.PutUint64(counterBytes, counter)
-	mac := hmac.New(sha1.New, key)
+	mac := hmac.New(newHash, key)
 	mac.Write(counterBytes)
 	hs := mac.Sum(nil)
+	// Dynamic truncation of RFC 4226 section 5.3
 	offset := hs[len(hs)-1] & 0x0f
-	truncated := hs[offset : offset+4]
-	truncated[0] &= 0x7f
-	snum := binary.BigEndian.Uint32(truncated)
-	d := snum % (uint32(math.Pow10(digits)))
-	otp := strconv.Itoa(int(d))
-	for len(otp) < digits {
-		otp = "0" + otp
+	snum := binary.BigEndian.Uint32(hs[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for range opts.Digits {
+		modulo *= 10
 	}
-	return otp
+	return fmt.Sprintf("%0*d", opts.Digits, snum%modulo)
 }
 
-func VerifyHOTP(key []byte, counter uint64, digits int, otp string) bool {
-	return GenerateHOTP(key, counter, digits) == otp
+func equal(generated, code string) bool {
+	return subtle.ConstantTimeCompare([]byte(generated), []byte(code)) == 1
 }
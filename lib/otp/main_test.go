@@ -1,10 +1,18 @@
 package otp
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 )
 
+// rfcKey returns the test secrets of RFC 6238 appendix B, the ASCII digits repeated to the size of the hash
+func rfcKey(size int) []byte {
+	return []byte(strings.Repeat("1234567890", 7)[:size])
+}
+
 func TestGenerateHOTP(t *testing.T) {
 	key := make([]byte, 20)
 	for i := 0; i < len(key); i++ {
@@ -36,7 +44,10 @@ func TestGenerateHOTP(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(fmt.Sprintf("Counter: %d", test.counter), func(t *testing.T) {
-			result := GenerateHOTP(key, test.counter, 6)
+			result, err := GenerateHOTP(key, test.counter, Options{})
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
 			if result != test.expected {
 				t.Errorf("got %s, expected %s", result, test.expected)
 			}
@@ -83,19 +94,220 @@ func TestVerifyHOTP(t *testing.T) {
 
 	for _, test := range validTests {
 		t.Run(fmt.Sprintf("Counter: %d", test.counter), func(t *testing.T) {
-			result := VerifyHOTP(key, test.counter, 6, test.otp)
-			if !result {
-				t.Error("got false, expected true")
+			_, result, err := ValidateHOTP(key, test.counter, test.otp, Options{})
+			if err != nil || !result {
+				t.Errorf("got %v, %v, expected true", result, err)
+			}
+			if !VerifyHOTP(key, test.counter, 6, test.otp) {
+				t.Error("got false from VerifyHOTP, expected true")
 			}
 		})
 	}
 	for _, test := range invlaidTests {
 		t.Run(fmt.Sprintf("Counter: %d", test.counter), func(t *testing.T) {
-			result := VerifyHOTP(key, test.counter, 6, test.otp)
-			if result {
-				t.Error("got true, expected false")
+			_, result, err := ValidateHOTP(key, test.counter, test.otp, Options{})
+			if err != nil || result {
+				t.Errorf("got %v, %v, expected false", result, err)
+			}
+			if VerifyHOTP(key, test.counter, 6, test.otp) {
+				t.Error("got true from VerifyHOTP, expected false")
 			}
 		})
 	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	key := rfcKey(20)
+	// RFC 6238 appendix B, step 1 with 8 digits
+	now := time.Unix(59, 0)
+
+	if !VerifyTOTP(now, key, 30*time.Second, 8, "94287082") {
+		t.Fatalf("expected the code of the current step to be valid")
+	}
+	if VerifyTOTP(now.Add(30*time.Second), key, 30*time.Second, 8, "94287082") {
+		t.Fatalf("expected the code of the previous step to be refused")
+	}
+	if VerifyTOTP(now, key, 30*time.Second, 5, "94287") {
+		t.Fatalf("expected invalid digits to be refused")
+	}
+
+	// the grace period accepts the neighbouring steps only
+	if !VerifyTOTPWithGracePeriod(now.Add(30*time.Second), key, 30*time.Second, 8, "94287082", 30*time.Second) {
+		t.Fatalf("expected the code of the previous step to be valid within the grace period")
+	}
+	if VerifyTOTPWithGracePeriod(now.Add(60*time.Second), key, 30*time.Second, 8, "94287082", 30*time.Second) {
+		t.Fatalf("expected the code two steps back to be refused")
+	}
+}
+
+func TestGenerateHOTPRFC4226(t *testing.T) {
+	// Appendix D of RFC 4226
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range expected {
+		result, err := GenerateHOTP(rfcKey(20), uint64(counter), Options{})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if result != code {
+			t.Fatalf("unexpected code for counter %d; got %s; want %s", counter, result, code)
+		}
+	}
+}
+
+func TestGenerateTOTPRFC6238(t *testing.T) {
+	f := func(unix int64, algorithm Algorithm, keySize int, expect string) {
+		t.Helper()
+
+		result, err := GenerateTOTP(rfcKey(keySize), time.Unix(unix, 0), Options{Algorithm: algorithm, Digits: 8})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if result != expect {
+			t.Fatalf("unexpected %s code at %d; got %s; want %s", algorithm, unix, result, expect)
+		}
+	}
+
+	// Appendix B of RFC 6238
+	f(59, AlgorithmSHA1, 20, "94287082")
+	f(59, AlgorithmSHA256, 32, "46119246")
+	f(59, AlgorithmSHA512, 64, "90693936")
+	f(1111111109, AlgorithmSHA1, 20, "07081804")
+	f(1111111109, AlgorithmSHA256, 32, "68084774")
+	f(1111111109, AlgorithmSHA512, 64, "25091201")
+	f(1111111111, AlgorithmSHA1, 20, "14050471")
+	f(1111111111, AlgorithmSHA256, 32, "67062674")
+	f(1111111111, AlgorithmSHA512, 64, "99943326")
+	f(1234567890, AlgorithmSHA1, 20, "89005924")
+	f(1234567890, AlgorithmSHA256, 32, "91819424")
+	f(1234567890, AlgorithmSHA512, 64, "93441116")
+	f(2000000000, AlgorithmSHA1, 20, "69279037")
+	f(2000000000, AlgorithmSHA256, 32, "90698825")
+	f(2000000000, AlgorithmSHA512, 64, "38618901")
+	f(20000000000, AlgorithmSHA1, 20, "65353130")
+	f(20000000000, AlgorithmSHA256, 32, "77737706")
+	f(20000000000, AlgorithmSHA512, 64, "47863826")
+}
+
+func TestTimeStep(t *testing.T) {
+	f := func(unix int64, opts Options, expect uint64) {
+		t.Helper()
+
+		step, err := TimeStep(time.Unix(unix, 0), opts)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if step != expect {
+			t.Fatalf("unexpected step at %d; got %d; want %d", unix, step, expect)
+		}
+	}
+
+	f(59, Options{}, 1)
+	f(1111111109, Options{}, 0x23523EC)
+	f(90, Options{Period: 60 * time.Second}, 1)
+	// steps are counted from T0
+	f(100, Options{T0: 40}, 2)
+	f(100, Options{T0: 100}, 0)
+
+	if _, err := TimeStep(time.Unix(10, 0), Options{T0: 100}); !errors.Is(err, ErrBeforeT0) {
+		t.Fatalf("expected ErrBeforeT0, got: %v", err)
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	f := func(opts Options, expect error) {
+		t.Helper()
+
+		if _, err := GenerateHOTP(rfcKey(20), 0, opts); !errors.Is(err, expect) {
+			t.Fatalf("unexpected error for %+v; got %v; want %v", opts, err, expect)
+		}
+		if _, err := GenerateTOTP(rfcKey(20), time.Unix(59, 0), opts); !errors.Is(err, expect) {
+			t.Fatalf("unexpected error for %+v; got %v; want %v", opts, err, expect)
+		}
+		if _, _, err := ValidateTOTP(rfcKey(20), time.Unix(59, 0), "123456", opts); !errors.Is(err, expect) {
+			t.Fatalf("unexpected error for %+v; got %v; want %v", opts, err, expect)
+		}
+	}
+
+	f(Options{Digits: 5}, ErrInvalidDigits)
+	f(Options{Digits: 9}, ErrInvalidDigits)
+	f(Options{Period: -time.Second}, ErrInvalidPeriod)
+	f(Options{Period: 1500 * time.Millisecond}, ErrInvalidPeriod)
+	f(Options{Algorithm: Algorithm(7)}, ErrInvalidAlgorithm)
+	f(Options{Skew: MaxSkew + 1}, ErrInvalidSkew)
+	f(Options{Skew: ^uint(0)}, ErrInvalidSkew)
+}
+
+func TestValidateTOTP(t *testing.T) {
+	key := rfcKey(20)
+	now := time.Unix(1111111111, 0)
+	current, err := TimeStep(now, Options{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	f := func(offset time.Duration, skew uint, expectValid bool, expectDrift int) {
+		t.Helper()
+
+		code, err := GenerateTOTP(key, now.Add(offset), Options{})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		match, valid, err := ValidateTOTP(key, now, code, Options{Skew: skew})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if valid != expectValid {
+			t.Fatalf("unexpected validity of a code %s away with skew %d; got %v; want %v", offset, skew, valid, expectValid)
+		}
+		if valid && (match.Drift != expectDrift || match.Step != uint64(int64(current)+int64(expectDrift))) {
+			t.Fatalf("unexpected match %+v; want drift %d from step %d", match, expectDrift, current)
+		}
+	}
+
+	f(0, 0, true, 0)
+	f(-30*time.Second, 0, false, 0)
+	f(-30*time.Second, 1, true, -1)
+	f(30*time.Second, 1, true, 1)
+	f(60*time.Second, 1, false, 0)
+	f(-60*time.Second, 2, true, -2)
+	f(90*time.Second, 3, true, 3)
+
+	// codes of another length or value
+	for _, code := range []string{"", "14050471", "000000"} {
+		if _, valid, err := ValidateTOTP(key, now, code, Options{Skew: 1}); err != nil || valid {
+			t.Fatalf("expected %q to be rejected, got %v, %v", code, valid, err)
+		}
+	}
+
+	// the window does not reach before T0
+	code, err := GenerateTOTP(key, time.Unix(0, 0), Options{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if match, valid, err := ValidateTOTP(key, time.Unix(10, 0), code, Options{Skew: 2}); err != nil || !valid || match.Step != 0 {
+		t.Fatalf("unexpected match %+v, %v, %v", match, valid, err)
+	}
+}
+
+func TestValidateHOTP(t *testing.T) {
+	key := rfcKey(20)
+
+	f := func(counter uint64, code string, skew uint, expectValid bool, expectStep uint64) {
+		t.Helper()
+
+		match, valid, err := ValidateHOTP(key, counter, code, Options{Skew: skew})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if valid != expectValid || (valid && match.Step != expectStep) {
+			t.Fatalf("unexpected match %+v, %v; want %v at %d", match, valid, expectValid, expectStep)
+		}
+	}
 
+	f(0, "755224", 0, true, 0)
+	f(0, "103906", 0, false, 0)
+	// look-ahead only goes forward
+	f(0, "969429", 2, false, 0)
+	f(0, "969429", 3, true, 3)
+	f(3, "287082", 3, false, 0)
 }
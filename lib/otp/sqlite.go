@@ -0,0 +1,55 @@
+package otp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// SQLiteCounterSchema creates the table of SQLiteCounterStore, include it in the migrations of the app
+const SQLiteCounterSchema = `CREATE TABLE IF NOT EXISTS otp_counter (
+    key_id TEXT NOT NULL PRIMARY KEY,
+    next INTEGER NOT NULL
+);`
+
+// SQLiteCounterStore is a CounterStore in the otp_counter table, shared by every process using the database
+type SQLiteCounterStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteCounterStore returns a store on db, which must have the table of SQLiteCounterSchema
+func NewSQLiteCounterStore(db *sql.DB) *SQLiteCounterStore {
+	return &SQLiteCounterStore{db: db}
+}
+
+func (s *SQLiteCounterStore) Next(ctx context.Context, keyID string) (uint64, error) {
+	var next int64
+	err := s.db.QueryRowContext(ctx, `SELECT next FROM otp_counter WHERE key_id = ?`, keyID).Scan(&next)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return uint64(next), nil
+}
+
+// Advance only writes when next is ahead of the stored step, in a single statement so concurrent uses of a code
+// cannot both succeed
+func (s *SQLiteCounterStore) Advance(ctx context.Context, keyID string, next uint64) (bool, error) {
+	if next > math.MaxInt64 {
+		return false, fmt.Errorf("step %d does not fit in an INTEGER column", next)
+	}
+	result, err := s.db.ExecContext(ctx, `INSERT INTO otp_counter (key_id, next) VALUES (?, ?)
+ON CONFLICT(key_id) DO UPDATE SET next = EXCLUDED.next WHERE EXCLUDED.next > otp_counter.next`, keyID, int64(next))
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
@@ -0,0 +1,173 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultResyncWindow is how many counters after the expected one Resync searches by default
+const DefaultResyncWindow = 100
+
+var (
+	ErrInvalidCode  = errors.New("otp: invalid code")
+	ErrReplayedCode = errors.New("otp: code already used")
+)
+
+// CounterStore keeps the lowest unused time step or counter of each key, so a code is accepted at most once
+type CounterStore interface {
+	// Next returns the lowest unused step of the key, 0 for a key never used
+	Next(ctx context.Context, keyID string) (uint64, error)
+	// Advance moves the lowest unused step of the key to next. It reports false when the key is already
+	// at or past next, which happens when a concurrent request used the code first.
+	Advance(ctx context.Context, keyID string, next uint64) (bool, error)
+}
+
+// Verifier checks codes and records the steps they were used for, rejecting any code of a used step.
+// Options.Skew is the window around the current time step for TOTP and the look-ahead window for HOTP.
+type Verifier struct {
+	Store   CounterStore
+	Options Options
+	// ResyncWindow defaults to DefaultResyncWindow
+	ResyncWindow uint
+}
+
+// VerifyTOTP checks the code of now and marks its time step and the ones before as used.
+// Once a code is accepted, codes of earlier steps are rejected even within the skew window.
+func (v *Verifier) VerifyTOTP(ctx context.Context, keyID string, key []byte, now time.Time, code string) (Match, error) {
+	opts, err := v.Options.withDefaults()
+	if err != nil {
+		return Match{}, err
+	}
+	current, err := timeStep(now, opts)
+	if err != nil {
+		return Match{}, err
+	}
+	if len(code) != opts.Digits {
+		return Match{}, ErrInvalidCode
+	}
+	next, err := v.Store.Next(ctx, keyID)
+	if err != nil {
+		return Match{}, fmt.Errorf("otp: failed to get the next step: %w", err)
+	}
+
+	replayed := false
+	for _, drift := range window(opts.Skew) {
+		if drift < 0 && uint64(-drift) > current {
+			continue
+		}
+		step := current + uint64(drift)
+		if !equal(generate(key, step, opts), code) {
+			continue
+		}
+		if step < next {
+			replayed = true
+			continue
+		}
+		return v.advance(ctx, keyID, Match{Step: step, Drift: drift})
+	}
+	if replayed {
+		return Match{}, ErrReplayedCode
+	}
+	return Match{}, ErrInvalidCode
+}
+
+// VerifyHOTP checks the code against the next unused counter and the Skew counters after it,
+// then moves past the counter it matched. A key never used starts at counter 0,
+// advance the store to the initial counter of the key first otherwise.
+func (v *Verifier) VerifyHOTP(ctx context.Context, keyID string, key []byte, code string) (Match, error) {
+	opts, err := v.Options.withDefaults()
+	if err != nil {
+		return Match{}, err
+	}
+	if len(code) != opts.Digits {
+		return Match{}, ErrInvalidCode
+	}
+	next, err := v.Store.Next(ctx, keyID)
+	if err != nil {
+		return Match{}, fmt.Errorf("otp: failed to get the next counter: %w", err)
+	}
+
+	match, valid, err := ValidateHOTP(key, next, code, opts)
+	if err != nil {
+		return Match{}, err
+	}
+	if valid {
+		return v.advance(ctx, keyID, match)
+	}
+	// Only the last used counter is checked, older codes are just invalid
+	if next > 0 && equal(generate(key, next-1, opts), code) {
+		return Match{}, ErrReplayedCode
+	}
+	return Match{}, ErrInvalidCode
+}
+
+// Resync finds two consecutive codes within the resync window, for hardware tokens whose counter
+// drifted past the look-ahead window after many presses, then moves past the second one
+func (v *Verifier) Resync(ctx context.Context, keyID string, key []byte, code1, code2 string) (Match, error) {
+	opts, err := v.Options.withDefaults()
+	if err != nil {
+		return Match{}, err
+	}
+	if len(code1) != opts.Digits || len(code2) != opts.Digits {
+		return Match{}, ErrInvalidCode
+	}
+	next, err := v.Store.Next(ctx, keyID)
+	if err != nil {
+		return Match{}, fmt.Errorf("otp: failed to get the next counter: %w", err)
+	}
+
+	resyncWindow := v.ResyncWindow
+	if resyncWindow == 0 {
+		resyncWindow = DefaultResyncWindow
+	}
+	for drift := 0; drift <= int(resyncWindow); drift++ {
+		counter := next + uint64(drift)
+		if counter+1 < next {
+			break
+		}
+		if equal(generate(key, counter, opts), code1) && equal(generate(key, counter+1, opts), code2) {
+			return v.advance(ctx, keyID, Match{Step: counter + 1, Drift: drift + 1})
+		}
+	}
+	return Match{}, ErrInvalidCode
+}
+
+func (v *Verifier) advance(ctx context.Context, keyID string, match Match) (Match, error) {
+	advanced, err := v.Store.Advance(ctx, keyID, match.Step+1)
+	if err != nil {
+		return Match{}, fmt.Errorf("otp: failed to advance the next step: %w", err)
+	}
+	if !advanced {
+		return Match{}, ErrReplayedCode
+	}
+	return match, nil
+}
+
+// MemoryCounterStore is a CounterStore for a single process
+type MemoryCounterStore struct {
+	mu    sync.Mutex
+	steps map[string]uint64
+}
+
+func NewMemoryCounterStore() *MemoryCounterStore {
+	return &MemoryCounterStore{steps: make(map[string]uint64)}
+}
+
+func (s *MemoryCounterStore) Next(ctx context.Context, keyID string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.steps[keyID], nil
+}
+
+func (s *MemoryCounterStore) Advance(ctx context.Context, keyID string, next uint64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.steps[keyID] >= next {
+		return false, nil
+	}
+	s.steps[keyID] = next
+	return true, nil
+}
@@ -0,0 +1,219 @@
+package otp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func givenSQLiteCounterStore(t *testing.T) *SQLiteCounterStore {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	// Every connection to :memory: opens its own empty database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(SQLiteCounterSchema); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	return NewSQLiteCounterStore(db)
+}
+
+// forEachStore runs the test against every CounterStore implementation
+func forEachStore(t *testing.T, test func(t *testing.T, store CounterStore)) {
+	t.Run("memory", func(t *testing.T) { test(t, NewMemoryCounterStore()) })
+	t.Run("sqlite", func(t *testing.T) { test(t, givenSQLiteCounterStore(t)) })
+}
+
+func TestCounterStore(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store CounterStore) {
+		ctx := context.Background()
+
+		if next, err := store.Next(ctx, "alice"); err != nil || next != 0 {
+			t.Fatalf("expected a new key to start at 0, got %d, %v", next, err)
+		}
+		if ok, err := store.Advance(ctx, "alice", 5); err != nil || !ok {
+			t.Fatalf("expected to advance, got %v, %v", ok, err)
+		}
+		// never backwards nor twice to the same step
+		for _, next := range []uint64{5, 3} {
+			if ok, err := store.Advance(ctx, "alice", next); err != nil || ok {
+				t.Fatalf("expected not to advance to %d, got %v, %v", next, ok, err)
+			}
+		}
+		if next, err := store.Next(ctx, "alice"); err != nil || next != 5 {
+			t.Fatalf("unexpected next step %d, %v", next, err)
+		}
+		// keys are independent
+		if next, err := store.Next(ctx, "bob"); err != nil || next != 0 {
+			t.Fatalf("unexpected next step of another key %d, %v", next, err)
+		}
+	})
+}
+
+func TestVerifierTOTP(t *testing.T) {
+	key := rfcKey(20)
+	now := time.Unix(1111111111, 0)
+	code := func(offset time.Duration) string {
+		t.Helper()
+
+		code, err := GenerateTOTP(key, now.Add(offset), Options{})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		return code
+	}
+
+	forEachStore(t, func(t *testing.T, store CounterStore) {
+		ctx := context.Background()
+		v := &Verifier{Store: store, Options: Options{Skew: 1}}
+
+		f := func(code string, expect error, expectDrift int) {
+			t.Helper()
+
+			match, err := v.VerifyTOTP(ctx, "alice", key, now, code)
+			if !errors.Is(err, expect) {
+				t.Fatalf("unexpected error for %s; got %v; want %v", code, err, expect)
+			}
+			if err == nil && match.Drift != expectDrift {
+				t.Fatalf("unexpected drift %d; want %d", match.Drift, expectDrift)
+			}
+		}
+
+		f("000000", ErrInvalidCode, 0)
+		f(code(-30*time.Second), nil, -1)
+		f(code(-30*time.Second), ErrReplayedCode, 0)
+		f(code(0), nil, 0)
+		f(code(0), ErrReplayedCode, 0)
+		f(code(30*time.Second), nil, 1)
+		// a used later step invalidates the earlier ones
+		f(code(0), ErrReplayedCode, 0)
+		f(code(60*time.Second), ErrInvalidCode, 0)
+
+		// another key is not affected
+		if _, err := v.VerifyTOTP(ctx, "bob", key, now, code(0)); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	})
+}
+
+func TestVerifierTOTPConcurrent(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store CounterStore) {
+		ctx := context.Background()
+		v := &Verifier{Store: store}
+		now := time.Unix(1111111111, 0)
+		code, err := GenerateTOTP(rfcKey(20), now, Options{})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		accepted := 0
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := v.VerifyTOTP(ctx, "alice", rfcKey(20), now, code); err == nil {
+					mu.Lock()
+					accepted++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if accepted != 1 {
+			t.Fatalf("expected the code to be accepted once, got %d", accepted)
+		}
+	})
+}
+
+func TestVerifierHOTP(t *testing.T) {
+	// Appendix D of RFC 4226
+	codes := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	forEachStore(t, func(t *testing.T, store CounterStore) {
+		ctx := context.Background()
+		v := &Verifier{Store: store, Options: Options{Skew: 2}}
+
+		f := func(code string, expect error, expectStep uint64) {
+			t.Helper()
+
+			match, err := v.VerifyHOTP(ctx, "token", rfcKey(20), code)
+			if !errors.Is(err, expect) {
+				t.Fatalf("unexpected error for %s; got %v; want %v", code, err, expect)
+			}
+			if err == nil && match.Step != expectStep {
+				t.Fatalf("unexpected counter %d; want %d", match.Step, expectStep)
+			}
+		}
+
+		f(codes[0], nil, 0)
+		f(codes[0], ErrReplayedCode, 0)
+		// within the look-ahead window, skipping presses
+		f(codes[3], nil, 3)
+		f(codes[2], ErrInvalidCode, 0)
+		f(codes[3], ErrReplayedCode, 0)
+		// past the look-ahead window
+		f(codes[7], ErrInvalidCode, 0)
+		f(codes[6], nil, 6)
+
+		if next, err := store.Next(ctx, "token"); err != nil || next != 7 {
+			t.Fatalf("unexpected next counter %d, %v", next, err)
+		}
+	})
+}
+
+func TestVerifierResync(t *testing.T) {
+	key := rfcKey(20)
+	code := func(counter uint64) string {
+		t.Helper()
+
+		code, err := GenerateHOTP(key, counter, Options{})
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		return code
+	}
+
+	forEachStore(t, func(t *testing.T, store CounterStore) {
+		ctx := context.Background()
+		v := &Verifier{Store: store, Options: Options{Skew: 3}, ResyncWindow: 50}
+
+		// the token was pressed far beyond the look-ahead window
+		if _, err := v.VerifyHOTP(ctx, "token", key, code(40)); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("expected ErrInvalidCode, got: %v", err)
+		}
+		// codes that are not consecutive
+		if _, err := v.Resync(ctx, "token", key, code(40), code(42)); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("expected ErrInvalidCode, got: %v", err)
+		}
+		// beyond the resync window
+		if _, err := v.Resync(ctx, "token", key, code(60), code(61)); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("expected ErrInvalidCode, got: %v", err)
+		}
+
+		match, err := v.Resync(ctx, "token", key, code(40), code(41))
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if match.Step != 41 {
+			t.Fatalf("unexpected counter %d; want 41", match.Step)
+		}
+		// the pair cannot be replayed, the next press is accepted
+		if _, err := v.Resync(ctx, "token", key, code(40), code(41)); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("expected ErrInvalidCode, got: %v", err)
+		}
+		if _, err := v.VerifyHOTP(ctx, "token", key, code(42)); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	})
+}
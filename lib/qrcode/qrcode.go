@@ -0,0 +1,473 @@
+// Package qrcode encodes data as QR codes (ISO/IEC 18004) in byte mode, with versions 1 to 40
+// and the four error correction levels, using the standard library only.
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Level is the error correction level, the share of the code that can be damaged and still read
+type Level int
+
+const (
+	// LevelL recovers about 7% of the codewords
+	LevelL Level = iota
+	// LevelM recovers about 15% of the codewords
+	LevelM
+	// LevelQ recovers about 25% of the codewords
+	LevelQ
+	// LevelH recovers about 30% of the codewords
+	LevelH
+)
+
+const (
+	MinVersion = 1
+	MaxVersion = 40
+)
+
+var (
+	ErrInvalidLevel = errors.New("qrcode: invalid error correction level")
+	ErrDataTooLong  = errors.New("qrcode: data does not fit in a version 40 code")
+)
+
+// String returns the letter of the level
+func (l Level) String() string {
+	switch l {
+	case LevelL:
+		return "L"
+	case LevelM:
+		return "M"
+	case LevelQ:
+		return "Q"
+	case LevelH:
+		return "H"
+	default:
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+}
+
+// formatBits returns the two bits of the level in the format information, which are not in level order
+func (l Level) formatBits() int {
+	return [...]int{LevelL: 1, LevelM: 0, LevelQ: 3, LevelH: 2}[l]
+}
+
+// Code is an encoded QR code, a square of Size×Size dark and light modules
+type Code struct {
+	Version int
+	Level   Level
+	// Mask is the data mask pattern, chosen to give the lowest penalty
+	Mask int
+	Size int
+
+	modules    []bool
+	isFunction []bool
+}
+
+// Encode returns the smallest code holding data at the level
+func Encode(data []byte, level Level) (*Code, error) {
+	return encode(data, level, -1)
+}
+
+// Dark reports whether the module at column x and row y is dark, modules outside the code are light
+func (c *Code) Dark(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+		return false
+	}
+	return c.modules[y*c.Size+x]
+}
+
+// encode builds the code with the mask pattern, or the best one when mask is -1
+func encode(data []byte, level Level, mask int) (*Code, error) {
+	if level < LevelL || level > LevelH {
+		return nil, ErrInvalidLevel
+	}
+	version := MinVersion
+	for ; ; version++ {
+		if version > MaxVersion {
+			return nil, ErrDataTooLong
+		}
+		if dataBits(len(data), version) <= numDataCodewords(version, level)*8 {
+			break
+		}
+	}
+
+	size := version*4 + 17
+	c := &Code{
+		Version:    version,
+		Level:      level,
+		Size:       size,
+		modules:    make([]bool, size*size),
+		isFunction: make([]bool, size*size),
+	}
+	c.drawFunctionPatterns()
+	c.drawCodewords(addErrorCorrection(dataCodewords(data, version, level), version, level))
+
+	if mask < 0 {
+		minPenalty := 0
+		for candidate := 0; candidate < 8; candidate++ {
+			c.applyMask(candidate)
+			c.drawFormatBits(candidate)
+			penalty := c.penalty()
+			if candidate == 0 || penalty < minPenalty {
+				mask, minPenalty = candidate, penalty
+			}
+			// Masking twice restores the modules
+			c.applyMask(candidate)
+		}
+	}
+	c.Mask = mask
+	c.applyMask(mask)
+	c.drawFormatBits(mask)
+	c.isFunction = nil
+	return c, nil
+}
+
+// charCountBits is the length of the character count indicator of byte mode
+func charCountBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// dataBits returns the length of a byte mode segment of n bytes, or more than any capacity when n overflows the count
+func dataBits(n, version int) int {
+	if n >= 1<<charCountBits(version) {
+		return 1 << 30
+	}
+	return 4 + charCountBits(version) + n*8
+}
+
+// dataCodewords writes data as a single byte mode segment, terminated and padded to the capacity of the version
+func dataCodewords(data []byte, version int, level Level) []byte {
+	capacity := numDataCodewords(version, level)
+	var bb bitBuffer
+	// Byte mode indicator
+	bb.append(0b0100, 4)
+	bb.append(len(data), charCountBits(version))
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+	bb.append(0, min(4, capacity*8-bb.len()))
+	bb.append(0, (8-bb.len()%8)%8)
+
+	codewords := bb.bytes()
+	for pad := byte(0xec); len(codewords) < capacity; pad ^= 0xec ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+	return codewords
+}
+
+// addErrorCorrection splits the data into blocks, appends the error correction codewords of each block
+// and interleaves the blocks
+func addErrorCorrection(data []byte, version int, level Level) []byte {
+	numBlocks := numErrorCorrectionBlocks[level][version]
+	blockEccLen := eccCodewordsPerBlock[level][version]
+	rawCodewords := numRawDataModules(version) / 8
+	// The first blocks are one data codeword shorter when the codewords do not split evenly
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	generator := rsGenerator(blockEccLen)
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		dataLen := shortBlockLen - blockEccLen
+		if i >= numShortBlocks {
+			dataLen++
+		}
+		block := append([]byte{}, data[k:k+dataLen]...)
+		k += dataLen
+		blocks[i] = append(block, rsRemainder(block, generator)...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := 0; i <= shortBlockLen; i++ {
+		for j, block := range blocks {
+			// Short blocks have no data codeword at the last data position of long blocks
+			if j < numShortBlocks && i >= shortBlockLen-blockEccLen {
+				if i == shortBlockLen-blockEccLen {
+					continue
+				}
+				result = append(result, block[i-1])
+				continue
+			}
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+func (c *Code) set(x, y int, dark bool) {
+	c.modules[y*c.Size+x] = dark
+	c.isFunction[y*c.Size+x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.Size; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+
+	c.drawFinderPattern(3, 3)
+	c.drawFinderPattern(c.Size-4, 3)
+	c.drawFinderPattern(3, c.Size-4)
+
+	positions := alignmentPatternPositions(c.Version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// The corners are taken by the finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			c.drawAlignmentPattern(x, y)
+		}
+	}
+
+	// Reserve the format information, it is drawn once the mask is known
+	c.drawFormatBits(0)
+	c.drawVersion()
+}
+
+// drawFinderPattern draws the 7×7 finder pattern centered on x, y with its light separator
+func (c *Code) drawFinderPattern(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.Size || yy >= c.Size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			c.set(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// drawAlignmentPattern draws the 5×5 alignment pattern centered on x, y
+func (c *Code) drawAlignmentPattern(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// drawFormatBits draws both copies of the level and mask, protected by a BCH(15,5) code
+func (c *Code) drawFormatBits(mask int) {
+	data := c.Level.formatBits()<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+
+	// Around the top left finder pattern
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(bits, i))
+	}
+	c.set(8, 7, bit(bits, 6))
+	c.set(8, 8, bit(bits, 7))
+	c.set(7, 8, bit(bits, 8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(bits, i))
+	}
+
+	// Split between the other two finder patterns
+	for i := 0; i < 8; i++ {
+		c.set(c.Size-1-i, 8, bit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.Size-15+i, bit(bits, i))
+	}
+	// The dark module is always set
+	c.set(8, c.Size-8, true)
+}
+
+// drawVersion draws both copies of the version, protected by a BCH(18,6) code, from version 7
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+	rem := c.Version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1f25
+	}
+	bits := c.Version<<12 | rem
+	for i := 0; i < 18; i++ {
+		a, b := c.Size-11+i%3, i/3
+		c.set(a, b, bit(bits, i))
+		c.set(b, a, bit(bits, i))
+	}
+}
+
+// drawCodewords places the codewords in two-module columns zigzagging up and down from the bottom right corner.
+// The remainder bits left at the end stay light.
+func (c *Code) drawCodewords(codewords []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		// The vertical timing pattern is skipped
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.isFunction[y*c.Size+x] || i >= len(codewords)*8 {
+					continue
+				}
+				c.modules[y*c.Size+x] = bit(int(codewords[i/8]), 7-i%8)
+				i++
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by the mask pattern
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.isFunction[y*c.Size+x] && maskInverts(mask, x, y) {
+				c.modules[y*c.Size+x] = !c.modules[y*c.Size+x]
+			}
+		}
+	}
+}
+
+func maskInverts(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// penalty scores how hard the code is to read with the four rules of the standard, lower is better
+func (c *Code) penalty() int {
+	score := 0
+	// Runs of five or more modules of the same color and finder-like patterns, in rows then columns
+	for _, horizontal := range []bool{true, false} {
+		for a := 0; a < c.Size; a++ {
+			line := make([]bool, c.Size)
+			for b := range line {
+				if horizontal {
+					line[b] = c.Dark(b, a)
+				} else {
+					line[b] = c.Dark(a, b)
+				}
+			}
+			score += linePenalty(line)
+		}
+	}
+
+	// 2×2 blocks of the same color
+	for y := 0; y < c.Size-1; y++ {
+		for x := 0; x < c.Size-1; x++ {
+			dark := c.Dark(x, y)
+			if dark == c.Dark(x+1, y) && dark == c.Dark(x, y+1) && dark == c.Dark(x+1, y+1) {
+				score += 3
+			}
+		}
+	}
+
+	// Balance of dark and light modules, 10 points per 5% away from half
+	dark := 0
+	for _, m := range c.modules {
+		if m {
+			dark++
+		}
+	}
+	total := c.Size * c.Size
+	score += abs(dark*20-total*10) / total * 10
+	return score
+}
+
+var (
+	finderLikeBefore = []bool{false, false, false, false, true, false, true, true, true, false, true}
+	finderLikeAfter  = []bool{true, false, true, true, true, false, true, false, false, false, false}
+)
+
+func linePenalty(line []bool) int {
+	score := 0
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+		if run >= 5 {
+			score += run - 2
+		}
+		run = 1
+	}
+	for i := 0; i+len(finderLikeBefore) <= len(line); i++ {
+		if matches(line[i:], finderLikeBefore) {
+			score += 40
+		}
+		if matches(line[i:], finderLikeAfter) {
+			score += 40
+		}
+	}
+	return score
+}
+
+func matches(line, pattern []bool) bool {
+	for i, m := range pattern {
+		if line[i] != m {
+			return false
+		}
+	}
+	return true
+}
+
+type bitBuffer []bool
+
+func (bb *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*bb = append(*bb, bit(value, i))
+	}
+}
+
+func (bb *bitBuffer) len() int {
+	return len(*bb)
+}
+
+// bytes packs the bits, its length must be a multiple of 8
+func (bb *bitBuffer) bytes() []byte {
+	result := make([]byte, len(*bb)/8)
+	for i, b := range *bb {
+		if b {
+			result[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return result
+}
+
+func bit(value, i int) bool {
+	return value>>i&1 != 0
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,280 @@
+package qrcode
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// readReference reads a matrix of testdata, generated with an independent encoder:
+// a "# version V level L mask M" line, a "# data" line then one row of # and . per line
+func readReference(t *testing.T, name string) (version int, level Level, mask int, data string, rows []string) {
+	t.Helper()
+
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatalf("failed to open reference: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	scanner.Scan()
+	var levelName string
+	if _, err := fmt.Sscanf(scanner.Text(), "# version %d level %s mask %d", &version, &levelName, &mask); err != nil {
+		t.Fatalf("invalid reference header %q: %v", scanner.Text(), err)
+	}
+	level = Level(strings.Index("LMQH", levelName))
+	scanner.Scan()
+	data = strings.TrimPrefix(scanner.Text(), "# ")
+	for scanner.Scan() {
+		rows = append(rows, scanner.Text())
+	}
+	return version, level, mask, data, rows
+}
+
+func TestEncodeReference(t *testing.T) {
+	f := func(name string) {
+		t.Helper()
+
+		version, level, mask, data, rows := readReference(t, name)
+		c, err := encode([]byte(data), level, mask)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if c.Version != version || c.Size != len(rows) {
+			t.Fatalf("unexpected version for %s; got %d; want %d", name, c.Version, version)
+		}
+		for y, row := range rows {
+			for x, m := range row {
+				if c.Dark(x, y) != (m == '#') {
+					t.Fatalf("unexpected module at %d,%d of %s; got dark %v", x, y, name, c.Dark(x, y))
+				}
+			}
+		}
+	}
+
+	f("hello-l.txt")
+	f("url-m.txt")
+	// from version 7 the version information is drawn
+	f("otpauth-q.txt")
+	// from version 10 the character count takes 16 bits
+	f("text-h.txt")
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	f := func(data []byte, level Level, expectVersion int) {
+		t.Helper()
+
+		c, err := Encode(data, level)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if c.Version != expectVersion {
+			t.Fatalf("unexpected version for %d bytes at %s; got %d; want %d", len(data), level, c.Version, expectVersion)
+		}
+		decoded, err := decode(c)
+		if err != nil {
+			t.Fatalf("failed to decode version %d at %s: %v", c.Version, level, err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Fatalf("unexpected data after a round trip; got %q; want %q", decoded, data)
+		}
+	}
+
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		_, _ = rand.Read(b)
+		return b
+	}
+
+	f(nil, LevelM, 1)
+	f([]byte("hello"), LevelH, 1)
+	// the byte capacities of each level at the boundaries of versions
+	f(random(17), LevelL, 1)
+	f(random(18), LevelL, 2)
+	f(random(14), LevelM, 1)
+	f(random(7), LevelH, 1)
+	f(random(8), LevelH, 2)
+	f(random(271), LevelL, 10)
+	f(random(1273), LevelH, 40)
+	f(random(2953), LevelL, 40)
+	for version := MinVersion; version <= MaxVersion; version++ {
+		for level := LevelL; level <= LevelH; level++ {
+			capacity := (numDataCodewords(version, level)*8 - 4 - charCountBits(version)) / 8
+			f(random(capacity), level, version)
+		}
+	}
+
+	if _, err := Encode(random(2954), LevelL); !errors.Is(err, ErrDataTooLong) {
+		t.Fatalf("expected ErrDataTooLong, got: %v", err)
+	}
+	if _, err := Encode(nil, Level(4)); !errors.Is(err, ErrInvalidLevel) {
+		t.Fatalf("expected ErrInvalidLevel, got: %v", err)
+	}
+}
+
+func TestEncodeMask(t *testing.T) {
+	data := []byte("otpauth://totp/Todo:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Todo")
+	c, err := Encode(data, LevelM)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	// the chosen mask has the lowest penalty of all eight
+	for mask := 0; mask < 8; mask++ {
+		other, err := encode(data, LevelM, mask)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if penalty(other) < penalty(c) {
+			t.Fatalf("mask %d has a lower penalty than the chosen mask %d", mask, c.Mask)
+		}
+	}
+}
+
+func TestAlignmentPatternPositions(t *testing.T) {
+	f := func(version int, expect []int) {
+		t.Helper()
+
+		if result := alignmentPatternPositions(version); fmt.Sprint(result) != fmt.Sprint(expect) {
+			t.Fatalf("unexpected positions for version %d; got %v; want %v", version, result, expect)
+		}
+	}
+
+	// Annex E of ISO/IEC 18004
+	f(1, nil)
+	f(2, []int{6, 18})
+	f(7, []int{6, 22, 38})
+	f(22, []int{6, 26, 50, 74, 98})
+	f(32, []int{6, 34, 60, 86, 112, 138})
+	f(36, []int{6, 24, 50, 76, 102, 128, 154})
+	f(40, []int{6, 30, 58, 86, 114, 142, 170})
+}
+
+// penalty scores a finished code, with its function patterns rebuilt
+func penalty(c *Code) int {
+	scored := *c
+	scored.isFunction = functionModules(c.Version)
+	return scored.penalty()
+}
+
+// functionModules returns which modules of the version are not data
+func functionModules(version int) []bool {
+	size := version*4 + 17
+	blank := &Code{Version: version, Size: size, modules: make([]bool, size*size), isFunction: make([]bool, size*size)}
+	blank.drawFunctionPatterns()
+	return blank.isFunction
+}
+
+// decode reads the data of a single byte mode segment back from the modules
+func decode(c *Code) ([]byte, error) {
+	// The format information around the top left finder pattern, checked against every valid one
+	var read int
+	for i := 0; i <= 5; i++ {
+		read |= b2i(c.Dark(8, i)) << i
+	}
+	read |= b2i(c.Dark(8, 7))<<6 | b2i(c.Dark(8, 8))<<7 | b2i(c.Dark(7, 8))<<8
+	for i := 9; i < 15; i++ {
+		read |= b2i(c.Dark(14-i, 8)) << i
+	}
+	level, mask := Level(-1), -1
+	for l := LevelL; l <= LevelH; l++ {
+		for m := 0; m < 8; m++ {
+			data := l.formatBits()<<3 | m
+			rem := data
+			for i := 0; i < 10; i++ {
+				rem = rem<<1 ^ (rem>>9)*0x537
+			}
+			if (data<<10|rem)^0x5412 == read {
+				level, mask = l, m
+			}
+		}
+	}
+	if level != c.Level || mask != c.Mask {
+		return nil, fmt.Errorf("unexpected format information %015b", read)
+	}
+
+	// The codewords in placement order, unmasked
+	isFunction := functionModules(c.Version)
+	var bits bitBuffer
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if !isFunction[y*c.Size+x] {
+					bits = append(bits, c.Dark(x, y) != maskInverts(mask, x, y))
+				}
+			}
+		}
+	}
+	// The remainder bits are not part of any codeword
+	bits = bits[:len(bits)/8*8]
+	interleaved := bits.bytes()
+
+	// Deinterleave the blocks, the first ones are a data codeword shorter, and check their error correction
+	numBlocks := numErrorCorrectionBlocks[level][c.Version]
+	eccLen := eccCodewordsPerBlock[level][c.Version]
+	numShortBlocks := numBlocks - len(interleaved)%numBlocks
+	shortBlockLen := len(interleaved) / numBlocks
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i <= shortBlockLen; i++ {
+		for j := range blocks {
+			if j < numShortBlocks && i == shortBlockLen-eccLen {
+				continue
+			}
+			blocks[j] = append(blocks[j], interleaved[k])
+			k++
+		}
+	}
+	var data []byte
+	for j, block := range blocks {
+		dataLen := len(block) - eccLen
+		if !bytes.Equal(block[dataLen:], rsRemainder(block[:dataLen], rsGenerator(eccLen))) {
+			return nil, fmt.Errorf("invalid error correction of block %d", j)
+		}
+		data = append(data, block[:dataLen]...)
+	}
+
+	// The segment header then the bytes
+	var payload bitBuffer
+	for _, b := range data {
+		payload.append(int(b), 8)
+	}
+	next := func(n int) int {
+		v := 0
+		for _, b := range payload[:n] {
+			v = v<<1 | b2i(b)
+		}
+		payload = payload[n:]
+		return v
+	}
+	if mode := next(4); mode != 0b0100 {
+		return nil, fmt.Errorf("unexpected mode %04b", mode)
+	}
+	n := next(charCountBits(c.Version))
+	decoded := make([]byte, n)
+	for i := range decoded {
+		decoded[i] = byte(next(8))
+	}
+	return decoded, nil
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
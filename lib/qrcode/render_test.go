@@ -0,0 +1,56 @@
+package qrcode
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestPNG(t *testing.T) {
+	c, err := Encode([]byte("https://example.com/"), LevelM)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	encoded, err := c.PNG(3)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("failed to decode png: %v", err)
+	}
+
+	side := (c.Size + 2*QuietZone) * 3
+	if img.Bounds().Dx() != side || img.Bounds().Dy() != side {
+		t.Fatalf("unexpected size %v; want %d", img.Bounds(), side)
+	}
+	for y := -QuietZone; y < c.Size+QuietZone; y++ {
+		for x := -QuietZone; x < c.Size+QuietZone; x++ {
+			// the center pixel of each module
+			r, _, _, _ := img.At((x+QuietZone)*3+1, (y+QuietZone)*3+1).RGBA()
+			if dark := r == 0; dark != c.Dark(x, y) {
+				t.Fatalf("unexpected pixel of module %d,%d; got dark %v", x, y, dark)
+			}
+		}
+	}
+}
+
+func TestSVG(t *testing.T) {
+	c, err := Encode([]byte("hello"), LevelL)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	svg := c.SVG()
+	if err := xml.Unmarshal([]byte(svg), new(struct{})); err != nil {
+		t.Fatalf("invalid svg: %v", err)
+	}
+	if !strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 29 29"`) {
+		t.Fatalf("unexpected svg %s", svg)
+	}
+	// the top row of the finder patterns, merged into runs
+	if !strings.Contains(svg, `M4 4h7v1h-7z`) || !strings.Contains(svg, `M18 4h7v1h-7z`) {
+		t.Fatalf("expected the finder patterns in %s", svg)
+	}
+}
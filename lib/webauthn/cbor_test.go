@@ -0,0 +1,127 @@
+package webauthn
+
+import (
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	f := func(encoded string, expect any) {
+		t.Helper()
+
+		data, err := hex.DecodeString(encoded)
+		if err != nil {
+			t.Fatalf("invalid test data %s: %v", encoded, err)
+		}
+		got, rest, err := decodeCBOR(data)
+		if err != nil {
+			t.Fatalf("unexpected error decoding %s: %v", encoded, err)
+		}
+		if len(rest) != 0 {
+			t.Fatalf("unexpected remaining bytes %x", rest)
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("unexpected value for %s; got %#v; want %#v", encoded, got, expect)
+		}
+	}
+
+	// RFC 8949 Appendix A
+	f("00", int64(0))
+	f("17", int64(23))
+	f("1818", int64(24))
+	f("1903e8", int64(1000))
+	f("1a000f4240", int64(1000000))
+	f("1b000000e8d4a51000", int64(1000000000000))
+	f("20", int64(-1))
+	f("3863", int64(-100))
+	f("3903e7", int64(-1000))
+	f("f90000", float64(0))
+	f("f93c00", float64(1))
+	f("f97bff", float64(65504))
+	f("fa47c35000", float64(100000))
+	f("fb3ff199999999999a", 1.1)
+	f("f4", false)
+	f("f5", true)
+	f("f6", nil)
+	f("40", []byte{})
+	f("4401020304", []byte{1, 2, 3, 4})
+	f("60", "")
+	f("6449455446", "IETF")
+	f("62c3bc", "ü")
+	f("80", []any{})
+	f("83010203", []any{int64(1), int64(2), int64(3)})
+	f("8301820203820405", []any{int64(1), []any{int64(2), int64(3)}, []any{int64(4), int64(5)}})
+	f("a0", map[any]any{})
+	f("a201020304", map[any]any{int64(1): int64(2), int64(3): int64(4)})
+	f("a26161016162820203", map[any]any{"a": int64(1), "b": []any{int64(2), int64(3)}})
+
+	// Infinity and NaN
+	got, _, err := decodeCBOR([]byte{0xf9, 0x7c, 0x00})
+	if err != nil || got != math.Inf(1) {
+		t.Fatalf("unexpected infinity %v, %v", got, err)
+	}
+	got, _, err = decodeCBOR([]byte{0xf9, 0x7e, 0x00})
+	if value, ok := got.(float64); err != nil || !ok || !math.IsNaN(value) {
+		t.Fatalf("unexpected NaN %v, %v", got, err)
+	}
+}
+
+func TestDecodeCBORReturnsRemainingBytes(t *testing.T) {
+	got, rest, err := decodeCBOR([]byte{0x01, 0x02, 0x03})
+	if err != nil || got != int64(1) {
+		t.Fatalf("unexpected value %v, %v", got, err)
+	}
+	if len(rest) != 2 {
+		t.Fatalf("unexpected remaining bytes %x", rest)
+	}
+}
+
+func TestDecodeCBORRejectsInvalidData(t *testing.T) {
+	f := func(encoded string) {
+		t.Helper()
+
+		data, err := hex.DecodeString(encoded)
+		if err != nil {
+			t.Fatalf("invalid test data %s: %v", encoded, err)
+		}
+		if got, _, err := decodeCBOR(data); err == nil {
+			t.Fatalf("expected an error decoding %s, got %#v", encoded, got)
+		}
+	}
+
+	// empty and truncated items
+	f("")
+	f("19")
+	f("1a0000")
+	f("44010203")
+	f("830102")
+	f("a20102")
+
+	// lengths larger than the data
+	f("5bffffffffffffffff")
+	f("9bffffffffffffffff")
+	f("bbffffffffffffffff")
+
+	// integers beyond int64
+	f("1bffffffffffffffff")
+	f("3bffffffffffffffff")
+
+	// indefinite lengths, tags and unknown simple values
+	f("5f42010243030405ff")
+	f("9fff")
+	f("c11a514b67b0")
+	f("f0")
+
+	// unsupported and duplicate map keys
+	f("a1f401")
+	f("a201020103")
+
+	// nesting too deep
+	deep := ""
+	for range maxCBORDepth + 2 {
+		deep += "81"
+	}
+	f(deep + "01")
+}
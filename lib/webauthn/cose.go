@@ -0,0 +1,102 @@
+package webauthn
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers of the supported public keys
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+)
+
+// COSE key parameters, see RFC 9053
+const (
+	coseKeyType      = 1
+	coseKeyAlgorithm = 3
+	coseKeyCurve     = -1
+	coseKeyX         = -2
+	coseKeyY         = -3
+
+	coseKeyTypeOKP   = 1
+	coseKeyTypeEC2   = 2
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+// PublicKey is a credential public key decoded from its COSE form
+type PublicKey struct {
+	Algorithm int64
+	key       any
+}
+
+// ParsePublicKey decodes a COSE_Key holding an ES256 or an EdDSA (Ed25519) public key
+func ParsePublicKey(cose []byte) (PublicKey, error) {
+	item, rest, err := decodeCBOR(cose)
+	if err != nil {
+		return PublicKey{}, fmt.Errorf("%w: %w", ErrInvalidPublicKey, err)
+	}
+	if len(rest) != 0 {
+		return PublicKey{}, fmt.Errorf("%w: trailing data", ErrInvalidPublicKey)
+	}
+	return parsePublicKeyMap(item)
+}
+
+func parsePublicKeyMap(item any) (PublicKey, error) {
+	m, ok := item.(map[any]any)
+	if !ok {
+		return PublicKey{}, fmt.Errorf("%w: not a map", ErrInvalidPublicKey)
+	}
+	keyType, _ := m[int64(coseKeyType)].(int64)
+	alg, _ := m[int64(coseKeyAlgorithm)].(int64)
+	curve, _ := m[int64(coseKeyCurve)].(int64)
+	x, _ := m[int64(coseKeyX)].([]byte)
+
+	switch {
+	case alg == AlgES256 && keyType == coseKeyTypeEC2 && curve == coseCurveP256:
+		y, _ := m[int64(coseKeyY)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return PublicKey{}, fmt.Errorf("%w: invalid P-256 coordinates", ErrInvalidPublicKey)
+		}
+		// ecdh rejects points which are not on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return PublicKey{}, fmt.Errorf("%w: %w", ErrInvalidPublicKey, err)
+		}
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		return PublicKey{Algorithm: alg, key: key}, nil
+	case alg == AlgEdDSA && keyType == coseKeyTypeOKP && curve == coseCurveEd25519:
+		if len(x) != ed25519.PublicKeySize {
+			return PublicKey{}, fmt.Errorf("%w: invalid Ed25519 key", ErrInvalidPublicKey)
+		}
+		return PublicKey{Algorithm: alg, key: ed25519.PublicKey(x)}, nil
+	default:
+		return PublicKey{}, fmt.Errorf("%w: key type %d, algorithm %d, curve %d", ErrUnsupportedAlgorithm, keyType, alg, curve)
+	}
+}
+
+// Verify checks the signature of data made with the private key.
+// ES256 signatures are ASN.1 DER encoded as authenticators produce them.
+func (k PublicKey) Verify(data, signature []byte) error {
+	valid := false
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		valid = ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, data, signature)
+	}
+	if !valid {
+		return ErrInvalidSignature
+	}
+	return nil
+}
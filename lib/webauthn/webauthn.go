@@ -0,0 +1,447 @@
+// Package webauthn implements the relying party side of Web Authentication ceremonies for passkeys.
+// It supports the "none" attestation format and ES256 and EdDSA credentials, using only the standard library.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidResponse        = errors.New("invalid webauthn response")
+	ErrChallengeMismatch      = errors.New("webauthn challenge mismatch")
+	ErrOriginMismatch         = errors.New("webauthn origin mismatch")
+	ErrRelyingPartyMismatch   = errors.New("webauthn relying party mismatch")
+	ErrUserNotPresent         = errors.New("webauthn user presence was not confirmed")
+	ErrUserNotVerified        = errors.New("webauthn user verification was not performed")
+	ErrUnsupportedAttestation = errors.New("unsupported webauthn attestation format")
+	ErrUnsupportedAlgorithm   = errors.New("unsupported webauthn public key algorithm")
+	ErrInvalidPublicKey       = errors.New("invalid webauthn public key")
+	ErrInvalidSignature       = errors.New("invalid webauthn signature")
+	ErrCredentialMismatch     = errors.New("webauthn credential mismatch")
+	ErrSignCountRegression    = errors.New("webauthn signature counter did not increase, the authenticator may be cloned")
+)
+
+// challengeSize is the number of random bytes of a challenge, the specification asks for at least 16
+const challengeSize = 32
+
+// ceremonyTimeout is the hint given to browsers for how long the user has to respond
+const ceremonyTimeout = 5 * time.Minute
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+	flagExtensions   = 0x80
+)
+
+// User verification requirements of a ceremony
+const (
+	UserVerificationRequired    = "required"
+	UserVerificationPreferred   = "preferred"
+	UserVerificationDiscouraged = "discouraged"
+)
+
+// Bytes is a binary value encoded in JSON as unpadded base64url, as browsers serialize credentials
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// String returns the base64url form of the value
+func (b Bytes) String() string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// NewChallenge returns a random challenge for a ceremony, it must be used only once
+func NewChallenge() (Bytes, error) {
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// RelyingParty is the website credentials are scoped to
+type RelyingParty struct {
+	// ID is the domain credentials are bound to
+	ID string
+	// Name is shown by authenticators when creating a credential
+	Name string
+	// Origin is the scheme, host and port pages run the ceremonies from
+	Origin string
+}
+
+// NewRelyingParty returns the relying party of the website served at origin, its ID is the origin host name
+func NewRelyingParty(origin, name string) (RelyingParty, error) {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return RelyingParty{}, fmt.Errorf("invalid webauthn origin: %w", err)
+	}
+	if u.Scheme == "" || u.Hostname() == "" {
+		return RelyingParty{}, fmt.Errorf("invalid webauthn origin %q", origin)
+	}
+	return RelyingParty{
+		ID:     u.Hostname(),
+		Name:   name,
+		Origin: u.Scheme + "://" + u.Host,
+	}, nil
+}
+
+// User is the account a credential is created for.
+// ID is an opaque handle stored by the authenticator, it must not contain personal information.
+type User struct {
+	ID          Bytes
+	Name        string
+	DisplayName string
+}
+
+// Credential is a registered public key credential
+type Credential struct {
+	ID Bytes
+	// PublicKey is the COSE_Key of the credential, parse it with ParsePublicKey
+	PublicKey Bytes
+	Algorithm int64
+	SignCount uint32
+}
+
+// CreationOptions are passed to navigator.credentials.create, in the JSON form accepted by
+// PublicKeyCredential.parseCreationOptionsFromJSON
+type CreationOptions struct {
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              Bytes                  `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   Bytes  `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// RequestOptions are passed to navigator.credentials.get, in the JSON form accepted by
+// PublicKeyCredential.parseRequestOptionsFromJSON
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions returns the options to create a discoverable credential with user verification.
+// Authenticators already holding one of the exclude credentials refuse to create another one.
+func (rp RelyingParty) CreationOptions(user User, challenge Bytes, exclude []Bytes) CreationOptions {
+	return CreationOptions{
+		RP:        RelyingPartyEntity{ID: rp.ID, Name: rp.Name},
+		User:      UserEntity{ID: user.ID, Name: user.Name, DisplayName: user.DisplayName},
+		Challenge: challenge,
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgEdDSA},
+		},
+		Timeout:            ceremonyTimeout.Milliseconds(),
+		ExcludeCredentials: descriptors(exclude),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: UserVerificationRequired,
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions returns the options to get an assertion.
+// Without allowed credentials, the authenticator offers the discoverable credentials it holds for the relying party.
+func (rp RelyingParty) RequestOptions(challenge Bytes, allow []Bytes, userVerification string) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          ceremonyTimeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: descriptors(allow),
+		UserVerification: userVerification,
+	}
+}
+
+func descriptors(ids []Bytes) []CredentialDescriptor {
+	list := make([]CredentialDescriptor, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+	return list
+}
+
+// RegistrationResponse is the JSON serialization of the credential returned by navigator.credentials.create
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes `json:"clientDataJSON"`
+		AttestationObject Bytes `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON serialization of the credential returned by navigator.credentials.get
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes `json:"clientDataJSON"`
+		AuthenticatorData Bytes `json:"authenticatorData"`
+		Signature         Bytes `json:"signature"`
+		UserHandle        Bytes `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// ClientData is the data the browser signs along with the authenticator data
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   Bytes  `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// ClientData decodes the client data of the response, its challenge tells which ceremony it answers
+func (r RegistrationResponse) ClientData() (ClientData, error) {
+	return parseClientData(r.Response.ClientDataJSON)
+}
+
+// ClientData decodes the client data of the response, its challenge tells which ceremony it answers
+func (r AssertionResponse) ClientData() (ClientData, error) {
+	return parseClientData(r.Response.ClientDataJSON)
+}
+
+func parseClientData(raw []byte) (ClientData, error) {
+	var clientData ClientData
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return ClientData{}, fmt.Errorf("%w: client data: %w", ErrInvalidResponse, err)
+	}
+	return clientData, nil
+}
+
+// VerifyRegistration checks the response to the CreationOptions built with challenge and returns the new credential.
+// Only the "none" attestation format is accepted, so the authenticator model is not verified.
+func (rp RelyingParty) VerifyRegistration(challenge Bytes, response RegistrationResponse) (Credential, error) {
+	if err := checkCredentialID(response.Type, response.ID, response.RawID); err != nil {
+		return Credential{}, err
+	}
+	if err := rp.checkClientData(response.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return Credential{}, err
+	}
+
+	item, rest, err := decodeCBOR(response.Response.AttestationObject)
+	if err != nil || len(rest) != 0 {
+		return Credential{}, fmt.Errorf("%w: attestation object", ErrInvalidResponse)
+	}
+	attestation, ok := item.(map[any]any)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: attestation object", ErrInvalidResponse)
+	}
+	format, _ := attestation["fmt"].(string)
+	statement, _ := attestation["attStmt"].(map[any]any)
+	if format != "none" || len(statement) != 0 {
+		return Credential{}, fmt.Errorf("%w: %q", ErrUnsupportedAttestation, format)
+	}
+	rawAuthData, _ := attestation["authData"].([]byte)
+
+	authData, err := rp.parseAuthenticatorData(rawAuthData, true)
+	if err != nil {
+		return Credential{}, err
+	}
+	if authData.flags&flagAttested == 0 {
+		return Credential{}, fmt.Errorf("%w: missing attested credential data", ErrInvalidResponse)
+	}
+	if !bytes.Equal(authData.credentialID, response.RawID) {
+		return Credential{}, ErrCredentialMismatch
+	}
+
+	return Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		Algorithm: authData.algorithm,
+		SignCount: authData.signCount,
+	}, nil
+}
+
+// VerifyAssertion checks the response to the RequestOptions built with challenge against the stored credential
+// and returns the new signature counter to store.
+// User presence is always required, user verification only when requireUserVerification is set.
+func (rp RelyingParty) VerifyAssertion(challenge Bytes, credential Credential, response AssertionResponse, requireUserVerification bool) (uint32, error) {
+	if err := checkCredentialID(response.Type, response.ID, response.RawID); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(response.RawID, credential.ID) {
+		return 0, ErrCredentialMismatch
+	}
+	if err := rp.checkClientData(response.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := rp.parseAuthenticatorData(response.Response.AuthenticatorData, requireUserVerification)
+	if err != nil {
+		return 0, err
+	}
+
+	publicKey, err := ParsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(response.Response.ClientDataJSON)
+	signed := append(append([]byte(nil), response.Response.AuthenticatorData...), clientDataHash[:]...)
+	if err := publicKey.Verify(signed, response.Response.Signature); err != nil {
+		return 0, err
+	}
+
+	// Authenticators without a counter always report 0, any other must move forward
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return 0, ErrSignCountRegression
+	}
+	return authData.signCount, nil
+}
+
+func checkCredentialID(credentialType, id string, rawID Bytes) error {
+	if credentialType != "public-key" || len(rawID) == 0 || id != rawID.String() {
+		return fmt.Errorf("%w: credential id", ErrInvalidResponse)
+	}
+	return nil
+}
+
+func (rp RelyingParty) checkClientData(raw []byte, ceremony string, challenge Bytes) error {
+	clientData, err := parseClientData(raw)
+	if err != nil {
+		return err
+	}
+	if clientData.Type != ceremony {
+		return fmt.Errorf("%w: unexpected type %q", ErrInvalidResponse, clientData.Type)
+	}
+	if len(challenge) == 0 || subtle.ConstantTimeCompare(clientData.Challenge, challenge) != 1 {
+		return ErrChallengeMismatch
+	}
+	if clientData.Origin != rp.Origin || clientData.CrossOrigin {
+		return fmt.Errorf("%w: %q", ErrOriginMismatch, clientData.Origin)
+	}
+	return nil
+}
+
+type authenticatorData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+	algorithm    int64
+}
+
+// parseAuthenticatorData decodes the authenticator data and checks its relying party and flags
+func (rp RelyingParty) parseAuthenticatorData(data []byte, requireUserVerification bool) (authenticatorData, error) {
+	if len(data) < 37 {
+		return authenticatorData{}, fmt.Errorf("%w: authenticator data too short", ErrInvalidResponse)
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(data[:32], rpIDHash[:]) != 1 {
+		return authenticatorData{}, ErrRelyingPartyMismatch
+	}
+
+	authData := authenticatorData{
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return authenticatorData{}, ErrUserNotPresent
+	}
+	if requireUserVerification && authData.flags&flagUserVerified == 0 {
+		return authenticatorData{}, ErrUserNotVerified
+	}
+
+	rest := data[37:]
+	if authData.flags&flagAttested != 0 {
+		// AAGUID, then the length of the credential ID
+		if len(rest) < 18 {
+			return authenticatorData{}, fmt.Errorf("%w: attested credential data too short", ErrInvalidResponse)
+		}
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLength {
+			return authenticatorData{}, fmt.Errorf("%w: credential id too short", ErrInvalidResponse)
+		}
+		authData.credentialID = append([]byte(nil), rest[:idLength]...)
+		rest = rest[idLength:]
+
+		item, remaining, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, fmt.Errorf("%w: %w", ErrInvalidPublicKey, err)
+		}
+		publicKey, err := parsePublicKeyMap(item)
+		if err != nil {
+			return authenticatorData{}, err
+		}
+		authData.publicKey = append([]byte(nil), rest[:len(rest)-len(remaining)]...)
+		authData.algorithm = publicKey.Algorithm
+		rest = remaining
+	}
+	if authData.flags&flagExtensions != 0 {
+		item, remaining, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, fmt.Errorf("%w: extensions: %w", ErrInvalidResponse, err)
+		}
+		if _, ok := item.(map[any]any); !ok {
+			return authenticatorData{}, fmt.Errorf("%w: extensions", ErrInvalidResponse)
+		}
+		rest = remaining
+	}
+	if len(rest) != 0 {
+		return authenticatorData{}, fmt.Errorf("%w: trailing authenticator data", ErrInvalidResponse)
+	}
+
+	return authData, nil
+}
@@ -0,0 +1,259 @@
+package webauthn_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/AltSoyuz/soy-experiments/lib/webauthn"
+	"github.com/AltSoyuz/soy-experiments/lib/webauthn/webauthntest"
+)
+
+const testOrigin = "https://app.example.com"
+
+func givenRelyingParty(t *testing.T) webauthn.RelyingParty {
+	t.Helper()
+
+	rp, err := webauthn.NewRelyingParty(testOrigin+"/", "Example")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	return rp
+}
+
+// givenCredential registers a credential of the authenticator
+func givenCredential(t *testing.T, rp webauthn.RelyingParty, authenticator *webauthntest.Authenticator) webauthn.Credential {
+	t.Helper()
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	user := webauthn.User{ID: []byte{1}, Name: "user@example.com", DisplayName: "user@example.com"}
+	response, err := authenticator.Create(rp.CreationOptions(user, challenge, nil))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	credential, err := rp.VerifyRegistration(challenge, response)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	return credential
+}
+
+func TestNewRelyingParty(t *testing.T) {
+	f := func(origin, expectID, expectOrigin string) {
+		t.Helper()
+
+		rp, err := webauthn.NewRelyingParty(origin, "Example")
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if rp.ID != expectID || rp.Origin != expectOrigin {
+			t.Fatalf("unexpected relying party %+v", rp)
+		}
+	}
+
+	f("https://app.example.com", "app.example.com", "https://app.example.com")
+	f("http://localhost:8080/path", "localhost", "http://localhost:8080")
+
+	if _, err := webauthn.NewRelyingParty("not a url", "Example"); err == nil {
+		t.Fatalf("expected an error for an invalid origin")
+	}
+}
+
+func TestBytesJSON(t *testing.T) {
+	data, err := json.Marshal(webauthn.Bytes{0xfb, 0xff})
+	if err != nil || string(data) != `"-_8"` {
+		t.Fatalf("unexpected encoding %s, %v", data, err)
+	}
+
+	var b webauthn.Bytes
+	// Padded values are accepted too
+	if err := json.Unmarshal([]byte(`"-_8="`), &b); err != nil || len(b) != 2 || b[0] != 0xfb || b[1] != 0xff {
+		t.Fatalf("unexpected decoding %x, %v", b, err)
+	}
+	if err := json.Unmarshal([]byte(`"not base64!"`), &b); err == nil {
+		t.Fatalf("expected an error for invalid base64url")
+	}
+}
+
+func TestCeremonies(t *testing.T) {
+	f := func(algorithm int64) {
+		t.Helper()
+
+		rp := givenRelyingParty(t)
+		authenticator := webauthntest.NewAuthenticator(testOrigin, algorithm)
+		credential := givenCredential(t, rp, authenticator)
+		if credential.Algorithm != algorithm || credential.SignCount != 0 {
+			t.Fatalf("unexpected credential %+v", credential)
+		}
+		if _, err := webauthn.ParsePublicKey(credential.PublicKey); err != nil {
+			t.Fatalf("expected a valid public key, got: %v", err)
+		}
+
+		// Each assertion moves the counter forward
+		for expect := uint32(1); expect <= 2; expect++ {
+			challenge, err := webauthn.NewChallenge()
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			response, err := authenticator.Get(rp.RequestOptions(challenge, nil, webauthn.UserVerificationRequired))
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			signCount, err := rp.VerifyAssertion(challenge, credential, response, true)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if signCount != expect {
+				t.Fatalf("unexpected sign count %d; want %d", signCount, expect)
+			}
+			credential.SignCount = signCount
+		}
+	}
+
+	f(webauthn.AlgES256)
+	f(webauthn.AlgEdDSA)
+}
+
+func TestVerifyRegistrationRejectsInvalidResponses(t *testing.T) {
+	rp := givenRelyingParty(t)
+	user := webauthn.User{ID: []byte{1}, Name: "user@example.com", DisplayName: "user@example.com"}
+
+	f := func(prepare func(a *webauthntest.Authenticator, options *webauthn.CreationOptions), tamper func(r *webauthn.RegistrationResponse), expect error) {
+		t.Helper()
+
+		challenge, err := webauthn.NewChallenge()
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		authenticator := webauthntest.NewAuthenticator(testOrigin, webauthn.AlgES256)
+		options := rp.CreationOptions(user, challenge, nil)
+		prepare(authenticator, &options)
+		response, err := authenticator.Create(options)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		tamper(&response)
+
+		if _, err := rp.VerifyRegistration(challenge, response); !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+	}
+	noPrepare := func(a *webauthntest.Authenticator, options *webauthn.CreationOptions) {}
+	noTamper := func(r *webauthn.RegistrationResponse) {}
+
+	// another challenge
+	f(func(a *webauthntest.Authenticator, options *webauthn.CreationOptions) {
+		options.Challenge = []byte("another challenge")
+	}, noTamper, webauthn.ErrChallengeMismatch)
+
+	// a page on another origin
+	f(func(a *webauthntest.Authenticator, options *webauthn.CreationOptions) {
+		a.Origin = "https://evil.example.com"
+	}, noTamper, webauthn.ErrOriginMismatch)
+
+	// a credential for another relying party
+	f(func(a *webauthntest.Authenticator, options *webauthn.CreationOptions) {
+		options.RP.ID = "evil.example.com"
+	}, noTamper, webauthn.ErrRelyingPartyMismatch)
+
+	// passkeys must verify the user
+	f(func(a *webauthntest.Authenticator, options *webauthn.CreationOptions) {
+		a.SkipUserVerification = true
+	}, noTamper, webauthn.ErrUserNotVerified)
+
+	// the credential id must match the attested one
+	f(noPrepare, func(r *webauthn.RegistrationResponse) {
+		r.RawID = []byte("another id")
+		r.ID = r.RawID.String()
+	}, webauthn.ErrCredentialMismatch)
+	f(noPrepare, func(r *webauthn.RegistrationResponse) {
+		r.ID = "another id"
+	}, webauthn.ErrInvalidResponse)
+
+	// client data of an assertion
+	f(noPrepare, func(r *webauthn.RegistrationResponse) {
+		r.Response.ClientDataJSON = []byte(`{"type":"webauthn.get","challenge":"","origin":"` + testOrigin + `"}`)
+	}, webauthn.ErrInvalidResponse)
+
+	// broken attestation objects
+	f(noPrepare, func(r *webauthn.RegistrationResponse) {
+		r.Response.AttestationObject = r.Response.AttestationObject[:len(r.Response.AttestationObject)-1]
+	}, webauthn.ErrInvalidResponse)
+	f(noPrepare, func(r *webauthn.RegistrationResponse) {
+		r.Response.AttestationObject = append(r.Response.AttestationObject, 0)
+	}, webauthn.ErrInvalidResponse)
+}
+
+func TestVerifyAssertionRejectsInvalidResponses(t *testing.T) {
+	rp := givenRelyingParty(t)
+
+	f := func(prepare func(a *webauthntest.Authenticator, credential *webauthn.Credential) *webauthntest.Authenticator, tamper func(r *webauthn.AssertionResponse), requireUserVerification bool, expect error) {
+		t.Helper()
+
+		authenticator := webauthntest.NewAuthenticator(testOrigin, webauthn.AlgEdDSA)
+		credential := givenCredential(t, rp, authenticator)
+		authenticator = prepare(authenticator, &credential)
+
+		challenge, err := webauthn.NewChallenge()
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		response, err := authenticator.Get(rp.RequestOptions(challenge, []webauthn.Bytes{credential.ID}, webauthn.UserVerificationPreferred))
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		tamper(&response)
+
+		if _, err := rp.VerifyAssertion(challenge, credential, response, requireUserVerification); !errors.Is(err, expect) {
+			t.Fatalf("unexpected error; got %v; want %v", err, expect)
+		}
+	}
+	noPrepare := func(a *webauthntest.Authenticator, credential *webauthn.Credential) *webauthntest.Authenticator {
+		return a
+	}
+	noTamper := func(r *webauthn.AssertionResponse) {}
+
+	// valid without user verification when it is not required
+	f(func(a *webauthntest.Authenticator, credential *webauthn.Credential) *webauthntest.Authenticator {
+		a.SkipUserVerification = true
+		return a
+	}, noTamper, false, nil)
+	f(func(a *webauthntest.Authenticator, credential *webauthn.Credential) *webauthntest.Authenticator {
+		a.SkipUserVerification = true
+		return a
+	}, noTamper, true, webauthn.ErrUserNotVerified)
+
+	// signatures over other data
+	f(noPrepare, func(r *webauthn.AssertionResponse) {
+		r.Response.Signature[0] ^= 0xff
+	}, false, webauthn.ErrInvalidSignature)
+	f(noPrepare, func(r *webauthn.AssertionResponse) {
+		r.Response.ClientDataJSON = append(r.Response.ClientDataJSON[:len(r.Response.ClientDataJSON)-1], ' ', '}')
+	}, false, webauthn.ErrInvalidSignature)
+
+	// a response from another credential
+	f(func(a *webauthntest.Authenticator, credential *webauthn.Credential) *webauthntest.Authenticator {
+		other := givenCredential(t, rp, webauthntest.NewAuthenticator(testOrigin, webauthn.AlgEdDSA))
+		credential.PublicKey = other.PublicKey
+		return a
+	}, noTamper, false, webauthn.ErrInvalidSignature)
+	f(noPrepare, func(r *webauthn.AssertionResponse) {
+		r.RawID = []byte("another id")
+		r.ID = r.RawID.String()
+	}, false, webauthn.ErrCredentialMismatch)
+
+	// a cloned authenticator falls behind the stored counter
+	f(func(a *webauthntest.Authenticator, credential *webauthn.Credential) *webauthntest.Authenticator {
+		clone := a.Clone()
+		credential.SignCount = 5
+		return clone
+	}, noTamper, false, webauthn.ErrSignCountRegression)
+
+	// truncated authenticator data
+	f(noPrepare, func(r *webauthn.AssertionResponse) {
+		r.Response.AuthenticatorData = r.Response.AuthenticatorData[:36]
+	}, false, webauthn.ErrInvalidResponse)
+}
@@ -0,0 +1,225 @@
+// Package webauthntest provides a software authenticator to run WebAuthn ceremonies in tests without a browser.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/AltSoyuz/soy-experiments/lib/webauthn"
+)
+
+var (
+	ErrNoCredential       = errors.New("webauthntest: no matching credential")
+	ErrExcludedCredential = errors.New("webauthntest: a credential in the exclude list is already registered")
+	ErrUnsupported        = errors.New("webauthntest: none of the requested algorithms is supported")
+)
+
+// Authenticator creates and uses discoverable credentials the way a platform authenticator does,
+// as seen through a browser running on Origin
+type Authenticator struct {
+	Origin    string
+	Algorithm int64
+	// SkipUserVerification leaves the user verified flag unset, like a security key without a PIN
+	SkipUserVerification bool
+
+	mu          sync.Mutex
+	credentials []*credential
+}
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	ecdsaKey   *ecdsa.PrivateKey
+	ed25519Key ed25519.PrivateKey
+	signCount  uint32
+}
+
+// NewAuthenticator returns an authenticator creating credentials with the given COSE algorithm,
+// webauthn.AlgES256 or webauthn.AlgEdDSA
+func NewAuthenticator(origin string, algorithm int64) *Authenticator {
+	return &Authenticator{Origin: origin, Algorithm: algorithm}
+}
+
+// Create answers navigator.credentials.create with a new credential and a "none" attestation
+func (a *Authenticator) Create(options webauthn.CreationOptions) (webauthn.RegistrationResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	supported := slices.ContainsFunc(options.PubKeyCredParams, func(p webauthn.CredentialParameter) bool {
+		return p.Alg == a.Algorithm
+	})
+	if !supported {
+		return webauthn.RegistrationResponse{}, ErrUnsupported
+	}
+	for _, excluded := range options.ExcludeCredentials {
+		if a.find(options.RP.ID, excluded.ID) != nil {
+			return webauthn.RegistrationResponse{}, ErrExcludedCredential
+		}
+	}
+
+	c := &credential{
+		id:         randomBytes(16),
+		rpID:       options.RP.ID,
+		userHandle: options.User.ID,
+	}
+	var publicKey []byte
+	switch a.Algorithm {
+	case webauthn.AlgES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return webauthn.RegistrationResponse{}, err
+		}
+		c.ecdsaKey = key
+		publicKey = encodeMap(
+			int64(1), int64(2),
+			int64(3), webauthn.AlgES256,
+			int64(-1), int64(1),
+			int64(-2), key.X.FillBytes(make([]byte, 32)),
+			int64(-3), key.Y.FillBytes(make([]byte, 32)),
+		)
+	case webauthn.AlgEdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return webauthn.RegistrationResponse{}, err
+		}
+		c.ed25519Key = private
+		publicKey = encodeMap(
+			int64(1), int64(1),
+			int64(3), webauthn.AlgEdDSA,
+			int64(-1), int64(6),
+			int64(-2), []byte(public),
+		)
+	default:
+		return webauthn.RegistrationResponse{}, ErrUnsupported
+	}
+	a.credentials = append(a.credentials, c)
+
+	authData := a.authenticatorData(c, 0x40)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(c.id)))
+	authData = append(authData, c.id...)
+	authData = append(authData, publicKey...)
+
+	var response webauthn.RegistrationResponse
+	response.ID = webauthn.Bytes(c.id).String()
+	response.RawID = c.id
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = a.clientData("webauthn.create", options.Challenge)
+	response.Response.AttestationObject = webauthn.Bytes(encodeMap(
+		"fmt", "none",
+		"attStmt", encodeMap(),
+		"authData", authData,
+	))
+	return response, nil
+}
+
+// Get answers navigator.credentials.get with an assertion of the first allowed credential,
+// or of any credential for the relying party when none are listed
+func (a *Authenticator) Get(options webauthn.RequestOptions) (webauthn.AssertionResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var c *credential
+	if len(options.AllowCredentials) == 0 {
+		c = a.find(options.RPID, nil)
+	}
+	for _, allowed := range options.AllowCredentials {
+		if c = a.find(options.RPID, allowed.ID); c != nil {
+			break
+		}
+	}
+	if c == nil {
+		return webauthn.AssertionResponse{}, ErrNoCredential
+	}
+
+	c.signCount++
+	authData := a.authenticatorData(c, 0)
+	clientData := a.clientData("webauthn.get", options.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	signature, err := c.sign(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	if err != nil {
+		return webauthn.AssertionResponse{}, err
+	}
+
+	var response webauthn.AssertionResponse
+	response.ID = webauthn.Bytes(c.id).String()
+	response.RawID = c.id
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = clientData
+	response.Response.AuthenticatorData = authData
+	response.Response.Signature = signature
+	response.Response.UserHandle = c.userHandle
+	return response, nil
+}
+
+// Clone returns an authenticator holding copies of the credentials, with the same signature counters
+func (a *Authenticator) Clone() *Authenticator {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	clone := &Authenticator{Origin: a.Origin, Algorithm: a.Algorithm, SkipUserVerification: a.SkipUserVerification}
+	for _, c := range a.credentials {
+		copied := *c
+		clone.credentials = append(clone.credentials, &copied)
+	}
+	return clone
+}
+
+// find returns the credential with the id for the relying party, or the first one for it when id is nil
+func (a *Authenticator) find(rpID string, id []byte) *credential {
+	for _, c := range a.credentials {
+		if c.rpID == rpID && (id == nil || slices.Equal(c.id, id)) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) authenticatorData(c *credential, flags byte) []byte {
+	flags |= 0x01
+	if !a.SkipUserVerification {
+		flags |= 0x04
+	}
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, c.signCount)
+}
+
+func (a *Authenticator) clientData(ceremony string, challenge webauthn.Bytes) []byte {
+	data, err := json.Marshal(map[string]any{
+		"type":        ceremony,
+		"challenge":   challenge,
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("webauthntest: failed to encode client data: %v", err))
+	}
+	return data
+}
+
+func (c *credential) sign(data []byte) ([]byte, error) {
+	if c.ed25519Key != nil {
+		return ed25519.Sign(c.ed25519Key, data), nil
+	}
+	digest := sha256.Sum256(data)
+	return ecdsa.SignASN1(rand.Reader, c.ecdsaKey, digest[:])
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("webauthntest: failed to generate random bytes: %v", err))
+	}
+	return b
+}
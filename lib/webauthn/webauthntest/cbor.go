@@ -0,0 +1,53 @@
+package webauthntest
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// rawCBOR is an already encoded item, nested as is
+type rawCBOR []byte
+
+// encodeMap encodes alternating keys and values as a CBOR map, keeping their order.
+// Keys and values are int64, string, []byte or rawCBOR.
+func encodeMap(pairs ...any) rawCBOR {
+	out := appendHead(nil, 5, uint64(len(pairs)/2))
+	for _, item := range pairs {
+		out = appendItem(out, item)
+	}
+	return out
+}
+
+func appendItem(out []byte, item any) []byte {
+	switch v := item.(type) {
+	case int64:
+		if v < 0 {
+			return appendHead(out, 1, uint64(-1-v))
+		}
+		return appendHead(out, 0, uint64(v))
+	case string:
+		return append(appendHead(out, 3, uint64(len(v))), v...)
+	case []byte:
+		return append(appendHead(out, 2, uint64(len(v))), v...)
+	case rawCBOR:
+		return append(out, v...)
+	default:
+		panic(fmt.Sprintf("webauthntest: cannot encode %T", item))
+	}
+}
+
+// appendHead appends the initial byte of an item with the shortest encoding of its argument
+func appendHead(out []byte, major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return append(out, major<<5|byte(arg))
+	case arg <= 0xff:
+		return append(out, major<<5|24, byte(arg))
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(out, major<<5|25), uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(out, major<<5|26), uint32(arg))
+	default:
+		return binary.BigEndian.AppendUint64(append(out, major<<5|27), arg)
+	}
+}